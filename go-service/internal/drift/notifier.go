@@ -0,0 +1,82 @@
+package drift
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/benkamin03/prism/internal/store"
+)
+
+// Notifier posts drift reports to a webhook.
+type Notifier struct {
+	URL string
+	// Secret signs the body into the X-Prism-Signature-256 header the way GitHub signs its deliveries, optional
+	Secret     string
+	HTTPClient *http.Client
+}
+
+func NewNotifier(url, secret string) *Notifier {
+	return &Notifier{
+		URL:        url,
+		Secret:     secret,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notification is the body of a drift webhook, the plan text is left out.
+type Notification struct {
+	Event        string          `json:"event"`
+	ReportID     int64           `json:"report_id"`
+	RepositoryID int             `json:"repository_id"`
+	Repository   string          `json:"repository"`
+	CommitSHA    string          `json:"commit_sha"`
+	Environment  string          `json:"environment,omitempty"`
+	Root         string          `json:"root"`
+	Resources    json.RawMessage `json:"resources"`
+	CheckedAt    time.Time       `json:"checked_at"`
+}
+
+func (n *Notifier) Notify(ctx context.Context, report *store.DriftReport) error {
+	body, err := json.Marshal(&Notification{
+		Event:        "drift_detected",
+		ReportID:     report.ID,
+		RepositoryID: report.RepositoryID,
+		Repository:   report.Repository,
+		CommitSHA:    report.CommitSHA,
+		Environment:  report.Environment,
+		Root:         report.Root,
+		Resources:    report.Resources,
+		CheckedAt:    report.CheckedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal drift notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create drift notification: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Prism-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send drift notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("drift webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
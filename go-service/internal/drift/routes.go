@@ -0,0 +1,175 @@
+package drift
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
+	"github.com/benkamin03/prism/internal/minio"
+	"github.com/benkamin03/prism/internal/orchestrator"
+	"github.com/benkamin03/prism/internal/scm"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/benkamin03/prism/internal/variables"
+	"github.com/labstack/echo/v4"
+)
+
+type DriftRoutesConfig struct {
+	Echo        *echo.Echo
+	Store       *store.Store
+	MinioClient minio.MinioClient
+	Secrets     secrets.Provider
+	GitHubApp   *github.App
+	Engines     *engine.Selector
+	// Optional, codify is unavailable without it
+	Codifier orchestrator.Codifier
+}
+
+// RemediateRequest turns a drift report into a conversation.
+type RemediateRequest struct {
+	// Mode is codify or revert
+	Mode string `json:"mode"`
+	// ConversationID names the new branch, drift-<report id>-<unix time> by default
+	ConversationID string `json:"conversation_id,omitempty"`
+	// GitHubToken of the caller, who must be able to push to the repository of the report
+	GitHubToken string `json:"github_token"`
+	ProjectID   string `json:"project_id,omitempty"`
+}
+
+func SetupRoutes(routesConfig *DriftRoutesConfig) {
+	e := routesConfig.Echo
+
+	// GET /drift?repo_url=...&limit=...
+	// Without repo_url returns the latest report of every checked repository, with it the
+	// history of that repository, newest first
+	e.GET("/drift", func(c echo.Context) error {
+		repoURL := c.QueryParam("repo_url")
+		if repoURL == "" {
+			reports, err := routesConfig.Store.LatestDriftReports()
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+			}
+			return c.JSON(http.StatusOK, echo.Map{"reports": reports})
+		}
+
+		repository, err := scm.RepositoryKey(repoURL)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		limit := 20
+		if value := c.QueryParam("limit"); value != "" {
+			if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+				return c.JSON(http.StatusBadRequest, echo.Map{"error": "limit must be a positive number"})
+			}
+		}
+
+		reports, err := routesConfig.Store.ListDriftReports(repository, limit)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, echo.Map{"repository": repository, "reports": reports})
+	})
+
+	// POST /drift/:id/remediate
+	// Opens a conversation branch that codifies the drift of a report or reverts it, body is a RemediateRequest
+	e.POST("/drift/:id/remediate", func(c echo.Context) error {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid report id"})
+		}
+		var req RemediateRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid JSON body"})
+		}
+		if req.Mode != orchestrator.RemediateCodify && req.Mode != orchestrator.RemediateRevert {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "mode must be codify or revert"})
+		}
+		if req.Mode == orchestrator.RemediateCodify && routesConfig.Codifier == nil {
+			return c.JSON(http.StatusServiceUnavailable, echo.Map{"error": "no model provider is configured to codify drift"})
+		}
+
+		// Remediation pushes a branch and opens a PR, only on behalf of someone who could do so themselves
+		if req.GitHubToken == "" {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": github.ErrNoToken.Error()})
+		}
+
+		report, err := routesConfig.Store.GetDriftReport(id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		if report == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "drift report not found"})
+		}
+		if !report.Drifted {
+			return c.JSON(http.StatusConflict, echo.Map{"error": "the report found no drift"})
+		}
+		var drift []orchestrator.ResourceDrift
+		if err := json.Unmarshal(report.Resources, &drift); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to read drift report: %v", err)})
+		}
+
+		repository, err := routesConfig.Store.GetImportedRepository(report.RepositoryID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		if repository == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "the repository of the report is no longer imported"})
+		}
+
+		if err := github.RequirePush(repository.CloneURL(), req.GitHubToken); err != nil {
+			return c.JSON(github.AccessStatus(err), echo.Map{"error": err.Error()})
+		}
+
+		conversationID := req.ConversationID
+		if conversationID == "" {
+			conversationID = fmt.Sprintf("drift-%d-%d", report.ID, time.Now().Unix())
+		}
+		token := routesConfig.GitHubApp.TokenFor(repository.CloneURL(), req.GitHubToken)
+
+		orch := orchestrator.NewOrchestrator(&orchestrator.NewOrchestratorInput{
+			RepoURL:     repository.CloneURL(),
+			GitHubToken: token,
+			UserID:      repository.UserID,
+			ProjectID:   req.ProjectID,
+			Environment: report.Environment,
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+		})
+
+		remediation, err := orch.Remediate(conversationID, req.Mode, drift, routesConfig.Codifier)
+		var missingErr *variables.MissingError
+		if errors.As(err, &missingErr) {
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": missingErr.Error(), "missing_variables": missingErr.Variables})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, remediation)
+	})
+
+	// GET /drift/:id
+	e.GET("/drift/:id", func(c echo.Context) error {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid report id"})
+		}
+
+		report, err := routesConfig.Store.GetDriftReport(id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		if report == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "drift report not found"})
+		}
+		return c.JSON(http.StatusOK, report)
+	})
+}
@@ -0,0 +1,24 @@
+package drift
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRemediateRequiresTheCallersToken(t *testing.T) {
+	e := echo.New()
+	SetupRoutes(&DriftRoutesConfig{Echo: e})
+
+	req := httptest.NewRequest(http.MethodPost, "/drift/7/remediate", strings.NewReader(`{"mode":"revert"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a remediation without a token to be rejected, got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,215 @@
+package drift
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
+	"github.com/benkamin03/prism/internal/minio"
+	"github.com/benkamin03/prism/internal/orchestrator"
+	"github.com/benkamin03/prism/internal/redact"
+	"github.com/benkamin03/prism/internal/scm"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/benkamin03/prism/internal/worker"
+)
+
+type SchedulerConfig struct {
+	Store       *store.Store
+	WorkerPool  *worker.Pool
+	MinioClient minio.MinioClient
+	Secrets     secrets.Provider
+	GitHubApp   *github.App
+	Engines     *engine.Selector
+	// Interval between two checks of a repository
+	Interval time.Duration
+	// Jitter spreads the checks of a round over up to this long, so they do not all start at once
+	Jitter time.Duration
+	// Concurrency bounds the checks queued on or running in the worker pool at the same time.
+	// Each check clones into its own directory, so checks never get in the way of other pool
+	// jobs or requests, this only limits the load the checks add.
+	Concurrency int
+	// Optional, notified of every check that found drift
+	Notifier *Notifier
+}
+
+// Scheduler periodically runs a refresh-only plan of the default branch of every imported repository.
+type Scheduler struct {
+	config *SchedulerConfig
+	slots  chan struct{}
+
+	mu sync.Mutex
+	// inFlight holds the repositories whose check of an earlier round has not finished yet
+	inFlight map[int]bool
+}
+
+func NewScheduler(config *SchedulerConfig) *Scheduler {
+	concurrency := config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Scheduler{
+		config:   config,
+		slots:    make(chan struct{}, concurrency),
+		inFlight: map[int]bool{},
+	}
+}
+
+// Start checks all repositories every interval until ctx is done, the first round starts right away.
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			s.runRound(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) runRound(ctx context.Context) {
+	repositories, err := s.config.Store.ListImportedRepositories()
+	if err != nil {
+		log.Printf("Drift check skipped: %v", err)
+		return
+	}
+	log.Printf("Checking %d repositories for drift", len(repositories))
+
+	for i := range repositories {
+		repository := repositories[i]
+		if !s.claim(repository.ID) {
+			log.Printf("Drift check of %s/%s is still running, skipping it this round", repository.Owner, repository.Name)
+			continue
+		}
+		go s.schedule(ctx, &repository)
+	}
+}
+
+// schedule waits for the jitter and a free slot, then queues the check on the worker pool.
+func (s *Scheduler) schedule(ctx context.Context, repository *store.ImportedRepository) {
+	if s.config.Jitter > 0 {
+		select {
+		case <-ctx.Done():
+			s.release(repository.ID)
+			return
+		case <-time.After(time.Duration(rand.Int63n(int64(s.config.Jitter)))):
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		s.release(repository.ID)
+		return
+	case s.slots <- struct{}{}:
+	}
+
+	err := s.config.WorkerPool.Submit(func(ctx context.Context) {
+		defer func() {
+			<-s.slots
+			s.release(repository.ID)
+		}()
+		s.check(ctx, repository)
+	})
+	if err != nil {
+		<-s.slots
+		s.release(repository.ID)
+		if errors.Is(err, worker.ErrQueueFull) {
+			log.Printf("Drift check of %s/%s skipped, the job queue is full", repository.Owner, repository.Name)
+			return
+		}
+		log.Printf("Drift check of %s/%s not queued: %v", repository.Owner, repository.Name, err)
+	}
+}
+
+func (s *Scheduler) claim(repositoryID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inFlight[repositoryID] {
+		return false
+	}
+	s.inFlight[repositoryID] = true
+	return true
+}
+
+func (s *Scheduler) release(repositoryID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, repositoryID)
+}
+
+// check runs the refresh-only plan of a repository in a clone of its own and stores its report,
+// failed checks included.
+func (s *Scheduler) check(ctx context.Context, repository *store.ImportedRepository) {
+	cloneURL := repository.CloneURL()
+	key, err := scm.RepositoryKey(cloneURL)
+	if err != nil {
+		log.Printf("Drift check of %s skipped: %v", cloneURL, err)
+		return
+	}
+
+	orch := orchestrator.NewOrchestrator(&orchestrator.NewOrchestratorInput{
+		RepoURL:     cloneURL,
+		GitHubToken: s.config.GitHubApp.RepositoryToken(cloneURL, repository.GitHubToken),
+		UserID:      repository.UserID,
+		MinioClient: s.config.MinioClient,
+		Secrets:     s.config.Secrets,
+		Engines:     s.config.Engines,
+		Store:       s.config.Store,
+		Context:     ctx,
+	})
+
+	report, err := orch.DetectDrift()
+	if err != nil {
+		log.Printf("Drift check of %s failed: %v", key, err)
+	}
+	record, err := driftRecord(orch.Redactor(), repository, key, report, err)
+	if err != nil {
+		log.Printf("Failed to marshal drift of %s: %v", key, err)
+		return
+	}
+
+	if err := s.config.Store.SaveDriftReport(record); err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	log.Printf("Drift check of %s done, drifted: %t", key, record.Drifted)
+
+	if record.Drifted && s.config.Notifier != nil {
+		if err := s.config.Notifier.Notify(ctx, record); err != nil {
+			log.Printf("Failed to notify about drift of %s: %v", key, err)
+		}
+	}
+}
+
+// driftRecord is the stored report of a check, a failed one when checkErr is set. The error and
+// the plan quote the configuration and values of the run, they are redacted once more with
+// its redactor before they are stored.
+func driftRecord(redactor *redact.Redactor, repository *store.ImportedRepository, key string, report *orchestrator.DriftReport, checkErr error) (*store.DriftReport, error) {
+	record := &store.DriftReport{RepositoryID: repository.ID, Repository: key}
+	if checkErr != nil {
+		record.Error = redactor.String(checkErr.Error())
+		return record, nil
+	}
+
+	resources, err := json.Marshal(report.Resources)
+	if err != nil {
+		return nil, err
+	}
+	record.CommitSHA = report.CommitSHA
+	record.Environment = report.Environment
+	record.Root = report.Root
+	record.Drifted = report.Drifted
+	record.Resources = redactor.Bytes(resources)
+	record.PlanText = redactor.String(report.PlanText)
+	return record, nil
+}
@@ -0,0 +1,49 @@
+package drift
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/benkamin03/prism/internal/orchestrator"
+	"github.com/benkamin03/prism/internal/redact"
+	"github.com/benkamin03/prism/internal/store"
+)
+
+func TestDriftRecordIsRedactedBeforeItIsStored(t *testing.T) {
+	// Checks redact with the scope of their run on redact.Default
+	redactor := redact.Default.Scope()
+	defer redactor.Close()
+	redactor.Add("hunter2")
+
+	report := &orchestrator.DriftReport{
+		CommitSHA: "abc123",
+		Root:      "infra",
+		Drifted:   true,
+		Resources: []orchestrator.ResourceDrift{{
+			Address:    "aws_db_instance.main",
+			Type:       "aws_db_instance",
+			Actions:    []string{"update"},
+			Attributes: []orchestrator.AttributeDrift{{Name: "password", Before: "hunter2", After: "changed"}},
+		}},
+		PlanText: "  ~ password = \"hunter2\" -> \"changed\"",
+	}
+	record, err := driftRecord(redactor, &store.ImportedRepository{ID: 7}, "github.com/acme/infra", report, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !record.Drifted || record.RepositoryID != 7 || record.Repository != "github.com/acme/infra" || record.CommitSHA != "abc123" || record.Root != "infra" {
+		t.Errorf("unexpected record %+v", record)
+	}
+	if strings.Contains(record.PlanText, "hunter2") || !strings.Contains(string(record.Resources), `"before":"[REDACTED]"`) {
+		t.Errorf("expected the stored plan and resources to be redacted, got %q and %s", record.PlanText, record.Resources)
+	}
+
+	record, err = driftRecord(redactor, &store.ImportedRepository{ID: 7}, "github.com/acme/infra", nil, errors.New("failed to read hunter2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if record.Error != "failed to read "+redact.Placeholder {
+		t.Errorf("expected the stored error to be redacted, got %q", record.Error)
+	}
+}
@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/providercache"
+	"github.com/benkamin03/prism/internal/redact"
+)
+
+// cli runs the commands Terraform and OpenTofu share.
+type cli struct {
+	binary string
+	dir    string
+	env    []string
+	// plugins is held while installing providers, nil without a shared plugin cache
+	plugins *providercache.Cache
+	// redactor knows the secret values of the run, redact.Default when it was created without one
+	redactor *redact.Redactor
+}
+
+// inheritedEnv names the variables of the service that commands get, the rest of its environment
+// holds the credentials of the database, MinIO and Infisical and stays out of runs.
+var inheritedEnv = []string{
+	"PATH", "HOME", "USER", "TMPDIR", "LANG", "LC_ALL", "TZ", "SSL_CERT_FILE", "SSL_CERT_DIR",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+}
+
+// inheritedPrefixes pass on the CLI settings of Terraform and Terragrunt, like the plugin cache.
+// Variables only come from the mappings of the root, never from the service.
+var inheritedPrefixes = []string{"TF_", "TG_", "TERRAGRUNT_"}
+
+// baseEnv returns the part of the service's environment commands run with.
+func baseEnv() []string {
+	env := []string{}
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if slices.Contains(inheritedEnv, name) {
+			env = append(env, entry)
+			continue
+		}
+		for _, prefix := range inheritedPrefixes {
+			if strings.HasPrefix(name, prefix) && !strings.HasPrefix(name, "TF_VAR_") {
+				env = append(env, entry)
+				break
+			}
+		}
+	}
+	return env
+}
+
+func (c *cli) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(c.binary, args...)
+	cmd.Dir = c.dir
+	cmd.Env = append(baseEnv(), c.env...)
+	return cmd
+}
+
+// run returns the combined output, which is also included in the error on failure. Secret
+// values the engine was given are redacted from both.
+func (c *cli) run(args ...string) (string, error) {
+	output, err := c.command(args...).CombinedOutput()
+	output = c.redactor.Bytes(output)
+	if err != nil {
+		return string(output), fmt.Errorf("%s %s failed: %s, %w", c.binary, args[0], string(output), err)
+	}
+	return string(output), nil
+}
+
+// Init installs the providers recorded in .terraform.lock.hcl unless upgrade is set, which
+// picks the newest versions allowed by the configuration instead.
+func (c *cli) Init(upgrade bool) error {
+	args := []string{"init", "-no-color", "-input=false"}
+	if upgrade {
+		args = append(args, "-upgrade")
+	}
+	if c.plugins != nil {
+		lock := c.plugins.Lock
+		if !upgrade && c.plugins.Cached(c.dir) {
+			// Only links providers from the cache, which other such inits may do at the same time
+			lock = c.plugins.RLock
+		}
+		unlock, err := lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	_, err := c.run(args...)
+	return err
+}
+
+func (c *cli) Validate() (*ValidateResult, error) {
+	cmd := c.command("validate", "-json", "-no-color")
+	// validate exits non-zero for invalid configurations but still prints its JSON report on stdout
+	output, runErr := cmd.Output()
+	// Diagnostics quote the configuration and variable values
+	output = c.redactor.Bytes(output)
+
+	var result ValidateResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("%s validate failed: %s, %w", c.binary, string(output), runErr)
+		}
+		return nil, fmt.Errorf("failed to parse %s validate output: %w", c.binary, err)
+	}
+	return &result, nil
+}
+
+func (c *cli) Plan(options *PlanOptions) (string, error) {
+	args := []string{"plan", "-no-color", "-input=false", "-out=tfplan"}
+	if options != nil {
+		for _, varFile := range options.VarFiles {
+			args = append(args, "-var-file="+varFile)
+		}
+	}
+	return c.run(args...)
+}
+
+func (c *cli) Show() (map[string]interface{}, error) {
+	cmd := c.command("show", "-json", "tfplan")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s show -json failed: %s, %w", c.binary, c.redactor.String(stderr.String()), err)
+	}
+
+	var plan map[string]interface{}
+	if err := json.Unmarshal(output, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan JSON: %w", err)
+	}
+	// The JSON plan holds sensitive values in plain text, only marked as sensitive
+	c.redactor.Value(plan)
+	return NormalizePlan(plan), nil
+}
+
+func (c *cli) ShowText() (string, error) {
+	return c.run("show", "-no-color", "tfplan")
+}
+
+func (c *cli) Apply() (string, error) {
+	return c.run("apply", "-no-color", "-input=false", "tfplan")
+}
+
+func (c *cli) StateList() ([]string, error) {
+	output, err := c.run("state", "list")
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			addresses = append(addresses, line)
+		}
+	}
+	return addresses, nil
+}
+
+// TerraformEngine runs HashiCorp Terraform.
+type TerraformEngine struct {
+	*cli
+}
+
+func (t *TerraformEngine) Name() string {
+	return Terraform
+}
+
+// OpenTofuEngine runs OpenTofu, whose CLI and plan format match Terraform's.
+type OpenTofuEngine struct {
+	*cli
+}
+
+func (o *OpenTofuEngine) Name() string {
+	return OpenTofu
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benkamin03/prism/internal/redact"
+)
+
+// fakeBinary writes a script standing in for terraform, which runs script for every command.
+func fakeBinary(t *testing.T, script string) string {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), "terraform")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("failed to write fake binary: %v", err)
+	}
+	return binary
+}
+
+func TestEnginesUseTheirRedactor(t *testing.T) {
+	// Runs pass the scope of their context, which lends its values to redact.Default
+	redactor := redact.Default.Scope()
+	defer redactor.Close()
+	redactor.Add("hunter2")
+	binary := fakeBinary(t, `echo "  + password = \"hunter2\""
+echo "Error: hunter2 was rejected" >&2
+exit 1
+`)
+
+	eng, err := (&Selector{}).New(Terraform, t.TempDir(), "", nil, redactor)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tf := eng.(*TerraformEngine)
+	if tf.redactor != redactor {
+		t.Error("expected the engine to use the redactor of its run")
+	}
+	tf.binary = binary
+	output, err := tf.Plan(nil)
+	if err == nil {
+		t.Fatal("expected the failing plan to return an error")
+	}
+	for _, text := range []string{output, err.Error()} {
+		if strings.Contains(text, "hunter2") || !strings.Contains(text, redact.Placeholder) {
+			t.Errorf("expected the output and error to be redacted, got %q", text)
+		}
+	}
+
+	eng, err = (&Selector{}).New(Terraform, t.TempDir(), "", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if eng.(*TerraformEngine).redactor != redact.Default {
+		t.Error("expected engines without a redactor to fall back to redact.Default")
+	}
+}
+
+func TestCommandsOnlyGetTheirEnvironment(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://prism:hunter2@db/prism")
+	t.Setenv("TF_VAR_db_password", "hunter2")
+	t.Setenv("TF_PLUGIN_CACHE_DIR", "/var/cache/terraform")
+	c := &cli{binary: fakeBinary(t, "env\n"), dir: t.TempDir(), redactor: redact.New(0), env: []string{"TF_VAR_region=eu-west-1"}}
+
+	output, err := c.run("version")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"DATABASE_URL=", "TF_VAR_db_password="} {
+		if strings.Contains(output, name) {
+			t.Errorf("expected %s of the service to stay out of the command, got %q", name, output)
+		}
+	}
+	for _, entry := range []string{"PATH=", "TF_PLUGIN_CACHE_DIR=/var/cache/terraform", "TF_VAR_region=eu-west-1"} {
+		if !strings.Contains(output, entry) {
+			t.Errorf("expected %s in the environment of the command, got %q", entry, output)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// DriftDetector compares the state of a root module with the real infrastructure without
+// planning any change to it.
+type DriftDetector interface {
+	// RefreshPlan writes a refresh-only tfplan and reports whether the infrastructure drifted
+	// from the state, based on the detailed exit code of the plan
+	RefreshPlan(options *PlanOptions) (bool, string, error)
+}
+
+func (c *cli) RefreshPlan(options *PlanOptions) (bool, string, error) {
+	args := []string{"plan", "-refresh-only", "-detailed-exitcode", "-no-color", "-input=false", "-out=tfplan"}
+	if options != nil {
+		for _, varFile := range options.VarFiles {
+			args = append(args, "-var-file="+varFile)
+		}
+	}
+
+	output, err := c.command(args...).CombinedOutput()
+	output = c.redactor.Bytes(output)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		// 2 means the plan succeeded and is not empty
+		return true, string(output), nil
+	}
+	if err != nil {
+		return false, string(output), fmt.Errorf("%s plan -refresh-only failed: %s, %w", c.binary, string(output), err)
+	}
+	return false, string(output), nil
+}
+
+// RefreshPlan is not supported for stacks, the detailed exit codes of the units are not
+// combined by run-all.
+func (t *TerragruntEngine) RefreshPlan(options *PlanOptions) (bool, string, error) {
+	return false, "", fmt.Errorf("drift detection is not supported for Terragrunt stacks")
+}
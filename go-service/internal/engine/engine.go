@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/benkamin03/prism/internal/providercache"
+	"github.com/benkamin03/prism/internal/redact"
+	"github.com/benkamin03/prism/internal/tfversion"
+)
+
+// Names accepted for the engine setting of a repository
+const (
+	Terraform = "terraform"
+	OpenTofu  = "opentofu"
+)
+
+// Engine runs the infrastructure-as-code CLI in one root module. Plan writes tfplan into the
+// module directory, the other commands read it from there.
+type Engine interface {
+	Name() string
+	Init(upgrade bool) error
+	Validate() (*ValidateResult, error)
+	// Plan returns the human readable plan output
+	Plan(options *PlanOptions) (string, error)
+	// Show returns the saved plan as normalized JSON
+	Show() (map[string]interface{}, error)
+	// ShowText renders the saved plan as plain text
+	ShowText() (string, error)
+	Apply() (string, error)
+	StateList() ([]string, error)
+}
+
+type PlanOptions struct {
+	// VarFiles are passed with -var-file, relative to the module directory
+	VarFiles []string
+}
+
+// Selector creates engines with the binary version each root module asks for.
+// A nil Selector, or one without a manager for an engine, uses the binary from PATH.
+type Selector struct {
+	Terraform *tfversion.Manager
+	OpenTofu  *tfversion.Manager
+	// Plugins is shared by all engines for their providers, without it every init downloads them
+	Plugins *providercache.Cache
+}
+
+// New creates the named engine for the module in dir. pinned is an exact version to use
+// instead of the module's required_version, env is added to the environment of every command.
+// Output and plans are redacted with redactor, redact.Default when it is nil.
+// A dir holding a Terragrunt stack is run through terragrunt with the named engine underneath.
+func (s *Selector) New(name, dir, pinned string, env []string, redactor *redact.Redactor) (Engine, error) {
+	if IsTerragrunt(dir) {
+		return s.NewTerragrunt(name, dir, pinned, env, redactor)
+	}
+
+	binary, err := s.resolve(name, dir, pinned)
+	if err != nil {
+		return nil, err
+	}
+
+	c := s.newCLI(binary, dir, env, redactor)
+	if name == OpenTofu {
+		return &OpenTofuEngine{c}, nil
+	}
+	return &TerraformEngine{c}, nil
+}
+
+// newCLI points the commands of an engine at the plugin cache, when there is one.
+func (s *Selector) newCLI(binary, dir string, env []string, redactor *redact.Redactor) *cli {
+	if redactor == nil {
+		redactor = redact.Default
+	}
+	if s == nil || s.Plugins == nil {
+		return &cli{binary: binary, dir: dir, env: env, redactor: redactor}
+	}
+	return &cli{
+		binary:   binary,
+		dir:      dir,
+		env:      append(append([]string{}, env...), s.Plugins.Env()...),
+		plugins:  s.Plugins,
+		redactor: redactor,
+	}
+}
+
+// resolve picks the binary of the named engine for the module in dir.
+func (s *Selector) resolve(name, dir, pinned string) (string, error) {
+	var manager *tfversion.Manager
+	binary := ""
+	switch name {
+	case Terraform, "":
+		binary = "terraform"
+		if s != nil {
+			manager = s.Terraform
+		}
+	case OpenTofu:
+		binary = "tofu"
+		if s != nil {
+			manager = s.OpenTofu
+		}
+	default:
+		return "", fmt.Errorf("unknown engine %q, expected %s or %s", name, Terraform, OpenTofu)
+	}
+
+	if manager != nil {
+		return manager.Resolve(dir, pinned)
+	}
+	if pinned != "" {
+		return "", fmt.Errorf("cannot use %s %s, version management is not configured", binary, pinned)
+	}
+	return binary, nil
+}
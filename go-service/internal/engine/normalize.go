@@ -0,0 +1,19 @@
+package engine
+
+// NormalizePlan gives plans from every engine the shape the frontend reads. Empty sections
+// are omitted by the CLIs when nothing changes and are filled in here, and the CLI version
+// is always reported as terraform_version.
+func NormalizePlan(plan map[string]interface{}) map[string]interface{} {
+	if _, ok := plan["terraform_version"]; !ok {
+		if version, ok := plan["tofu_version"]; ok {
+			plan["terraform_version"] = version
+		}
+	}
+	if plan["resource_changes"] == nil {
+		plan["resource_changes"] = []interface{}{}
+	}
+	if plan["output_changes"] == nil {
+		plan["output_changes"] = map[string]interface{}{}
+	}
+	return plan
+}
@@ -0,0 +1,47 @@
+package engine
+
+// StateEditor changes the state of a root module directly instead of through a plan. The
+// state must be local to the module, Terragrunt units keep theirs in their own backends.
+type StateEditor interface {
+	StateMove(source, destination string) (string, error)
+	StateRemove(addresses []string) (string, error)
+	// Import adopts an existing object into the state at address, the configuration of the
+	// module is evaluated, so it needs the same var files as a plan
+	Import(address, id string, options *PlanOptions) (string, error)
+	StateReplaceProvider(from, to string) (string, error)
+	// GenerateConfig plans the import blocks of the module and writes configuration for the
+	// resources they import without one into out, which must not exist yet
+	GenerateConfig(out string, options *PlanOptions) (string, error)
+}
+
+func (c *cli) StateMove(source, destination string) (string, error) {
+	return c.run("state", "mv", source, destination)
+}
+
+func (c *cli) StateRemove(addresses []string) (string, error) {
+	return c.run(append([]string{"state", "rm"}, addresses...)...)
+}
+
+func (c *cli) Import(address, id string, options *PlanOptions) (string, error) {
+	args := []string{"import", "-no-color", "-input=false"}
+	if options != nil {
+		for _, varFile := range options.VarFiles {
+			args = append(args, "-var-file="+varFile)
+		}
+	}
+	return c.run(append(args, address, id)...)
+}
+
+func (c *cli) StateReplaceProvider(from, to string) (string, error) {
+	return c.run("state", "replace-provider", "-auto-approve", from, to)
+}
+
+func (c *cli) GenerateConfig(out string, options *PlanOptions) (string, error) {
+	args := []string{"plan", "-no-color", "-input=false", "-out=tfplan", "-generate-config-out=" + out}
+	if options != nil {
+		for _, varFile := range options.VarFiles {
+			args = append(args, "-var-file="+varFile)
+		}
+	}
+	return c.run(args...)
+}
@@ -0,0 +1,390 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/redact"
+)
+
+// Terragrunt is reported as the engine name of Terragrunt stacks
+const Terragrunt = "terragrunt"
+
+const terragruntConfigFile = "terragrunt.hcl"
+
+// Saved plans of all units, laid out by terragrunt as <dir>/<unit>/tfplan.tfplan and tfplan.json
+const (
+	terragruntPlanDir     = ".prism-plans"
+	terragruntJSONPlanDir = ".prism-plans-json"
+)
+
+// TerragruntEngine runs terragrunt run-all over every unit of a stack, with Terraform or
+// OpenTofu underneath. Units keep their state in their own remote_state backends.
+type TerragruntEngine struct {
+	*cli
+	// Units in dependency order, relative to the stack directory
+	Units []string
+}
+
+// IsTerragrunt reports whether dir or any directory below it has a terragrunt.hcl.
+func IsTerragrunt(dir string) bool {
+	found := false
+	filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || found {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() && path != dir && skipTerragruntDir(entry.Name()) {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && entry.Name() == terragruntConfigFile {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+func skipTerragruntDir(name string) bool {
+	return name == ".git" || name == ".terraform" || name == ".terragrunt-cache" ||
+		name == terragruntPlanDir || name == terragruntJSONPlanDir
+}
+
+// NewTerragrunt creates a Terragrunt engine for the stack in dir, running the named engine's binary.
+func (s *Selector) NewTerragrunt(name, dir, pinned string, env []string, redactor *redact.Redactor) (*TerragruntEngine, error) {
+	binary, err := s.resolve(name, dir, pinned)
+	if err != nil {
+		return nil, err
+	}
+
+	units, err := TerragruntUnits(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// TERRAGRUNT_TFPATH points terragrunt at the selected terraform or tofu binary
+	env = append(append([]string{}, env...), "TERRAGRUNT_TFPATH="+binary, "TERRAGRUNT_NON_INTERACTIVE=true")
+	return &TerragruntEngine{
+		cli:   s.newCLI("terragrunt", dir, env, redactor),
+		Units: units,
+	}, nil
+}
+
+func (t *TerragruntEngine) Name() string {
+	return Terragrunt
+}
+
+// unit returns a cli running terragrunt in a single unit.
+func (t *TerragruntEngine) unit(unit string) *cli {
+	return &cli{binary: t.binary, dir: filepath.Join(t.dir, unit), env: t.env, plugins: t.plugins, redactor: t.redactor}
+}
+
+func (t *TerragruntEngine) Init(upgrade bool) error {
+	args := []string{"run-all", "init", "-no-color", "-input=false"}
+	if upgrade {
+		args = append(args, "-upgrade")
+	}
+	if t.plugins != nil {
+		unitDirs := []string{}
+		for _, unit := range t.Units {
+			unitDirs = append(unitDirs, filepath.Join(t.dir, unit))
+		}
+		lock := t.plugins.RLock
+		if upgrade || !t.plugins.Cached(unitDirs...) {
+			// Units would otherwise write to the same plugin cache in parallel
+			args = append(args, "--terragrunt-parallelism", "1")
+			lock = t.plugins.Lock
+		}
+		unlock, err := lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	_, err := t.run(args...)
+	return err
+}
+
+// Validate validates each unit, prefixing diagnostics with the unit they belong to.
+func (t *TerragruntEngine) Validate() (*ValidateResult, error) {
+	combined := &ValidateResult{Valid: true, Diagnostics: []Diagnostic{}}
+	for _, unit := range t.Units {
+		result, err := t.unit(unit).Validate()
+		if err != nil {
+			return nil, fmt.Errorf("unit %s: %w", unit, err)
+		}
+
+		combined.Valid = combined.Valid && result.Valid
+		combined.ErrorCount += result.ErrorCount
+		combined.WarningCount += result.WarningCount
+		for _, diagnostic := range result.Diagnostics {
+			if diagnostic.Range != nil {
+				diagnostic.Range.Filename = filepath.ToSlash(filepath.Join(unit, diagnostic.Range.Filename))
+			}
+			combined.Diagnostics = append(combined.Diagnostics, diagnostic)
+		}
+	}
+	return combined, nil
+}
+
+// autoVarFiles returns the *.auto.tfvars and *.auto.tfvars.json files of the stack directory in
+// lexical order, the order terraform loads them in.
+func (t *TerragruntEngine) autoVarFiles() ([]string, error) {
+	varFiles := []string{}
+	for _, pattern := range []string{"*.auto.tfvars", "*.auto.tfvars.json"} {
+		matches, err := filepath.Glob(filepath.Join(t.absDir(), pattern))
+		if err != nil {
+			return nil, err
+		}
+		varFiles = append(varFiles, matches...)
+	}
+	sort.Strings(varFiles)
+	return varFiles, nil
+}
+
+func (t *TerragruntEngine) Plan(options *PlanOptions) (string, error) {
+	args := []string{
+		"run-all", "plan", "-no-color", "-input=false",
+		"--terragrunt-out-dir", filepath.Join(t.absDir(), terragruntPlanDir),
+		"--terragrunt-json-out-dir", filepath.Join(t.absDir(), terragruntJSONPlanDir),
+	}
+	// Units run in their own directories and never load the stack's *.auto.tfvars files, like
+	// the variable set Prism writes, so they are passed first as terraform would load them
+	autoVarFiles, err := t.autoVarFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, varFile := range autoVarFiles {
+		args = append(args, "-var-file="+varFile)
+	}
+	if options != nil {
+		// Var files must not be relative to the stack either
+		for _, varFile := range options.VarFiles {
+			args = append(args, "-var-file="+filepath.Join(t.absDir(), varFile))
+		}
+	}
+	return t.run(args...)
+}
+
+func (t *TerragruntEngine) absDir() string {
+	dir, err := filepath.Abs(t.dir)
+	if err != nil {
+		return t.dir
+	}
+	return dir
+}
+
+// Show merges the JSON plans of all units into one plan, in dependency order. Each resource
+// change is tagged with its unit and outputs are keyed by unit, so the shape matches a
+// single plan.
+func (t *TerragruntEngine) Show() (map[string]interface{}, error) {
+	resourceChanges := []interface{}{}
+	outputChanges := map[string]interface{}{}
+	merged := map[string]interface{}{
+		"units": t.Units,
+	}
+
+	for _, unit := range t.Units {
+		content, err := os.ReadFile(filepath.Join(t.dir, terragruntJSONPlanDir, unit, "tfplan.json"))
+		if errors.Is(err, os.ErrNotExist) {
+			// Units without changes to plan, e.g. only reading data sources, may not save a plan
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read plan of unit %s: %w", unit, err)
+		}
+
+		var plan map[string]interface{}
+		if err := json.Unmarshal(content, &plan); err != nil {
+			return nil, fmt.Errorf("failed to parse plan of unit %s: %w", unit, err)
+		}
+		t.redactor.Value(plan)
+		plan = NormalizePlan(plan)
+
+		for _, key := range []string{"format_version", "terraform_version"} {
+			if _, ok := merged[key]; !ok && plan[key] != nil {
+				merged[key] = plan[key]
+			}
+		}
+		if changes, ok := plan["resource_changes"].([]interface{}); ok {
+			for _, change := range changes {
+				if changeMap, ok := change.(map[string]interface{}); ok {
+					changeMap["unit"] = unit
+				}
+				resourceChanges = append(resourceChanges, change)
+			}
+		}
+		if outputs, ok := plan["output_changes"].(map[string]interface{}); ok {
+			for name, output := range outputs {
+				outputChanges[unit+"/"+name] = output
+			}
+		}
+	}
+
+	merged["resource_changes"] = resourceChanges
+	merged["output_changes"] = outputChanges
+	return merged, nil
+}
+
+// ShowText renders the saved plans of all units, each under a heading with its path.
+func (t *TerragruntEngine) ShowText() (string, error) {
+	var text strings.Builder
+	for _, unit := range t.Units {
+		planFile := filepath.Join(t.absDir(), terragruntPlanDir, unit, "tfplan.tfplan")
+		if _, err := os.Stat(planFile); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		output, err := t.unit(unit).run("show", "-no-color", planFile)
+		if err != nil {
+			return "", fmt.Errorf("unit %s: %w", unit, err)
+		}
+		fmt.Fprintf(&text, "# %s\n\n%s\n", unit, output)
+	}
+	return text.String(), nil
+}
+
+// Apply applies the saved plans of all units, terragrunt orders them by their dependencies.
+func (t *TerragruntEngine) Apply() (string, error) {
+	return t.run("run-all", "apply", "-no-color", "-input=false",
+		"--terragrunt-out-dir", filepath.Join(t.absDir(), terragruntPlanDir))
+}
+
+// StateList lists the resources of all units as <unit>:<address>.
+func (t *TerragruntEngine) StateList() ([]string, error) {
+	addresses := []string{}
+	for _, unit := range t.Units {
+		unitAddresses, err := t.unit(unit).StateList()
+		if err != nil {
+			return nil, fmt.Errorf("unit %s: %w", unit, err)
+		}
+		for _, address := range unitAddresses {
+			addresses = append(addresses, unit+":"+address)
+		}
+	}
+	return addresses, nil
+}
+
+var (
+	terraformBlockPattern    = regexp.MustCompile(`(?m)^\s*terraform\s*\{`)
+	dependencyPathPattern    = regexp.MustCompile(`(?m)^\s*config_path\s*=\s*"([^"]+)"`)
+	dependenciesBlockPattern = regexp.MustCompile(`(?s)dependencies\s*\{[^}]*?paths\s*=\s*\[([^\]]*)\]`)
+	quotedPattern            = regexp.MustCompile(`"([^"]+)"`)
+)
+
+// TerragruntUnits finds the units of the stack in dir and orders them so that every unit
+// comes after the units it depends on. A terragrunt.hcl without a terraform block at the top
+// of the stack only holds shared configuration and is not a unit.
+func TerragruntUnits(dir string) ([]string, error) {
+	dependencies := map[string][]string{}
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && skipTerragruntDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Name() != terragruntConfigFile {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		unitDir := filepath.Dir(path)
+		unit, err := filepath.Rel(dir, unitDir)
+		if err != nil {
+			return err
+		}
+		if unit == "." && !terraformBlockPattern.Match(content) {
+			return nil
+		}
+
+		paths := []string{}
+		for _, match := range dependencyPathPattern.FindAllSubmatch(content, -1) {
+			paths = append(paths, string(match[1]))
+		}
+		for _, match := range dependenciesBlockPattern.FindAllSubmatch(content, -1) {
+			for _, quoted := range quotedPattern.FindAllSubmatch(match[1], -1) {
+				paths = append(paths, string(quoted[1]))
+			}
+		}
+
+		unitDependencies := []string{}
+		for _, dependencyPath := range paths {
+			dependency, err := filepath.Rel(dir, filepath.Join(unitDir, filepath.FromSlash(dependencyPath)))
+			if err != nil {
+				return err
+			}
+			unitDependencies = append(unitDependencies, filepath.ToSlash(dependency))
+		}
+		dependencies[filepath.ToSlash(unit)] = unitDependencies
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover terragrunt units: %w", err)
+	}
+
+	return dependencyOrder(dependencies)
+}
+
+// dependencyOrder sorts units topologically, alphabetically among units that are ready at the
+// same time so that the order is stable. Dependencies outside the stack are ignored.
+func dependencyOrder(dependencies map[string][]string) ([]string, error) {
+	remaining := map[string]int{}
+	dependents := map[string][]string{}
+	for unit, unitDependencies := range dependencies {
+		remaining[unit] += 0
+		for _, dependency := range unitDependencies {
+			if _, ok := dependencies[dependency]; !ok {
+				continue
+			}
+			remaining[unit]++
+			dependents[dependency] = append(dependents[dependency], unit)
+		}
+	}
+
+	ready := []string{}
+	for unit, count := range remaining {
+		if count == 0 {
+			ready = append(ready, unit)
+		}
+	}
+
+	ordered := []string{}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		unit := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, unit)
+
+		for _, dependent := range dependents[unit] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(ordered) != len(dependencies) {
+		cyclic := []string{}
+		for unit, count := range remaining {
+			if count > 0 {
+				cyclic = append(cyclic, unit)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, fmt.Errorf("terragrunt units have a dependency cycle: %s", strings.Join(cyclic, ", "))
+	}
+	return ordered, nil
+}
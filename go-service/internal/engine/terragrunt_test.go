@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benkamin03/prism/internal/redact"
+)
+
+func TestTerragruntPlanPassesTheStackVariables(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"prism.auto.tfvars.json", "common.auto.tfvars", "ignored.tfvars"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	tg := &TerragruntEngine{cli: &cli{binary: fakeBinary(t, `echo "$@"`), dir: dir, redactor: redact.New(0)}}
+
+	output, err := tg.Plan(&PlanOptions{VarFiles: []string{"env/prod.tfvars"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"-var-file=" + filepath.Join(dir, "common.auto.tfvars"),
+		"-var-file=" + filepath.Join(dir, "prism.auto.tfvars.json"),
+		"-var-file=" + filepath.Join(dir, "env/prod.tfvars"),
+	}, " ")
+	if !strings.Contains(output, want) {
+		t.Errorf("expected the auto var files before the configured ones, got %q", output)
+	}
+	if strings.Contains(output, "ignored.tfvars") {
+		t.Errorf("expected only auto var files to be passed on their own, got %q", output)
+	}
+}
+
+// writeStack lays out a terragrunt stack below a temporary directory, files maps slash separated
+// paths to their content.
+func writeStack(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create the directory of %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestTerragruntUnits(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name: "dependency blocks",
+			files: map[string]string{
+				"terragrunt.hcl":     `remote_state { backend = "s3" }`,
+				"app/terragrunt.hcl": "dependency \"vpc\" {\n  config_path = \"../vpc\"\n}\ndependency \"db\" {\n  config_path = \"../db\"\n}",
+				"db/terragrunt.hcl":  "dependency \"vpc\" {\n  config_path = \"../vpc\"\n}",
+				"vpc/terragrunt.hcl": `terraform { source = "../modules/vpc" }`,
+			},
+			want: []string{"vpc", "db", "app"},
+		},
+		{
+			name: "dependencies block",
+			files: map[string]string{
+				"a/terragrunt.hcl":                   "dependencies {\n  paths = [\"../c\", \"../b\"]\n}",
+				"b/terragrunt.hcl":                   "",
+				"c/terragrunt.hcl":                   "dependencies {\n  paths = [\"../b\"]\n}",
+				".terragrunt-cache/b/terragrunt.hcl": "",
+			},
+			want: []string{"b", "c", "a"},
+		},
+		{
+			name: "nested units and a unit at the top",
+			files: map[string]string{
+				"terragrunt.hcl":              "terraform {\n  source = \"./module\"\n}",
+				"prod/us/app/terragrunt.hcl":  "dependency \"root\" {\n  config_path = \"../../..\"\n}",
+				"prod/eu/app/terragrunt.hcl":  "",
+				".terraform/terragrunt.hcl":   "",
+				".prism-plans/terragrunt.hcl": "",
+			},
+			want: []string{".", "prod/eu/app", "prod/us/app"},
+		},
+		{
+			name: "dependencies outside the stack",
+			files: map[string]string{
+				"app/terragrunt.hcl": "dependency \"shared\" {\n  config_path = \"../../shared\"\n}\ndependencies {\n  paths = [\"../missing\"]\n}",
+				"db/terragrunt.hcl":  "",
+			},
+			want: []string{"app", "db"},
+		},
+	}
+
+	for _, test := range tests {
+		units, err := TerragruntUnits(writeStack(t, test.files))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if strings.Join(units, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: expected units %v, got %v", test.name, test.want, units)
+		}
+	}
+}
+
+func TestTerragruntUnitsRejectsCycles(t *testing.T) {
+	dir := writeStack(t, map[string]string{
+		"a/terragrunt.hcl":   "dependency \"b\" {\n  config_path = \"../b\"\n}",
+		"b/terragrunt.hcl":   "dependencies {\n  paths = [\"../c\"]\n}",
+		"c/terragrunt.hcl":   "dependency \"a\" {\n  config_path = \"../a\"\n}",
+		"vpc/terragrunt.hcl": "",
+	})
+
+	_, err := TerragruntUnits(dir)
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle: a, b, c") {
+		t.Errorf("expected the units of the cycle to be named, got %v", err)
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+	ordered, err := dependencyOrder(map[string][]string{
+		"app":   {"db", "vpc"},
+		"db":    {"vpc"},
+		"cdn":   {},
+		"vpc":   nil,
+		"audit": {"external/logging"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(ordered, ","); got != "audit,cdn,vpc,db,app" {
+		t.Errorf("expected ready units alphabetically and dependents after their dependencies, got %s", got)
+	}
+
+	if _, err := dependencyOrder(map[string][]string{"a": {"a"}}); err == nil {
+		t.Error("expected a unit depending on itself to be a cycle")
+	}
+}
+
+func TestTerragruntShowMergesUnitPlans(t *testing.T) {
+	dir := writeStack(t, map[string]string{
+		".prism-plans-json/vpc/tfplan.json": `{
+			"format_version": "1.2",
+			"terraform_version": "1.9.0",
+			"resource_changes": [{"address": "aws_vpc.main", "change": {"actions": ["create"]}}],
+			"output_changes": {"id": {"actions": ["create"]}}
+		}`,
+		".prism-plans-json/app/tfplan.json": `{
+			"format_version": "1.2",
+			"resource_changes": [{"address": "aws_instance.web", "change": {"actions": ["update"]}}],
+			"output_changes": {"id": {"actions": ["update"]}}
+		}`,
+	})
+	tg := &TerragruntEngine{cli: &cli{dir: dir, redactor: redact.New(0)}, Units: []string{"vpc", "data", "app"}}
+
+	plan, err := tg.Show()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if plan["format_version"] != "1.2" || plan["terraform_version"] != "1.9.0" {
+		t.Errorf("expected the versions of the first unit, got %v %v", plan["format_version"], plan["terraform_version"])
+	}
+	changes := plan["resource_changes"].([]interface{})
+	if len(changes) != 2 {
+		t.Fatalf("expected the changes of both planned units, got %v", changes)
+	}
+	for i, want := range []string{"vpc:aws_vpc.main", "app:aws_instance.web"} {
+		change := changes[i].(map[string]interface{})
+		if got := change["unit"].(string) + ":" + change["address"].(string); got != want {
+			t.Errorf("expected change %d to be %s in dependency order, got %s", i, want, got)
+		}
+	}
+	outputs := plan["output_changes"].(map[string]interface{})
+	if len(outputs) != 2 || outputs["vpc/id"] == nil || outputs["app/id"] == nil {
+		t.Errorf("expected the outputs keyed by unit, got %v", outputs)
+	}
+}
+
+func TestTerragruntShowRejectsBrokenUnitPlans(t *testing.T) {
+	dir := writeStack(t, map[string]string{".prism-plans-json/vpc/tfplan.json": "{"})
+	tg := &TerragruntEngine{cli: &cli{dir: dir, redactor: redact.New(0)}, Units: []string{"vpc"}}
+
+	if _, err := tg.Show(); err == nil || !strings.Contains(err.Error(), "unit vpc") {
+		t.Errorf("expected the unit of the broken plan to be named, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package engine
+
+type DiagnosticPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type DiagnosticRange struct {
+	Filename string        `json:"filename"`
+	Start    DiagnosticPos `json:"start"`
+	End      DiagnosticPos `json:"end"`
+}
+
+type Diagnostic struct {
+	Severity string           `json:"severity"` // error or warning
+	Summary  string           `json:"summary"`
+	Detail   string           `json:"detail"`
+	Range    *DiagnosticRange `json:"range,omitempty"`
+}
+
+type ValidateResult struct {
+	Valid        bool         `json:"valid"`
+	ErrorCount   int          `json:"error_count"`
+	WarningCount int          `json:"warning_count"`
+	Diagnostics  []Diagnostic `json:"diagnostics"`
+}
@@ -0,0 +1,49 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNoToken is returned by RequirePush for a request made without a token of its caller.
+var ErrNoToken = errors.New("github token is required")
+
+// ErrNoPushAccess is returned by RequirePush when the caller can not push to the repository.
+var ErrNoPushAccess = errors.New("github token can not push to the repository")
+
+// RequirePush checks that token belongs to someone who can push to the repository of repoURL.
+// Routes that change a repository, its state or its PRs, or that show its state, require it:
+// Prism acts with its own tokens there, so it must not do more than the caller could.
+func RequirePush(repoURL, token string) error {
+	if token == "" {
+		return ErrNoToken
+	}
+	owner, repo, err := ParseRepoURL(repoURL)
+	if err != nil {
+		return err
+	}
+
+	writable, err := NewClient(token).CanWrite(owner, repo)
+	if err != nil {
+		return fmt.Errorf("failed to check access to %s/%s: %w", owner, repo, err)
+	}
+	if !writable {
+		return fmt.Errorf("%w %s/%s", ErrNoPushAccess, owner, repo)
+	}
+	return nil
+}
+
+// AccessStatus returns the HTTP status to answer a RequirePush error with.
+func AccessStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNoToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrNoPushAccess):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInvalidRepoURL):
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
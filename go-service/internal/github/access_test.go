@@ -0,0 +1,38 @@
+package github
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequirePush(t *testing.T) {
+	fake := newFakeAppAPI(t)
+	t.Setenv("GITHUB_API_URL", fake.server.URL)
+
+	tests := []struct {
+		name    string
+		repoURL string
+		token   string
+		status  int
+	}{
+		{"caller without a token", "https://github.com/acme/infra.git", "", http.StatusUnauthorized},
+		{"caller who can only read the repository", "https://github.com/acme/infra.git", readerToken, http.StatusForbidden},
+		{"caller who can not read the repository", "https://github.com/acme/infra.git", strangerToken, http.StatusForbidden},
+		{"repository that is not on GitHub", "https://gitlab.com/acme/infra.git", writerToken, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RequirePush(tt.repoURL, tt.token)
+			if err == nil {
+				t.Fatal("expected the caller to be rejected")
+			}
+			if status := AccessStatus(err); status != tt.status {
+				t.Errorf("expected status %d, got %d: %v", tt.status, status, err)
+			}
+		})
+	}
+
+	if err := RequirePush("https://github.com/acme/infra.git", writerToken); err != nil {
+		t.Errorf("expected a caller who can push to be let through, got %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benkamin03/prism/internal/store"
+)
+
+// Installation tokens live for an hour, refresh them a little early so a clone never starts with a token about to expire
+const installationTokenRefreshMargin = 5 * time.Minute
+
+// How long a caller's write access to a repository is trusted once GitHub confirmed it
+const accessCacheTTL = 5 * time.Minute
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// installationCall is a token request in flight, callers for the same repository wait for it.
+type installationCall struct {
+	done  chan struct{}
+	token *installationToken
+	err   error
+}
+
+// ImportedRepositories finds the repositories imported into Prism, store.Store implements it.
+type ImportedRepositories interface {
+	FindImportedRepositoryByName(owner, name string) (*store.ImportedRepository, error)
+}
+
+// App authenticates as a GitHub App and hands out installation tokens, cached per repository.
+type App struct {
+	appID        string
+	privateKey   *rsa.PrivateKey
+	baseURL      string
+	httpClient   *http.Client
+	repositories ImportedRepositories
+
+	mu      sync.Mutex
+	tokens  map[string]*installationToken
+	pending map[string]*installationCall
+	// access holds until when a personal token was confirmed to push to a repository
+	access map[string]time.Time
+}
+
+// NewApp creates the App, its installation tokens are only handed to callers for repositories
+// found in repositories.
+func NewApp(appID string, privateKeyPEM []byte, repositories ImportedRepositories) (*App, error) {
+	privateKey, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &App{
+		appID:        appID,
+		privateKey:   privateKey,
+		baseURL:      NewClient("").BaseURL,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		repositories: repositories,
+		tokens:       map[string]*installationToken{},
+		pending:      map[string]*installationCall{},
+		access:       map[string]time.Time{},
+	}, nil
+}
+
+func parsePrivateKey(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
+	// Secret stores often keep multi-line values with escaped newlines
+	if !strings.Contains(string(privateKeyPEM), "\n") {
+		privateKeyPEM = []byte(strings.ReplaceAll(string(privateKeyPEM), `\n`, "\n"))
+	}
+
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("failed to decode GitHub App private key PEM")
+	}
+
+	// GitHub issues PKCS#1 keys, accept PKCS#8 too in case the key was converted
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("GitHub App private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// JWT signs the short-lived token the App uses to call the /app endpoints.
+func (a *App) JWT() (string, error) {
+	now := time.Now()
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]interface{}{
+		"iat": now.Add(-60 * time.Second).Unix(), // allow for clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),   // GitHub rejects anything over 10 minutes
+		"iss": a.appID,
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (a *App) appRequest(method, path string, out interface{}, expected int) error {
+	jwt, err := a.JWT()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, a.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	client := &Client{BaseURL: a.baseURL, HTTPClient: a.httpClient}
+	return client.do(req, out, expected)
+}
+
+// InstallationToken returns a token scoped to the App's installation on the repository.
+// Concurrent callers for the same repository wait for a single request, without holding up
+// the other repositories.
+func (a *App) InstallationToken(owner, repo string) (string, error) {
+	key := strings.ToLower(owner + "/" + repo)
+
+	a.mu.Lock()
+	if cached, ok := a.tokens[key]; ok && time.Until(cached.ExpiresAt) > installationTokenRefreshMargin {
+		a.mu.Unlock()
+		return cached.Token, nil
+	}
+	if call, ok := a.pending[key]; ok {
+		a.mu.Unlock()
+		<-call.done
+		if call.err != nil {
+			return "", call.err
+		}
+		return call.token.Token, nil
+	}
+	call := &installationCall{done: make(chan struct{})}
+	a.pending[key] = call
+	a.mu.Unlock()
+
+	call.token, call.err = a.createInstallationToken(owner, repo)
+
+	a.mu.Lock()
+	delete(a.pending, key)
+	if call.err == nil {
+		a.tokens[key] = call.token
+	}
+	a.mu.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		return "", call.err
+	}
+	return call.token.Token, nil
+}
+
+func (a *App) createInstallationToken(owner, repo string) (*installationToken, error) {
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	if err := a.appRequest("GET", fmt.Sprintf("/repos/%s/%s/installation", owner, repo), &installation, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to find installation for %s/%s: %w", owner, repo, err)
+	}
+
+	var token installationToken
+	if err := a.appRequest("POST", fmt.Sprintf("/app/installations/%d/access_tokens", installation.ID), &token, http.StatusCreated); err != nil {
+		return nil, fmt.Errorf("failed to create installation token for %s/%s: %w", owner, repo, err)
+	}
+	return &token, nil
+}
+
+// TokenFor picks the token for a request on a repository. The installation token can push,
+// force-push and delete branches, so it is only used for repositories imported into Prism, and
+// only when the caller's personal token has push or admin access to the repository itself: the
+// App never lets someone change a repository they could not change themselves.
+// Otherwise, and for a nil App, the personal token is used as is.
+func (a *App) TokenFor(repoURL, personalToken string) string {
+	if a == nil || personalToken == "" {
+		return personalToken
+	}
+
+	owner, repo, err := ParseRepoURL(repoURL)
+	if err != nil {
+		return personalToken
+	}
+	if a.repositories == nil {
+		return personalToken
+	}
+	imported, err := a.repositories.FindImportedRepositoryByName(owner, repo)
+	if err != nil {
+		log.Printf("Using personal token for %s/%s: %v", owner, repo, err)
+		return personalToken
+	}
+	if imported == nil {
+		return personalToken
+	}
+	if ok, err := a.canPush(owner, repo, personalToken); !ok {
+		if err != nil {
+			log.Printf("Using personal token for %s/%s: %v", owner, repo, err)
+		}
+		return personalToken
+	}
+	return a.RepositoryToken(repoURL, personalToken)
+}
+
+// RepositoryToken picks the token for work Prism does on an imported repository by itself, like
+// webhooks and drift checks: an installation token when the App is configured and installed on
+// it, otherwise the stored token of the user who imported it.
+// A nil App always falls back to the stored token.
+func (a *App) RepositoryToken(repoURL, storedToken string) string {
+	if a == nil {
+		return storedToken
+	}
+
+	owner, repo, err := ParseRepoURL(repoURL)
+	if err != nil {
+		return storedToken
+	}
+
+	token, err := a.InstallationToken(owner, repo)
+	if err != nil {
+		log.Printf("Falling back to personal token for %s/%s: %v", owner, repo, err)
+		return storedToken
+	}
+	return token
+}
+
+// canPush reports whether personalToken can push to the repository, remembering confirmed access
+// for a few minutes so requests do not each ask GitHub again.
+func (a *App) canPush(owner, repo, personalToken string) (bool, error) {
+	digest := sha256.Sum256([]byte(personalToken))
+	key := base64.RawURLEncoding.EncodeToString(digest[:]) + ":" + strings.ToLower(owner+"/"+repo)
+
+	a.mu.Lock()
+	until, ok := a.access[key]
+	a.mu.Unlock()
+	if ok && time.Now().Before(until) {
+		return true, nil
+	}
+
+	client := &Client{BaseURL: a.baseURL, Token: personalToken, HTTPClient: a.httpClient}
+	writable, err := client.CanWrite(owner, repo)
+	if err != nil || !writable {
+		return false, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for cached, until := range a.access {
+		if time.Now().After(until) {
+			delete(a.access, cached)
+		}
+	}
+	a.access[key] = time.Now().Add(accessCacheTTL)
+	return true, nil
+}
@@ -0,0 +1,190 @@
+package github
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benkamin03/prism/internal/store"
+)
+
+const (
+	installationTokenValue = "ghs_installation"
+	writerToken            = "ghp_writer"
+	readerToken            = "ghp_reader"
+	strangerToken          = "ghp_stranger"
+)
+
+// fakeRepositories lists the imported repositories by owner/name.
+type fakeRepositories map[string]bool
+
+func (f fakeRepositories) FindImportedRepositoryByName(owner, name string) (*store.ImportedRepository, error) {
+	if !f[owner+"/"+name] {
+		return nil, nil
+	}
+	return &store.ImportedRepository{Owner: owner, Name: name}, nil
+}
+
+// fakeAppAPI serves the installation endpoints, and the repositories writerToken can push to and
+// readerToken can only read.
+type fakeAppAPI struct {
+	server        *httptest.Server
+	tokenRequests atomic.Int32
+	reads         atomic.Int32
+	// release holds token requests until it is closed, nil to answer right away
+	release chan struct{}
+}
+
+func newFakeAppAPI(t *testing.T) *fakeAppAPI {
+	t.Helper()
+	fake := &fakeAppAPI{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/{owner}/{repo}", func(w http.ResponseWriter, r *http.Request) {
+		fake.reads.Add(1)
+		var permissions map[string]bool
+		switch r.Header.Get("Authorization") {
+		case "token " + writerToken:
+			permissions = map[string]bool{"admin": false, "push": true, "pull": true}
+		case "token " + readerToken:
+			permissions = map[string]bool{"admin": false, "push": false, "pull": true}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"full_name":   r.PathValue("owner") + "/" + r.PathValue("repo"),
+			"permissions": permissions,
+		})
+	})
+	mux.HandleFunc("GET /repos/{owner}/{repo}/installation", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int64{"id": 7})
+	})
+	mux.HandleFunc("POST /app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		fake.tokenRequests.Add(1)
+		if fake.release != nil {
+			<-fake.release
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(installationToken{Token: installationTokenValue, ExpiresAt: time.Now().Add(time.Hour)})
+	})
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func newTestApp(t *testing.T, fake *fakeAppAPI, repositories ImportedRepositories) *App {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	app, err := NewApp("12345", keyPEM, repositories)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	app.baseURL = fake.server.URL
+	return app
+}
+
+func TestTokenForImportedRepositoryTheCallerCanPushTo(t *testing.T) {
+	fake := newFakeAppAPI(t)
+	app := newTestApp(t, fake, fakeRepositories{"acme/infra": true})
+
+	for i := 0; i < 2; i++ {
+		if got := app.TokenFor("https://github.com/acme/infra.git", writerToken); got != installationTokenValue {
+			t.Errorf("expected the installation token, got %q", got)
+		}
+	}
+	if fake.reads.Load() != 1 {
+		t.Errorf("expected the access of the caller to be checked once, got %d", fake.reads.Load())
+	}
+}
+
+func TestTokenForKeepsThePersonalToken(t *testing.T) {
+	fake := newFakeAppAPI(t)
+	app := newTestApp(t, fake, fakeRepositories{"acme/infra": true})
+
+	tests := []struct {
+		name    string
+		repoURL string
+		token   string
+	}{
+		{"repository that was not imported", "https://github.com/acme/other.git", writerToken},
+		{"caller who can only read the repository", "https://github.com/acme/infra.git", readerToken},
+		{"caller who can not read the repository", "https://github.com/acme/infra.git", strangerToken},
+		{"caller without a token", "https://github.com/acme/infra.git", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := app.TokenFor(tt.repoURL, tt.token); got != tt.token {
+				t.Errorf("expected the personal token, got %q", got)
+			}
+		})
+	}
+	if fake.tokenRequests.Load() != 0 {
+		t.Errorf("expected no installation token to be created, got %d", fake.tokenRequests.Load())
+	}
+
+	var nilApp *App
+	if got := nilApp.TokenFor("https://github.com/acme/infra.git", writerToken); got != writerToken {
+		t.Errorf("expected a nil App to keep the personal token, got %q", got)
+	}
+}
+
+func TestRepositoryToken(t *testing.T) {
+	fake := newFakeAppAPI(t)
+	app := newTestApp(t, fake, nil)
+
+	if got := app.RepositoryToken("https://github.com/acme/infra.git", "stored"); got != installationTokenValue {
+		t.Errorf("expected the installation token, got %q", got)
+	}
+	if got := app.RepositoryToken("not a url", "stored"); got != "stored" {
+		t.Errorf("expected the stored token, got %q", got)
+	}
+}
+
+func TestInstallationTokenSingleRequest(t *testing.T) {
+	fake := newFakeAppAPI(t)
+	fake.release = make(chan struct{})
+	app := newTestApp(t, fake, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := app.InstallationToken("acme", "infra"); err != nil || token != installationTokenValue {
+				t.Errorf("unexpected token %q: %v", token, err)
+			}
+		}()
+	}
+
+	// Other callers are not held up while the request is in flight
+	app.mu.Lock()
+	app.tokens["acme/cached"] = &installationToken{Token: "ghs_cached", ExpiresAt: time.Now().Add(time.Hour)}
+	app.mu.Unlock()
+	if token, err := app.InstallationToken("acme", "cached"); err != nil || token != "ghs_cached" {
+		t.Errorf("expected the cached token while another request is in flight, got %q %v", token, err)
+	}
+
+	close(fake.release)
+	wg.Wait()
+	if fake.tokenRequests.Load() != 1 {
+		t.Errorf("expected concurrent callers to share one request, got %d", fake.tokenRequests.Load())
+	}
+}
@@ -0,0 +1,133 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	CheckName     = "Prism plan"
+	StatusContext = "prism/plan"
+
+	// GitHub rejects check run updates with more than 50 annotations
+	maxAnnotations = 50
+	// and commit status descriptions longer than 140 characters
+	maxStatusDescription = 140
+)
+
+type CommitStatus struct {
+	State       string `json:"state"` // error, failure, pending or success
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context"`
+}
+
+type CheckRunAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"` // notice, warning or failure
+	Title           string `json:"title,omitempty"`
+	Message         string `json:"message"`
+}
+
+type CheckRunOutput struct {
+	Title       string               `json:"title"`
+	Summary     string               `json:"summary"`
+	Text        string               `json:"text,omitempty"`
+	Annotations []CheckRunAnnotation `json:"annotations,omitempty"`
+}
+
+type CheckRun struct {
+	ID         int64           `json:"id,omitempty"`
+	Name       string          `json:"name"`
+	HeadSHA    string          `json:"head_sha"`
+	Status     string          `json:"status,omitempty"`     // queued, in_progress or completed
+	Conclusion string          `json:"conclusion,omitempty"` // success, failure, neutral, ...
+	Output     *CheckRunOutput `json:"output,omitempty"`
+}
+
+func (c *Client) CreateCommitStatus(owner, repo, sha string, status *CommitStatus) error {
+	if len(status.Description) > maxStatusDescription {
+		status.Description = status.Description[:maxStatusDescription-3] + "..."
+	}
+
+	req, err := c.newRequest("POST", fmt.Sprintf("/repos/%s/%s/statuses/%s", owner, repo, sha), status)
+	if err != nil {
+		return err
+	}
+
+	if err := c.do(req, nil, http.StatusCreated); err != nil {
+		return fmt.Errorf("failed to create commit status: %w", err)
+	}
+	return nil
+}
+
+// CreateCheckRun requires a GitHub App token, personal tokens get a 403 or 404.
+func (c *Client) CreateCheckRun(owner, repo string, checkRun *CheckRun) (*CheckRun, error) {
+	if checkRun.Output != nil && len(checkRun.Output.Annotations) > maxAnnotations {
+		checkRun.Output.Annotations = checkRun.Output.Annotations[:maxAnnotations]
+	}
+
+	req, err := c.newRequest("POST", fmt.Sprintf("/repos/%s/%s/check-runs", owner, repo), checkRun)
+	if err != nil {
+		return nil, err
+	}
+
+	var created CheckRun
+	if err := c.do(req, &created, http.StatusCreated); err != nil {
+		return nil, fmt.Errorf("failed to create check run: %w", err)
+	}
+	return &created, nil
+}
+
+type PlanReport struct {
+	SHA         string
+	Pending     bool
+	Success     bool
+	Title       string
+	Summary     string
+	Text        string
+	Annotations []CheckRunAnnotation
+}
+
+// ReportPlan publishes a plan result on a commit as a check run, falling back to a
+// commit status when the token is not allowed to create check runs. Later reports
+// for the same commit supersede earlier ones.
+func (c *Client) ReportPlan(owner, repo string, report *PlanReport) error {
+	status, conclusion, state := "completed", "success", "success"
+	switch {
+	case report.Pending:
+		status, conclusion, state = "queued", "", "pending"
+	case !report.Success:
+		conclusion, state = "failure", "failure"
+	}
+
+	_, err := c.CreateCheckRun(owner, repo, &CheckRun{
+		Name:       CheckName,
+		HeadSHA:    report.SHA,
+		Status:     status,
+		Conclusion: conclusion,
+		Output: &CheckRunOutput{
+			Title:       report.Title,
+			Summary:     report.Summary,
+			Text:        report.Text,
+			Annotations: report.Annotations,
+		},
+	})
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || (apiErr.StatusCode != http.StatusForbidden && apiErr.StatusCode != http.StatusNotFound) {
+		return err
+	}
+
+	return c.CreateCommitStatus(owner, repo, report.SHA, &CommitStatus{
+		State:       state,
+		Description: report.Title,
+		Context:     StatusContext,
+	})
+}
@@ -0,0 +1,287 @@
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/redact"
+)
+
+const defaultBaseURL = "https://api.github.com"
+
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client authenticated with a personal or OAuth token.
+// GITHUB_API_URL overrides the API endpoint (e.g. for GitHub Enterprise or a local fake).
+func NewClient(token string) *Client {
+	baseURL := os.Getenv("GITHUB_API_URL")
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{},
+	}
+}
+
+type CreatePRRequest struct {
+	Title string `json:"title"`
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+	Body  string `json:"body"`
+}
+
+type UpdatePRRequest struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+	State string `json:"state,omitempty"` // open or closed
+}
+
+type PullRequestRepo struct {
+	ID       int64  `json:"id"`
+	FullName string `json:"full_name"`
+}
+
+type PullRequestRef struct {
+	Ref  string          `json:"ref"`
+	SHA  string          `json:"sha"`
+	Repo PullRequestRepo `json:"repo"`
+}
+
+type PullRequest struct {
+	Number         int            `json:"number"`
+	HTMLURL        string         `json:"html_url"`
+	Title          string         `json:"title"`
+	Body           string         `json:"body"`
+	State          string         `json:"state"`
+	Merged         bool           `json:"merged"`
+	Mergeable      *bool          `json:"mergeable"` // nil while GitHub is still computing it
+	MergeableState string         `json:"mergeable_state"`
+	Head           PullRequestRef `json:"head"`
+	Base           PullRequestRef `json:"base"`
+}
+
+// ErrInvalidRepoURL is returned for URLs that do not name a GitHub repository.
+var ErrInvalidRepoURL = errors.New("invalid repo URL format")
+
+// ParseRepoURL extracts the owner and repository name from a GitHub clone URL.
+// Example: https://github.com/drshooby/test-terraform-repo.git -> drshooby, test-terraform-repo
+func ParseRepoURL(repoURL string) (string, string, error) {
+	repoPath := strings.TrimPrefix(repoURL, "https://github.com/")
+	repoPath = strings.TrimSuffix(repoPath, ".git")
+	parts := strings.Split(repoPath, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidRepoURL, repoURL)
+	}
+	return parts[0], parts[1], nil
+}
+
+func (c *Client) newRequest(method, path string, payload interface{}) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		// Comments, statuses and pull request bodies quote plan output
+		body = bytes.NewBuffer(redact.Default.Bytes(jsonData))
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("token %s", c.Token))
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends the request and decodes the response into out when the status matches one of expected.
+func (c *Client) do(req *http.Request, out interface{}, expected ...int) error {
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			if out == nil {
+				return nil
+			}
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+			return nil
+		}
+	}
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	return &APIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+}
+
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// RepositoryPermissions is what a token may do on a repository.
+type RepositoryPermissions struct {
+	Admin bool `json:"admin"`
+	Push  bool `json:"push"`
+	Pull  bool `json:"pull"`
+}
+
+// Permissions returns what the token of the client may do on the repository, nil when it can not
+// see the repository. GitHub answers 404 for private repositories the token can not see.
+func (c *Client) Permissions(owner, repo string) (*RepositoryPermissions, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/repos/%s/%s", owner, repo), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var repository struct {
+		Permissions RepositoryPermissions `json:"permissions"`
+	}
+	if err := c.do(req, &repository, http.StatusOK); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &repository.Permissions, nil
+}
+
+// Login returns the login of the user the token of the client belongs to.
+func (c *Client) Login() (string, error) {
+	req, err := c.newRequest("GET", "/user", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := c.do(req, &user, http.StatusOK); err != nil {
+		return "", fmt.Errorf("failed to get the user of the token: %w", err)
+	}
+	return user.Login, nil
+}
+
+// CanRead reports whether the token of the client can read the repository.
+func (c *Client) CanRead(owner, repo string) (bool, error) {
+	permissions, err := c.Permissions(owner, repo)
+	if err != nil {
+		return false, err
+	}
+	return permissions != nil, nil
+}
+
+// CanWrite reports whether the token of the client can push to the repository. Any token can
+// read a public repository, so reading alone says nothing about what its owner may change.
+func (c *Client) CanWrite(owner, repo string) (bool, error) {
+	permissions, err := c.Permissions(owner, repo)
+	if err != nil {
+		return false, err
+	}
+	return permissions != nil && (permissions.Push || permissions.Admin), nil
+}
+
+func (c *Client) BranchExists(owner, repo, branchName string) (bool, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/repos/%s/%s/branches/%s", owner, repo, branchName), nil)
+	if err != nil {
+		return false, err
+	}
+
+	if err := c.do(req, nil, http.StatusOK); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Client) CreatePullRequest(owner, repo string, prRequest *CreatePRRequest) (*PullRequest, error) {
+	req, err := c.newRequest("POST", fmt.Sprintf("/repos/%s/%s/pulls", owner, repo), prRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var pr PullRequest
+	if err := c.do(req, &pr, http.StatusCreated); err != nil {
+		return nil, fmt.Errorf("failed to create PR: %w", err)
+	}
+	return &pr, nil
+}
+
+// FindOpenPullRequest returns the open pull request whose head is the given branch, or nil if there is none.
+func (c *Client) FindOpenPullRequest(owner, repo, head string) (*PullRequest, error) {
+	query := url.Values{}
+	query.Set("state", "open")
+	query.Set("head", fmt.Sprintf("%s:%s", owner, head))
+	path := fmt.Sprintf("/repos/%s/%s/pulls?%s", owner, repo, query.Encode())
+	req, err := c.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var prs []PullRequest
+	if err := c.do(req, &prs, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to list PRs: %w", err)
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return &prs[0], nil
+}
+
+func (c *Client) UpdatePullRequest(owner, repo string, number int, prRequest *UpdatePRRequest) (*PullRequest, error) {
+	req, err := c.newRequest("PATCH", fmt.Sprintf("/repos/%s/%s/pulls/%d", owner, repo, number), prRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var pr PullRequest
+	if err := c.do(req, &pr, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to update PR #%d: %w", number, err)
+	}
+	return &pr, nil
+}
+
+// AuthenticatedCloneURL embeds the token in an HTTPS clone URL so git can clone and push without a credential helper.
+// Both personal and installation tokens are accepted as the password of the x-access-token user.
+func AuthenticatedCloneURL(repoURL, token string) (string, error) {
+	parsed, err := url.Parse(repoURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid repo URL %s: %w", repoURL, err)
+	}
+	if token == "" || parsed.Scheme != "https" {
+		return repoURL, nil
+	}
+	parsed.User = url.UserPassword("x-access-token", token)
+	return parsed.String(), nil
+}
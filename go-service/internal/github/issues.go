@@ -0,0 +1,57 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type IssueComment struct {
+	ID      int64  `json:"id,omitempty"`
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url,omitempty"`
+}
+
+type collaboratorPermission struct {
+	Permission string `json:"permission"` // admin, write, read or none
+}
+
+func (c *Client) GetPullRequest(owner, repo string, number int) (*PullRequest, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/repos/%s/%s/pulls/%d", owner, repo, number), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var pr PullRequest
+	if err := c.do(req, &pr, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to get PR #%d: %w", number, err)
+	}
+	return &pr, nil
+}
+
+// CreateIssueComment comments on an issue or pull request, they share the same numbering.
+func (c *Client) CreateIssueComment(owner, repo string, number int, body string) (*IssueComment, error) {
+	req, err := c.newRequest("POST", fmt.Sprintf("/repos/%s/%s/issues/%d/comments", owner, repo, number), &IssueComment{Body: body})
+	if err != nil {
+		return nil, err
+	}
+
+	var comment IssueComment
+	if err := c.do(req, &comment, http.StatusCreated); err != nil {
+		return nil, fmt.Errorf("failed to comment on #%d: %w", number, err)
+	}
+	return &comment, nil
+}
+
+// CanPush reports whether the user has write or admin access to the repository.
+func (c *Client) CanPush(owner, repo, username string) (bool, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/repos/%s/%s/collaborators/%s/permission", owner, repo, username), nil)
+	if err != nil {
+		return false, err
+	}
+
+	var permission collaboratorPermission
+	if err := c.do(req, &permission, http.StatusOK); err != nil {
+		return false, fmt.Errorf("failed to get permission of %s: %w", username, err)
+	}
+	return permission.Permission == "admin" || permission.Permission == "write", nil
+}
@@ -0,0 +1,90 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type CombinedStatus struct {
+	State    string `json:"state"` // failure, pending or success
+	Statuses []struct {
+		Context string `json:"context"`
+		State   string `json:"state"`
+	} `json:"statuses"`
+}
+
+type checkRunList struct {
+	CheckRuns []CheckRun `json:"check_runs"`
+}
+
+type Review struct {
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	State string `json:"state"` // APPROVED, CHANGES_REQUESTED, COMMENTED, ...
+}
+
+// AddLabels adds labels to a pull request, creating any that do not exist yet.
+func (c *Client) AddLabels(owner, repo string, number int, labels []string) error {
+	payload := map[string][]string{"labels": labels}
+	req, err := c.newRequest("POST", fmt.Sprintf("/repos/%s/%s/issues/%d/labels", owner, repo, number), payload)
+	if err != nil {
+		return err
+	}
+
+	if err := c.do(req, nil, http.StatusOK); err != nil {
+		return fmt.Errorf("failed to label #%d: %w", number, err)
+	}
+	return nil
+}
+
+func (c *Client) GetCombinedStatus(owner, repo, ref string) (*CombinedStatus, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/repos/%s/%s/commits/%s/status", owner, repo, ref), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status CombinedStatus
+	if err := c.do(req, &status, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to get status of %s: %w", ref, err)
+	}
+	return &status, nil
+}
+
+func (c *Client) ListCheckRuns(owner, repo, ref string) ([]CheckRun, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/repos/%s/%s/commits/%s/check-runs", owner, repo, ref), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var list checkRunList
+	if err := c.do(req, &list, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to list check runs of %s: %w", ref, err)
+	}
+	return list.CheckRuns, nil
+}
+
+func (c *Client) ListReviews(owner, repo string, number int) ([]Review, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/repos/%s/%s/pulls/%d/reviews", owner, repo, number), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var reviews []Review
+	if err := c.do(req, &reviews, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to list reviews of #%d: %w", number, err)
+	}
+	return reviews, nil
+}
+
+func (c *Client) DeleteBranch(owner, repo, branchName string) error {
+	req, err := c.newRequest("DELETE", fmt.Sprintf("/repos/%s/%s/git/refs/heads/%s", owner, repo, branchName), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := c.do(req, nil, http.StatusNoContent); err != nil {
+		return fmt.Errorf("failed to delete branch %s: %w", branchName, err)
+	}
+	return nil
+}
@@ -1,86 +1,282 @@
 package infisical
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net/http"
+	"sync"
 
 	"github.com/benkamin03/prism/internal/infisical/jsondefs"
+	"github.com/benkamin03/prism/internal/secrets"
 	infisical "github.com/infisical/go-sdk"
+	infisicalerrors "github.com/infisical/go-sdk/packages/errors"
+	"github.com/infisical/go-sdk/packages/models"
 )
 
-var PersistentConfig InfisicalClientConfig
-
 type InfisicalClientConfig struct {
 	SiteUrl               string
 	InfisicalClientID     string
 	InfisicalClientSecret string
 }
 
-type InfisicalSecretOptions struct {
-	Environment string
-	ProjectID   string
-	SecretPath  string
-}
-
+// InfisicalClient is the secrets.Provider backed by an Infisical instance.
 type InfisicalClient struct {
 	client infisical.InfisicalClientInterface
+	config InfisicalClientConfig
+	tokens *TokenManager
+
+	mu sync.Mutex
+	// sdkToken is the token last handed to the SDK client
+	sdkToken string
 }
 
-func NewInfisicalClient(config *InfisicalClientConfig) (*InfisicalClient, error) {
+// NewInfisicalClient creates the client without logging in, Login or the first request does.
+func NewInfisicalClient(config *InfisicalClientConfig) *InfisicalClient {
 	infisicalClientInterface := infisical.NewInfisicalClient(context.Background(), infisical.Config{
-		SiteUrl:          config.SiteUrl,
-		AutoTokenRefresh: true,
+		SiteUrl: config.SiteUrl,
+		// The token manager renews the token, the SDK only gets to use it
+		AutoTokenRefresh: false,
 	})
 
-	if infisicalClientInterface == nil {
-		log.Fatal("infisicalClient is nil")
+	return &InfisicalClient{
+		client: infisicalClientInterface,
+		config: *config,
+		tokens: NewTokenManager(config),
+	}
+}
+
+// Login authenticates the machine identity unless a valid token is cached. A failed login is
+// tried again by the next request, so Infisical may start after the service.
+//
+// For machine identity (what go sdk uses)
+// 1. Org -> Access Control -> Identities -> Create Identity w/ Member Role
+// 2. Secrets Manager -> Access Management -> Machine Identities -> Add Identity -> Select w/ Developer Role
+// 3. Org -> Access Control -> Identities -> Click Identity -> Universal Auth
+// -> Copy Client ID -> Create Client Secret -> Copy Client Secret
+func (c *InfisicalClient) Login() error {
+	if _, err := c.tokens.Token(context.Background()); err != nil {
+		return fmt.Errorf("infisical authentication failed: %w", err)
 	}
+	return nil
+}
 
-	// For machine identity (what go sdk uses)
-	// 1. Org -> Access Control -> Identities -> Create Identity w/ Member Role
-	// 2. Secrets Manager -> Access Management -> Machine Identities -> Add Identity -> Select w/ Developer Role
-	// 3. Org -> Access Control -> Identities -> Click Identity -> Universal Auth
-	// -> Copy Client ID -> Create Client Secret -> Copy Client Secret
-	_, err := infisicalClientInterface.Auth().UniversalAuthLogin(config.InfisicalClientID, config.InfisicalClientSecret)
+// call runs an SDK request with the current token. A request rejected with 401 runs once more
+// with a fresh token, in case Infisical revoked the cached one.
+func (c *InfisicalClient) call(ctx context.Context, request func() error) error {
+	for attempt := 0; ; attempt++ {
+		token, err := c.tokens.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("infisical authentication failed: %w", err)
+		}
+		c.mu.Lock()
+		if c.sdkToken != token {
+			c.client.Auth().SetAccessToken(token)
+			c.sdkToken = token
+		}
+		c.mu.Unlock()
+
+		err = request()
+		if attempt > 0 || !hasStatus(err, http.StatusUnauthorized) {
+			return err
+		}
+		c.tokens.Invalidate(token)
+	}
+}
+
+func (c *InfisicalClient) List(ctx context.Context, options *secrets.Options) (map[string]string, error) {
+	var list []models.Secret
+	err := c.call(ctx, func() (err error) {
+		list, err = c.client.Secrets().List(infisical.ListSecretsOptions{
+			Environment: options.Environment,
+			ProjectID:   options.ProjectID,
+			SecretPath:  options.Path(),
+		})
+		return err
+	})
 	if err != nil {
-		panic(fmt.Sprintf("Authentication failed: %v", err))
+		return nil, fmt.Errorf("error listing secrets: %w", err)
 	}
 
-	PersistentConfig.SiteUrl = config.SiteUrl
-	PersistentConfig.InfisicalClientID = config.InfisicalClientID
-	PersistentConfig.InfisicalClientSecret = config.InfisicalClientSecret
+	secretsMap := make(map[string]string, len(list))
+	for _, secret := range list {
+		secretsMap[secret.SecretKey] = secret.SecretValue
+	}
+	return secretsMap, nil
+}
 
-	return &InfisicalClient{
-		client: infisicalClientInterface,
-	}, nil
+func (c *InfisicalClient) Get(ctx context.Context, options *secrets.Options, key string) (string, error) {
+	var secret models.Secret
+	err := c.call(ctx, func() (err error) {
+		secret, err = c.client.Secrets().Retrieve(infisical.RetrieveSecretOptions{
+			SecretKey:   key,
+			Environment: options.Environment,
+			ProjectID:   options.ProjectID,
+			SecretPath:  options.Path(),
+		})
+		return err
+	})
+	if isNotFound(err) {
+		return "", secrets.ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting secret %s: %w", key, err)
+	}
+	return secret.SecretValue, nil
 }
 
-func NewListSecretOptions(base InfisicalSecretOptions) infisical.ListSecretsOptions {
-	return infisical.ListSecretsOptions{
-		Environment:        base.Environment,
-		ProjectID:          base.ProjectID,
-		SecretPath:         base.SecretPath,
-		AttachToProcessEnv: true, // access secrets with getEnv
+func (c *InfisicalClient) Set(ctx context.Context, options *secrets.Options, key, value string) error {
+	err := c.call(ctx, func() error {
+		_, err := c.client.Secrets().Update(infisical.UpdateSecretOptions{
+			SecretKey:      key,
+			Environment:    options.Environment,
+			ProjectID:      options.ProjectID,
+			SecretPath:     options.Path(),
+			NewSecretValue: value,
+		})
+		return err
+	})
+	if isNotFound(err) {
+		err = c.call(ctx, func() error {
+			_, err := c.client.Secrets().Create(infisical.CreateSecretOptions{
+				SecretKey:   key,
+				Environment: options.Environment,
+				ProjectID:   options.ProjectID,
+				SecretPath:  options.Path(),
+				SecretValue: value,
+			})
+			return err
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("error setting secret %s: %w", key, err)
 	}
+	return nil
 }
 
-func (infisicalClient InfisicalClient) ListSecrets(options *InfisicalSecretOptions) *jsondefs.GetSecretResponse {
-	secrets, err := infisicalClient.client.Secrets().List(NewListSecretOptions(*options))
+func (c *InfisicalClient) Delete(ctx context.Context, options *secrets.Options, key string) error {
+	err := c.call(ctx, func() error {
+		_, err := c.client.Secrets().Delete(infisical.DeleteSecretOptions{
+			SecretKey:   key,
+			Environment: options.Environment,
+			ProjectID:   options.ProjectID,
+			SecretPath:  options.Path(),
+		})
+		return err
+	})
+	if isNotFound(err) {
+		return secrets.ErrNotFound
+	}
 	if err != nil {
-		return &jsondefs.GetSecretResponse{
-			StatusCode: 500,
-			Error:      fmt.Sprintf("Error getting secrets: %v", err),
-		}
+		return fmt.Errorf("error deleting secret %s: %w", key, err)
 	}
+	return nil
+}
 
-	secretsMap := make(map[string]string, len(secrets))
-	for _, secret := range secrets {
-		secretsMap[secret.SecretKey] = secret.SecretValue
+func (c *InfisicalClient) ListFolders(ctx context.Context, options *secrets.Options) ([]string, error) {
+	var list []models.Folder
+	err := c.call(ctx, func() (err error) {
+		list, err = c.client.Folders().List(infisical.ListFoldersOptions{
+			ProjectID:   options.ProjectID,
+			Environment: options.Environment,
+			Path:        options.Path(),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing folders: %w", err)
+	}
+
+	folders := make([]string, 0, len(list))
+	for _, folder := range list {
+		folders = append(folders, folder.Name)
+	}
+	return folders, nil
+}
+
+// ListEnvironments reads the environments of a project, the SDK has no call for them.
+func (c *InfisicalClient) ListEnvironments(ctx context.Context, projectID string) ([]secrets.Environment, error) {
+	url := fmt.Sprintf("%s/api/v1/workspace/%s", c.tokens.siteURL, projectID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.tokens.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	return &jsondefs.GetSecretResponse{
-		Secrets:    secretsMap,
-		StatusCode: 200,
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("project %s not found", projectID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("api returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Workspace struct {
+			Environments []secrets.Environment `json:"environments"`
+		} `json:"workspace"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
+	if result.Workspace.Environments == nil {
+		return []secrets.Environment{}, nil
+	}
+	return result.Workspace.Environments, nil
+}
+
+// CreateProject creates an Infisical project, the SDK has no call for it.
+func (c *InfisicalClient) CreateProject(ctx context.Context, request *jsondefs.CreateProjectRequest) (*jsondefs.CreateProjectResponse, error) {
+	bodyBytes, err := json.Marshal(map[string]interface{}{
+		"projectName":        request.ProjectName,
+		"projectDescription": request.ProjectDescription,
+		"slug":               request.Slug,
+		"type":               request.TypeField,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/v2/workspace", c.tokens.siteURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.tokens.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("api returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Project jsondefs.CreateProjectResponse `json:"project"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result.Project, nil
+}
+
+func isNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+func hasStatus(err error, statusCode int) bool {
+	var apiError *infisicalerrors.APIError
+	return errors.As(err, &apiError) && apiError.StatusCode == statusCode
 }
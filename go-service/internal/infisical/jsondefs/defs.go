@@ -4,14 +4,38 @@ type ListSecretsRequest struct {
 	Environment string `json:"environment" validate:"required"`
 	ProjectID   string `json:"projectId" validate:"required"`
 	SecretPath  string `json:"secretPath" validate:"required"`
+	// Reveal asks for the values, which needs the reveal token
+	Reveal bool `json:"reveal,omitempty"`
 }
 
 type GetSecretResponse struct {
 	Secrets    map[string]string `json:"secret"`
+	Masked     bool              `json:"masked"`
 	StatusCode int               `json:"statusCode"`
 	Error      string            `json:"error,omitempty"`
 }
 
+type SecretRequest struct {
+	Environment string `json:"environment"`
+	ProjectID   string `json:"projectId"`
+	SecretPath  string `json:"secretPath,omitempty"`
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+}
+
+type SecretResponse struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Masked bool   `json:"masked"`
+}
+
+type ImportSecretsResponse struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	// Skipped keys exist already and were kept, as overwrite was not set
+	Skipped []string `json:"skipped"`
+}
+
 type CreateProjectRequest struct {
 	ProjectName        string `json:"projectName"`
 	ProjectDescription string `json:"projectDescription,omitempty"`
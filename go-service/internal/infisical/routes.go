@@ -1,20 +1,32 @@
 package infisical
 
 import (
-	"bytes"
-	"encoding/json"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 
 	"github.com/benkamin03/prism/internal/infisical/jsondefs"
-	"github.com/benkamin03/prism/internal/infisical/utils"
+	"github.com/benkamin03/prism/internal/redact"
+	"github.com/benkamin03/prism/internal/secrets"
 	"github.com/labstack/echo/v4"
 )
 
+// RevealTokenHeader carries the token that allows a request to read secret values and to change
+// secrets, a request that may overwrite a secret may as well read it.
+const RevealTokenHeader = "X-Prism-Reveal-Token"
+
+// maxImportSize bounds an imported dotenv file
+const maxImportSize = 1 << 20
+
 type InfisicalRoutesConfig struct {
-	InfisicalClient InfisicalClient
-	Echo            *echo.Echo
+	Secrets secrets.Provider
+	// RevealToken must be sent in RevealTokenHeader to get unmasked values and to create, update,
+	// delete or import secrets, no request may when it is empty
+	RevealToken string
+	Echo        *echo.Echo
 }
 
 func SetupRoutes(routesConfig *InfisicalRoutesConfig) {
@@ -29,91 +41,295 @@ func SetupRoutes(routesConfig *InfisicalRoutesConfig) {
 		if err := c.Bind(&req); err != nil {
 			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
 		}
+		client, ok := routesConfig.Secrets.(*InfisicalClient)
+		if !ok {
+			return c.JSON(http.StatusNotImplemented, echo.Map{"error": "projects can only be created with the infisical secrets backend"})
+		}
 
-		accessToken, err := utils.GetInfisicalAccessToken(
-			PersistentConfig.InfisicalClientID,
-			PersistentConfig.InfisicalClientSecret,
-			PersistentConfig.SiteUrl,
-		)
+		project, err := client.CreateProject(c.Request().Context(), &req)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("auth failed: %v", err)})
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{
+			"id":   project.ID,
+			"name": project.Name,
+			"slug": project.Slug,
+		})
+	})
+
+	e.POST("/secrets/list", func(c echo.Context) error {
+		var req jsondefs.ListSecretsRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
 		}
 
-		payload := map[string]interface{}{
-			"projectName":        req.ProjectName,
-			"projectDescription": req.ProjectDescription,
-			"slug":               req.Slug,
-			"type":               req.TypeField,
+		if req.Environment == "" || req.ProjectID == "" || req.SecretPath == "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "missing required fields"})
+		}
+		if req.Reveal && !routesConfig.canReveal(c) {
+			return forbidden(c, "revealing secret values")
 		}
 
-		bodyBytes, err := json.Marshal(payload)
+		secretsMap, err := routesConfig.Secrets.List(c.Request().Context(), &secrets.Options{
+			Environment: req.Environment,
+			ProjectID:   req.ProjectID,
+			SecretPath:  req.SecretPath,
+		})
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed to marshal payload"})
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("error retrieving secrest: %v", err)})
+		}
+		if req.Reveal {
+			redact.Skip(c)
+		} else {
+			for key := range secretsMap {
+				secretsMap[key] = secrets.MaskedValue
+			}
+		}
+
+		getSecretResponse := &jsondefs.GetSecretResponse{
+			Secrets:    secretsMap,
+			Masked:     !req.Reveal,
+			StatusCode: http.StatusOK,
+		}
+		return c.JSON(http.StatusOK, getSecretResponse)
+	})
+
+	e.GET("/secrets/environments", func(c echo.Context) error {
+		projectID := c.QueryParam("projectId")
+		if projectID == "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "projectId is required"})
 		}
 
-		apiURL := fmt.Sprintf("%s/api/v2/workspace", PersistentConfig.SiteUrl)
-		httpReq, err := http.NewRequest("POST", apiURL, bytes.NewReader(bodyBytes))
+		environments, err := routesConfig.Secrets.ListEnvironments(c.Request().Context(), projectID)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed to create request"})
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 		}
+		return c.JSON(http.StatusOK, echo.Map{"environments": environments})
+	})
 
-		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-		httpReq.Header.Set("Content-Type", "application/json")
+	e.GET("/secrets/folders", func(c echo.Context) error {
+		options, err := queryOptions(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
 
-		client := &http.Client{}
-		resp, err := client.Do(httpReq)
+		folders, err := routesConfig.Secrets.ListFolders(c.Request().Context(), options)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed to send request"})
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 		}
-		defer resp.Body.Close()
+		return c.JSON(http.StatusOK, echo.Map{"secretPath": options.Path(), "folders": folders})
+	})
 
-		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-			bodyBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return c.JSON(http.StatusInternalServerError, echo.Map{"error": "api returned non-OK status and failed to read response body"})
-			}
+	e.GET("/secrets/secret/:key", func(c echo.Context) error {
+		options, err := queryOptions(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		reveal := c.QueryParam("reveal") == "true"
+		if reveal && !routesConfig.canReveal(c) {
+			return forbidden(c, "revealing secret values")
+		}
 
-			return c.JSON(http.StatusInternalServerError, echo.Map{
-				"error": fmt.Sprintf("api returned %d: %s", resp.StatusCode, string(bodyBytes)),
-			})
+		key := c.Param("key")
+		value, err := routesConfig.Secrets.Get(c.Request().Context(), options, key)
+		if errors.Is(err, secrets.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": fmt.Sprintf("secret %s not found", key)})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		if reveal {
+			redact.Skip(c)
 		}
+		return c.JSON(http.StatusOK, secretResponse(key, value, reveal))
+	})
 
-		var result struct {
-			Project jsondefs.CreateProjectResponse `json:"project"`
+	e.POST("/secrets/secret", func(c echo.Context) error {
+		if !routesConfig.canReveal(c) {
+			return forbidden(c, "creating secrets")
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed to decode response"})
+		var req jsondefs.SecretRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+		}
+		options, err := requestOptions(&req)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
 		}
 
-		return c.JSON(http.StatusOK, echo.Map{
-			"id":   result.Project.ID,
-			"name": result.Project.Name,
-			"slug": result.Project.Slug,
-		})
+		_, err = routesConfig.Secrets.Get(c.Request().Context(), options, req.Key)
+		if err == nil {
+			return c.JSON(http.StatusConflict, echo.Map{"error": fmt.Sprintf("secret %s already exists", req.Key)})
+		}
+		if !errors.Is(err, secrets.ErrNotFound) {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		if err := routesConfig.Secrets.Set(c.Request().Context(), options, req.Key, req.Value); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusCreated, secretResponse(req.Key, req.Value, false))
 	})
 
-	e.POST("/secrets/list", func(c echo.Context) error {
-		var req jsondefs.ListSecretsRequest
+	e.PUT("/secrets/secret/:key", func(c echo.Context) error {
+		if !routesConfig.canReveal(c) {
+			return forbidden(c, "updating secrets")
+		}
+		var req jsondefs.SecretRequest
 		if err := c.Bind(&req); err != nil {
 			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
 		}
+		req.Key = c.Param("key")
+		options, err := requestOptions(&req)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
 
-		if req.Environment == "" || req.ProjectID == "" || req.SecretPath == "" {
-			return c.JSON(http.StatusBadRequest, echo.Map{"error": "missing required fields"})
+		_, err = routesConfig.Secrets.Get(c.Request().Context(), options, req.Key)
+		if errors.Is(err, secrets.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": fmt.Sprintf("secret %s not found", req.Key)})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		if err := routesConfig.Secrets.Set(c.Request().Context(), options, req.Key, req.Value); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 		}
+		return c.JSON(http.StatusOK, secretResponse(req.Key, req.Value, false))
+	})
 
-		getSecretResponse := routesConfig.InfisicalClient.ListSecrets(&InfisicalSecretOptions{
-			Environment: req.Environment,
-			ProjectID:   req.ProjectID,
-			SecretPath:  req.SecretPath,
-		})
+	e.DELETE("/secrets/secret/:key", func(c echo.Context) error {
+		if !routesConfig.canReveal(c) {
+			return forbidden(c, "deleting secrets")
+		}
+		options, err := queryOptions(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
 
-		if getSecretResponse.StatusCode != http.StatusOK {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("error retrieving secrest: %v", getSecretResponse.Error)})
+		key := c.Param("key")
+		err = routesConfig.Secrets.Delete(c.Request().Context(), options, key)
+		if errors.Is(err, secrets.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": fmt.Sprintf("secret %s not found", key)})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 		}
+		return c.JSON(http.StatusOK, echo.Map{"deleted": true})
+	})
 
-		return c.JSON(http.StatusOK, getSecretResponse)
+	// Imports a dotenv file sent as the multipart field file, the other fields address the folder
+	e.POST("/secrets/import", func(c echo.Context) error {
+		if !routesConfig.canReveal(c) {
+			return forbidden(c, "importing secrets")
+		}
+		options := &secrets.Options{
+			Environment: c.FormValue("environment"),
+			ProjectID:   c.FormValue("projectId"),
+			SecretPath:  c.FormValue("secretPath"),
+		}
+		if options.Environment == "" || options.ProjectID == "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "environment and projectId are required"})
+		}
+		overwrite := c.FormValue("overwrite") == "true"
+
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "a dotenv file is required"})
+		}
+		if fileHeader.Size > maxImportSize {
+			return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{"error": "the dotenv file is larger than 1MB"})
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "failed to read the dotenv file"})
+		}
+		defer file.Close()
+		content, err := io.ReadAll(io.LimitReader(file, maxImportSize))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "failed to read the dotenv file"})
+		}
+
+		values, err := secrets.ParseDotenv(string(content))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": fmt.Sprintf("invalid dotenv file: %v", err)})
+		}
+		existing, err := routesConfig.Secrets.List(c.Request().Context(), options)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		response := &jsondefs.ImportSecretsResponse{Created: []string{}, Updated: []string{}, Skipped: []string{}}
+		for _, key := range keys {
+			_, exists := existing[key]
+			if exists && !overwrite {
+				response.Skipped = append(response.Skipped, key)
+				continue
+			}
+			if err := routesConfig.Secrets.Set(c.Request().Context(), options, key, values[key]); err != nil {
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"error":    err.Error(),
+					"imported": response,
+				})
+			}
+			if exists {
+				response.Updated = append(response.Updated, key)
+			} else {
+				response.Created = append(response.Created, key)
+			}
+		}
+		return c.JSON(http.StatusOK, response)
 	})
+}
+
+// canReveal checks the reveal token of a request, comparing in constant time.
+func (routesConfig *InfisicalRoutesConfig) canReveal(c echo.Context) bool {
+	if routesConfig.RevealToken == "" {
+		return false
+	}
+	token := c.Request().Header.Get(RevealTokenHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(routesConfig.RevealToken)) == 1
+}
+
+func forbidden(c echo.Context, action string) error {
+	return c.JSON(http.StatusForbidden, echo.Map{"error": action + " requires a valid " + RevealTokenHeader})
+}
+
+func queryOptions(c echo.Context) (*secrets.Options, error) {
+	options := &secrets.Options{
+		Environment: c.QueryParam("environment"),
+		ProjectID:   c.QueryParam("projectId"),
+		SecretPath:  c.QueryParam("secretPath"),
+	}
+	if options.Environment == "" || options.ProjectID == "" {
+		return nil, fmt.Errorf("environment and projectId are required")
+	}
+	return options, nil
+}
+
+func requestOptions(req *jsondefs.SecretRequest) (*secrets.Options, error) {
+	if req.Environment == "" || req.ProjectID == "" {
+		return nil, fmt.Errorf("environment and projectId are required")
+	}
+	if !secrets.ValidKey(req.Key) {
+		return nil, fmt.Errorf("%q is not a valid secret key", req.Key)
+	}
+	return &secrets.Options{
+		Environment: req.Environment,
+		ProjectID:   req.ProjectID,
+		SecretPath:  req.SecretPath,
+	}, nil
+}
 
-	// create, fetch
+func secretResponse(key, value string, reveal bool) *jsondefs.SecretResponse {
+	if !reveal {
+		value = secrets.MaskedValue
+	}
+	return &jsondefs.SecretResponse{Key: key, Value: value, Masked: !reveal}
 }
@@ -0,0 +1,259 @@
+package infisical
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benkamin03/prism/internal/infisical/jsondefs"
+	"github.com/benkamin03/prism/internal/redact"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/labstack/echo/v4"
+)
+
+const testRevealToken = "reveal-token"
+
+var devOptions = &secrets.Options{ProjectID: "infra", Environment: "dev"}
+
+// serveSecrets sets up the routes over a memory provider holding DB_PASSWORD, behind the
+// redaction middleware the server runs them with, which knows the value as a secret.
+func serveSecrets(t *testing.T, revealToken string) (*echo.Echo, secrets.Provider) {
+	t.Helper()
+	provider := secrets.NewMemoryProvider()
+	if err := provider.Set(context.Background(), devOptions, "DB_PASSWORD", "hunter2"); err != nil {
+		t.Fatalf("failed to seed secrets: %v", err)
+	}
+
+	redactor := redact.New(0)
+	redactor.Add("hunter2")
+	e := echo.New()
+	e.Use(redact.Middleware(redactor))
+	SetupRoutes(&InfisicalRoutesConfig{Secrets: provider, RevealToken: revealToken, Echo: e})
+	return e, provider
+}
+
+func serve(e *echo.Echo, method, target, token string, body interface{}) *httptest.ResponseRecorder {
+	var content []byte
+	if body != nil {
+		content, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(content))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(RevealTokenHeader, token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestListSecretsMasksValuesUnlessRevealed(t *testing.T) {
+	e, _ := serveSecrets(t, testRevealToken)
+	list := func(reveal bool, token string) *httptest.ResponseRecorder {
+		return serve(e, http.MethodPost, "/secrets/list", token, &jsondefs.ListSecretsRequest{
+			Environment: "dev", ProjectID: "infra", SecretPath: "/", Reveal: reveal,
+		})
+	}
+
+	tests := []struct {
+		name   string
+		reveal bool
+		token  string
+		status int
+		value  string
+	}{
+		{name: "masked", status: http.StatusOK, value: secrets.MaskedValue},
+		{name: "masked with a token", token: testRevealToken, status: http.StatusOK, value: secrets.MaskedValue},
+		{name: "revealed", reveal: true, token: testRevealToken, status: http.StatusOK, value: "hunter2"},
+		{name: "without a token", reveal: true, status: http.StatusForbidden},
+		{name: "with a wrong token", reveal: true, token: "guess", status: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		rec := list(test.reveal, test.token)
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d: %s", test.name, test.status, rec.Code, rec.Body.String())
+			continue
+		}
+		if test.status != http.StatusOK {
+			if strings.Contains(rec.Body.String(), "hunter2") {
+				t.Errorf("%s: expected no value in a refusal, got %s", test.name, rec.Body.String())
+			}
+			continue
+		}
+		var response jsondefs.GetSecretResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: invalid response: %v", test.name, err)
+		}
+		if response.Secrets["DB_PASSWORD"] != test.value || response.Masked == test.reveal {
+			t.Errorf("%s: expected %q, got %+v", test.name, test.value, response)
+		}
+	}
+}
+
+func TestGetSecretMasksValuesUnlessRevealed(t *testing.T) {
+	e, _ := serveSecrets(t, testRevealToken)
+	target := "/secrets/secret/DB_PASSWORD?projectId=infra&environment=dev"
+
+	var masked jsondefs.SecretResponse
+	rec := serve(e, http.MethodGet, target, testRevealToken, nil)
+	json.Unmarshal(rec.Body.Bytes(), &masked)
+	if rec.Code != http.StatusOK || masked.Value != secrets.MaskedValue || !masked.Masked {
+		t.Errorf("expected a masked value, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	var revealed jsondefs.SecretResponse
+	rec = serve(e, http.MethodGet, target+"&reveal=true", testRevealToken, nil)
+	json.Unmarshal(rec.Body.Bytes(), &revealed)
+	if rec.Code != http.StatusOK || revealed.Value != "hunter2" || revealed.Masked {
+		t.Errorf("expected the value, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serve(e, http.MethodGet, target+"&reveal=true", "", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("expected revealing without a token to be forbidden, got %d", rec.Code)
+	}
+	if rec := serve(e, http.MethodGet, "/secrets/secret/MISSING?projectId=infra&environment=dev", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected a missing secret to be 404, got %d", rec.Code)
+	}
+}
+
+func TestNoRequestRevealsWithoutAConfiguredToken(t *testing.T) {
+	e, _ := serveSecrets(t, "")
+
+	if rec := serve(e, http.MethodGet, "/secrets/secret/DB_PASSWORD?projectId=infra&environment=dev&reveal=true", "", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("expected revealing to be forbidden, got %d", rec.Code)
+	}
+	secret := &jsondefs.SecretRequest{Environment: "dev", ProjectID: "infra", Key: "API_KEY", Value: "value"}
+	if rec := serve(e, http.MethodPost, "/secrets/secret", "", secret); rec.Code != http.StatusForbidden {
+		t.Errorf("expected creating secrets to be forbidden, got %d", rec.Code)
+	}
+}
+
+func TestChangingSecretsRequiresTheRevealToken(t *testing.T) {
+	e, provider := serveSecrets(t, testRevealToken)
+	secret := &jsondefs.SecretRequest{Environment: "dev", ProjectID: "infra", Key: "API_KEY", Value: "s3cr3t"}
+	update := &jsondefs.SecretRequest{Environment: "dev", ProjectID: "infra", Value: "changed"}
+
+	requests := []struct {
+		method string
+		target string
+		body   interface{}
+	}{
+		{method: http.MethodPost, target: "/secrets/secret", body: secret},
+		{method: http.MethodPut, target: "/secrets/secret/DB_PASSWORD", body: update},
+		{method: http.MethodDelete, target: "/secrets/secret/DB_PASSWORD?projectId=infra&environment=dev"},
+	}
+	for _, token := range []string{"", "guess"} {
+		for _, request := range requests {
+			if rec := serve(e, request.method, request.target, token, request.body); rec.Code != http.StatusForbidden {
+				t.Errorf("%s %s with token %q: expected 403, got %d", request.method, request.target, token, rec.Code)
+			}
+		}
+	}
+	values, _ := provider.List(context.Background(), devOptions)
+	if !reflect.DeepEqual(values, map[string]string{"DB_PASSWORD": "hunter2"}) {
+		t.Fatalf("expected refused requests to change nothing, got %v", values)
+	}
+
+	if rec := serve(e, http.MethodPost, "/secrets/secret", testRevealToken, secret); rec.Code != http.StatusCreated || strings.Contains(rec.Body.String(), "s3cr3t") {
+		t.Errorf("expected the secret to be created with a masked response, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(e, http.MethodPost, "/secrets/secret", testRevealToken, secret); rec.Code != http.StatusConflict {
+		t.Errorf("expected creating an existing secret to conflict, got %d", rec.Code)
+	}
+	if rec := serve(e, http.MethodPut, "/secrets/secret/DB_PASSWORD", testRevealToken, update); rec.Code != http.StatusOK {
+		t.Errorf("expected the secret to be updated, got %d", rec.Code)
+	}
+	if rec := serve(e, http.MethodPut, "/secrets/secret/MISSING", testRevealToken, update); rec.Code != http.StatusNotFound {
+		t.Errorf("expected updating a missing secret to be 404, got %d", rec.Code)
+	}
+	if value, _ := provider.Get(context.Background(), devOptions, "DB_PASSWORD"); value != "changed" {
+		t.Errorf("expected the updated value, got %q", value)
+	}
+	if rec := serve(e, http.MethodDelete, "/secrets/secret/API_KEY?projectId=infra&environment=dev", testRevealToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("expected the secret to be deleted, got %d", rec.Code)
+	}
+	if rec := serve(e, http.MethodDelete, "/secrets/secret/API_KEY?projectId=infra&environment=dev", testRevealToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected deleting a missing secret to be 404, got %d", rec.Code)
+	}
+}
+
+func importDotenv(e *echo.Echo, token, content string, fields map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	if content != "" {
+		file, _ := writer.CreateFormFile("file", ".env")
+		file.Write([]byte(content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/secrets/import", &body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	if token != "" {
+		req.Header.Set(RevealTokenHeader, token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestImportDotenv(t *testing.T) {
+	e, provider := serveSecrets(t, testRevealToken)
+	fields := map[string]string{"projectId": "infra", "environment": "dev"}
+	content := "# database\nexport DB_PASSWORD=changed\nAPI_KEY=\"line\\nbreak\"\nREGION=eu-west-1 # default\n"
+
+	if rec := importDotenv(e, "", content, fields); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected importing without a token to be forbidden, got %d", rec.Code)
+	}
+	if values, _ := provider.List(context.Background(), devOptions); len(values) != 1 {
+		t.Fatalf("expected a refused import to change nothing, got %v", values)
+	}
+
+	rec := importDotenv(e, testRevealToken, content, fields)
+	var response jsondefs.ImportSecretsResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	want := jsondefs.ImportSecretsResponse{Created: []string{"API_KEY", "REGION"}, Updated: []string{}, Skipped: []string{"DB_PASSWORD"}}
+	if rec.Code != http.StatusOK || !reflect.DeepEqual(response, want) {
+		t.Errorf("expected existing secrets to be kept, got %d %s", rec.Code, rec.Body.String())
+	}
+	values, _ := provider.List(context.Background(), devOptions)
+	if !reflect.DeepEqual(values, map[string]string{"DB_PASSWORD": "hunter2", "API_KEY": "line\nbreak", "REGION": "eu-west-1"}) {
+		t.Errorf("unexpected secrets after the import %v", values)
+	}
+
+	fields["overwrite"] = "true"
+	rec = importDotenv(e, testRevealToken, content, fields)
+	response = jsondefs.ImportSecretsResponse{}
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	want = jsondefs.ImportSecretsResponse{Created: []string{}, Updated: []string{"API_KEY", "DB_PASSWORD", "REGION"}, Skipped: []string{}}
+	if rec.Code != http.StatusOK || !reflect.DeepEqual(response, want) {
+		t.Errorf("expected existing secrets to be overwritten, got %d %s", rec.Code, rec.Body.String())
+	}
+	if value, _ := provider.Get(context.Background(), devOptions, "DB_PASSWORD"); value != "changed" {
+		t.Errorf("expected the overwritten value, got %q", value)
+	}
+
+	invalid := []struct {
+		name    string
+		content string
+		fields  map[string]string
+	}{
+		{name: "invalid line", content: "API_KEY=value\nnot a pair\n", fields: fields},
+		{name: "invalid key", content: "1KEY=value\n", fields: fields},
+		{name: "no file", fields: fields},
+		{name: "no project", content: "API_KEY=value\n", fields: map[string]string{"environment": "dev"}},
+	}
+	for _, test := range invalid {
+		if rec := importDotenv(e, testRevealToken, test.content, test.fields); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", test.name, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package infisical
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshMargin renews a token this long before it expires, so no request is sent with a
+// token that expires on the way
+const refreshMargin = time.Minute
+
+// TokenManager logs the machine identity in with universal auth and caches its access token
+// until shortly before it expires. The SDK client and the REST calls share it.
+type TokenManager struct {
+	siteURL      string
+	clientID     string
+	clientSecret string
+	httpClient   *http.Client
+
+	mu    sync.Mutex
+	token string
+	// expiresAt is zero for tokens without an expiry
+	expiresAt time.Time
+}
+
+func NewTokenManager(config *InfisicalClientConfig) *TokenManager {
+	return &TokenManager{
+		siteURL:      strings.TrimSuffix(config.SiteUrl, "/"),
+		clientID:     config.InfisicalClientID,
+		clientSecret: config.InfisicalClientSecret,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Token returns the cached token, logging in again when there is none or it is about to
+// expire. Concurrent callers wait for a single login.
+func (m *TokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && (m.expiresAt.IsZero() || time.Now().Before(m.expiresAt.Add(-refreshMargin))) {
+		return m.token, nil
+	}
+
+	token, expiresIn, err := m.login(ctx)
+	if err != nil {
+		return "", err
+	}
+	m.token = token
+	m.expiresAt = time.Time{}
+	if expiresIn > 0 {
+		m.expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return m.token, nil
+}
+
+// Invalidate drops token after Infisical rejected it, unless another caller already replaced it.
+func (m *TokenManager) Invalidate(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token == token {
+		m.token = ""
+		m.expiresAt = time.Time{}
+	}
+}
+
+func (m *TokenManager) login(ctx context.Context) (string, int, error) {
+	payload := url.Values{}
+	payload.Set("clientId", m.clientID)
+	payload.Set("clientSecret", m.clientSecret)
+
+	loginURL := fmt.Sprintf("%s/api/v1/auth/universal-auth/login", m.siteURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, loginURL, strings.NewReader(payload.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create login request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("infisical login failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", 0, fmt.Errorf("infisical login failed: %d - %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result struct {
+		AccessToken string `json:"accessToken"`
+		ExpiresIn   int    `json:"expiresIn"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", 0, fmt.Errorf("failed to decode login response: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", 0, fmt.Errorf("infisical login returned no access token")
+	}
+	return result.AccessToken, result.ExpiresIn, nil
+}
+
+// Do sends a REST request with the access token. A request rejected with 401 is sent once
+// more with a fresh token, its body must be replayable through GetBody.
+func (m *TokenManager) Do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := m.Token(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		if attempt > 0 && req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("failed to replay request body: %w", err)
+			}
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		resp, err := m.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		m.Invalidate(token)
+	}
+}
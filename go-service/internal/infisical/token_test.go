@@ -0,0 +1,252 @@
+package infisical
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeInfisical issues token-1, token-2, ... on every login and accepts API calls with
+// any token that has not been revoked.
+type fakeInfisical struct {
+	expiresIn  int
+	loginDelay time.Duration
+
+	logins  atomic.Int32
+	mu      sync.Mutex
+	revoked map[string]bool
+	bodies  []string
+}
+
+func newFakeInfisical(t *testing.T, expiresIn int) (*fakeInfisical, *TokenManager) {
+	t.Helper()
+	fake := &fakeInfisical{expiresIn: expiresIn, revoked: map[string]bool{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return fake, NewTokenManager(&InfisicalClientConfig{
+		SiteUrl:               server.URL + "/",
+		InfisicalClientID:     "client-id",
+		InfisicalClientSecret: "client-secret",
+	})
+}
+
+func (f *fakeInfisical) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1/auth/universal-auth/login" {
+		r.ParseForm()
+		if r.PostForm.Get("clientId") != "client-id" || r.PostForm.Get("clientSecret") != "client-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		time.Sleep(f.loginDelay)
+		login := f.logins.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"accessToken": fmt.Sprintf("token-%d", login),
+			"expiresIn":   f.expiresIn,
+		})
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	f.mu.Lock()
+	f.bodies = append(f.bodies, string(body))
+	revoked := f.revoked[token]
+	f.mu.Unlock()
+	if revoked {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Write([]byte(token))
+}
+
+func (f *fakeInfisical) revoke(token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revoked[token] = true
+}
+
+func TestTokenIsCached(t *testing.T) {
+	for _, expiresIn := range []int{0, 3600} {
+		fake, manager := newFakeInfisical(t, expiresIn)
+
+		for i := 0; i < 3; i++ {
+			token, err := manager.Token(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token != "token-1" {
+				t.Errorf("expected the cached token-1, got %s", token)
+			}
+		}
+		if logins := fake.logins.Load(); logins != 1 {
+			t.Errorf("expected a single login for tokens expiring in %ds, got %d", expiresIn, logins)
+		}
+	}
+}
+
+func TestTokenIsRefreshedBeforeItExpires(t *testing.T) {
+	// A token expiring within refreshMargin is renewed on every call
+	fake, manager := newFakeInfisical(t, int(refreshMargin.Seconds())/2)
+
+	first, err := manager.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := manager.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first == second || fake.logins.Load() != 2 {
+		t.Errorf("expected a second login, got %s and %s after %d logins", first, second, fake.logins.Load())
+	}
+}
+
+func TestTokenIsRefreshedAfterItExpired(t *testing.T) {
+	fake, manager := newFakeInfisical(t, 3600)
+	if _, err := manager.Token(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	manager.mu.Lock()
+	manager.expiresAt = time.Now().Add(refreshMargin - time.Second)
+	manager.mu.Unlock()
+
+	token, err := manager.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token != "token-2" || fake.logins.Load() != 2 {
+		t.Errorf("expected token-2 from a second login, got %s after %d logins", token, fake.logins.Load())
+	}
+}
+
+func TestConcurrentTokenCallsLogInOnce(t *testing.T) {
+	fake, manager := newFakeInfisical(t, 3600)
+	fake.loginDelay = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 20)
+	errs := make([]error, len(tokens))
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = manager.Token(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	for i, token := range tokens {
+		if errs[i] != nil {
+			t.Fatalf("unexpected error: %v", errs[i])
+		}
+		if token != "token-1" {
+			t.Errorf("expected every caller to get token-1, got %s", token)
+		}
+	}
+	if logins := fake.logins.Load(); logins != 1 {
+		t.Errorf("expected a single login, got %d", logins)
+	}
+}
+
+func TestTokenLoginFailure(t *testing.T) {
+	_, manager := newFakeInfisical(t, 3600)
+	manager.clientSecret = "wrong"
+
+	if _, err := manager.Token(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the rejected login to fail, got %v", err)
+	}
+}
+
+func TestInvalidateKeepsNewerToken(t *testing.T) {
+	fake, manager := newFakeInfisical(t, 3600)
+	if _, err := manager.Token(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	manager.Invalidate("token-0")
+	if token, _ := manager.Token(context.Background()); token != "token-1" {
+		t.Errorf("expected a stale token not to drop token-1, got %s", token)
+	}
+
+	manager.Invalidate("token-1")
+	if token, _ := manager.Token(context.Background()); token != "token-2" {
+		t.Errorf("expected a login after invalidating token-1, got %s", token)
+	}
+	if logins := fake.logins.Load(); logins != 2 {
+		t.Errorf("expected two logins, got %d", logins)
+	}
+}
+
+func TestDoRetriesRejectedTokenWithReplayedBody(t *testing.T) {
+	fake, manager := newFakeInfisical(t, 3600)
+	if _, err := manager.Token(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Revoked on the server before it expires, e.g. after the identity was rotated
+	fake.revoke("token-1")
+
+	req, err := http.NewRequest(http.MethodPost, manager.siteURL+"/api/v2/workspace", strings.NewReader(`{"projectName":"infra"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := manager.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	used, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(used) != "token-2" {
+		t.Errorf("expected the retry to succeed with token-2, got %d %s", resp.StatusCode, used)
+	}
+	if len(fake.bodies) != 2 || fake.bodies[0] != fake.bodies[1] || fake.bodies[1] != `{"projectName":"infra"}` {
+		t.Errorf("expected the body to be sent twice, got %q", fake.bodies)
+	}
+	if token, _ := manager.Token(context.Background()); token != "token-2" {
+		t.Errorf("expected the rejected token to be replaced, got %s", token)
+	}
+}
+
+func TestDoRetriesOnlyOnce(t *testing.T) {
+	fake, manager := newFakeInfisical(t, 3600)
+	fake.revoke("token-1")
+	fake.revoke("token-2")
+
+	req, _ := http.NewRequest(http.MethodGet, manager.siteURL+"/api/v2/workspace", nil)
+	resp, err := manager.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized || fake.logins.Load() != 2 {
+		t.Errorf("expected the second 401 to be returned after two logins, got %d after %d logins", resp.StatusCode, fake.logins.Load())
+	}
+}
+
+func TestDoDoesNotRetryBodyWithoutGetBody(t *testing.T) {
+	fake, manager := newFakeInfisical(t, 3600)
+	fake.revoke("token-1")
+
+	req, _ := http.NewRequest(http.MethodPost, manager.siteURL+"/api/v2/workspace", nil)
+	req.Body = io.NopCloser(strings.NewReader("once"))
+	resp, err := manager.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized || len(fake.bodies) != 1 {
+		t.Errorf("expected the 401 to be returned without a retry, got %d after %d requests", resp.StatusCode, len(fake.bodies))
+	}
+}
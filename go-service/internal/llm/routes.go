@@ -1,8 +1,7 @@
 package llm
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,14 +11,28 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/benkamin03/prism/internal/infisical"
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
+	"github.com/benkamin03/prism/internal/minio"
 	"github.com/benkamin03/prism/internal/orchestrator"
+	"github.com/benkamin03/prism/internal/planstatus"
+	"github.com/benkamin03/prism/internal/policy"
+	"github.com/benkamin03/prism/internal/prbody"
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/scm"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/benkamin03/prism/internal/variables"
 	"github.com/labstack/echo/v4"
 )
 
 type LLMRoutesConfig struct {
-	Echo            *echo.Echo
-	InfisicalClient infisical.InfisicalClient
+	Echo        *echo.Echo
+	Secrets     secrets.Provider
+	MinioClient minio.MinioClient
+	GitHubApp   *github.App
+	Engines     *engine.Selector
+	Store       *store.Store
 }
 
 func SetupRoutes(routesConfig *LLMRoutesConfig) {
@@ -31,9 +44,11 @@ func SetupRoutes(routesConfig *LLMRoutesConfig) {
 
 	// POST /llm-plan
 	// Expected payload (multipart/form-data):
-	// - repo_url: string (required) - GitHub repository URL to clone
-	// - github_token: string (required) - GitHub personal access token for authentication
+	// - repo_url: string (required) - GitHub, GitLab or Gitea repository URL to clone
+	// - github_token: string (required) - GitHub personal access token, replaced by the GitHub App token on imported repositories it can push to
 	// - files: file[] (required) - One or more .tf files to replace/add in the cloned repo
+	// - prompt: string (optional) - The prompt that produced the files, recorded in the commit body
+	// - environment: string (optional) - Environment from .prism.yaml to plan against, the first one by default
 	//
 	// Returns JSON:
 	// - On success: { "plan": <terraform_plan_json>, "output": <terraform_plan_text> }
@@ -53,8 +68,10 @@ func SetupRoutes(routesConfig *LLMRoutesConfig) {
 		}
 
 		repoURL := c.FormValue("repo_url")
-		githubToken := c.FormValue("github_token")
+		githubToken := routesConfig.GitHubApp.TokenFor(repoURL, c.FormValue("github_token"))
 		projectID := c.FormValue("project_id")
+		prompt := c.FormValue("prompt")
+		environment := c.FormValue("environment")
 
 		if repoURL == "" || githubToken == "" {
 			return c.JSON(http.StatusBadRequest, echo.Map{"error": "repo_url, and github_token are required"})
@@ -65,19 +82,25 @@ func SetupRoutes(routesConfig *LLMRoutesConfig) {
 			return c.JSON(http.StatusBadRequest, echo.Map{"error": "at least one file is required"})
 		}
 
-		orchestrator := orchestrator.NewOrchestrator(&orchestrator.NewOrchestratorInput{
+		orch := orchestrator.NewOrchestrator(&orchestrator.NewOrchestratorInput{
 			RepoURL:     repoURL,
 			GitHubToken: githubToken,
 			ProjectID:   projectID,
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
 		})
-		log.Printf("Orchestrator initialized: %v", orchestrator)
+		// The conversation plans against its own state, never the repository's
+		orch.Workspace = conversationID
 
-		tmpDir, err := orchestrator.CloneAndNavigateToRepo()
+		tmpDir, err := orch.CloneRepo()
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to clone and navigate to repo: %v", err)})
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to clone repo: %v", err)})
 		}
+		defer orch.Cleanup()
 
-		if err := orchestrator.GetOrCreateBranch(conversationID); err != nil {
+		if err := orch.GetOrCreateBranch(conversationID); err != nil {
 			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to get or create branch: %v", err)})
 		}
 
@@ -112,6 +135,7 @@ func SetupRoutes(routesConfig *LLMRoutesConfig) {
 		}
 
 		cmd := exec.Command("git", "status")
+		cmd.Dir = tmpDir
 		statusOutput, err := cmd.CombinedOutput()
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to get git status: %s", string(statusOutput))})
@@ -120,14 +144,16 @@ func SetupRoutes(routesConfig *LLMRoutesConfig) {
 
 		// Add all changes
 		cmd = exec.Command("git", "add", ".")
+		cmd.Dir = tmpDir
 		if output, err := cmd.CombinedOutput(); err != nil {
 			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to add files: %s", string(output))})
 		}
 		log.Printf("Staged changes for commit")
 
 		// Commit changes
-		commitMsg := fmt.Sprintf("Update terraform config for conversation %s", conversationID)
+		commitMsg := orchestrator.ConversationCommitMessage(conversationID, prompt)
 		cmd = exec.Command("git", "commit", "-m", commitMsg)
+		cmd.Dir = tmpDir
 		if output, err := cmd.CombinedOutput(); err != nil {
 			// Check if it's "nothing to commit" error
 			if !strings.Contains(string(output), "nothing to commit") {
@@ -138,6 +164,7 @@ func SetupRoutes(routesConfig *LLMRoutesConfig) {
 
 		// Get commit hash
 		cmd = exec.Command("git", "rev-parse", "HEAD")
+		cmd.Dir = tmpDir
 		commitHashBytes, err := cmd.CombinedOutput()
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to get commit hash: %s", string(commitHashBytes))})
@@ -147,59 +174,133 @@ func SetupRoutes(routesConfig *LLMRoutesConfig) {
 
 		// Push to remote
 		cmd = exec.Command("git", "push", "--force", "origin", conversationID)
+		cmd.Dir = tmpDir
 		if output, err := cmd.CombinedOutput(); err != nil {
 			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to push: %s", string(output))})
 		}
 		log.Printf("Pushed changes to remote branch %s", conversationID)
 
-		// Inject the variables into the environment for terraform
-		secretsResponse := routesConfig.InfisicalClient.ListSecrets(&infisical.InfisicalSecretOptions{
-			Environment: "dev",
-			ProjectID:   projectID,
-			SecretPath:  "/",
-		})
-		if secretsResponse.StatusCode != http.StatusOK || secretsResponse.Error != "" {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to fetch secrets: %s", secretsResponse.Error)})
+		provider, err := scm.NewProvider(repoURL, githubToken)
+		if err != nil {
+			log.Printf("Not reporting to the git host: %v", err)
+		}
+		var statusReporter *planstatus.Reporter
+		if provider != nil {
+			statusReporter = planstatus.NewReporter(provider, repoURL, commitHash)
+		}
+
+		// The uploaded files may include the configuration itself, so read it from the new commit
+		config, err := repoconfig.Load(tmpDir)
+		if err != nil {
+			statusReporter.Failure("Invalid repository configuration", err.Error(), nil)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		workingDir, _ := repoconfig.CleanPath(config.WorkingDir)
+		settings, err := config.ForEnvironment(workingDir, environment)
+		if err != nil {
+			statusReporter.Failure("Unknown environment", err.Error(), nil)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+
+		// Pass the mapped secrets to the engine's environment
+		env, secretMappings, err := orch.SecretEnv(settings)
+		if err != nil {
+			statusReporter.Failure("Failed to fetch secrets", err.Error(), nil)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to fetch secrets: %v", err)})
+		}
+
+		// Generate the variable set's tfvars and make sure nothing the plan needs is missing
+		repository, err := scm.RepositoryKey(repoURL)
+		if err != nil {
+			statusReporter.Failure("Invalid repository URL", err.Error(), nil)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		varFiles, err := orchestrator.RelativeVarFiles(workingDir, settings.VarFiles)
+		if err != nil {
+			statusReporter.Failure("Invalid var files", err.Error(), nil)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		if _, err := variables.Prepare(routesConfig.Store, repository, settings.VariableEnvironment(), filepath.Join(tmpDir, workingDir), env, varFiles); err != nil {
+			statusReporter.Failure("Missing variables", err.Error(), nil)
+			var missingErr *variables.MissingError
+			if errors.As(err, &missingErr) {
+				return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error(), "missing_variables": missingErr.Variables})
+			}
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 		}
-		log.Printf("Fetched secrets from Infisical: %v", secretsResponse.Secrets)
 
-		// Inject the secret key/value pairs into the environment
-		for key, value := range secretsResponse.Secrets {
-			log.Printf("Injecting secret into environment: %s", key)
-			if err := os.Setenv(key, value); err != nil {
-				return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to set env var %s: %v", key, err)})
+		eng, err := routesConfig.Engines.New(settings.Engine, filepath.Join(tmpDir, workingDir), settings.TerraformVersion, env, orch.Redactor())
+		if err != nil {
+			statusReporter.Failure("Failed to select an engine", err.Error(), nil)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		// Download or create the state of the conversation's workspace
+		managedState := orchestrator.ManagesState(eng)
+		var stateBucket string
+		if managedState {
+			if stateBucket, err = orch.DownloadState(settings); err != nil {
+				statusReporter.Failure("Failed to download state", err.Error(), nil)
+				return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 			}
 		}
 
-		// Run terraform init
-		cmd = exec.Command("terraform", "init")
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("terraform init failed: %s", string(output))})
+		// Run init
+		if err := eng.Init(false); err != nil {
+			statusReporter.Failure(eng.Name()+" init failed", err.Error(), nil)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 		}
 
-		// Run terraform plan
-		cmd = exec.Command("terraform", "plan", "-no-color", "-input=false", "-out=tfplan")
-		planOutput, err := cmd.CombinedOutput()
+		// Validate first so that errors can be annotated on the offending .tf lines
+		validateResult, err := eng.Validate()
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("terraform plan failed: %s", string(planOutput))})
+			statusReporter.Failure(eng.Name()+" validate failed", err.Error(), nil)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		if !validateResult.Valid {
+			title := fmt.Sprintf("%s validate found %d error(s)", eng.Name(), validateResult.ErrorCount)
+			statusReporter.Failure(title, "", planstatus.AnnotationsFromDiagnostics(workingDir, validateResult.Diagnostics))
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error":       title,
+				"diagnostics": validateResult.Diagnostics,
+			})
+		}
+
+		// Run plan
+		if _, err := eng.Plan(&engine.PlanOptions{VarFiles: varFiles}); err != nil {
+			statusReporter.Failure(eng.Name()+" plan failed", err.Error(), nil)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		if managedState {
+			if err := orch.UploadState(stateBucket, settings); err != nil {
+				statusReporter.Failure("Failed to upload state", err.Error(), nil)
+				return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+			}
 		}
 
 		// Convert plan to JSON
-		cmd = exec.Command("terraform", "show", "-json", "tfplan")
-		jsonOutput, err := cmd.CombinedOutput()
+		planJSON, err := eng.Show()
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("terraform show failed: %s", string(jsonOutput))})
+			statusReporter.Failure(eng.Name()+" show failed", err.Error(), nil)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 		}
+		statusReporter.Success(planJSON)
+		policyResults := policy.Evaluate(tmpDir, settings.Policies, planJSON)
 
-		var planJSON map[string]interface{}
-		if err := json.Unmarshal(jsonOutput, &planJSON); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to parse plan JSON: %v", err)})
+		// Keep the description of an already open PR in sync with the new commit
+		if provider != nil {
+			if err := refreshPullRequestBody(provider, eng, tmpDir, repoURL, conversationID, planJSON, policyResults); err != nil {
+				log.Printf("Failed to refresh pull request body for %s: %v", conversationID, err)
+			}
 		}
 
 		return c.JSON(http.StatusOK, echo.Map{
-			"plan":        planJSON,
-			"commit_hash": commitHash,
-			"branch":      conversationID,
+			"plan":            planJSON,
+			"commit_hash":     commitHash,
+			"branch":          conversationID,
+			"secret_mappings": secretMappings,
+			"policy_results":  policyResults,
 		})
 	})
 
@@ -216,39 +317,30 @@ func SetupRoutes(routesConfig *LLMRoutesConfig) {
 			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid JSON body"})
 		}
 
+		req.GithubToken = routesConfig.GitHubApp.TokenFor(req.RepoURL, req.GithubToken)
 		if req.RepoURL == "" || req.GithubToken == "" {
 			return c.JSON(http.StatusBadRequest, echo.Map{"error": "repo_url and github_token are required"})
 		}
 
-		// Set defaults
 		baseBranch := req.BaseBranch
-		if baseBranch == "" {
-			baseBranch = "main"
-		}
 
 		prTitle := req.PRTitle
 		if prTitle == "" {
 			prTitle = fmt.Sprintf("Terraform updates for conversation %s", conversationID)
 		}
 
-		prBody := req.PRBody
-		if prBody == "" {
-			prBody = fmt.Sprintf("Automated terraform configuration updates for conversation %s", conversationID)
+		// Pick the git host from the repo URL and extract owner and repo from it
+		provider, err := scm.NewProvider(req.RepoURL, req.GithubToken)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
 		}
-
-		// Extract owner and repo from repoURL
-		// Example: https://github.com/drshooby/test-terraform-repo.git -> drshooby, test-terraform-repo
-		repoPath := strings.TrimPrefix(req.RepoURL, "https://github.com/")
-		repoPath = strings.TrimSuffix(repoPath, ".git")
-		parts := strings.Split(repoPath, "/")
-		if len(parts) != 2 {
+		repo, err := provider.ParseRepoURL(req.RepoURL)
+		if err != nil {
 			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid repo URL format"})
 		}
-		owner := parts[0]
-		repoName := parts[1]
 
-		// Check if branch exists remotely using GitHub API
-		exists, err := branchExistsOnGitHub(conversationID, owner, repoName, req.GithubToken)
+		// Check if branch exists remotely using the host's API
+		exists, err := provider.BranchExists(repo, conversationID)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to check branch: %v", err)})
 		}
@@ -256,110 +348,213 @@ func SetupRoutes(routesConfig *LLMRoutesConfig) {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": fmt.Sprintf("branch %s does not exist", conversationID)})
 		}
 
-		// Create pull request
-		pr, err := createPullRequest(req.GithubToken, owner, repoName, conversationID, baseBranch, prTitle, prBody)
+		orch := orchestrator.NewOrchestrator(&orchestrator.NewOrchestratorInput{
+			RepoURL:     req.RepoURL,
+			GitHubToken: req.GithubToken,
+			UserID:      req.UserID,
+			ProjectID:   req.ProjectID,
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+		})
+
+		prBody := req.PRBody
+		if prBody == "" {
+			conversationPlan, err := orch.PlanConversation(conversationID, baseBranch)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to plan conversation: %v", err)})
+			}
+
+			prBody = prbody.Render(&prbody.Input{
+				ConversationID: conversationID,
+				Plan:           conversationPlan.Plan,
+				PlanText:       conversationPlan.PlanText,
+				Prompts:        conversationPlan.Prompts,
+				PolicyResults:  conversationPlan.PolicyResults,
+			})
+			baseBranch = conversationPlan.BaseBranch
+		}
+
+		// Fall back to the base branch configured in the repository
+		if baseBranch == "" {
+			config, err := orch.LoadRepoConfig()
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+			}
+			baseBranch = config.BaseBranch
+		}
+
+		// Update the open PR for the branch, or create one
+		pr, created, err := scm.UpsertMergeRequest(provider, repo, &scm.MergeRequestInput{
+			Title:        prTitle,
+			Body:         prBody,
+			SourceBranch: conversationID,
+			TargetBranch: baseBranch,
+			Labels:       req.Labels,
+		})
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to create PR: %v", err)})
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to create or update PR: %v", err)})
 		}
 
 		return c.JSON(http.StatusOK, echo.Map{
 			"pr_number": pr.Number,
-			"pr_url":    pr.HTMLURL,
+			"pr_url":    pr.URL,
 			"branch":    conversationID,
 			"base":      baseBranch,
+			"created":   created,
 		})
 	})
-}
 
-type CreatePRRequestBody struct {
-	RepoURL     string `json:"repo_url"`
-	GithubToken string `json:"github_token"`
-	BaseBranch  string `json:"base_branch,omitempty"` // defaults to "main"
-	PRTitle     string `json:"pr_title,omitempty"`
-	PRBody      string `json:"pr_body,omitempty"`
-}
+	// GET /conversations/:id/pr?repo_url=...
+	// Returns the mergeability, checks and reviews of the open PR for the conversation branch.
+	// The token is read from the Authorization header, and replaced by the GitHub App token on imported repositories it can push to.
+	e.GET("/conversations/:id/pr", func(c echo.Context) error {
+		conversationID := c.Param("id")
+		repoURL := c.QueryParam("repo_url")
 
-type CreatePRRequest struct {
-	Title string `json:"title"`
-	Head  string `json:"head"`
-	Base  string `json:"base"`
-	Body  string `json:"body"`
-}
+		provider, repo, err := conversationProvider(routesConfig.GitHubApp, repoURL, c.Request().Header.Get("Authorization"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
 
-type CreatePRResponse struct {
-	Number  int    `json:"number"`
-	HTMLURL string `json:"html_url"`
-	Title   string `json:"title"`
-}
+		pr, err := provider.FindOpenMergeRequest(repo, conversationID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to find PR: %v", err)})
+		}
+		if pr == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": fmt.Sprintf("no open PR for branch %s", conversationID)})
+		}
 
-// Helper functions
-func branchExistsOnGitHub(branchName, owner, repo, token string) (bool, error) {
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/branches/%s", owner, repo, branchName)
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return false, err
-	}
+		status, err := provider.GetMergeRequestStatus(repo, pr.Number)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to get PR status: %v", err)})
+		}
 
-	req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
+		return c.JSON(http.StatusOK, status)
+	})
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
+	// DELETE /conversations/:id/pr?repo_url=...
+	// Closes the open PR for the conversation branch, if any, and deletes the branch and the
+	// conversation's workspace state.
+	e.DELETE("/conversations/:id/pr", func(c echo.Context) error {
+		conversationID := c.Param("id")
+		repoURL := c.QueryParam("repo_url")
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		return true, nil
-	case http.StatusNotFound:
-		return false, nil
-	}
+		provider, repo, err := conversationProvider(routesConfig.GitHubApp, repoURL, c.Request().Header.Get("Authorization"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+
+		pr, err := provider.FindOpenMergeRequest(repo, conversationID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to find PR: %v", err)})
+		}
+		if pr != nil {
+			if err := provider.CloseMergeRequest(repo, pr.Number); err != nil {
+				return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to close PR: %v", err)})
+			}
+		}
+
+		exists, err := provider.BranchExists(repo, conversationID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to check branch: %v", err)})
+		}
+		if exists {
+			if err := provider.DeleteBranch(repo, conversationID); err != nil {
+				return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to delete branch: %v", err)})
+			}
+		}
 
-	bodyBytes, _ := io.ReadAll(resp.Body)
-	return false, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(bodyBytes))
+		// The conversation is closed, so its workspace state goes with it
+		orch := orchestrator.NewOrchestrator(&orchestrator.NewOrchestratorInput{
+			RepoURL:     repoURL,
+			MinioClient: routesConfig.MinioClient,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+		})
+		workspaceDeleted, err := orch.DeleteWorkspace(conversationID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": fmt.Sprintf("failed to delete workspace: %v", err)})
+		}
+
+		response := echo.Map{
+			"branch":            conversationID,
+			"branch_deleted":    exists,
+			"workspace_deleted": workspaceDeleted,
+		}
+		if pr != nil {
+			response["pr_number"] = pr.Number
+		}
+		return c.JSON(http.StatusOK, response)
+	})
 }
 
-func createPullRequest(token, owner, repo, head, base, title, body string) (*CreatePRResponse, error) {
-	prRequest := CreatePRRequest{
-		Title: title,
-		Head:  head,
-		Base:  base,
-		Body:  body,
+// conversationProvider resolves the token and git host for routes that only get the repo URL.
+func conversationProvider(app *github.App, repoURL, personalToken string) (scm.Provider, *scm.Repository, error) {
+	token := app.TokenFor(repoURL, personalToken)
+	if repoURL == "" || token == "" {
+		return nil, nil, fmt.Errorf("repo_url and a token are required")
 	}
 
-	jsonData, err := json.Marshal(prRequest)
+	provider, err := scm.NewProvider(repoURL, token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal PR request: %w", err)
+		return nil, nil, err
 	}
-
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls", owner, repo)
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonData))
+	repo, err := provider.ParseRepoURL(repoURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("invalid repo URL format")
 	}
+	return provider, repo, nil
+}
 
-	req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	req.Header.Set("Content-Type", "application/json")
+type CreatePRRequestBody struct {
+	RepoURL     string   `json:"repo_url"`
+	GithubToken string   `json:"github_token"`          // replaced by the GitHub App token on imported repositories it can push to
+	BaseBranch  string   `json:"base_branch,omitempty"` // defaults to base_branch in .prism.yaml, or "main"
+	PRTitle     string   `json:"pr_title,omitempty"`
+	PRBody      string   `json:"pr_body,omitempty"`    // generated from the branch's plan when empty
+	UserID      string   `json:"user_id,omitempty"`    // bucket of the repository state a new workspace starts from, looked up from the imported repository when empty
+	ProjectID   string   `json:"project_id,omitempty"` // secrets project used to generate the body
+	Labels      []string `json:"labels,omitempty"`
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// refreshPullRequestBody regenerates the description of the open PR for the conversation branch.
+// It must run right after a plan so that tfplan and the branch history in the clone in dir are available.
+func refreshPullRequestBody(provider scm.Provider, eng engine.Engine, dir, repoURL, conversationID string, plan map[string]interface{}, policyResults []policy.Result) error {
+	repo, err := provider.ParseRepoURL(repoURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to create PR (status %d): %s", resp.StatusCode, string(bodyBytes))
+	pr, err := provider.FindOpenMergeRequest(repo, conversationID)
+	if err != nil {
+		return err
+	}
+	if pr == nil || !prbody.IsGenerated(pr.Body) {
+		// Nothing to refresh, or the body was written by hand
+		return nil
+	}
+
+	planText, err := eng.ShowText()
+	if err != nil {
+		return err
 	}
 
-	var prResponse CreatePRResponse
-	if err := json.NewDecoder(resp.Body).Decode(&prResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	prompts, err := orchestrator.PromptHistory(dir, pr.TargetBranch)
+	if err != nil {
+		return err
 	}
 
-	return &prResponse, nil
+	_, err = provider.UpdateMergeRequest(repo, pr.Number, &scm.MergeRequestInput{
+		Body: prbody.Render(&prbody.Input{
+			ConversationID: conversationID,
+			Plan:           plan,
+			PlanText:       planText,
+			Prompts:        prompts,
+			PolicyResults:  policyResults,
+		}),
+	})
+	return err
 }
@@ -1,8 +1,11 @@
 package minio
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 
 	"github.com/minio/minio-go/v7"
@@ -114,3 +117,60 @@ func (minioClient *MinioClient) UploadFileObject(ctx context.Context, bucketName
 	log.Printf("Successfully uploaded %s of size %d\n", info.Key, info.Size)
 	return nil
 }
+
+// ListObjectNames returns the names of all objects below prefix in a bucket.
+func (minioClient *MinioClient) ListObjectNames(ctx context.Context, bucketName, prefix string) ([]string, error) {
+	names := []string{}
+	for object := range minioClient.client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("error listing objects in bucket %s: %v", bucketName, object.Err)
+		}
+		names = append(names, object.Key)
+	}
+	return names, nil
+}
+
+// RemoveObjects deletes all objects below prefix in a bucket and returns how many there were.
+func (minioClient *MinioClient) RemoveObjects(ctx context.Context, bucketName, prefix string) (int, error) {
+	names, err := minioClient.ListObjectNames(ctx, bucketName, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range names {
+		if err := minioClient.client.RemoveObject(ctx, bucketName, name, minio.RemoveObjectOptions{}); err != nil {
+			return 0, fmt.Errorf("error removing object %s from bucket %s: %v", name, bucketName, err)
+		}
+	}
+	return len(names), nil
+}
+
+// ErrObjectNotFound is returned by ReadObject when the bucket or the object does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ReadObject returns the content of an object without creating its bucket.
+func (minioClient *MinioClient) ReadObject(ctx context.Context, bucketName, objectName string) ([]byte, error) {
+	object, err := minioClient.client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading object %s from bucket %s: %v", objectName, bucketName, err)
+	}
+	defer object.Close()
+
+	content, err := io.ReadAll(object)
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "NoSuchKey", "NoSuchBucket":
+			return nil, fmt.Errorf("%w: %s in bucket %s", ErrObjectNotFound, objectName, bucketName)
+		}
+		return nil, fmt.Errorf("error reading object %s from bucket %s: %v", objectName, bucketName, err)
+	}
+	return content, nil
+}
+
+// WriteObject stores content as an object of an existing bucket.
+func (minioClient *MinioClient) WriteObject(ctx context.Context, bucketName, objectName string, content []byte) error {
+	if _, err := minioClient.client.PutObject(ctx, bucketName, objectName, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("error writing object %s to bucket %s: %v", objectName, bucketName, err)
+	}
+	return nil
+}
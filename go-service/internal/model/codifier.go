@@ -0,0 +1,66 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/orchestrator"
+)
+
+const codifySystemPrompt = `You maintain Terraform configuration. Resources were changed outside of Terraform and the
+configuration must be updated so that a plan against the real infrastructure shows no changes.
+Only change the attributes that drifted, keep the style of the existing files and never add
+provider credentials. Reply with JSON only, in the form
+{"files": [{"path": "<path relative to the repository root>", "content": "<complete file content>"}]}
+listing every .tf file you change with its full new content.`
+
+// Codifier asks a model for the configuration that matches drifted infrastructure.
+type Codifier struct {
+	Provider Provider
+}
+
+func (c *Codifier) Codify(ctx context.Context, request *orchestrator.CodifyRequest) ([]orchestrator.FileContent, error) {
+	drift, err := json.MarshalIndent(request.Drift, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal drift: %w", err)
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("These resources drifted, before is the value in the state and after the real one:\n\n")
+	prompt.Write(drift)
+	prompt.WriteString("\n\nThe configuration of the repository:\n")
+	for _, file := range request.Files {
+		fmt.Fprintf(&prompt, "\n--- %s\n%s\n", file.Path, file.Content)
+	}
+	if request.PlanText != "" {
+		fmt.Fprintf(&prompt, "\nAttempt %d. The plan after your previous changes still shows changes:\n\n%s\n", request.Attempt, request.PlanText)
+	}
+
+	completion, err := c.Provider.Complete(ctx, codifySystemPrompt, prompt.String())
+	if err != nil {
+		return nil, fmt.Errorf("error in Complete: %w", err)
+	}
+	return parseFiles(completion)
+}
+
+// parseFiles reads the files from a completion, tolerating text or code fences around the JSON.
+func parseFiles(completion string) ([]orchestrator.FileContent, error) {
+	start := strings.Index(completion, "{")
+	end := strings.LastIndex(completion, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("model reply holds no JSON")
+	}
+
+	var reply struct {
+		Files []orchestrator.FileContent `json:"files"`
+	}
+	if err := json.Unmarshal([]byte(completion[start:end+1]), &reply); err != nil {
+		return nil, fmt.Errorf("failed to parse model reply: %w", err)
+	}
+	if len(reply.Files) == 0 {
+		return nil, fmt.Errorf("model reply changes no files")
+	}
+	return reply.Files, nil
+}
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Names accepted for MODEL_PROVIDER
+const (
+	OpenAI    = "openai"
+	Anthropic = "anthropic"
+)
+
+// Provider is a language model that completes a prompt.
+type Provider interface {
+	Name() string
+	Complete(ctx context.Context, system, prompt string) (string, error)
+}
+
+type ProviderConfig struct {
+	// Name picks the API, openai also covers servers with an OpenAI-compatible API
+	Name    string
+	BaseURL string
+	APIKey  string
+	Model   string
+}
+
+// New creates the configured provider, nil when no provider is configured.
+func New(config *ProviderConfig) (Provider, error) {
+	if config.Name == "" {
+		return nil, nil
+	}
+	if config.Model == "" {
+		return nil, fmt.Errorf("a model is required for provider %s", config.Name)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	switch config.Name {
+	case OpenAI:
+		baseURL := config.BaseURL
+		if baseURL == "" {
+			baseURL = "https://api.openai.com/v1"
+		}
+		return &openAIProvider{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: config.APIKey, model: config.Model, client: client}, nil
+	case Anthropic:
+		baseURL := config.BaseURL
+		if baseURL == "" {
+			baseURL = "https://api.anthropic.com"
+		}
+		return &anthropicProvider{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: config.APIKey, model: config.Model, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown model provider %q, expected %s or %s", config.Name, OpenAI, Anthropic)
+	}
+}
+
+// openAIProvider speaks the chat completions API.
+type openAIProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func (p *openAIProvider) Name() string {
+	return OpenAI
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, system, prompt string) (string, error) {
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	request := map[string]interface{}{
+		"model": p.model,
+		"messages": []message{
+			{Role: "system", Content: system},
+			{Role: "user", Content: prompt},
+		},
+	}
+
+	var response struct {
+		Choices []struct {
+			Message message `json:"message"`
+		} `json:"choices"`
+	}
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	if err := post(ctx, p.client, p.baseURL+"/chat/completions", headers, request, &response); err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("%s returned no choices", p.Name())
+	}
+	return response.Choices[0].Message.Content, nil
+}
+
+// anthropicProvider speaks the messages API.
+type anthropicProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func (p *anthropicProvider) Name() string {
+	return Anthropic
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, system, prompt string) (string, error) {
+	request := map[string]interface{}{
+		"model":      p.model,
+		"max_tokens": 8192,
+		"system":     system,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+	}
+
+	var response struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	headers := map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": "2023-06-01",
+	}
+	if err := post(ctx, p.client, p.baseURL+"/v1/messages", headers, request, &response); err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	for _, block := range response.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String(), nil
+}
+
+func post(ctx context.Context, client *http.Client, url string, headers map[string]string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response from %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d: %s", url, resp.StatusCode, string(responseBody))
+	}
+	if err := json.Unmarshal(responseBody, out); err != nil {
+		return fmt.Errorf("failed to parse response from %s: %w", url, err)
+	}
+	return nil
+}
@@ -9,93 +9,191 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
 
-	"github.com/benkamin03/prism/internal/infisical"
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
 	"github.com/benkamin03/prism/internal/minio"
+	"github.com/benkamin03/prism/internal/policy"
+	"github.com/benkamin03/prism/internal/redact"
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/benkamin03/prism/internal/store"
 	"github.com/labstack/echo/v4"
 )
 
 type Orchestrator struct {
-	RepoURL         string
-	GitHubToken     string
-	UserID          string
-	ProjectID       string
-	MinioClient     minio.MinioClient
-	InfisicalClient infisical.InfisicalClient
-	context         context.Context
+	RepoURL     string
+	GitHubToken string
+	UserID      string
+	ProjectID   string
+	// Environment declared in the repository configuration to plan, the first one when empty
+	Environment string
+	// Workspace is the conversation whose own state is planned, the repository's state when empty
+	Workspace   string
+	MinioClient minio.MinioClient
+	Secrets     secrets.Provider
+	Engines     *engine.Selector
+	// Optional, plans only get the variables from the repository itself without it
+	Store   *store.Store
+	context context.Context
+	// Root module of the last generateJSONPlan, reused to apply and show its tfplan
+	lastRun *rootRun
+	// dir is the clone of the last CloneRepo
+	dir string
+	// redactor holds the tokens and secrets of this run, the one on its context
+	redactor *redact.Redactor
+	// tempDirs are the clones and credentials directories of this run, removed by Cleanup
+	tempDirs   []string
+	tempDirsMu sync.Mutex
 }
 
 type NewOrchestratorInput struct {
-	RepoURL         string
-	GitHubToken     string
-	UserID          string
-	ProjectID       string
-	MinioClient     minio.MinioClient
-	InfisicalClient infisical.InfisicalClient
-	context         context.Context
+	RepoURL     string
+	GitHubToken string
+	UserID      string
+	ProjectID   string
+	Environment string
+	MinioClient minio.MinioClient
+	Secrets     secrets.Provider
+	// Optional, engines from PATH are used for every root without it
+	Engines *engine.Selector
+	Store   *store.Store
+	Context context.Context
 }
 
 func NewOrchestrator(config *NewOrchestratorInput) *Orchestrator {
+	redactor := redact.FromContext(config.Context)
+	// git prints the clone URL, which holds the token
+	redactor.Add(config.GitHubToken)
 	return &Orchestrator{
-		RepoURL:         config.RepoURL,
-		GitHubToken:     config.GitHubToken,
-		UserID:          config.UserID,
-		ProjectID:       config.ProjectID,
-		MinioClient:     config.MinioClient,
-		InfisicalClient: config.InfisicalClient,
-		context:         config.context,
+		RepoURL:     config.RepoURL,
+		GitHubToken: config.GitHubToken,
+		UserID:      config.UserID,
+		ProjectID:   config.ProjectID,
+		Environment: config.Environment,
+		MinioClient: config.MinioClient,
+		Secrets:     config.Secrets,
+		Engines:     config.Engines,
+		Store:       config.Store,
+		context:     config.Context,
+		redactor:    redactor,
 	}
 }
 
-func (o *Orchestrator) CloneAndNavigateToRepo() (string, error) {
-	tmpDir, err := os.MkdirTemp("/var/tmp/", "cloned-repo-")
+// Redactor redacts the tokens and secrets the orchestrator was given or fetched so far.
+func (o *Orchestrator) Redactor() *redact.Redactor {
+	return o.redactor
+}
+
+// CloneRepo clones the repository into a new temporary directory, which the orchestrator runs
+// every following command in. The caller defers Cleanup to remove it.
+func (o *Orchestrator) CloneRepo() (string, error) {
+	tmpDir, err := o.tempDir("/var/tmp/", "cloned-repo-")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp dir: %w", err)
 	}
 
-	// Now go to this temporary directory
-	log.Printf("Changing to temp directory: %s", tmpDir)
-	if err := os.Chdir(tmpDir); err != nil {
-		return "", fmt.Errorf("failed to change dir: %w", err)
-	}
-
-	// Export GitHub token for authentication
-	log.Printf("Setting GH_TOKEN environment variable")
-	if err := os.Setenv("GH_TOKEN", o.GitHubToken); err != nil {
-		return "", fmt.Errorf("failed to set GH_TOKEN env: %w", err)
+	// Authenticate git through the clone URL so that later pushes reuse the same token
+	cloneURL, err := github.AuthenticatedCloneURL(o.RepoURL, o.GitHubToken)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("error in AuthenticatedCloneURL: %w", err)
 	}
 
 	// Clone the repository
-	log.Printf("Cloning repository into temp directory")
-	cmd := exec.Command("git", "clone", o.RepoURL, ".")
+	log.Printf("Cloning repository into %s", tmpDir)
+	cmd := exec.Command("git", "clone", cloneURL, tmpDir)
 	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(tmpDir)
 		return "", fmt.Errorf("failed to clone repo: %s, %w", string(output), err)
 	}
 
+	o.dir = tmpDir
 	return tmpDir, nil
 }
 
-func (o *Orchestrator) downloadOrCreateTFStateFile(bucketName string) error {
-	// Create the .terraform directory
-	if err := os.MkdirAll(".terraform", os.ModePerm); err != nil {
-		return fmt.Errorf("error creating .terraform directory: %w", err)
+// tempDir creates a temporary directory that Cleanup removes.
+func (o *Orchestrator) tempDir(dir, pattern string) (string, error) {
+	tmpDir, err := os.MkdirTemp(dir, pattern)
+	if err != nil {
+		return "", err
 	}
+	o.tempDirsMu.Lock()
+	defer o.tempDirsMu.Unlock()
+	o.tempDirs = append(o.tempDirs, tmpDir)
+	return tmpDir, nil
+}
 
-	if err := o.MinioClient.DownloadFileObject(o.context, bucketName, "terraform.tfstate", ".terraform/terraform.tfstate"); err != nil {
-		// Create the file if it does not exist
-		if err := os.NewFile(0, ".terraform/terraform.tfstate").Close(); err != nil {
-			return fmt.Errorf("error creating empty terraform.tfstate: %w", err)
+// Cleanup removes the clones and the credentials files of the run. Every caller of CloneRepo
+// defers it, so mapped secrets never outlive the request or job that fetched them.
+func (o *Orchestrator) Cleanup() {
+	o.tempDirsMu.Lock()
+	defer o.tempDirsMu.Unlock()
+	for _, tmpDir := range o.tempDirs {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Printf("Failed to remove %s: %v", tmpDir, err)
 		}
-		fmt.Println("terraform.tfstate not found in bucket, created empty file.")
+	}
+	o.tempDirs = nil
+}
+
+// git runs git in the clone. Nothing changes the working directory of the process, which
+// requests and background jobs share.
+func (o *Orchestrator) git(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = o.dir
+	return cmd
+}
+
+// path resolves a path relative to the repository root to the file in the clone.
+func (o *Orchestrator) path(relPath string) string {
+	return filepath.Join(o.dir, relPath)
+}
+
+// The state lives next to the configuration, where terraform's default local backend reads and writes it
+const (
+	stateObjectName = "terraform.tfstate"
+	localStatePath  = "terraform.tfstate"
+)
+
+func (o *Orchestrator) downloadOrCreateTFStateFile(bucketName string, settings *repoconfig.RootSettings) error {
+	localPath := o.path(filepath.Join(settings.Root, localStatePath))
+	stateObject, err := o.stateObject(settings)
+	if err != nil {
+		return err
+	}
+	// A new workspace starts from the repository's state, not from nothing
+	if err := o.seedWorkspace(bucketName, settings); err != nil {
+		return fmt.Errorf("error in seedWorkspace: %w", err)
+	}
+	if err := o.MinioClient.DownloadFileObject(o.context, bucketName, stateObject, localPath); err != nil {
+		// Create the file if it does not exist, terraform treats an empty state file as no state
+		if err := os.WriteFile(localPath, nil, 0644); err != nil {
+			return fmt.Errorf("error creating empty %s: %w", localPath, err)
+		}
+		fmt.Printf("%s not found in bucket, created empty file.\n", stateObject)
 	} else {
-		fmt.Println("Downloaded terraform.tfstate from bucket.")
+		fmt.Printf("Downloaded %s from bucket.\n", stateObject)
+	}
+	return nil
+}
+
+func (o *Orchestrator) uploadTFStateFile(bucketName string, settings *repoconfig.RootSettings) error {
+	stateObject, err := o.stateObject(settings)
+	if err != nil {
+		return err
 	}
+	if err := o.MinioClient.UploadFileObject(o.context, bucketName, stateObject, o.path(filepath.Join(settings.Root, localStatePath))); err != nil {
+		return fmt.Errorf("error uploading %s: %w", stateObject, err)
+	}
+	log.Printf("Uploaded updated %s to bucket %s", stateObject, bucketName)
 	return nil
 }
 
 func (o *Orchestrator) remoteBranchExists(branchName string) bool {
 	// Check if branch exists on remote
-	cmd := exec.Command("git", "rev-parse", "--verify", branchName)
+	cmd := o.git("rev-parse", "--verify", branchName)
 	if err := cmd.Run(); err != nil {
 		return false
 	}
@@ -104,7 +202,7 @@ func (o *Orchestrator) remoteBranchExists(branchName string) bool {
 
 func (o *Orchestrator) pushToRemote(branchName string) error {
 	// Push the branch to remote
-	cmd := exec.Command("git", "push", "--force", "-u", "origin", branchName)
+	cmd := o.git("push", "--force", "-u", "origin", branchName)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to push branch %s to remote: %s, %w", branchName, string(output), err)
 	}
@@ -114,8 +212,13 @@ func (o *Orchestrator) pushToRemote(branchName string) error {
 func (o *Orchestrator) GetOrCreateBranch(branchName string) error {
 	// Check if branch exists
 	if !o.remoteBranchExists(branchName) {
-		// Branch does not exist, create it
-		cmd := exec.Command("git", "checkout", "-b", branchName)
+		config, err := repoconfig.Load(o.dir)
+		if err != nil {
+			return err
+		}
+
+		// Branch does not exist, create it from the configured base branch
+		cmd := o.git("checkout", "-b", branchName, "origin/"+config.BaseBranch)
 		if output, err := cmd.CombinedOutput(); err != nil {
 			return fmt.Errorf("failed to create branch %s: %s, %w", branchName, string(output), err)
 		}
@@ -126,7 +229,7 @@ func (o *Orchestrator) GetOrCreateBranch(branchName string) error {
 		}
 
 		// Pull the latest changes
-		cmd := exec.Command("git", "pull", "origin", branchName)
+		cmd := o.git("pull", "origin", branchName)
 		if output, err := cmd.CombinedOutput(); err != nil {
 			return fmt.Errorf("failed to pull latest changes for branch %s: %s, %w", branchName, string(output), err)
 		}
@@ -143,6 +246,7 @@ func (o *Orchestrator) GetOrCreateBranch(branchName string) error {
 type FileContent struct {
 	Path    string `json:"path"`
 	Content string `json:"content"`
+	Root    string `json:"root,omitempty"` // root module the file belongs to, empty for shared modules
 }
 
 type FilesResponse struct {
@@ -150,11 +254,16 @@ type FilesResponse struct {
 	Count int           `json:"count"`
 }
 
-func getTerraformFiles() ([]FileContent, error) {
+// getTerraformFiles returns the .tf files of the repository cloned to rootPath.
+func getTerraformFiles(rootPath string) ([]FileContent, error) {
 	var files []FileContent
-	rootPath, err := os.Getwd()
+	config, err := repoconfig.Load(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := DiscoverRoots(rootPath, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get working directory: %w", err)
+		return nil, fmt.Errorf("error in DiscoverRoots: %w", err)
 	}
 
 	err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
@@ -162,8 +271,11 @@ func getTerraformFiles() ([]FileContent, error) {
 			return err
 		}
 
-		// Skip directories
+		// Skip directories, and never descend into downloaded modules or git metadata
 		if info.IsDir() {
+			if path != rootPath && skipDir(info.Name()) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -183,6 +295,7 @@ func getTerraformFiles() ([]FileContent, error) {
 			files = append(files, FileContent{
 				Path:    relPath,
 				Content: string(content),
+				Root:    rootForPath(roots, relPath),
 			})
 		}
 
@@ -194,7 +307,7 @@ func getTerraformFiles() ([]FileContent, error) {
 
 func handleGetTerraformFiles(c echo.Context) error {
 	//
-	files, err := getTerraformFiles()
+	files, err := getTerraformFiles(".")
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": err.Error(),
@@ -211,7 +324,7 @@ func handleGetTerraformFiles(c echo.Context) error {
 
 func (o *Orchestrator) checkoutLocalBranch(branchName string) error {
 	// Checkout to the branch
-	cmd := exec.Command("git", "checkout", branchName)
+	cmd := o.git("checkout", branchName)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to checkout to branch %s: %s, %w", branchName, string(output), err)
 	}
@@ -219,26 +332,28 @@ func (o *Orchestrator) checkoutLocalBranch(branchName string) error {
 }
 
 func (o *Orchestrator) DeleteCommit(conversationID, commitHash string) (map[string]interface{}, error) {
-	// Clone and navigate to the repository
-	if _, err := o.CloneAndNavigateToRepo(); err != nil {
-		return nil, fmt.Errorf("error in cloneAndNavigateToRepo: %w", err)
+	// Clone the repository
+	if _, err := o.CloneRepo(); err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
 	}
-	log.Printf("Successfully cloned and navigated to repo")
+	defer o.Cleanup()
+	log.Printf("Successfully cloned repo")
 
 	// Checkout to the conversation branch
 	if err := o.checkoutLocalBranch(conversationID); err != nil {
 		return nil, fmt.Errorf("error in checkoutLocalBranch: %w", err)
 	}
 	log.Printf("Checked out to branch: %s", conversationID)
+	o.Workspace = conversationID
 
 	// Delete the commit by resetting to the previous commit
-	cmd := exec.Command("git", "reset", "--hard", commitHash+"^")
+	cmd := o.git("reset", "--hard", commitHash+"^")
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return nil, fmt.Errorf("failed to reset commit %s: %s, %w", commitHash, string(output), err)
 	}
 	log.Printf("Reset to previous commit before: %s", commitHash)
 
-	cmd = exec.Command("git", "push", "--force", "origin", conversationID)
+	cmd = o.git("push", "--force", "origin", conversationID)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return nil, fmt.Errorf("failed to force push after deleting commit %s: %s, %w", commitHash, string(output), err)
 	}
@@ -248,10 +363,11 @@ func (o *Orchestrator) DeleteCommit(conversationID, commitHash string) (map[stri
 }
 
 func (o *Orchestrator) GetConversation(conversationID string) (*FilesResponse, error) {
-	// Clone and navigate to the repository
-	if _, err := o.CloneAndNavigateToRepo(); err != nil {
-		return nil, fmt.Errorf("error in cloneAndNavigateToRepo: %w", err)
+	// Clone the repository
+	if _, err := o.CloneRepo(); err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
 	}
+	defer o.Cleanup()
 
 	// Get or create the branch for the conversation
 	if err := o.GetOrCreateBranch(conversationID); err != nil {
@@ -260,7 +376,7 @@ func (o *Orchestrator) GetConversation(conversationID string) (*FilesResponse, e
 	log.Printf("Successfully got or created branch for conversation ID: %s", conversationID)
 
 	// Fetch all the
-	files, err := getTerraformFiles()
+	files, err := getTerraformFiles(o.dir)
 	response := FilesResponse{
 		Files: files,
 		Count: len(files),
@@ -272,90 +388,186 @@ func (o *Orchestrator) GetConversation(conversationID string) (*FilesResponse, e
 	return &response, nil
 }
 
-func (o *Orchestrator) generateJSONPlan() (map[string]interface{}, error) {
+// prepareRun makes sure the state bucket exists and loads the repository configuration of the checkout.
+func (o *Orchestrator) prepareRun() (string, *repoconfig.Config, error) {
+	config, err := repoconfig.Load(o.dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	bucketName, err := o.stateBucket()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return bucketName, config, nil
+}
+
+// stateBucket makes sure the bucket holding the state exists, the user's bucket or, for a
+// conversation, the workspace bucket after recording the plan in its workspace.
+func (o *Orchestrator) stateBucket() (string, error) {
+	bucketName := o.UserID
+	if o.Workspace != "" {
+		if err := o.touchWorkspace(); err != nil {
+			return "", fmt.Errorf("error in touchWorkspace: %w", err)
+		}
+		bucketName = WorkspaceBucket
+	}
+
 	// Check if the bucket exists, if not create it
-	bucket, err := o.MinioClient.GetOrCreateBucket(o.context, o.UserID)
+	bucket, err := o.MinioClient.GetOrCreateBucket(o.context, bucketName)
 	if err != nil {
-		return nil, fmt.Errorf("error in GetOrCreateBucket: %w", err)
+		return "", fmt.Errorf("error in GetOrCreateBucket: %w", err)
 	}
+	return bucket.Name, nil
+}
 
-	// Download or create the terraform.tfstate file
-	if err := o.downloadOrCreateTFStateFile(bucket.Name); err != nil {
-		return nil, fmt.Errorf("error in downloadOrCreateTFStateFile: %w", err)
+// rootRun is a root module with the engine that runs it.
+type rootRun struct {
+	*repoconfig.RootSettings
+	engine engine.Engine
+	// bucket holds the state of the root once it was planned
+	bucket string
+	// secretMappings the engine was given secrets with
+	secretMappings []SecretMappingUse
+}
+
+// ManagesState reports whether the state of a root module run by eng is kept in MinIO.
+// Terragrunt units keep their state in the backends of their remote_state blocks instead.
+func ManagesState(eng engine.Engine) bool {
+	return eng.Name() != engine.Terragrunt
+}
+
+func (r *rootRun) managesState() bool {
+	return ManagesState(r.engine)
+}
+
+// prepareRoot fetches the secrets of a root module and creates the engine version it requires.
+func (o *Orchestrator) prepareRoot(settings *repoconfig.RootSettings) (*rootRun, error) {
+	env, mappings, err := o.SecretEnv(settings)
+	if err != nil {
+		return nil, fmt.Errorf("error in SecretEnv: %w", err)
+	}
+	if err := o.prepareVariables(settings, env); err != nil {
+		return nil, fmt.Errorf("error in prepareVariables: %w", err)
 	}
 
-	// Fetch and inject the secrets into the environment
-	secretsResponse := o.InfisicalClient.ListSecrets(&infisical.InfisicalSecretOptions{
-		Environment: "dev",
-		ProjectID:   o.ProjectID,
-		SecretPath:  "/",
-	})
-	if secretsResponse.StatusCode != http.StatusOK || secretsResponse.Error != "" {
-		return nil, fmt.Errorf("failed to fetch secrets (status code %d): %s", secretsResponse.StatusCode, secretsResponse.Error)
+	eng, err := o.Engines.New(settings.Engine, o.path(settings.Root), settings.TerraformVersion, env, o.redactor)
+	if err != nil {
+		return nil, fmt.Errorf("error in New: %w", err)
 	}
-	log.Printf("Fetched secrets from Infisical: %v", secretsResponse.Secrets)
+	log.Printf("Using %s for %s", eng.Name(), settings.Root)
+
+	return &rootRun{RootSettings: settings, engine: eng, secretMappings: mappings}, nil
+}
 
-	// Inject the secret key/value pairs into the environment
-	for key, value := range secretsResponse.Secrets {
-		log.Printf("Injecting secret into environment: %s", key)
-		if err := os.Setenv(key, value); err != nil {
-			return nil, fmt.Errorf("failed to set secret env %s: %w", key, err)
+// RelativeVarFiles turns var files relative to the repository root into paths relative to a root module.
+func RelativeVarFiles(root string, varFiles []string) ([]string, error) {
+	relPaths := []string{}
+	for _, varFile := range varFiles {
+		relPath, err := filepath.Rel(root, filepath.FromSlash(varFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve var file %s: %w", varFile, err)
+		}
+		relPaths = append(relPaths, relPath)
+	}
+	return relPaths, nil
+}
+
+// generateJSONPlan plans the working directory of the repository and remembers it for a following apply.
+func (o *Orchestrator) generateJSONPlan() (map[string]interface{}, error) {
+	bucketName, config, err := o.prepareRun()
+	if err != nil {
+		return nil, fmt.Errorf("error in prepareRun: %w", err)
+	}
+
+	workingDir, _ := repoconfig.CleanPath(config.WorkingDir)
+	settings, err := config.ForEnvironment(workingDir, o.Environment)
+	if err != nil {
+		return nil, err
+	}
+	run, err := o.prepareRoot(settings)
+	if err != nil {
+		return nil, fmt.Errorf("error in prepareRoot: %w", err)
+	}
+
+	plan, err := o.planRoot(bucketName, run)
+	if err != nil {
+		return nil, err
+	}
+	o.lastRun = run
+	return plan, nil
+}
+
+// planRoot plans a single root module, leaving tfplan and plan.json in its directory.
+// It never changes the working directory, so several roots can be planned at once.
+func (o *Orchestrator) planRoot(bucketName string, run *rootRun) (map[string]interface{}, error) {
+	root := run.Root
+	managedState := run.managesState()
+	run.bucket = bucketName
+
+	// Download or create the terraform.tfstate file
+	if managedState {
+		if err := o.downloadOrCreateTFStateFile(bucketName, run.RootSettings); err != nil {
+			return nil, fmt.Errorf("error in downloadOrCreateTFStateFile: %w", err)
 		}
 	}
 
 	// Run terraform plan
-	log.Printf("Running terraform init")
-	cmd := exec.Command("terraform", "init", "-upgrade")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("terraform init failed: %s, %w", string(output), err)
+	// Without -upgrade the provider versions committed in .terraform.lock.hcl are kept
+	log.Printf("Running %s init in %s", run.engine.Name(), root)
+	if err := run.engine.Init(false); err != nil {
+		return nil, err
 	}
-	log.Printf("Terraform initialized successfully")
+	log.Printf("Initialized successfully in %s", root)
 
-	log.Printf("Running terraform plan")
-	cmd = exec.Command("terraform", "plan", "-no-color", "-input=false", "-out=tfplan")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("terraform plan failed: %s, %w", string(output), err)
+	varFiles, err := RelativeVarFiles(root, run.VarFiles)
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("Terraform plan executed successfully")
+
+	log.Printf("Running %s plan in %s", run.engine.Name(), root)
+	if _, err := run.engine.Plan(&engine.PlanOptions{VarFiles: varFiles}); err != nil {
+		return nil, err
+	}
+	log.Printf("Plan executed successfully in %s", root)
 
 	// Ensure that we save this state file
-	if err := o.MinioClient.UploadFileObject(o.context, bucket.Name, "terraform.tfstate", ".terraform/terraform.tfstate"); err != nil {
-		return nil, fmt.Errorf("error uploading terraform.tfstate: %w", err)
+	if managedState {
+		if err := o.uploadTFStateFile(bucketName, run.RootSettings); err != nil {
+			return nil, fmt.Errorf("error in uploadTFStateFile: %w", err)
+		}
 	}
-	log.Printf("Uploaded updated terraform.tfstate to bucket %s", bucket.Name)
 
 	// Convert the plan to json
-	log.Printf("Converting terraform plan to JSON")
-	cmd = exec.Command("sh", "-c", "terraform show -json tfplan > plan.json")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("terraform show -json failed: %s, %w", string(output), err)
-	} else {
-		log.Printf("Terraform plan in JSON format:\n%s", string(output))
+	log.Printf("Converting plan to JSON")
+	response, err := run.engine.Show()
+	if err != nil {
+		return nil, err
 	}
-
-	// Read the JSON file and log its content
-	planFileContent, err := os.ReadFile("plan.json")
+	planFileContent, err := json.Marshal(response)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read plan.json: %w", err)
+		return nil, fmt.Errorf("failed to marshal plan JSON: %w", err)
 	}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(planFileContent, &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal plan.json: %w", err)
+	// Keep the JSON plan next to tfplan like the terraform CLI workflow would
+	if err := os.WriteFile(o.path(filepath.Join(root, "plan.json")), planFileContent, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write plan.json: %w", err)
 	}
+
 	log.Printf("Terraform Plan JSON Content: %v", response)
 
 	return response, nil
 }
 
 func (o *Orchestrator) Plan() (map[string]interface{}, error) {
-	// Clone and navigate to the repository
-	tmpDir, err := o.CloneAndNavigateToRepo()
+	// Clone the repository
+	tmpDir, err := o.CloneRepo()
 	if err != nil {
-		return nil, fmt.Errorf("error in cloneAndNavigateToRepo: %w", err)
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
 	}
-	defer os.RemoveAll(tmpDir) // Clean up temp dir after execution
-	log.Printf("Successfully cloned and navigated to repo")
+	defer o.Cleanup()
+	log.Printf("Successfully cloned repo")
 
 	response, err := o.generateJSONPlan()
 	if err != nil {
@@ -365,3 +577,215 @@ func (o *Orchestrator) Plan() (map[string]interface{}, error) {
 
 	return response, nil
 }
+
+type RevisionPlan struct {
+	Plan     map[string]interface{}
+	PlanText string
+	// Digest is the PlanDigest of Plan, the plan an apply of the revision must match
+	Digest        string
+	PolicyResults []policy.Result
+	// Prompts is the prompt history since BaseBranch, only read when a base branch was given
+	Prompts    []string
+	BaseBranch string
+}
+
+func (o *Orchestrator) checkoutRevision(sha string) error {
+	cmd := o.git("checkout", "--detach", sha)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to checkout %s: %s, %w", sha, string(output), err)
+	}
+	log.Printf("Checked out revision: %s", sha)
+	return nil
+}
+
+// PlanRevision plans an exact commit, e.g. the head of a push or pull request. The prompt history
+// of a pull request is read from the same clone when its baseBranch is given, so describing the
+// pull request does not need a second one.
+func (o *Orchestrator) PlanRevision(sha, baseBranch string) (*RevisionPlan, error) {
+	if _, err := o.CloneRepo(); err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	if err := o.checkoutRevision(sha); err != nil {
+		return nil, fmt.Errorf("error in checkoutRevision: %w", err)
+	}
+
+	plan, err := o.generateJSONPlan()
+	if err != nil {
+		return nil, fmt.Errorf("error in generateJSONPlan: %w", err)
+	}
+
+	planText, err := o.lastRun.engine.ShowText()
+	if err != nil {
+		return nil, fmt.Errorf("error in ShowText: %w", err)
+	}
+
+	var prompts []string
+	if baseBranch != "" {
+		if prompts, err = PromptHistory(o.dir, baseBranch); err != nil {
+			return nil, fmt.Errorf("error in PromptHistory: %w", err)
+		}
+	}
+
+	return &RevisionPlan{
+		Plan:          plan,
+		PlanText:      planText,
+		Digest:        PlanDigest(plan),
+		PolicyResults: policy.Evaluate(o.dir, o.lastRun.Policies, plan),
+		Prompts:       prompts,
+		BaseBranch:    baseBranch,
+	}, nil
+}
+
+type ApplyResult struct {
+	Plan        map[string]interface{}
+	ApplyOutput string
+}
+
+// ApprovalError stops an apply to an environment that has fewer approvals than it requires.
+type ApprovalError struct {
+	Environment string
+	Required    int
+	Approved    int
+}
+
+func (e *ApprovalError) Error() string {
+	return fmt.Sprintf("environment %s requires %d approval(s), got %d", e.Environment, e.Required, e.Approved)
+}
+
+// PlanChangedError stops an apply whose plan is not the one that was reviewed, e.g. because the
+// infrastructure or the state changed since.
+type PlanChangedError struct {
+	SHA string
+}
+
+func (e *PlanChangedError) Error() string {
+	return fmt.Sprintf("the plan of %s changed since it was reviewed", e.SHA)
+}
+
+// checkReviewed returns a PlanChangedError unless plan is the one with the reviewed digest. A
+// revision without a reviewed plan has nothing that may be applied.
+func checkReviewed(sha string, plan map[string]interface{}, reviewedDigest string) error {
+	if reviewedDigest == "" || PlanDigest(plan) != reviewedDigest {
+		return &PlanChangedError{SHA: sha}
+	}
+	return nil
+}
+
+// ApplyRevision plans an exact commit and applies that plan, saving the resulting state.
+// approvedBy are the users who approved the change, checked against the approvals its
+// environment requires before anything is planned. The plan is only applied when its digest is
+// reviewedDigest, the one of the plan that was reviewed, a PlanChangedError is returned otherwise.
+func (o *Orchestrator) ApplyRevision(sha string, approvedBy []string, reviewedDigest string) (*ApplyResult, error) {
+	if _, err := o.CloneRepo(); err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	if err := o.checkoutRevision(sha); err != nil {
+		return nil, fmt.Errorf("error in checkoutRevision: %w", err)
+	}
+
+	config, err := repoconfig.Load(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	if environment := config.Environment(o.Environment); environment != nil {
+		approved := environment.Approvals.Count(approvedBy)
+		if approved < environment.Approvals.Required {
+			return nil, &ApprovalError{Environment: environment.Name, Required: environment.Approvals.Required, Approved: approved}
+		}
+	}
+
+	plan, err := o.generateJSONPlan()
+	if err != nil {
+		return nil, fmt.Errorf("error in generateJSONPlan: %w", err)
+	}
+	if err := checkReviewed(sha, plan, reviewedDigest); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Running terraform apply in %s", o.lastRun.Root)
+	output, applyErr := o.lastRun.engine.Apply()
+
+	// Save whatever was applied, even a partial apply changes real infrastructure
+	if o.lastRun.managesState() {
+		if err := o.uploadTFStateFile(o.lastRun.bucket, o.lastRun.RootSettings); err != nil {
+			return nil, fmt.Errorf("error in uploadTFStateFile: %w", err)
+		}
+	}
+	if applyErr != nil {
+		return nil, applyErr
+	}
+	log.Printf("Terraform apply executed successfully")
+
+	return &ApplyResult{
+		Plan:        plan,
+		ApplyOutput: output,
+	}, nil
+}
+
+type ConversationPlan struct {
+	Plan          map[string]interface{}
+	PlanText      string
+	Prompts       []string
+	BaseBranch    string
+	PolicyResults []policy.Result
+}
+
+// PlanConversation plans the conversation branch and collects what is needed to describe it in a pull request.
+// An empty baseBranch uses the base branch from the repository configuration.
+func (o *Orchestrator) PlanConversation(conversationID, baseBranch string) (*ConversationPlan, error) {
+	if _, err := o.CloneRepo(); err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	if err := o.checkoutLocalBranch(conversationID); err != nil {
+		return nil, fmt.Errorf("error in checkoutLocalBranch: %w", err)
+	}
+
+	o.Workspace = conversationID
+	plan, err := o.generateJSONPlan()
+	if err != nil {
+		return nil, fmt.Errorf("error in generateJSONPlan: %w", err)
+	}
+
+	planText, err := o.lastRun.engine.ShowText()
+	if err != nil {
+		return nil, fmt.Errorf("error in ShowText: %w", err)
+	}
+
+	if baseBranch == "" {
+		config, err := repoconfig.Load(o.dir)
+		if err != nil {
+			return nil, err
+		}
+		baseBranch = config.BaseBranch
+	}
+
+	prompts, err := PromptHistory(o.dir, baseBranch)
+	if err != nil {
+		return nil, fmt.Errorf("error in PromptHistory: %w", err)
+	}
+
+	return &ConversationPlan{
+		Plan:          plan,
+		PlanText:      planText,
+		Prompts:       prompts,
+		BaseBranch:    baseBranch,
+		PolicyResults: policy.Evaluate(o.dir, o.lastRun.Policies, plan),
+	}, nil
+}
+
+// LoadRepoConfig clones the repository and reads the configuration of its default branch.
+func (o *Orchestrator) LoadRepoConfig() (*repoconfig.Config, error) {
+	tmpDir, err := o.CloneRepo()
+	if err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	return repoconfig.Load(tmpDir)
+}
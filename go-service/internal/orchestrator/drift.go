@@ -0,0 +1,196 @@
+package orchestrator
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/tfstate"
+)
+
+// DriftReport compares the state of the working directory of the default branch with the
+// real infrastructure.
+type DriftReport struct {
+	CommitSHA   string          `json:"commit_sha"`
+	Root        string          `json:"root"`
+	Environment string          `json:"environment,omitempty"`
+	Drifted     bool            `json:"drifted"`
+	Resources   []ResourceDrift `json:"resources"`
+	PlanText    string          `json:"plan_text"`
+}
+
+// ResourceDrift is a resource whose real object no longer matches its state.
+type ResourceDrift struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	// Actions are ["update"] for changed objects and ["delete"] for objects that are gone
+	Actions    []string         `json:"actions"`
+	Attributes []AttributeDrift `json:"attributes"`
+}
+
+// AttributeDrift is a changed top-level attribute, sensitive values are masked.
+type AttributeDrift struct {
+	Name   string      `json:"name"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// DetectDrift clones the default branch and runs a refresh-only plan of its working directory.
+// Nothing is stored, a refresh-only plan never changes the state.
+func (o *Orchestrator) DetectDrift() (*DriftReport, error) {
+	if _, err := o.CloneRepo(); err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	sha, err := o.git("rev-parse", "HEAD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+
+	bucketName, config, err := o.prepareRun()
+	if err != nil {
+		return nil, fmt.Errorf("error in prepareRun: %w", err)
+	}
+	workingDir, _ := repoconfig.CleanPath(config.WorkingDir)
+	settings, err := config.ForEnvironment(workingDir, o.Environment)
+	if err != nil {
+		return nil, err
+	}
+	run, err := o.prepareRoot(settings)
+	if err != nil {
+		return nil, fmt.Errorf("error in prepareRoot: %w", err)
+	}
+	detector, ok := run.engine.(engine.DriftDetector)
+	if !ok {
+		return nil, fmt.Errorf("%s can not detect drift", run.engine.Name())
+	}
+
+	if run.managesState() {
+		if err := o.downloadOrCreateTFStateFile(bucketName, settings); err != nil {
+			return nil, fmt.Errorf("error in downloadOrCreateTFStateFile: %w", err)
+		}
+	}
+	if err := run.engine.Init(false); err != nil {
+		return nil, err
+	}
+	varFiles, err := RelativeVarFiles(workingDir, settings.VarFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Running refresh-only plan in %s", workingDir)
+	drifted, _, err := detector.RefreshPlan(&engine.PlanOptions{VarFiles: varFiles})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DriftReport{
+		CommitSHA:   strings.TrimSpace(string(sha)),
+		Root:        workingDir,
+		Environment: settings.Environment,
+		Drifted:     drifted,
+		Resources:   []ResourceDrift{},
+	}
+	if !drifted {
+		return report, nil
+	}
+
+	plan, err := run.engine.Show()
+	if err != nil {
+		return nil, err
+	}
+	if report.PlanText, err = run.engine.ShowText(); err != nil {
+		return nil, err
+	}
+	report.Resources = DriftedResources(plan)
+	return report, nil
+}
+
+// DriftedResources reads the resource_drift section of a plan.
+func DriftedResources(plan map[string]interface{}) []ResourceDrift {
+	resources := []ResourceDrift{}
+	entries, _ := plan["resource_drift"].([]interface{})
+	for _, entry := range entries {
+		resourceDrift, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		change, _ := resourceDrift["change"].(map[string]interface{})
+		address, _ := resourceDrift["address"].(string)
+		resourceType, _ := resourceDrift["type"].(string)
+
+		actions := []string{}
+		rawActions, _ := change["actions"].([]interface{})
+		for _, a := range rawActions {
+			if action, ok := a.(string); ok {
+				actions = append(actions, action)
+			}
+		}
+
+		resource := ResourceDrift{Address: address, Type: resourceType, Actions: actions, Attributes: []AttributeDrift{}}
+		// Every attribute of a deleted object changed, listing them says nothing
+		if len(actions) != 1 || actions[0] != "delete" {
+			resource.Attributes = changedAttributes(change)
+		}
+		resources = append(resources, resource)
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Address < resources[j].Address })
+	return resources
+}
+
+func changedAttributes(change map[string]interface{}) []AttributeDrift {
+	before, _ := change["before"].(map[string]interface{})
+	after, _ := change["after"].(map[string]interface{})
+	beforeSensitive, _ := change["before_sensitive"].(map[string]interface{})
+	afterSensitive, _ := change["after_sensitive"].(map[string]interface{})
+
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+
+	attributes := []AttributeDrift{}
+	for name := range names {
+		if reflect.DeepEqual(before[name], after[name]) {
+			continue
+		}
+		attribute := AttributeDrift{Name: name, Before: before[name], After: after[name]}
+		// Any sensitive value inside the attribute hides all of it
+		if isSensitive(beforeSensitive[name]) || isSensitive(afterSensitive[name]) {
+			attribute.Before = tfstate.SensitiveValue
+			attribute.After = tfstate.SensitiveValue
+		}
+		attributes = append(attributes, attribute)
+	}
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Name < attributes[j].Name })
+	return attributes
+}
+
+// isSensitive reports whether a before_sensitive or after_sensitive value marks anything.
+func isSensitive(value interface{}) bool {
+	switch value := value.(type) {
+	case bool:
+		return value
+	case map[string]interface{}:
+		for _, element := range value {
+			if isSensitive(element) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, element := range value {
+			if isSensitive(element) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package orchestrator
+
+import (
+	"fmt"
+
+	"github.com/benkamin03/prism/internal/repoconfig"
+)
+
+// EnvironmentInfo describes an environment declared in the repository configuration.
+type EnvironmentInfo struct {
+	Name                 string   `json:"name"`
+	InfisicalEnvironment string   `json:"infisical_environment"`
+	RequiredApprovals    int      `json:"required_approvals"`
+	Approvers            []string `json:"approvers,omitempty"`
+	// Next is the environment changes are promoted to, empty for the last one
+	Next string `json:"next,omitempty"`
+}
+
+// ListEnvironments clones the repository and returns the environments of its default branch in promotion order.
+func (o *Orchestrator) ListEnvironments() ([]EnvironmentInfo, error) {
+	config, err := o.LoadRepoConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	environments := []EnvironmentInfo{}
+	for _, environment := range config.Environments {
+		info := EnvironmentInfo{
+			Name:                 environment.Name,
+			InfisicalEnvironment: environment.Name,
+			RequiredApprovals:    environment.Approvals.Required,
+			Approvers:            environment.Approvals.Approvers,
+		}
+		if environment.Infisical.Environment != "" {
+			info.InfisicalEnvironment = environment.Infisical.Environment
+		}
+		if next := config.NextEnvironment(environment.Name); next != nil {
+			info.Next = next.Name
+		}
+		environments = append(environments, info)
+	}
+	return environments, nil
+}
+
+type Promotion struct {
+	CommitSHA string                 `json:"commit_sha"`
+	From      string                 `json:"from"`
+	To        string                 `json:"to"`
+	Plan      map[string]interface{} `json:"plan"`
+	PlanText  string                 `json:"plan_text"`
+	// Approvals needed before the promoted change can be applied to To
+	RequiredApprovals int `json:"required_approvals"`
+}
+
+// Promote re-plans a commit that was planned against one environment against another one,
+// the next environment in promotion order when to is empty. The environments are read from
+// the configuration of that commit, so a change is promoted with the configuration it was
+// planned with.
+func (o *Orchestrator) Promote(sha, from, to string) (*Promotion, error) {
+	if _, err := o.CloneRepo(); err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	if err := o.checkoutRevision(sha); err != nil {
+		return nil, fmt.Errorf("error in checkoutRevision: %w", err)
+	}
+
+	config, err := repoconfig.Load(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	if from == "" || config.Environment(from) == nil {
+		return nil, fmt.Errorf("environment %q is not declared in %s", from, repoconfig.FileName)
+	}
+	if to == "" {
+		next := config.NextEnvironment(from)
+		if next == nil {
+			return nil, fmt.Errorf("environment %s is the last one, there is nothing to promote to", from)
+		}
+		to = next.Name
+	}
+	target := config.Environment(to)
+	if target == nil {
+		return nil, fmt.Errorf("environment %q is not declared in %s", to, repoconfig.FileName)
+	}
+
+	o.Environment = to
+	plan, err := o.generateJSONPlan()
+	if err != nil {
+		return nil, fmt.Errorf("error in generateJSONPlan: %w", err)
+	}
+
+	planText, err := o.lastRun.engine.ShowText()
+	if err != nil {
+		return nil, fmt.Errorf("error in ShowText: %w", err)
+	}
+
+	return &Promotion{
+		CommitSHA:         sha,
+		From:              from,
+		To:                to,
+		Plan:              plan,
+		PlanText:          planText,
+		RequiredApprovals: target.Approvals.Required,
+	}, nil
+}
@@ -0,0 +1,44 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const conversationCommitPrefix = "Update terraform config for conversation"
+
+// ConversationCommitMessage builds the commit message for a conversation update.
+// The prompt, if any, is kept in the commit body so the branch carries its own prompt history.
+func ConversationCommitMessage(conversationID, prompt string) string {
+	subject := fmt.Sprintf("%s %s", conversationCommitPrefix, conversationID)
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return subject
+	}
+	return fmt.Sprintf("%s\n\n%s", subject, prompt)
+}
+
+// PromptHistory returns the prompts recorded on HEAD of the clone in dir since it diverged from
+// baseBranch, oldest first.
+func PromptHistory(dir, baseBranch string) ([]string, error) {
+	// %x1e separates commits, %x1f separates subject and body
+	cmd := exec.Command("git", "log", "--reverse", "--format=%s%x1f%b%x1e", fmt.Sprintf("origin/%s..HEAD", baseBranch))
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit history: %s, %w", string(output), err)
+	}
+
+	prompts := []string{}
+	for _, entry := range strings.Split(string(output), "\x1e") {
+		subject, body, found := strings.Cut(strings.TrimSpace(entry), "\x1f")
+		if !found || !strings.HasPrefix(subject, conversationCommitPrefix) {
+			continue
+		}
+		if body = strings.TrimSpace(body); body != "" {
+			prompts = append(prompts, body)
+		}
+	}
+	return prompts, nil
+}
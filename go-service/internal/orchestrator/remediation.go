@@ -0,0 +1,202 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/repoconfig"
+)
+
+// Ways to remediate drift
+const (
+	// RemediateCodify changes the configuration to match the infrastructure
+	RemediateCodify = "codify"
+	// RemediateRevert keeps the configuration, applying the conversation puts the infrastructure back
+	RemediateRevert = "revert"
+)
+
+// maxCodifyAttempts bounds the model calls of one remediation, each attempt sees the plan of the previous one
+const maxCodifyAttempts = 3
+
+// CodifyRequest asks for the configuration changes that make a plan of the drifted
+// infrastructure come out empty.
+type CodifyRequest struct {
+	Drift []ResourceDrift
+	// Files are the current .tf files of the repository
+	Files []FileContent
+	// Attempt starts at 1, PlanText holds the plan of the previous attempt after that
+	Attempt  int
+	PlanText string
+}
+
+// Codifier writes HCL for drifted infrastructure, usually with a language model.
+type Codifier interface {
+	// Codify returns the files to create or replace, paths are relative to the repository root
+	Codify(ctx context.Context, request *CodifyRequest) ([]FileContent, error)
+}
+
+type Remediation struct {
+	ConversationID string `json:"conversation_id"`
+	Mode           string `json:"mode"`
+	CommitSHA      string `json:"commit_sha"`
+	// Files the codifier wrote, empty for revert
+	Files    []FileContent `json:"files"`
+	Attempts int           `json:"attempts"`
+	// Converged is true when the final plan has no changes, which is the goal of codify
+	Converged bool                   `json:"converged"`
+	Summary   *PlanSummary           `json:"summary"`
+	Plan      map[string]interface{} `json:"plan"`
+	PlanText  string                 `json:"plan_text"`
+}
+
+// Remediate opens a conversation branch for drift of the default branch. codify lets the
+// codifier rewrite the configuration until the plan shows no changes, or until it ran out of
+// attempts. revert commits nothing but a marker, so the plan of the conversation is what
+// applying the code again would change back. The workspace of the conversation starts from the
+// repository's state so its plans see the same infrastructure.
+func (o *Orchestrator) Remediate(conversationID, mode string, drift []ResourceDrift, codifier Codifier) (*Remediation, error) {
+	if mode != RemediateCodify && mode != RemediateRevert {
+		return nil, fmt.Errorf("unknown remediation mode %q, expected %s or %s", mode, RemediateCodify, RemediateRevert)
+	}
+	if mode == RemediateCodify && codifier == nil {
+		return nil, fmt.Errorf("no model provider is configured to codify drift")
+	}
+
+	if _, err := o.CloneRepo(); err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	if err := o.GetOrCreateBranch(conversationID); err != nil {
+		return nil, fmt.Errorf("error in getOrCreateBranch: %w", err)
+	}
+	o.Workspace = conversationID
+
+	remediation := &Remediation{ConversationID: conversationID, Mode: mode, Files: []FileContent{}}
+	if mode == RemediateRevert {
+		if err := o.commitRemediation(conversationID, "Revert drift by applying the code again", nil); err != nil {
+			return nil, err
+		}
+		if err := o.planRemediation(remediation); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := o.codify(remediation, drift, codifier); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := o.pushToRemote(conversationID); err != nil {
+		return nil, fmt.Errorf("error in pushToRemote: %w", err)
+	}
+	sha, err := o.git("rev-parse", "HEAD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	remediation.CommitSHA = strings.TrimSpace(string(sha))
+	return remediation, nil
+}
+
+func (o *Orchestrator) codify(remediation *Remediation, drift []ResourceDrift, codifier Codifier) error {
+	written := map[string]FileContent{}
+	planText := ""
+	for attempt := 1; attempt <= maxCodifyAttempts; attempt++ {
+		files, err := getTerraformFiles(o.dir)
+		if err != nil {
+			return fmt.Errorf("error in getTerraformFiles: %w", err)
+		}
+
+		changes, err := codifier.Codify(o.context, &CodifyRequest{
+			Drift:    drift,
+			Files:    files,
+			Attempt:  attempt,
+			PlanText: planText,
+		})
+		if err != nil {
+			return fmt.Errorf("error in Codify: %w", err)
+		}
+		for _, change := range changes {
+			path, err := writeCodifiedFile(o.dir, change)
+			if err != nil {
+				return err
+			}
+			change.Path = path
+			written[path] = change
+		}
+
+		remediation.Attempts = attempt
+		if err := o.planRemediation(remediation); err != nil {
+			return err
+		}
+		log.Printf("Codify attempt %d of %s: %s", attempt, remediation.ConversationID, remediation.Summary.String())
+		if remediation.Converged {
+			break
+		}
+		planText = remediation.PlanText
+	}
+
+	paths := []string{}
+	for path, file := range written {
+		paths = append(paths, path)
+		remediation.Files = append(remediation.Files, file)
+	}
+	message := fmt.Sprintf("Codify drift of %d resource(s)", len(drift))
+	return o.commitRemediation(remediation.ConversationID, message, paths)
+}
+
+// writeCodifiedFile writes a file from the codifier into the clone in dir, the codifier may only
+// touch .tf files inside the repository. The returned path is relative to dir.
+func writeCodifiedFile(dir string, file FileContent) (string, error) {
+	path, err := repoconfig.CleanPath(file.Path)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Ext(path) != ".tf" || path == "." {
+		return "", fmt.Errorf("codified file %q is not a .tf file", file.Path)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, path), []byte(file.Content), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+func (o *Orchestrator) planRemediation(remediation *Remediation) error {
+	plan, err := o.generateJSONPlan()
+	if err != nil {
+		return fmt.Errorf("error in generateJSONPlan: %w", err)
+	}
+	planText, err := o.lastRun.engine.ShowText()
+	if err != nil {
+		return fmt.Errorf("error in ShowText: %w", err)
+	}
+
+	remediation.Plan = plan
+	remediation.PlanText = planText
+	remediation.Summary = SummarizePlan(plan)
+	remediation.Converged = !remediation.Summary.HasChanges()
+	return nil
+}
+
+// commitRemediation commits only the given paths, plans leave state and plan files behind
+// that must not end up on the branch. Without paths the commit is empty.
+func (o *Orchestrator) commitRemediation(conversationID, prompt string, paths []string) error {
+	if len(paths) > 0 {
+		cmd := o.git(append([]string{"add", "--"}, paths...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add files: %s, %w", string(output), err)
+		}
+	}
+
+	cmd := o.git("commit", "--allow-empty", "-m", ConversationCommitMessage(conversationID, prompt))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to commit: %s, %w", string(output), err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package orchestrator
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/benkamin03/prism/internal/repoconfig"
+)
+
+// A backend or provider block marks a directory that is applied on its own rather than used as a module
+var rootBlockPattern = regexp.MustCompile(`(?m)^\s*(backend|provider)\s+"[^"]*"\s*\{`)
+
+// skipDir reports directories that never contain root modules of the repository itself.
+func skipDir(name string) bool {
+	return name == ".git" || name == ".terraform" || name == ".terragrunt-cache" || name == "node_modules"
+}
+
+// DiscoverRoots returns the root modules under dir, relative to it and sorted. Roots listed in
+// the repository configuration take precedence over scanning for backend and provider blocks.
+func DiscoverRoots(dir string, config *repoconfig.Config) ([]string, error) {
+	if len(config.Roots) > 0 {
+		return config.RootPaths(), nil
+	}
+
+	found := map[string]bool{}
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && skipDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".tf" {
+			return nil
+		}
+
+		rootDir, err := filepath.Rel(dir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if found[rootDir] {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if rootBlockPattern.Match(content) {
+			found[rootDir] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover root modules: %w", err)
+	}
+
+	roots := []string{}
+	for root := range found {
+		roots = append(roots, filepath.ToSlash(root))
+	}
+	sort.Strings(roots)
+	return roots, nil
+}
+
+// rootForPath returns the deepest root containing the file at path, or "" if there is none.
+func rootForPath(roots []string, path string) string {
+	dir := filepath.ToSlash(filepath.Dir(path))
+	best := ""
+	for _, root := range roots {
+		if root != "." && dir != root && !strings.HasPrefix(dir, root+"/") {
+			continue
+		}
+		if best == "" || best == "." || (root != "." && len(root) > len(best)) {
+			best = root
+		}
+	}
+	return best
+}
+
+// stateObjectFor keeps the repository root at the original object name so existing state is still found.
+// Each environment keeps the state of its roots below its own prefix.
+func stateObjectFor(settings *repoconfig.RootSettings) string {
+	object := stateObjectName
+	if settings.Root != "." {
+		object = settings.Root + "/" + stateObjectName
+	}
+	if settings.Environment != "" {
+		object = "environments/" + settings.Environment + "/" + object
+	}
+	return object
+}
+
+// maxParallelRoots bounds the terraform processes started for one request
+const maxParallelRoots = 4
+
+type RootPlan struct {
+	Root           string                 `json:"root"`
+	Plan           map[string]interface{} `json:"plan,omitempty"`
+	SecretMappings []SecretMappingUse     `json:"secret_mappings,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+}
+
+// ListRoots clones the repository and returns its root modules.
+func (o *Orchestrator) ListRoots() ([]string, error) {
+	tmpDir, err := o.CloneRepo()
+	if err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	config, err := repoconfig.Load(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	return DiscoverRoots(tmpDir, config)
+}
+
+// PlanRoots clones the repository and plans the given roots in parallel, every discovered root
+// when none are given. A root that fails to plan is reported in its result without failing the others.
+func (o *Orchestrator) PlanRoots(roots []string) ([]RootPlan, error) {
+	tmpDir, err := o.CloneRepo()
+	if err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	bucketName, config, err := o.prepareRun()
+	if err != nil {
+		return nil, fmt.Errorf("error in prepareRun: %w", err)
+	}
+
+	if len(roots) == 0 {
+		if roots, err = DiscoverRoots(tmpDir, config); err != nil {
+			return nil, fmt.Errorf("error in DiscoverRoots: %w", err)
+		}
+		if len(roots) == 0 {
+			return nil, fmt.Errorf("no root modules found in repository")
+		}
+	}
+
+	cleanedRoots := []string{}
+	for _, root := range roots {
+		cleaned, err := repoconfig.CleanPath(root)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(filepath.Join(tmpDir, cleaned)); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("root %q does not exist in repository", root)
+		}
+		cleanedRoots = append(cleanedRoots, cleaned)
+	}
+
+	results := make([]RootPlan, len(cleanedRoots))
+	semaphore := make(chan struct{}, maxParallelRoots)
+	var wg sync.WaitGroup
+	for i, root := range cleanedRoots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[i].Root = root
+			settings, err := config.ForEnvironment(root, o.Environment)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			run, err := o.prepareRoot(settings)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			plan, err := o.planRoot(bucketName, run)
+			if err != nil {
+				log.Printf("Failed to plan root %s: %v", root, err)
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Plan = plan
+			results[i].SecretMappings = run.secretMappings
+		}()
+	}
+	wg.Wait()
+
+	return results, nil
+}
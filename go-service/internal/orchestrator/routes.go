@@ -2,32 +2,69 @@ package orchestrator
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"strconv"
 
-	"github.com/benkamin03/prism/internal/infisical"
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
 	"github.com/benkamin03/prism/internal/minio"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/benkamin03/prism/internal/tfstate"
+	"github.com/benkamin03/prism/internal/variables"
 	"github.com/labstack/echo/v4"
 )
 
 type OrchestratorRoutesConfig struct {
-	Echo            *echo.Echo
-	MinioClient     minio.MinioClient
-	InfisicalClient infisical.InfisicalClient
+	Echo        *echo.Echo
+	MinioClient minio.MinioClient
+	Secrets     secrets.Provider
+	GitHubApp   *github.App
+	Engines     *engine.Selector
+	Store       *store.Store
 }
 
 type PlanRequest struct {
 	RepoURL     string `json:"repo_url" validate:"required"`
-	GitHubToken string `json:"github_token" validate:"required"`
+	GitHubToken string `json:"github_token"` // replaced by the GitHub App token on imported repositories it can push to
 	UserID      string `json:"user_id" validate:"required"`
 	ProjectID   string `json:"project_id" validate:"required"`
+	// Roots to plan in parallel, relative to the repository root. When roots or all_roots is set
+	// the response holds one result per root instead of a single plan.
+	Roots    []string `json:"roots,omitempty"`
+	AllRoots bool     `json:"all_roots,omitempty"`
+	// Environment from the repository configuration, the first one by default
+	Environment string `json:"environment,omitempty"`
+}
+
+// PromoteRequest re-plans the commit planned against From against To, the environment after From by default.
+type PromoteRequest struct {
+	RepoURL     string `json:"repo_url"`
+	GitHubToken string `json:"github_token"`
+	UserID      string `json:"user_id"`
+	ProjectID   string `json:"project_id"`
+	CommitSHA   string `json:"commit_sha"`
+	From        string `json:"from"`
+	To          string `json:"to,omitempty"`
+}
+
+// StateOperationRequest changes the state of the repository, or of a conversation's workspace
+// when conversation_id is set.
+type StateOperationRequest struct {
+	RepoURL        string `json:"repo_url"`
+	GitHubToken    string `json:"github_token"`
+	UserID         string `json:"user_id"`
+	ProjectID      string `json:"project_id"`
+	Environment    string `json:"environment,omitempty"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	StateOperation
 }
 
 func SetupRoutes(routesConfig *OrchestratorRoutesConfig) {
 	e := routesConfig.Echo
-	log.Printf("infisicalClient (from SetupRoutes): %+v", routesConfig.InfisicalClient)
 
 	e.POST("/plan", func(c echo.Context) error {
 		var planRequest PlanRequest
@@ -39,37 +76,381 @@ func SetupRoutes(routesConfig *OrchestratorRoutesConfig) {
 			return c.String(http.StatusBadRequest, fmt.Sprintf("Error parsing request body: %v", err))
 		}
 
-		log.Printf("infisicalClient (from routes): %+v", routesConfig.InfisicalClient)
+		githubToken := routesConfig.GitHubApp.TokenFor(planRequest.RepoURL, planRequest.GitHubToken)
+		if githubToken == "" {
+			return c.String(http.StatusBadRequest, "github_token is required")
+		}
 
 		orchestrator := NewOrchestrator(&NewOrchestratorInput{
-			RepoURL:         planRequest.RepoURL,
-			GitHubToken:     planRequest.GitHubToken,
-			UserID:          planRequest.UserID,
-			MinioClient:     routesConfig.MinioClient,
-			InfisicalClient: routesConfig.InfisicalClient,
-			ProjectID:       planRequest.ProjectID,
-			context:         c.Request().Context(),
+			RepoURL:     planRequest.RepoURL,
+			GitHubToken: githubToken,
+			UserID:      planRequest.UserID,
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			ProjectID:   planRequest.ProjectID,
+			Environment: planRequest.Environment,
+			Context:     c.Request().Context(),
 		})
 
+		if len(planRequest.Roots) > 0 || planRequest.AllRoots {
+			results, err := orchestrator.PlanRoots(planRequest.Roots)
+			if err != nil {
+				return c.String(http.StatusInternalServerError, fmt.Sprintf("Error executing plan: %v", err))
+			}
+			return c.JSON(http.StatusOK, echo.Map{"roots": results})
+		}
+
 		response, err := orchestrator.Plan()
+		var missingErr *variables.MissingError
+		if errors.As(err, &missingErr) {
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": missingErr.Error(), "missing_variables": missingErr.Variables})
+		}
 		if err != nil {
 			return c.String(http.StatusInternalServerError, fmt.Sprintf("Error executing plan: %v", err))
 		}
+		// Names of the mappings only, next to the keys of the terraform plan
+		response["secret_mappings"] = orchestrator.SecretMappings()
 
 		return c.JSON(http.StatusOK, response)
 	})
 
+	e.GET("/roots", func(c echo.Context) error {
+		repoURL := c.QueryParam("repo_url")
+		githubToken := routesConfig.GitHubApp.TokenFor(repoURL, c.Request().Header.Get("Authorization"))
+
+		orchestrator := NewOrchestrator(&NewOrchestratorInput{
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+			GitHubToken: githubToken,
+			RepoURL:     repoURL,
+		})
+
+		roots, err := orchestrator.ListRoots()
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("Error discovering roots: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{"roots": roots})
+	})
+
+	e.GET("/environments", func(c echo.Context) error {
+		repoURL := c.QueryParam("repo_url")
+		githubToken := routesConfig.GitHubApp.TokenFor(repoURL, c.Request().Header.Get("Authorization"))
+
+		orchestrator := NewOrchestrator(&NewOrchestratorInput{
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+			GitHubToken: githubToken,
+			RepoURL:     repoURL,
+		})
+
+		environments, err := orchestrator.ListEnvironments()
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("Error listing environments: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{"environments": environments})
+	})
+
+	e.POST("/promote", func(c echo.Context) error {
+		var req PromoteRequest
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("Error parsing request body: %v", err))
+		}
+		if req.CommitSHA == "" || req.From == "" {
+			return c.String(http.StatusBadRequest, "commit_sha and from are required")
+		}
+
+		githubToken := routesConfig.GitHubApp.TokenFor(req.RepoURL, req.GitHubToken)
+		if githubToken == "" {
+			return c.String(http.StatusBadRequest, "github_token is required")
+		}
+
+		orchestrator := NewOrchestrator(&NewOrchestratorInput{
+			RepoURL:     req.RepoURL,
+			GitHubToken: githubToken,
+			UserID:      req.UserID,
+			ProjectID:   req.ProjectID,
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+		})
+
+		promotion, err := orchestrator.Promote(req.CommitSHA, req.From, req.To)
+		var missingErr *variables.MissingError
+		if errors.As(err, &missingErr) {
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": missingErr.Error(), "missing_variables": missingErr.Variables})
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("Error promoting %s: %v", req.CommitSHA, err))
+		}
+
+		return c.JSON(http.StatusOK, promotion)
+	})
+
+	// GET /variables/check?repo_url=...&root=...&environment=...&project_id=...
+	// Reports the declared variables of a root module and which of them have no value.
+	// Secrets only count as TF_VAR_ values when project_id is given.
+	e.GET("/variables/check", func(c echo.Context) error {
+		repoURL := c.QueryParam("repo_url")
+		githubToken := routesConfig.GitHubApp.TokenFor(repoURL, c.Request().Header.Get("Authorization"))
+
+		orchestrator := NewOrchestrator(&NewOrchestratorInput{
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+			GitHubToken: githubToken,
+			RepoURL:     repoURL,
+			ProjectID:   c.QueryParam("project_id"),
+		})
+
+		report, err := orchestrator.CheckVariables(c.QueryParam("root"), c.QueryParam("environment"))
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("Error checking variables: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, report)
+	})
+
+	// readState parses the state selected by the query, the repository's own state or with
+	// workspace a conversation's, root and environment pick the root module. States hold every
+	// attribute of the resources, only callers who can push to the repository may read them.
+	readState := func(c echo.Context) (*tfstate.State, error) {
+		repoURL := c.QueryParam("repo_url")
+		if err := github.RequirePush(repoURL, c.Request().Header.Get("Authorization")); err != nil {
+			return nil, err
+		}
+
+		orchestrator := NewOrchestrator(&NewOrchestratorInput{
+			MinioClient: routesConfig.MinioClient,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+			RepoURL:     repoURL,
+		})
+		orchestrator.Workspace = c.QueryParam("workspace")
+		return orchestrator.ReadState(c.QueryParam("root"), c.QueryParam("environment"))
+	}
+	stateError := func(c echo.Context, err error) error {
+		if errors.Is(err, github.ErrNoToken) || errors.Is(err, github.ErrNoPushAccess) || errors.Is(err, github.ErrInvalidRepoURL) {
+			return c.String(github.AccessStatus(err), err.Error())
+		}
+		if errors.Is(err, ErrNotImported) {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		if errors.Is(err, minio.ErrObjectNotFound) {
+			return c.String(http.StatusNotFound, "No state stored for this root")
+		}
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Error reading state: %v", err))
+	}
+
+	// GET /state/resources?repo_url=...&workspace=...&root=...&environment=...&type=...&module=...&provider=...
+	// Lists the resource addresses in the stored state, without cloning the repository
+	e.GET("/state/resources", func(c echo.Context) error {
+		state, err := readState(c)
+		if err != nil {
+			return stateError(c, err)
+		}
+
+		resources := state.ListResources(&tfstate.Filter{
+			Type:     c.QueryParam("type"),
+			Module:   c.QueryParam("module"),
+			Provider: c.QueryParam("provider"),
+		})
+		return c.JSON(http.StatusOK, echo.Map{
+			"serial":            state.Serial,
+			"terraform_version": state.TerraformVersion,
+			"resources":         resources,
+		})
+	})
+
+	// GET /state/resource?address=...&repo_url=...&workspace=...&root=...&environment=...
+	// Shows the attributes of one resource instance, sensitive values are masked
+	e.GET("/state/resource", func(c echo.Context) error {
+		address := c.QueryParam("address")
+		if address == "" {
+			return c.String(http.StatusBadRequest, "address is required")
+		}
+
+		state, err := readState(c)
+		if err != nil {
+			return stateError(c, err)
+		}
+
+		resource := state.FindResource(address)
+		if resource == nil {
+			return c.String(http.StatusNotFound, fmt.Sprintf("Resource %s not found in state", address))
+		}
+		return c.JSON(http.StatusOK, resource)
+	})
+
+	// GET /state/outputs?repo_url=...&workspace=...&root=...&environment=...
+	e.GET("/state/outputs", func(c echo.Context) error {
+		state, err := readState(c)
+		if err != nil {
+			return stateError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{"outputs": state.MaskedOutputs()})
+	})
+
+	// POST /state/operations
+	// Runs state mv, rm, import, generate-config or replace-provider, body is a StateOperationRequest
+	e.POST("/state/operations", func(c echo.Context) error {
+		var req StateOperationRequest
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("Error parsing request body: %v", err))
+		}
+		if err := req.Validate(); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+
+		// The audit log names whoever the token belongs to
+		if err := github.RequirePush(req.RepoURL, req.GitHubToken); err != nil {
+			return c.String(github.AccessStatus(err), err.Error())
+		}
+		actor, err := github.NewClient(req.GitHubToken).Login()
+		if err != nil {
+			return c.String(http.StatusBadGateway, err.Error())
+		}
+		req.Actor = actor
+		githubToken := routesConfig.GitHubApp.TokenFor(req.RepoURL, req.GitHubToken)
+
+		orchestrator := NewOrchestrator(&NewOrchestratorInput{
+			RepoURL:     req.RepoURL,
+			GitHubToken: githubToken,
+			UserID:      req.UserID,
+			ProjectID:   req.ProjectID,
+			Environment: req.Environment,
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+		})
+		orchestrator.Workspace = req.ConversationID
+
+		result, err := orchestrator.EditState(&req.StateOperation)
+		var missingErr *variables.MissingError
+		if errors.As(err, &missingErr) {
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": missingErr.Error(), "missing_variables": missingErr.Variables})
+		}
+		var lockedErr *StateLockedError
+		if errors.As(err, &lockedErr) {
+			return c.String(http.StatusConflict, fmt.Sprintf("Error running state %s: %v", req.Operation, err))
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("Error running state %s: %v", req.Operation, err))
+		}
+
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// GET /state/operations?repo_url=...&limit=...
+	// Lists the audit log of state operations, newest first, for callers who can push to the repository
+	e.GET("/state/operations", func(c echo.Context) error {
+		repoURL := c.QueryParam("repo_url")
+		if err := github.RequirePush(repoURL, c.Request().Header.Get("Authorization")); err != nil {
+			return c.String(github.AccessStatus(err), err.Error())
+		}
+
+		limit := 50
+		if value := c.QueryParam("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				return c.String(http.StatusBadRequest, "limit must be a positive number")
+			}
+			limit = parsed
+		}
+
+		orchestrator := NewOrchestrator(&NewOrchestratorInput{
+			Store:   routesConfig.Store,
+			Context: c.Request().Context(),
+			RepoURL: repoURL,
+		})
+
+		operations, err := orchestrator.ListStateOperations(limit)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("Error listing state operations: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{"operations": operations})
+	})
+
+	// GET /workspaces?repo_url=...
+	// Lists the conversations of the repository that have their own state, for callers who can push to it
+	e.GET("/workspaces", func(c echo.Context) error {
+		repoURL := c.QueryParam("repo_url")
+		if err := github.RequirePush(repoURL, c.Request().Header.Get("Authorization")); err != nil {
+			return c.String(github.AccessStatus(err), err.Error())
+		}
+
+		orchestrator := NewOrchestrator(&NewOrchestratorInput{
+			MinioClient: routesConfig.MinioClient,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+			RepoURL:     repoURL,
+		})
+
+		workspaces, err := orchestrator.ListWorkspaces()
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("Error listing workspaces: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{"workspaces": workspaces})
+	})
+
+	// DELETE /workspaces/:conversationID?repo_url=...
+	// Removes the state of a conversation, its next plan starts from an empty state again. Only
+	// callers who can push to the repository of the workspace may remove it.
+	e.DELETE("/workspaces/:conversationID", func(c echo.Context) error {
+		repoURL := c.QueryParam("repo_url")
+		if err := github.RequirePush(repoURL, c.Request().Header.Get("Authorization")); err != nil {
+			return c.String(github.AccessStatus(err), err.Error())
+		}
+
+		orchestrator := NewOrchestrator(&NewOrchestratorInput{
+			MinioClient: routesConfig.MinioClient,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+			RepoURL:     repoURL,
+		})
+
+		deleted, err := orchestrator.DeleteWorkspace(c.Param("conversationID"))
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("Error deleting workspace: %v", err))
+		}
+		if !deleted {
+			return c.String(http.StatusNotFound, "Workspace not found")
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{"deleted": true})
+	})
+
 	e.GET("/conversations/:conversationID", func(c echo.Context) error {
 		conversationID := c.Param("conversationID")
 		repoURL := c.QueryParam("repo_url")
-		githubToken := c.Request().Header.Get("Authorization")
+		githubToken := routesConfig.GitHubApp.TokenFor(repoURL, c.Request().Header.Get("Authorization"))
 
 		orchestrator := NewOrchestrator(&NewOrchestratorInput{
-			MinioClient:     routesConfig.MinioClient,
-			InfisicalClient: routesConfig.InfisicalClient,
-			context:         c.Request().Context(),
-			GitHubToken:     githubToken,
-			RepoURL:         repoURL,
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+			GitHubToken: githubToken,
+			RepoURL:     repoURL,
 		})
 
 		response, err := orchestrator.GetConversation(conversationID)
@@ -84,14 +465,16 @@ func SetupRoutes(routesConfig *OrchestratorRoutesConfig) {
 		conversationID := c.Param("conversationID")
 		commitHash := c.Param("commitHash")
 		repoURL := c.QueryParam("repo_url")
-		githubToken := c.Request().Header.Get("Authorization")
+		githubToken := routesConfig.GitHubApp.TokenFor(repoURL, c.Request().Header.Get("Authorization"))
 
 		orchestrator := NewOrchestrator(&NewOrchestratorInput{
-			MinioClient:     routesConfig.MinioClient,
-			InfisicalClient: routesConfig.InfisicalClient,
-			context:         c.Request().Context(),
-			GitHubToken:     githubToken,
-			RepoURL:         repoURL,
+			MinioClient: routesConfig.MinioClient,
+			Secrets:     routesConfig.Secrets,
+			Engines:     routesConfig.Engines,
+			Store:       routesConfig.Store,
+			Context:     c.Request().Context(),
+			GitHubToken: githubToken,
+			RepoURL:     repoURL,
 		})
 
 		response, err := orchestrator.DeleteCommit(conversationID, commitHash)
@@ -0,0 +1,135 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	writerToken = "ghp_writer"
+	readerToken = "ghp_reader"
+)
+
+// fakeGitHub answers the repository permissions of writerToken and readerToken, and who they belong to.
+func fakeGitHub(t *testing.T) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/{owner}/{repo}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "token " + writerToken:
+			json.NewEncoder(w).Encode(map[string]interface{}{"permissions": map[string]bool{"push": true, "pull": true}})
+		case "token " + readerToken:
+			json.NewEncoder(w).Encode(map[string]interface{}{"permissions": map[string]bool{"pull": true}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token "+writerToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"login": "writer"})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	t.Setenv("GITHUB_API_URL", server.URL)
+}
+
+// serveRoutes sends a request to the orchestrator routes without a store or MinIO, which rejected
+// requests never reach.
+func serveRoutes(t *testing.T, method, target, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveBody(t, method, target, token, "")
+}
+
+func serveBody(t *testing.T, method, target, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	e := echo.New()
+	SetupRoutes(&OrchestratorRoutesConfig{Echo: e})
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestStateRoutesRequirePushAccess(t *testing.T) {
+	fakeGitHub(t)
+	query := "?repo_url=https://github.com/acme/infra.git&address=aws_s3_bucket.logs"
+
+	for _, path := range []string{"/state/resources", "/state/resource", "/state/outputs"} {
+		if rec := serveRoutes(t, http.MethodGet, path+query, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected %s without a token to be rejected, got %d", path, rec.Code)
+		}
+		if rec := serveRoutes(t, http.MethodGet, path+query, readerToken); rec.Code != http.StatusForbidden {
+			t.Errorf("expected %s for a caller who can only read the repository to be rejected, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestStateRoutesOnlyReadImportedRepositories(t *testing.T) {
+	fakeGitHub(t)
+
+	// Without the imported repository no bucket is known to hold the state
+	rec := serveRoutes(t, http.MethodGet, "/state/outputs?repo_url=https://github.com/acme/infra.git&user_id=someone", writerToken)
+	if rec.Code != http.StatusNotFound || rec.Body.String() != ErrNotImported.Error() {
+		t.Errorf("expected the state of a repository that was not imported to be refused, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWorkspaceRoutesRequirePushAccess(t *testing.T) {
+	fakeGitHub(t)
+	query := "?repo_url=https://github.com/acme/infra.git"
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"caller without a token", "", http.StatusUnauthorized},
+		{"caller who can only read the repository", readerToken, http.StatusForbidden},
+		{"caller who can not see the repository", "ghp_stranger", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serveRoutes(t, http.MethodGet, "/workspaces"+query, tt.token); rec.Code != tt.status {
+				t.Errorf("expected listing the workspaces to answer %d, got %d", tt.status, rec.Code)
+			}
+			if rec := serveRoutes(t, http.MethodDelete, "/workspaces/fix-bucket"+query, tt.token); rec.Code != tt.status {
+				t.Errorf("expected deleting the workspace to answer %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestStateOperationRoutesRequirePushAccess(t *testing.T) {
+	fakeGitHub(t)
+
+	body := func(token string) string {
+		return `{"repo_url":"https://github.com/acme/infra.git","github_token":"` + token + `","operation":"rm","addresses":["aws_s3_bucket.logs"],"actor":"someone-else"}`
+	}
+	if rec := serveBody(t, http.MethodPost, "/state/operations", "", body("")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a state operation without a token to be rejected, got %d", rec.Code)
+	}
+	if rec := serveBody(t, http.MethodPost, "/state/operations", "", body(readerToken)); rec.Code != http.StatusForbidden {
+		t.Errorf("expected a state operation of a caller who can only read to be rejected, got %d", rec.Code)
+	}
+
+	query := "/state/operations?repo_url=https://github.com/acme/infra.git"
+	if rec := serveRoutes(t, http.MethodGet, query, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected the audit log without a token to be rejected, got %d", rec.Code)
+	}
+	if rec := serveRoutes(t, http.MethodGet, query, readerToken); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the audit log for a caller who can only read to be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/secrets"
+)
+
+// Targets of a secret mapping
+const (
+	MappingEnv   = "env"
+	MappingTFVar = "tf_var"
+	MappingFile  = "file"
+)
+
+// SecretMappingUse names a mapping a run used, never the value it handed over.
+type SecretMappingUse struct {
+	Secret string `json:"secret"`
+	Target string `json:"target"`
+	// Name is the environment variable, or the file name for file mappings
+	Name string `json:"name"`
+	// Env receives the path of the file of a file mapping
+	Env string `json:"env,omitempty"`
+}
+
+// MapSecrets turns the secrets of a root module into the environment of its engine following
+// its mappings. Secrets without a mapping are left out, a mapped secret that does not exist
+// fails the run. Credentials files are written to credentialsDir, which has to exist.
+func MapSecrets(values map[string]string, mappings []repoconfig.SecretMapping, credentialsDir string) ([]string, []SecretMappingUse, error) {
+	env := []string{}
+	uses := []SecretMappingUse{}
+	for _, mapping := range mappings {
+		value, ok := values[mapping.Secret]
+		if !ok {
+			return nil, nil, fmt.Errorf("secret %s is mapped in %s but does not exist: %w", mapping.Secret, repoconfig.FileName, secrets.ErrNotFound)
+		}
+
+		switch {
+		case mapping.File != "":
+			path := filepath.Join(credentialsDir, mapping.File)
+			if err := os.WriteFile(path, []byte(value), 0600); err != nil {
+				return nil, nil, fmt.Errorf("failed to write credentials file %s: %w", mapping.File, err)
+			}
+			if mapping.Env != "" {
+				env = append(env, mapping.Env+"="+path)
+			}
+			uses = append(uses, SecretMappingUse{Secret: mapping.Secret, Target: MappingFile, Name: mapping.File, Env: mapping.Env})
+		case mapping.TFVar != "":
+			name := "TF_VAR_" + mapping.TFVar
+			env = append(env, name+"="+value)
+			uses = append(uses, SecretMappingUse{Secret: mapping.Secret, Target: MappingTFVar, Name: name})
+		default:
+			env = append(env, mapping.Env+"="+value)
+			uses = append(uses, SecretMappingUse{Secret: mapping.Secret, Target: MappingEnv, Name: mapping.Env})
+		}
+	}
+	return env, uses, nil
+}
+
+// SecretEnv fetches the secrets of a root module and maps them to KEY=VALUE pairs for the
+// terraform environment. Roots can use different Infisical environments, so they are not set
+// on the process. Credentials files go to a directory of their own that Cleanup removes.
+// The project set in the repository configuration wins over the one the orchestrator was
+// given, so requests plan with the same secrets as webhooks and drift checks.
+func (o *Orchestrator) SecretEnv(settings *repoconfig.RootSettings) ([]string, []SecretMappingUse, error) {
+	if len(settings.Secrets) == 0 {
+		log.Printf("No secrets mapped for %s, planning without secrets", settings.Root)
+		return nil, []SecretMappingUse{}, nil
+	}
+	projectID := settings.InfisicalProject
+	if projectID == "" {
+		projectID = o.ProjectID
+	}
+	if projectID == "" {
+		// Planning without the mapped secrets would show changes that are not there
+		return nil, nil, fmt.Errorf("%s maps secrets of %s but sets no infisical.project", repoconfig.FileName, settings.Root)
+	}
+
+	values, err := o.Secrets.List(o.context, &secrets.Options{
+		Environment: settings.InfisicalEnvironment,
+		ProjectID:   projectID,
+		SecretPath:  settings.SecretPath,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch secrets: %w", err)
+	}
+	var credentialsDir string
+	for _, mapping := range settings.Secrets {
+		if mapping.File != "" {
+			if credentialsDir, err = o.tempDir("", "prism-credentials-"); err != nil {
+				return nil, nil, fmt.Errorf("failed to create credentials directory: %w", err)
+			}
+			break
+		}
+	}
+	env, uses, err := MapSecrets(values, settings.Secrets, credentialsDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, use := range uses {
+		log.Printf("Mapping secret %s to %s %s", use.Secret, use.Target, use.Name)
+		// Whatever the engine prints of the value is redacted from then on
+		o.redactor.Add(values[use.Secret])
+	}
+	return env, uses, nil
+}
+
+// SecretMappings returns the mappings used by the last plan, empty before any plan.
+func (o *Orchestrator) SecretMappings() []SecretMappingUse {
+	if o.lastRun == nil || o.lastRun.secretMappings == nil {
+		return []SecretMappingUse{}
+	}
+	return o.lastRun.secretMappings
+}
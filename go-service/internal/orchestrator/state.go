@@ -0,0 +1,60 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/tfstate"
+)
+
+// stateSettings names the stored state of a root module in an environment without cloning the
+// repository, the environment must be given when the repository declares environments.
+func stateSettings(root, environment string) (*repoconfig.RootSettings, error) {
+	if root == "" {
+		root = "."
+	}
+	root, err := repoconfig.CleanPath(root)
+	if err != nil {
+		return nil, err
+	}
+	if environment != "" && !repoconfig.ValidEnvironment(environment) {
+		return nil, fmt.Errorf("environment %q is not a valid environment slug", environment)
+	}
+	return &repoconfig.RootSettings{Root: root, Environment: environment}, nil
+}
+
+// ErrNotImported is returned for the repository's own state of a repository that was never imported.
+var ErrNotImported = errors.New("repository is not imported into Prism")
+
+// ReadState parses the stored state of a root module, the one of the orchestrator's workspace
+// when it has one. The error wraps minio.ErrObjectNotFound when the root was never planned.
+func (o *Orchestrator) ReadState(root, environment string) (*tfstate.State, error) {
+	settings, err := stateSettings(root, environment)
+	if err != nil {
+		return nil, err
+	}
+	stateObject, err := o.stateObject(settings)
+	if err != nil {
+		return nil, err
+	}
+	bucketName := WorkspaceBucket
+	if o.Workspace == "" {
+		// A user's bucket holds the state of each of their repositories under the same names, only
+		// the bucket of the user who imported the repository is known to hold this one
+		repo, err := o.importedRepository()
+		if err != nil {
+			return nil, err
+		}
+		if repo == nil {
+			return nil, ErrNotImported
+		}
+		bucketName = repo.UserID
+	}
+
+	content, err := o.MinioClient.ReadObject(o.context, bucketName, stateObject)
+	if err != nil {
+		return nil, fmt.Errorf("error in ReadObject: %w", err)
+	}
+	return tfstate.Parse(content)
+}
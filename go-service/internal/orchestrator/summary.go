@@ -0,0 +1,82 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// PlanSummary counts the actions in a terraform plan JSON document.
+type PlanSummary struct {
+	Create    int      `json:"create"`
+	Update    int      `json:"update"`
+	Replace   int      `json:"replace"`
+	Delete    int      `json:"delete"`
+	Destroyed []string `json:"destroyed"`
+	Replaced  []string `json:"replaced"`
+}
+
+func SummarizePlan(plan map[string]interface{}) *PlanSummary {
+	summary := &PlanSummary{
+		Destroyed: []string{},
+		Replaced:  []string{},
+	}
+
+	resourceChanges, _ := plan["resource_changes"].([]interface{})
+	for _, rc := range resourceChanges {
+		resourceChange, ok := rc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		address, _ := resourceChange["address"].(string)
+		change, _ := resourceChange["change"].(map[string]interface{})
+		rawActions, _ := change["actions"].([]interface{})
+
+		actions := make([]string, 0, len(rawActions))
+		for _, a := range rawActions {
+			if action, ok := a.(string); ok {
+				actions = append(actions, action)
+			}
+		}
+
+		switch {
+		case len(actions) == 2:
+			// ["delete", "create"] or ["create", "delete"]
+			summary.Replace++
+			summary.Replaced = append(summary.Replaced, address)
+		case len(actions) == 1 && actions[0] == "create":
+			summary.Create++
+		case len(actions) == 1 && actions[0] == "update":
+			summary.Update++
+		case len(actions) == 1 && actions[0] == "delete":
+			summary.Delete++
+			summary.Destroyed = append(summary.Destroyed, address)
+		}
+	}
+
+	return summary
+}
+
+func (s *PlanSummary) HasChanges() bool {
+	return s.Create+s.Update+s.Replace+s.Delete > 0
+}
+
+// String mirrors the "Plan: X to add, Y to change, Z to destroy." line printed by terraform.
+func (s *PlanSummary) String() string {
+	if !s.HasChanges() {
+		return "No changes."
+	}
+	return fmt.Sprintf("Plan: %d to add, %d to change, %d to destroy.", s.Create+s.Replace, s.Update, s.Delete+s.Replace)
+}
+
+// PlanDigest fingerprints the changes and the outputs of a terraform plan JSON document, leaving
+// out what differs between two runs planning the same changes, like the timestamp.
+func PlanDigest(plan map[string]interface{}) string {
+	changes, _ := json.Marshal(map[string]interface{}{
+		"resource_changes": plan["resource_changes"],
+		"output_changes":   plan["output_changes"],
+	})
+	digest := sha256.Sum256(changes)
+	return hex.EncodeToString(digest[:])
+}
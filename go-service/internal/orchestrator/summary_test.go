@@ -0,0 +1,54 @@
+package orchestrator
+
+import (
+	"errors"
+	"testing"
+)
+
+func testPlan(timestamp, instanceType string) map[string]interface{} {
+	return map[string]interface{}{
+		"format_version": "1.2",
+		"timestamp":      timestamp,
+		"resource_changes": []interface{}{map[string]interface{}{
+			"address": "aws_instance.web",
+			"change": map[string]interface{}{
+				"actions": []interface{}{"update"},
+				"after":   map[string]interface{}{"instance_type": instanceType},
+			},
+		}},
+	}
+}
+
+func TestPlanDigest(t *testing.T) {
+	reviewed := PlanDigest(testPlan("2026-10-19T10:00:00Z", "t3.small"))
+
+	if got := PlanDigest(testPlan("2026-10-19T10:05:00Z", "t3.small")); got != reviewed {
+		t.Error("expected the same changes planned again to keep their digest")
+	}
+	if got := PlanDigest(testPlan("2026-10-19T10:00:00Z", "t3.large")); got == reviewed {
+		t.Error("expected other changes to change the digest")
+	}
+}
+
+func TestCheckReviewedRefusesChangedPlans(t *testing.T) {
+	reviewed := PlanDigest(testPlan("2026-10-19T10:00:00Z", "t3.small"))
+
+	if err := checkReviewed("abc123", testPlan("2026-10-19T10:05:00Z", "t3.small"), reviewed); err != nil {
+		t.Errorf("expected the reviewed changes to be applied, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		plan   map[string]interface{}
+		digest string
+	}{
+		{name: "changed", plan: testPlan("2026-10-19T10:05:00Z", "t3.large"), digest: reviewed},
+		{name: "never reviewed", plan: testPlan("2026-10-19T10:05:00Z", "t3.small"), digest: ""},
+	}
+	for _, test := range tests {
+		var changedErr *PlanChangedError
+		if err := checkReviewed("abc123", test.plan, test.digest); !errors.As(err, &changedErr) || changedErr.SHA != "abc123" {
+			t.Errorf("%s: expected a PlanChangedError for abc123, got %v", test.name, err)
+		}
+	}
+}
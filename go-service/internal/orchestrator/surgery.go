@@ -0,0 +1,421 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/scm"
+	"github.com/benkamin03/prism/internal/store"
+)
+
+// Operations that change a state outside of a plan and apply
+const (
+	StateMove            = "mv"
+	StateRemove          = "rm"
+	StateImport          = "import"
+	StateGenerateConfig  = "generate-config"
+	StateReplaceProvider = "replace-provider"
+)
+
+const (
+	// importsFileName holds the import blocks of a generate-config request in the root module
+	importsFileName = "prism_imports.tf"
+	// GeneratedConfigFileName receives the configuration terraform generates for imported resources
+	GeneratedConfigFileName = "prism_generated.tf"
+)
+
+// Addresses are passed to terraform and written into import blocks, so keep them to the
+// characters a resource address can have
+var addressPattern = regexp.MustCompile(`^[A-Za-z0-9_\-.\[\]"]+$`)
+
+// ImportBlock is an import block added to the root module for generate-config.
+type ImportBlock struct {
+	To string `json:"to"`
+	ID string `json:"id"`
+}
+
+// StateOperation is a change to the state of a root module, the working directory by default.
+type StateOperation struct {
+	Operation string `json:"operation"`
+	Root      string `json:"root,omitempty"`
+	// mv
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	// rm
+	Addresses []string `json:"addresses,omitempty"`
+	// import
+	Address string `json:"address,omitempty"`
+	ID      string `json:"id,omitempty"`
+	// generate-config, next to the import blocks already in the configuration
+	Imports []ImportBlock `json:"imports,omitempty"`
+	// replace-provider, full source addresses such as registry.terraform.io/hashicorp/aws
+	FromProvider string `json:"from_provider,omitempty"`
+	ToProvider   string `json:"to_provider,omitempty"`
+	// Actor is recorded in the audit log, the GitHub login of the caller's token. It is never
+	// taken from a request, so nobody can act under another name.
+	Actor string `json:"-"`
+}
+
+// Validate checks that the operation is known and has the arguments it needs.
+func (s *StateOperation) Validate() error {
+	addresses := []string{}
+	switch s.Operation {
+	case StateMove:
+		if s.Source == "" || s.Destination == "" {
+			return fmt.Errorf("%s needs source and destination", s.Operation)
+		}
+		addresses = append(addresses, s.Source, s.Destination)
+	case StateRemove:
+		if len(s.Addresses) == 0 {
+			return fmt.Errorf("%s needs addresses", s.Operation)
+		}
+		addresses = append(addresses, s.Addresses...)
+	case StateImport:
+		if s.Address == "" || s.ID == "" {
+			return fmt.Errorf("%s needs address and id", s.Operation)
+		}
+		addresses = append(addresses, s.Address)
+	case StateGenerateConfig:
+		for _, block := range s.Imports {
+			if block.ID == "" {
+				return fmt.Errorf("import to %s needs an id", block.To)
+			}
+			addresses = append(addresses, block.To)
+		}
+	case StateReplaceProvider:
+		if s.FromProvider == "" || s.ToProvider == "" {
+			return fmt.Errorf("%s needs from_provider and to_provider", s.Operation)
+		}
+		addresses = append(addresses, s.FromProvider, s.ToProvider)
+	default:
+		return fmt.Errorf("unknown state operation %q, expected %s, %s, %s, %s or %s", s.Operation,
+			StateMove, StateRemove, StateImport, StateGenerateConfig, StateReplaceProvider)
+	}
+
+	for _, address := range addresses {
+		if !addressPattern.MatchString(strings.ReplaceAll(address, "/", "")) {
+			return fmt.Errorf("%q is not a valid address", address)
+		}
+	}
+	return nil
+}
+
+type StateOperationResult struct {
+	Operation string `json:"operation"`
+	Root      string `json:"root"`
+	// Output of the terraform command that changed the state
+	Output string `json:"output"`
+	// SnapshotObject holds the state from before the operation, in the same bucket as the state
+	SnapshotObject  string                 `json:"snapshot_object"`
+	GeneratedConfig string                 `json:"generated_config,omitempty"`
+	Plan            map[string]interface{} `json:"plan"`
+	PlanText        string                 `json:"plan_text"`
+	AuditID         int64                  `json:"audit_id,omitempty"`
+}
+
+// StateLockedError stops a state operation on a repository whose state is locked, by a pull
+// request between its plan and apply or by another state operation.
+type StateLockedError struct {
+	PullNumber int
+}
+
+func (e *StateLockedError) Error() string {
+	if e.PullNumber == store.StateOperationPullNumber {
+		return "the state is being changed by another state operation"
+	}
+	return fmt.Sprintf("the state is locked by pull request #%d", e.PullNumber)
+}
+
+// EditState runs a state operation on a root module of the default branch, or of the
+// conversation branch when the orchestrator has a workspace. The stored state is snapshotted
+// first, the operation is recorded in the audit log and a plan of the changed state shows the
+// effect. generate-config only plans the imports and leaves the state as it is.
+// The repository's own state is locked for the operation, like a pull request locks it.
+func (o *Orchestrator) EditState(operation *StateOperation) (*StateOperationResult, error) {
+	if err := operation.Validate(); err != nil {
+		return nil, err
+	}
+
+	if o.Workspace == "" {
+		unlock, err := o.lockState(operation.Actor)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	if _, err := o.CloneRepo(); err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	if o.Workspace != "" {
+		if err := o.checkoutLocalBranch(o.Workspace); err != nil {
+			return nil, fmt.Errorf("error in checkoutLocalBranch: %w", err)
+		}
+	}
+
+	bucketName, config, err := o.prepareRun()
+	if err != nil {
+		return nil, fmt.Errorf("error in prepareRun: %w", err)
+	}
+
+	root := operation.Root
+	if root == "" {
+		root = config.WorkingDir
+	}
+	if root, err = repoconfig.CleanPath(root); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(o.path(root)); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("root %q does not exist in repository", root)
+	}
+	settings, err := config.ForEnvironment(root, o.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := o.prepareRoot(settings)
+	if err != nil {
+		return nil, fmt.Errorf("error in prepareRoot: %w", err)
+	}
+	editor, ok := run.engine.(engine.StateEditor)
+	if !run.managesState() || !ok {
+		return nil, fmt.Errorf("%s keeps the state of %s outside of Prism, it can not be changed here", run.engine.Name(), root)
+	}
+	run.bucket = bucketName
+
+	if err := o.downloadOrCreateTFStateFile(bucketName, settings); err != nil {
+		return nil, fmt.Errorf("error in downloadOrCreateTFStateFile: %w", err)
+	}
+	if err := run.engine.Init(false); err != nil {
+		return nil, err
+	}
+	varFiles, err := RelativeVarFiles(root, settings.VarFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	edit := &stateEdit{
+		snapshot: func() (string, error) {
+			return o.snapshotState(bucketName, settings)
+		},
+		run: func() (string, error) {
+			log.Printf("Running state %s in %s", operation.Operation, root)
+			return runStateOperation(editor, operation, o.path(root), varFiles)
+		},
+		audit: func(snapshotObject string, operationErr error) (int64, error) {
+			return o.auditStateOperation(operation, settings, snapshotObject, operationErr)
+		},
+	}
+	if operation.Operation != StateGenerateConfig {
+		edit.store = func() error {
+			return o.uploadTFStateFile(bucketName, settings)
+		}
+	}
+	output, snapshotObject, auditID, err := edit.apply()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &StateOperationResult{
+		Operation:      operation.Operation,
+		Root:           root,
+		Output:         output,
+		SnapshotObject: snapshotObject,
+		AuditID:        auditID,
+	}
+
+	if operation.Operation == StateGenerateConfig {
+		generated, err := os.ReadFile(o.path(filepath.Join(root, GeneratedConfigFileName)))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", GeneratedConfigFileName, err)
+		}
+		result.GeneratedConfig = string(generated)
+	} else {
+		// Show what the next apply would do with the changed state
+		log.Printf("Running %s plan in %s", run.engine.Name(), root)
+		if _, err := run.engine.Plan(&engine.PlanOptions{VarFiles: varFiles}); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Plan, err = run.engine.Show(); err != nil {
+		return nil, err
+	}
+	if result.PlanText, err = run.engine.ShowText(); err != nil {
+		return nil, err
+	}
+	o.lastRun = run
+	return result, nil
+}
+
+// stateEdit holds the steps of a state operation that touch the stored state, see apply.
+type stateEdit struct {
+	snapshot func() (string, error)
+	run      func() (string, error)
+	audit    func(snapshotObject string, operationErr error) (int64, error)
+	// store uploads the changed state, nil for operations that leave the state as it is
+	store func() error
+}
+
+// apply snapshots the state, runs the operation and audits it before the changed state is
+// stored. Nothing is stored that was not audited, and a failed operation is audited as well.
+func (e *stateEdit) apply() (output, snapshotObject string, auditID int64, err error) {
+	if snapshotObject, err = e.snapshot(); err != nil {
+		return "", "", 0, fmt.Errorf("error in snapshotState: %w", err)
+	}
+	output, operationErr := e.run()
+	if auditID, err = e.audit(snapshotObject, operationErr); err != nil {
+		return "", "", 0, err
+	}
+	if operationErr != nil {
+		return "", "", 0, operationErr
+	}
+	if e.store != nil {
+		if err := e.store(); err != nil {
+			return "", "", 0, fmt.Errorf("error in uploadTFStateFile: %w", err)
+		}
+	}
+	return output, snapshotObject, auditID, nil
+}
+
+// lockState takes the lock of the repository, so no pull request plans or applies while its
+// state changes. Repositories that were not imported have no lock.
+func (o *Orchestrator) lockState(actor string) (func(), error) {
+	repository, err := o.importedRepository()
+	if err != nil {
+		return nil, err
+	}
+	if repository == nil {
+		return func() {}, nil
+	}
+
+	lock, acquired, err := o.Store.AcquireLock(repository.ID, store.StateOperationPullNumber, actor)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		if lock == nil {
+			return nil, fmt.Errorf("the lock of %s/%s changed while acquiring it, try again", repository.Owner, repository.Name)
+		}
+		return nil, &StateLockedError{PullNumber: lock.PullNumber}
+	}
+	return func() {
+		if _, err := o.Store.ReleaseLock(repository.ID, store.StateOperationPullNumber); err != nil {
+			log.Printf("Failed to release the state lock of %s/%s: %v", repository.Owner, repository.Name, err)
+		}
+	}, nil
+}
+
+// runStateOperation runs the operation on the root module in dir.
+func runStateOperation(editor engine.StateEditor, operation *StateOperation, dir string, varFiles []string) (string, error) {
+	switch operation.Operation {
+	case StateMove:
+		return editor.StateMove(operation.Source, operation.Destination)
+	case StateRemove:
+		return editor.StateRemove(operation.Addresses)
+	case StateImport:
+		return editor.Import(operation.Address, operation.ID, &engine.PlanOptions{VarFiles: varFiles})
+	case StateReplaceProvider:
+		return editor.StateReplaceProvider(operation.FromProvider, operation.ToProvider)
+	case StateGenerateConfig:
+		if len(operation.Imports) > 0 {
+			if err := writeImportBlocks(dir, operation.Imports); err != nil {
+				return "", err
+			}
+		}
+		if err := os.Remove(filepath.Join(dir, GeneratedConfigFileName)); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to remove %s: %w", GeneratedConfigFileName, err)
+		}
+		return editor.GenerateConfig(GeneratedConfigFileName, &engine.PlanOptions{VarFiles: varFiles})
+	}
+	return "", fmt.Errorf("unknown state operation %q", operation.Operation)
+}
+
+// writeImportBlocks adds the import blocks of a request to the root module in dir.
+func writeImportBlocks(dir string, imports []ImportBlock) error {
+	var b strings.Builder
+	for _, block := range imports {
+		fmt.Fprintf(&b, "import {\n  to = %s\n  id = %s\n}\n\n", block.To, hclString(block.ID))
+	}
+	if err := os.WriteFile(filepath.Join(dir, importsFileName), []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", importsFileName, err)
+	}
+	return nil
+}
+
+// hclString quotes a literal string, escaping the template sequences HCL would interpolate.
+func hclString(value string) string {
+	quoted, _ := json.Marshal(value)
+	escaped := strings.ReplaceAll(string(quoted), "${", "$${")
+	return strings.ReplaceAll(escaped, "%{", "%%{")
+}
+
+// snapshotState copies the downloaded state next to the stored one before it is changed.
+func (o *Orchestrator) snapshotState(bucketName string, settings *repoconfig.RootSettings) (string, error) {
+	stateObject, err := o.stateObject(settings)
+	if err != nil {
+		return "", err
+	}
+	snapshotObject := stateObject + ".snapshots/" + time.Now().UTC().Format("20060102T150405.000Z")
+	if err := o.MinioClient.UploadFileObject(o.context, bucketName, snapshotObject, o.path(filepath.Join(settings.Root, localStatePath))); err != nil {
+		return "", fmt.Errorf("error uploading %s: %w", snapshotObject, err)
+	}
+	log.Printf("Snapshotted %s to %s", stateObject, snapshotObject)
+	return snapshotObject, nil
+}
+
+// auditStateOperation records the operation and returns its id, 0 without a store.
+func (o *Orchestrator) auditStateOperation(operation *StateOperation, settings *repoconfig.RootSettings, snapshotObject string, operationErr error) (int64, error) {
+	if o.Store == nil {
+		log.Printf("No database configured, state %s in %s is not audited", operation.Operation, settings.Root)
+		return 0, nil
+	}
+	repository, err := scm.RepositoryKey(o.RepoURL)
+	if err != nil {
+		return 0, err
+	}
+	arguments, err := json.Marshal(operation)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal state operation: %w", err)
+	}
+
+	record := &store.StateOperation{
+		Repository:     repository,
+		Environment:    settings.Environment,
+		Root:           settings.Root,
+		Workspace:      o.Workspace,
+		Operation:      operation.Operation,
+		Arguments:      arguments,
+		Actor:          operation.Actor,
+		SnapshotObject: snapshotObject,
+	}
+	if operationErr != nil {
+		record.Error = o.redactor.String(operationErr.Error())
+	}
+	if err := o.Store.RecordStateOperation(record); err != nil {
+		return 0, err
+	}
+	return record.ID, nil
+}
+
+// ListStateOperations returns the audit log of state operations of the repository, newest first.
+func (o *Orchestrator) ListStateOperations(limit int) ([]store.StateOperation, error) {
+	if o.Store == nil {
+		return nil, fmt.Errorf("state operations are not audited without a database")
+	}
+	repository, err := scm.RepositoryKey(o.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+	return o.Store.ListStateOperations(repository, limit)
+}
@@ -0,0 +1,94 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recordedEdit is a stateEdit whose steps record the order they ran in.
+func recordedEdit(steps *[]string, runErr, auditErr error) *stateEdit {
+	return &stateEdit{
+		snapshot: func() (string, error) {
+			*steps = append(*steps, "snapshot")
+			return "terraform.tfstate.snapshots/20250101T000000.000Z", nil
+		},
+		run: func() (string, error) {
+			*steps = append(*steps, "run")
+			return "Move \"a\" to \"b\"", runErr
+		},
+		audit: func(snapshotObject string, operationErr error) (int64, error) {
+			*steps = append(*steps, "audit")
+			if snapshotObject == "" {
+				return 0, errors.New("audited without a snapshot")
+			}
+			return 42, auditErr
+		},
+		store: func() error {
+			*steps = append(*steps, "store")
+			return nil
+		},
+	}
+}
+
+func TestStateEditAuditsBeforeStoring(t *testing.T) {
+	var steps []string
+	output, snapshotObject, auditID, err := recordedEdit(&steps, nil, nil).apply()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"snapshot", "run", "audit", "store"}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("expected steps %v, got %v", want, steps)
+	}
+	if output == "" || snapshotObject == "" || auditID != 42 {
+		t.Errorf("unexpected result %q %q %d", output, snapshotObject, auditID)
+	}
+}
+
+func TestStateEditAuditsFailedOperations(t *testing.T) {
+	var steps []string
+	runErr := errors.New("resource not found")
+	if _, _, _, err := recordedEdit(&steps, runErr, nil).apply(); !errors.Is(err, runErr) {
+		t.Fatalf("expected the error of the operation, got %v", err)
+	}
+	if want := []string{"snapshot", "run", "audit"}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("expected the failed operation to be audited and not stored, got %v", steps)
+	}
+}
+
+func TestStateEditStoresNothingUnaudited(t *testing.T) {
+	var steps []string
+	auditErr := errors.New("database is down")
+	if _, _, _, err := recordedEdit(&steps, nil, auditErr).apply(); !errors.Is(err, auditErr) {
+		t.Fatalf("expected the error of the audit, got %v", err)
+	}
+	if want := []string{"snapshot", "run", "audit"}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("expected the state not to be stored without an audit record, got %v", steps)
+	}
+}
+
+func TestStateEditRunsNothingWithoutSnapshot(t *testing.T) {
+	var steps []string
+	edit := recordedEdit(&steps, nil, nil)
+	edit.snapshot = func() (string, error) {
+		steps = append(steps, "snapshot")
+		return "", errors.New("bucket is gone")
+	}
+	if _, _, _, err := edit.apply(); err == nil {
+		t.Fatal("expected the failed snapshot to stop the operation")
+	}
+	if want := []string{"snapshot"}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("expected nothing to run without a snapshot, got %v", steps)
+	}
+}
+
+func TestStateOperationActorIsNotTakenFromRequests(t *testing.T) {
+	var req StateOperationRequest
+	if err := json.Unmarshal([]byte(`{"operation":"rm","addresses":["aws_s3_bucket.logs"],"actor":"someone-else"}`), &req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Actor != "" {
+		t.Errorf("expected the actor of the request to be ignored, got %q", req.Actor)
+	}
+}
@@ -0,0 +1,83 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/scm"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/benkamin03/prism/internal/variables"
+)
+
+// prepareVariables writes the variable set of the root module's environment next to its
+// configuration, failing early when a required variable has no value from any source.
+func (o *Orchestrator) prepareVariables(settings *repoconfig.RootSettings, env []string) error {
+	repository, err := scm.RepositoryKey(o.RepoURL)
+	if err != nil {
+		return err
+	}
+	varFiles, err := RelativeVarFiles(settings.Root, settings.VarFiles)
+	if err != nil {
+		return err
+	}
+
+	_, err = variables.Prepare(o.Store, repository, settings.VariableEnvironment(), o.path(settings.Root), env, varFiles)
+	return err
+}
+
+// CheckVariables clones the repository and reports which variables of a root module get a value
+// in an environment, the working directory and the first environment by default.
+func (o *Orchestrator) CheckVariables(root, environment string) (*variables.Report, error) {
+	tmpDir, err := o.CloneRepo()
+	if err != nil {
+		return nil, fmt.Errorf("error in CloneRepo: %w", err)
+	}
+	defer o.Cleanup()
+
+	config, err := repoconfig.Load(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	if root == "" {
+		root = config.WorkingDir
+	}
+	if root, err = repoconfig.CleanPath(root); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(filepath.Join(tmpDir, root)); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("root %q does not exist in repository", root)
+	}
+
+	settings, err := config.ForEnvironment(root, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	env, _, err := o.SecretEnv(settings)
+	if err != nil {
+		return nil, fmt.Errorf("error in SecretEnv: %w", err)
+	}
+	repository, err := scm.RepositoryKey(o.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+	varFiles, err := RelativeVarFiles(root, settings.VarFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := o.variableSet(repository, settings.VariableEnvironment())
+	if err != nil {
+		return nil, err
+	}
+	return variables.Check(o.path(root), set, env, varFiles)
+}
+
+func (o *Orchestrator) variableSet(repository, environment string) ([]store.Variable, error) {
+	if o.Store == nil {
+		return nil, nil
+	}
+	return o.Store.ListVariables(repository, environment)
+}
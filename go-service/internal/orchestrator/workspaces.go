@@ -0,0 +1,195 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/github"
+	"github.com/benkamin03/prism/internal/minio"
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/scm"
+	"github.com/benkamin03/prism/internal/store"
+)
+
+// WorkspaceBucket keeps the state of every conversation, below <repository>/<conversation>/,
+// so planning a conversation never touches the state of the repository itself.
+const WorkspaceBucket = "prism-workspaces"
+
+// workspacePrefix returns the objects prefix of a conversation's workspace.
+func (o *Orchestrator) workspacePrefix(conversationID string) (string, error) {
+	if conversationID == "" || conversationID == "." || conversationID == ".." || strings.ContainsAny(conversationID, "/\\") {
+		return "", fmt.Errorf("conversation %q can not name a workspace", conversationID)
+	}
+	repository, err := scm.RepositoryKey(o.RepoURL)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(repository, conversationID) + "/", nil
+}
+
+// stateObject returns the object holding the state of a root module, inside the workspace when
+// the orchestrator plans a conversation.
+func (o *Orchestrator) stateObject(settings *repoconfig.RootSettings) (string, error) {
+	if o.Workspace == "" {
+		return stateObjectFor(settings), nil
+	}
+	prefix, err := o.workspacePrefix(o.Workspace)
+	if err != nil {
+		return "", err
+	}
+	return prefix + stateObjectFor(settings), nil
+}
+
+// touchWorkspace creates the workspace of the conversation on its first plan. Without a store
+// the state is still kept apart, the workspace is just not listed.
+func (o *Orchestrator) touchWorkspace() error {
+	if _, err := o.workspacePrefix(o.Workspace); err != nil {
+		return err
+	}
+	if o.Store == nil {
+		return nil
+	}
+	repository, err := scm.RepositoryKey(o.RepoURL)
+	if err != nil {
+		return err
+	}
+	_, created, err := o.Store.TouchWorkspace(repository, o.Workspace)
+	if err != nil {
+		return err
+	}
+	if created {
+		log.Printf("Created workspace %s of %s", o.Workspace, repository)
+	}
+	return nil
+}
+
+// repositoryBucket returns the bucket holding the repository's own state, the one of the user who
+// imported the repository when the orchestrator was not given one. It is empty when neither is known.
+func (o *Orchestrator) repositoryBucket() (string, error) {
+	if o.UserID != "" || o.Store == nil {
+		return o.UserID, nil
+	}
+	repo, err := o.importedRepository()
+	if err != nil || repo == nil {
+		return "", err
+	}
+	return repo.UserID, nil
+}
+
+// importedRepository looks the repository up in the imported repositories, nil when it was not
+// imported or there is no store.
+func (o *Orchestrator) importedRepository() (*store.ImportedRepository, error) {
+	if o.Store == nil {
+		return nil, nil
+	}
+	owner, name, err := github.ParseRepoURL(o.RepoURL)
+	if err != nil {
+		// Only repositories from GitHub are imported
+		return nil, nil
+	}
+	return o.Store.FindImportedRepositoryByName(owner, name)
+}
+
+// seedWorkspace copies the repository's state of a root module into the workspace on the first
+// plan of the conversation, unless the workspace already has a state of its own.
+func (o *Orchestrator) seedWorkspace(bucketName string, settings *repoconfig.RootSettings) error {
+	if o.Workspace == "" {
+		return nil
+	}
+	workspaceObject, err := o.stateObject(settings)
+	if err != nil {
+		return err
+	}
+	if _, err := o.MinioClient.ReadObject(o.context, bucketName, workspaceObject); err == nil {
+		return nil
+	} else if !errors.Is(err, minio.ErrObjectNotFound) {
+		return err
+	}
+
+	repositoryBucket, err := o.repositoryBucket()
+	if err != nil {
+		return err
+	}
+	if repositoryBucket == "" {
+		log.Printf("No repository state to seed workspace %s with", o.Workspace)
+		return nil
+	}
+	content, err := o.MinioClient.ReadObject(o.context, repositoryBucket, stateObjectFor(settings))
+	if errors.Is(err, minio.ErrObjectNotFound) {
+		log.Printf("No state of %s to seed workspace %s with", settings.Root, o.Workspace)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := o.MinioClient.WriteObject(o.context, bucketName, workspaceObject, content); err != nil {
+		return err
+	}
+	log.Printf("Seeded workspace %s with the state of %s", o.Workspace, settings.Root)
+	return nil
+}
+
+// DownloadState fetches the state of a root module of the current checkout, creating the bucket
+// and workspace as needed, and returns the bucket it has to be uploaded to again.
+func (o *Orchestrator) DownloadState(settings *repoconfig.RootSettings) (string, error) {
+	bucketName, err := o.stateBucket()
+	if err != nil {
+		return "", err
+	}
+	if err := o.downloadOrCreateTFStateFile(bucketName, settings); err != nil {
+		return "", fmt.Errorf("error in downloadOrCreateTFStateFile: %w", err)
+	}
+	return bucketName, nil
+}
+
+// UploadState stores the state of a root module fetched with DownloadState.
+func (o *Orchestrator) UploadState(bucketName string, settings *repoconfig.RootSettings) error {
+	return o.uploadTFStateFile(bucketName, settings)
+}
+
+// ListWorkspaces returns the conversation workspaces of the repository, most recently planned first.
+func (o *Orchestrator) ListWorkspaces() ([]store.Workspace, error) {
+	if o.Store == nil {
+		return nil, fmt.Errorf("workspaces are not tracked without a database")
+	}
+	repository, err := scm.RepositoryKey(o.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+	return o.Store.ListWorkspaces(repository)
+}
+
+// DeleteWorkspace removes the state of a conversation and its workspace record, reporting whether
+// there was anything to remove.
+func (o *Orchestrator) DeleteWorkspace(conversationID string) (bool, error) {
+	prefix, err := o.workspacePrefix(conversationID)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := o.MinioClient.GetOrCreateBucket(o.context, WorkspaceBucket); err != nil {
+		return false, fmt.Errorf("error in GetOrCreateBucket: %w", err)
+	}
+	removed, err := o.MinioClient.RemoveObjects(o.context, WorkspaceBucket, prefix)
+	if err != nil {
+		return false, fmt.Errorf("error in RemoveObjects: %w", err)
+	}
+	log.Printf("Removed %d state objects of workspace %s", removed, prefix)
+
+	deleted := removed > 0
+	if o.Store != nil {
+		repository, err := scm.RepositoryKey(o.RepoURL)
+		if err != nil {
+			return false, err
+		}
+		existed, err := o.Store.DeleteWorkspace(repository, conversationID)
+		if err != nil {
+			return false, err
+		}
+		deleted = deleted || existed
+	}
+	return deleted, nil
+}
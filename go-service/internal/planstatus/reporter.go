@@ -0,0 +1,130 @@
+package planstatus
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
+	"github.com/benkamin03/prism/internal/orchestrator"
+	"github.com/benkamin03/prism/internal/scm"
+)
+
+// Reporter publishes the outcome of a plan on a commit.
+// Reporting is best effort, a git host outage must not fail the plan itself.
+type Reporter struct {
+	provider scm.Provider
+	repo     *scm.Repository
+	sha      string
+}
+
+// planReporter is implemented by providers with richer reports than a commit status, i.e. GitHub check runs.
+type planReporter interface {
+	ReportPlan(repo *scm.Repository, report *github.PlanReport) error
+}
+
+func NewReporter(provider scm.Provider, repoURL, sha string) *Reporter {
+	repo, err := provider.ParseRepoURL(repoURL)
+	if err != nil {
+		log.Printf("Not reporting plan status: %v", err)
+		return nil
+	}
+	return &Reporter{
+		provider: provider,
+		repo:     repo,
+		sha:      sha,
+	}
+}
+
+func (r *Reporter) report(report *github.PlanReport) {
+	if r == nil {
+		return
+	}
+	report.SHA = r.sha
+
+	var err error
+	if reporter, ok := r.provider.(planReporter); ok {
+		err = reporter.ReportPlan(r.repo, report)
+	} else {
+		state := scm.StatusSuccess
+		switch {
+		case report.Pending:
+			state = scm.StatusPending
+		case !report.Success:
+			state = scm.StatusFailure
+		}
+		err = r.provider.SetStatus(r.repo, r.sha, &scm.CommitStatus{
+			State:       state,
+			Context:     github.StatusContext,
+			Description: report.Title,
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to report plan status for %s: %v", r.sha, err)
+	}
+}
+
+func (r *Reporter) Pending(title string) {
+	r.report(&github.PlanReport{
+		Pending: true,
+		Title:   title,
+		Summary: title,
+	})
+}
+
+func (r *Reporter) Failure(title, text string, annotations []github.CheckRunAnnotation) {
+	report := &github.PlanReport{
+		Success:     false,
+		Title:       title,
+		Summary:     title,
+		Annotations: annotations,
+	}
+	if text != "" {
+		report.Text = fmt.Sprintf("```text\n%s\n```", text)
+	}
+	r.report(report)
+}
+
+func (r *Reporter) Success(plan map[string]interface{}) {
+	summary := orchestrator.SummarizePlan(plan)
+	r.report(&github.PlanReport{
+		Success: true,
+		Title:   summary.String(),
+		Summary: fmt.Sprintf("| Create | Update | Replace | Destroy |\n| ---: | ---: | ---: | ---: |\n| %d | %d | %d | %d |",
+			summary.Create, summary.Update, summary.Replace, summary.Delete),
+	})
+}
+
+// AnnotationsFromDiagnostics pins diagnostics to their lines. Their file names are relative to
+// the root module that was validated, annotations need them relative to the repository.
+func AnnotationsFromDiagnostics(root string, diagnostics []engine.Diagnostic) []github.CheckRunAnnotation {
+	annotations := []github.CheckRunAnnotation{}
+	for _, diagnostic := range diagnostics {
+		// Diagnostics without a source range cannot be pinned to a line
+		if diagnostic.Range == nil || diagnostic.Range.Filename == "" {
+			continue
+		}
+
+		level := "failure"
+		if diagnostic.Severity == "warning" {
+			level = "warning"
+		}
+
+		message := diagnostic.Detail
+		if message == "" {
+			message = diagnostic.Summary
+		}
+
+		annotations = append(annotations, github.CheckRunAnnotation{
+			Path:            path.Join(root, filepath.ToSlash(diagnostic.Range.Filename)),
+			StartLine:       diagnostic.Range.Start.Line,
+			EndLine:         diagnostic.Range.End.Line,
+			AnnotationLevel: level,
+			Title:           diagnostic.Summary,
+			Message:         message,
+		})
+	}
+	return annotations
+}
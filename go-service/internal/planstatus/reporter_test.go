@@ -0,0 +1,256 @@
+package planstatus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
+	"github.com/benkamin03/prism/internal/scm"
+)
+
+const (
+	testRepoURL = "https://github.com/acme/infra.git"
+	testSHA     = "0123456789abcdef0123456789abcdef01234567"
+)
+
+type recordedRequest struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+// fakeGitHub records every request and answers check run creation with checkRunStatus.
+type fakeGitHub struct {
+	mu             sync.Mutex
+	requests       []recordedRequest
+	checkRunStatus int
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	f.requests = append(f.requests, recordedRequest{Method: r.Method, Path: r.URL.Path, Body: body})
+	f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/acme/infra/check-runs":
+		w.WriteHeader(f.checkRunStatus)
+		if f.checkRunStatus == http.StatusCreated {
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 1})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Resource not accessible by integration"})
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/acme/infra/statuses/"+testSHA:
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 1})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newGitHubReporter(t *testing.T, checkRunStatus int) (*Reporter, *fakeGitHub) {
+	t.Helper()
+	fake := &fakeGitHub{checkRunStatus: checkRunStatus}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	reporter := NewReporter(scm.NewGitHubEnterprise(server.URL, "token"), testRepoURL, testSHA)
+	if reporter == nil {
+		t.Fatal("NewReporter returned nil for a valid repository URL")
+	}
+	return reporter, fake
+}
+
+func TestReporterCreatesCheckRuns(t *testing.T) {
+	tests := []struct {
+		name       string
+		report     func(r *Reporter)
+		status     string
+		conclusion string
+		title      string
+	}{
+		{
+			name:   "pending",
+			report: func(r *Reporter) { r.Pending("Planning") },
+			status: "queued",
+			title:  "Planning",
+		},
+		{
+			name: "failure",
+			report: func(r *Reporter) {
+				r.Failure("Plan failed", "Error: boom", []github.CheckRunAnnotation{{Path: "main.tf", StartLine: 3, EndLine: 3, AnnotationLevel: "failure", Message: "boom"}})
+			},
+			status:     "completed",
+			conclusion: "failure",
+			title:      "Plan failed",
+		},
+		{
+			name:       "success",
+			report:     func(r *Reporter) { r.Success(map[string]interface{}{}) },
+			status:     "completed",
+			conclusion: "success",
+			title:      "No changes.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter, fake := newGitHubReporter(t, http.StatusCreated)
+			tt.report(reporter)
+
+			if len(fake.requests) != 1 {
+				t.Fatalf("expected a single request, got %+v", fake.requests)
+			}
+			request := fake.requests[0]
+			if request.Path != "/repos/acme/infra/check-runs" {
+				t.Fatalf("expected a check run, got %s %s", request.Method, request.Path)
+			}
+			if request.Body["name"] != github.CheckName || request.Body["head_sha"] != testSHA {
+				t.Errorf("unexpected check run %v", request.Body)
+			}
+			if request.Body["status"] != tt.status {
+				t.Errorf("expected status %q, got %v", tt.status, request.Body["status"])
+			}
+			conclusion, _ := request.Body["conclusion"].(string)
+			if conclusion != tt.conclusion {
+				t.Errorf("expected conclusion %q, got %q", tt.conclusion, conclusion)
+			}
+			output, _ := request.Body["output"].(map[string]interface{})
+			if output["title"] != tt.title {
+				t.Errorf("expected title %q, got %v", tt.title, output["title"])
+			}
+		})
+	}
+}
+
+func TestReporterSendsAnnotations(t *testing.T) {
+	reporter, fake := newGitHubReporter(t, http.StatusCreated)
+	reporter.Failure("Plan failed", "", []github.CheckRunAnnotation{{Path: "main.tf", StartLine: 3, EndLine: 4, AnnotationLevel: "failure", Message: "boom"}})
+
+	output, _ := fake.requests[0].Body["output"].(map[string]interface{})
+	annotations, _ := output["annotations"].([]interface{})
+	if len(annotations) != 1 {
+		t.Fatalf("expected one annotation, got %v", output["annotations"])
+	}
+	annotation := annotations[0].(map[string]interface{})
+	if annotation["path"] != "main.tf" || annotation["start_line"] != float64(3) || annotation["end_line"] != float64(4) {
+		t.Errorf("unexpected annotation %v", annotation)
+	}
+}
+
+func TestReporterFallsBackToCommitStatus(t *testing.T) {
+	for _, checkRunStatus := range []int{http.StatusForbidden, http.StatusNotFound} {
+		tests := []struct {
+			name   string
+			report func(r *Reporter)
+			state  string
+		}{
+			{name: "pending", report: func(r *Reporter) { r.Pending("Planning") }, state: "pending"},
+			{name: "failure", report: func(r *Reporter) { r.Failure("Plan failed", "Error: boom", nil) }, state: "failure"},
+			{name: "success", report: func(r *Reporter) { r.Success(map[string]interface{}{}) }, state: "success"},
+		}
+
+		for _, tt := range tests {
+			t.Run(http.StatusText(checkRunStatus)+"/"+tt.name, func(t *testing.T) {
+				reporter, fake := newGitHubReporter(t, checkRunStatus)
+				tt.report(reporter)
+
+				if len(fake.requests) != 2 {
+					t.Fatalf("expected a check run and a commit status, got %+v", fake.requests)
+				}
+				status := fake.requests[1]
+				if status.Path != "/repos/acme/infra/statuses/"+testSHA {
+					t.Fatalf("expected a commit status, got %s %s", status.Method, status.Path)
+				}
+				if status.Body["state"] != tt.state || status.Body["context"] != github.StatusContext {
+					t.Errorf("unexpected commit status %v", status.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestReporterDoesNotFallBackOnOtherErrors(t *testing.T) {
+	reporter, fake := newGitHubReporter(t, http.StatusInternalServerError)
+	reporter.Pending("Planning")
+
+	if len(fake.requests) != 1 {
+		t.Fatalf("expected only the check run request, got %+v", fake.requests)
+	}
+}
+
+func TestReporterSetsStatusOnOtherProviders(t *testing.T) {
+	var got map[string]string
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	reporter := NewReporter(scm.NewGitLab(server.URL, "token"), "https://gitlab.com/acme/infra.git", testSHA)
+	reporter.Failure("Plan failed", "", nil)
+
+	if path != "/projects/acme%2Finfra/statuses/"+testSHA {
+		t.Fatalf("unexpected request path %s", path)
+	}
+	if got["state"] != "failed" || got["name"] != github.StatusContext || got["description"] != "Plan failed" {
+		t.Errorf("unexpected commit status %v", got)
+	}
+}
+
+func TestNilReporterIsNoop(t *testing.T) {
+	var reporter *Reporter
+	reporter.Pending("Planning")
+	reporter.Failure("Plan failed", "", nil)
+	reporter.Success(map[string]interface{}{})
+}
+
+func TestNewReporterRejectsInvalidURL(t *testing.T) {
+	if reporter := NewReporter(scm.NewGitHub("token"), "not a url", testSHA); reporter != nil {
+		t.Errorf("expected no reporter for an invalid URL")
+	}
+}
+
+func TestAnnotationsFromDiagnostics(t *testing.T) {
+	diagnostics := []engine.Diagnostic{
+		{Severity: "error", Summary: "Unsupported argument", Detail: "An argument named \"foo\" is not expected here.", Range: &engine.DiagnosticRange{Filename: "main.tf", Start: engine.DiagnosticPos{Line: 2}, End: engine.DiagnosticPos{Line: 2}}},
+		{Severity: "warning", Summary: "Deprecated", Range: &engine.DiagnosticRange{Filename: "vars.tf", Start: engine.DiagnosticPos{Line: 5}, End: engine.DiagnosticPos{Line: 6}}},
+		{Severity: "error", Summary: "No range"},
+	}
+
+	annotations := AnnotationsFromDiagnostics("infra/prod", diagnostics)
+	if len(annotations) != 2 {
+		t.Fatalf("expected diagnostics without a range to be skipped, got %+v", annotations)
+	}
+	if annotations[0].Path != "infra/prod/main.tf" || annotations[1].Path != "infra/prod/vars.tf" {
+		t.Errorf("expected paths relative to the repository, got %q and %q", annotations[0].Path, annotations[1].Path)
+	}
+	for _, root := range []string{".", ""} {
+		if annotations := AnnotationsFromDiagnostics(root, diagnostics[:1]); annotations[0].Path != "main.tf" {
+			t.Errorf("expected paths of the repository root %q as they are, got %q", root, annotations[0].Path)
+		}
+	}
+	if nested := AnnotationsFromDiagnostics("./infra/prod/", diagnostics[:1]); nested[0].Path != "infra/prod/main.tf" {
+		t.Errorf("expected the root to be cleaned, got %q", nested[0].Path)
+	}
+	if annotations[0].Title != "Unsupported argument" || annotations[1].Title != "Deprecated" {
+		t.Errorf("expected the summaries as titles, got %q and %q", annotations[0].Title, annotations[1].Title)
+	}
+	if annotations[0].StartLine != 2 || annotations[0].EndLine != 2 {
+		t.Errorf("expected the lines of the diagnostic, got %d-%d", annotations[0].StartLine, annotations[0].EndLine)
+	}
+	if annotations[0].AnnotationLevel != "failure" || annotations[0].Message != diagnostics[0].Detail {
+		t.Errorf("unexpected error annotation %+v", annotations[0])
+	}
+	if annotations[1].AnnotationLevel != "warning" || annotations[1].Message != "Deprecated" || annotations[1].EndLine != 6 {
+		t.Errorf("unexpected warning annotation %+v", annotations[1])
+	}
+}
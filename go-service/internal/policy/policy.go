@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Binary evaluates the Rego policies of a repository against the JSON plan
+const Binary = "conftest"
+
+// Result is the outcome of one policy location of the repository configuration.
+type Result struct {
+	Policy  string `json:"policy"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// report is what conftest prints for each file it tested with --output json.
+type report struct {
+	Failures []struct {
+		Msg string `json:"msg"`
+	} `json:"failures"`
+}
+
+// Evaluate tests plan against every policy location, relative to the repository cloned to dir.
+// A location that can not be evaluated fails with the reason as its message, so a broken
+// policy never passes unnoticed.
+func Evaluate(dir string, policies []string, plan map[string]interface{}) []Result {
+	results := []Result{}
+	if len(policies) == 0 {
+		return results
+	}
+
+	planFile, err := writePlan(plan)
+	if err != nil {
+		for _, policy := range policies {
+			results = append(results, Result{Policy: policy, Message: err.Error()})
+		}
+		return results
+	}
+	defer os.Remove(planFile)
+
+	for _, policy := range policies {
+		results = append(results, evaluate(dir, policy, planFile))
+	}
+	return results
+}
+
+func writePlan(plan map[string]interface{}) (string, error) {
+	content, err := json.Marshal(plan)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	file, err := os.CreateTemp("", "prism-plan-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to write plan: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(content); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write plan: %w", err)
+	}
+	return file.Name(), nil
+}
+
+func evaluate(dir, policy, planFile string) Result {
+	result := Result{Policy: policy}
+	if _, err := exec.LookPath(Binary); err != nil {
+		result.Message = fmt.Sprintf("%s is not installed", Binary)
+		return result
+	}
+
+	cmd := exec.Command(Binary, "test", "--no-color", "--all-namespaces", "--output", "json",
+		"--policy", filepath.Join(dir, filepath.FromSlash(policy)), planFile)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// conftest exits non-zero when a policy fails but still prints its report
+	output, runErr := cmd.Output()
+
+	var reports []report
+	if err := json.Unmarshal(output, &reports); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" && runErr != nil {
+			message = runErr.Error()
+		}
+		if message == "" {
+			message = err.Error()
+		}
+		result.Message = fmt.Sprintf("failed to evaluate: %s", message)
+		return result
+	}
+
+	failures := []string{}
+	for _, r := range reports {
+		for _, failure := range r.Failures {
+			failures = append(failures, failure.Msg)
+		}
+	}
+	result.Passed = len(failures) == 0
+	result.Message = strings.Join(failures, "; ")
+	return result
+}
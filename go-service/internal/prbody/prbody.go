@@ -0,0 +1,105 @@
+package prbody
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/orchestrator"
+	"github.com/benkamin03/prism/internal/policy"
+	"github.com/benkamin03/prism/internal/repoconfig"
+)
+
+// Marker identifies bodies written by Prism so they can be refreshed without clobbering hand-written ones.
+const Marker = "<!-- prism:generated -->"
+
+// GitHub rejects bodies over 65536 characters, leave room for everything around the raw plan.
+const maxPlanTextLength = 50000
+
+type PolicyResult = policy.Result
+
+type Input struct {
+	ConversationID string
+	Plan           map[string]interface{}
+	PlanText       string
+	Prompts        []string
+	PolicyResults  []PolicyResult
+}
+
+func IsGenerated(body string) bool {
+	return strings.HasPrefix(strings.TrimSpace(body), Marker)
+}
+
+func Render(input *Input) string {
+	summary := orchestrator.SummarizePlan(input.Plan)
+
+	var b strings.Builder
+	b.WriteString(Marker + "\n")
+	fmt.Fprintf(&b, "## Terraform updates for conversation `%s`\n\n", input.ConversationID)
+	fmt.Fprintf(&b, "**%s**\n\n", summary.String())
+
+	b.WriteString("### Change summary\n\n")
+	b.WriteString("| Action | Count |\n")
+	b.WriteString("| --- | ---: |\n")
+	fmt.Fprintf(&b, "| Create | %d |\n", summary.Create)
+	fmt.Fprintf(&b, "| Update | %d |\n", summary.Update)
+	fmt.Fprintf(&b, "| Replace | %d |\n", summary.Replace)
+	fmt.Fprintf(&b, "| Destroy | %d |\n\n", summary.Delete)
+
+	b.WriteString("### Resources to destroy or replace\n\n")
+	if len(summary.Destroyed)+len(summary.Replaced) == 0 {
+		b.WriteString("_None._\n\n")
+	} else {
+		for _, address := range summary.Destroyed {
+			fmt.Fprintf(&b, "- :warning: `%s` will be **destroyed**\n", address)
+		}
+		for _, address := range summary.Replaced {
+			fmt.Fprintf(&b, "- :warning: `%s` will be **replaced**\n", address)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("### Policy results\n\n")
+	if len(input.PolicyResults) == 0 {
+		fmt.Fprintf(&b, "_No policies are configured in `%s`._\n\n", repoconfig.FileName)
+	} else {
+		b.WriteString("| Policy | Result | Message |\n")
+		b.WriteString("| --- | --- | --- |\n")
+		for _, result := range input.PolicyResults {
+			status := ":white_check_mark: pass"
+			if !result.Passed {
+				status = ":x: fail"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", result.Policy, status, escapeTableCell(result.Message))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("### Prompt history\n\n")
+	if len(input.Prompts) == 0 {
+		b.WriteString("_No prompts recorded._\n\n")
+	} else {
+		for i, prompt := range input.Prompts {
+			// Indent continuation lines so multi-line prompts stay inside their list item
+			fmt.Fprintf(&b, "%d. %s\n", i+1, strings.ReplaceAll(prompt, "\n", "\n   "))
+		}
+		b.WriteString("\n")
+	}
+
+	planText := strings.TrimSpace(input.PlanText)
+	if len(planText) > maxPlanTextLength {
+		planText = planText[:maxPlanTextLength] + "\n\n... (truncated)"
+	}
+	b.WriteString("<details>\n")
+	b.WriteString("<summary>Raw <code>terraform plan</code> output</summary>\n\n")
+	b.WriteString("```text\n")
+	b.WriteString(planText)
+	b.WriteString("\n```\n\n")
+	b.WriteString("</details>\n")
+
+	return b.String()
+}
+
+func escapeTableCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.ReplaceAll(value, "\n", " ")
+}
@@ -0,0 +1,238 @@
+package providercache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// Cache shares provider plugins between runs. Providers are installed from a filesystem mirror
+// when it has them and downloaded from their registries otherwise, either way they land in a
+// plugin cache that every later init links from instead of downloading again.
+//
+// Terraform does not lock the plugin cache itself, so inits that may write to it hold it alone,
+// across processes sharing the directory as well. Inits that only link providers the cache
+// already holds share it, only the first init of new provider versions is serialized.
+type Cache struct {
+	// PluginCacheDir is passed to terraform as TF_PLUGIN_CACHE_DIR
+	PluginCacheDir string
+	// MirrorDir is a filesystem mirror in terraform's packed or unpacked layout
+	MirrorDir string
+	// Offline installs providers from the mirror and the plugin cache only
+	Offline bool
+	// ConfigPath is the generated CLI configuration, passed as TF_CLI_CONFIG_FILE
+	ConfigPath string
+
+	// lockPath is locked through a file descriptor of its own per holder, which also orders the
+	// goroutines of this process
+	lockPath string
+}
+
+// New creates the plugin cache and mirror below dir and writes the CLI configuration using them.
+func New(dir string, offline bool) (*Cache, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &Cache{
+		PluginCacheDir: filepath.Join(dir, "plugins"),
+		MirrorDir:      filepath.Join(dir, "mirror"),
+		Offline:        offline,
+		ConfigPath:     filepath.Join(dir, "terraform.tfrc"),
+		lockPath:       filepath.Join(dir, ".lock"),
+	}
+	for _, path := range []string{cache.PluginCacheDir, cache.MirrorDir} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", path, err)
+		}
+	}
+	if err := os.WriteFile(cache.ConfigPath, []byte(cache.cliConfig()), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", cache.ConfigPath, err)
+	}
+
+	return cache, nil
+}
+
+// cliConfig leaves the dependency lock file in charge: terraform only links cached providers
+// whose checksums .terraform.lock.hcl lists, and verifies anything else it installs.
+func (c *Cache) cliConfig() string {
+	direct := "\n  direct {}"
+	if c.Offline {
+		direct = ""
+	}
+	return fmt.Sprintf(`plugin_cache_dir = %q
+
+provider_installation {
+  filesystem_mirror {
+    path = %q
+  }%s
+}
+`, c.PluginCacheDir, c.MirrorDir, direct)
+}
+
+// Env points terraform, and terragrunt running it, at the cache.
+func (c *Cache) Env() []string {
+	return []string{
+		"TF_CLI_CONFIG_FILE=" + c.ConfigPath,
+		"TF_PLUGIN_CACHE_DIR=" + c.PluginCacheDir,
+	}
+}
+
+// Lock waits until nothing else uses the cache and returns the function releasing it.
+func (c *Cache) Lock() (func(), error) {
+	fileLock := flock.New(c.lockPath)
+	if err := fileLock.Lock(); err != nil {
+		return nil, fmt.Errorf("failed to lock provider cache: %w", err)
+	}
+	return unlockFunc(fileLock), nil
+}
+
+// RLock waits until no init writes to the cache and returns the function releasing it. Any
+// number of inits that only read from the cache hold it at once.
+func (c *Cache) RLock() (func(), error) {
+	fileLock := flock.New(c.lockPath)
+	if err := fileLock.RLock(); err != nil {
+		return nil, fmt.Errorf("failed to lock provider cache: %w", err)
+	}
+	return unlockFunc(fileLock), nil
+}
+
+func unlockFunc(fileLock *flock.Flock) func() {
+	return func() {
+		if err := fileLock.Unlock(); err != nil {
+			log.Printf("Failed to unlock provider cache: %v", err)
+		}
+	}
+}
+
+// lockedProviderPattern matches the provider blocks of .terraform.lock.hcl with their version
+var lockedProviderPattern = regexp.MustCompile(`(?s)provider\s+"([^"]+)"\s*\{[^}]*?\bversion\s*=\s*"([^"]+)"`)
+
+// Cached reports whether the plugin cache holds every provider version the dependency lock files
+// of the root modules in dirs pin, so that an init of them without -upgrade only reads from the
+// cache. A root module without a lock file may install anything.
+func (c *Cache) Cached(dirs ...string) bool {
+	for _, dir := range dirs {
+		if !c.cached(dir) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cache) cached(dir string) bool {
+	content, err := os.ReadFile(filepath.Join(dir, ".terraform.lock.hcl"))
+	if err != nil {
+		return false
+	}
+	for _, match := range lockedProviderPattern.FindAllStringSubmatch(string(content), -1) {
+		source, version := match[1], match[2]
+		if strings.Contains(source, "..") || strings.Contains(version, "..") {
+			return false
+		}
+		platformDir := filepath.Join(c.PluginCacheDir, filepath.FromSlash(source), version, runtime.GOOS+"_"+runtime.GOARCH)
+		if info, err := os.Stat(platformDir); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// Bucket holds the packages of a provider mirror, minio.MinioClient implements it.
+type Bucket interface {
+	ListObjectNames(ctx context.Context, bucketName, prefix string) ([]string, error)
+	DownloadFileObject(ctx context.Context, bucketName, objectName, filePath string) error
+}
+
+// SeedEvery seeds the mirror again every interval until ctx is done, so packages added to the
+// bucket after startup reach the mirror as well.
+func (c *Cache) SeedEvery(ctx context.Context, interval time.Duration, bucket Bucket, bucketName, prefix string) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			seeded, err := c.Seed(ctx, bucket, bucketName, prefix)
+			if err != nil {
+				log.Printf("Failed to seed provider mirror from bucket %s: %v", bucketName, err)
+				continue
+			}
+			if seeded > 0 {
+				log.Printf("Seeded %d new provider packages from bucket %s", seeded, bucketName)
+			}
+		}
+	}()
+}
+
+// Seed copies the provider packages below prefix in a bucket into the mirror, keeping their
+// paths below the prefix. Packages already in the mirror are not downloaded again. Packages are
+// downloaded next to their final path and only the cache lock is taken to move them in place, so
+// inits are not held up by the downloads.
+func (c *Cache) Seed(ctx context.Context, bucket Bucket, bucketName, prefix string) (int, error) {
+	names, err := bucket.ListObjectNames(ctx, bucketName, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	seeded := 0
+	for _, name := range names {
+		relPath := strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/")
+		if relPath == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		cleaned := filepath.Clean(filepath.FromSlash(relPath))
+		if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+			log.Printf("Skipping provider mirror object outside the mirror: %s", name)
+			continue
+		}
+
+		localPath := filepath.Join(c.MirrorDir, cleaned)
+		if _, err := os.Stat(localPath); err == nil {
+			continue
+		}
+		if err := c.seedPackage(ctx, bucket, bucketName, name, localPath); err != nil {
+			return seeded, err
+		}
+		seeded++
+	}
+
+	return seeded, nil
+}
+
+// seedPackage downloads one package to a temporary file of its own, so concurrent seeds do not
+// write the same file and terraform never sees a partial package.
+func (c *Cache) seedPackage(ctx context.Context, bucket Bucket, bucketName, name, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), ".seed-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := bucket.DownloadFileObject(ctx, bucketName, name, tmp.Name()); err != nil {
+		return err
+	}
+
+	unlock, err := c.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return os.Rename(tmp.Name(), localPath)
+}
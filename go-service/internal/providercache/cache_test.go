@@ -0,0 +1,215 @@
+package providercache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+func TestCLIConfigKeepsTheLockFileInCharge(t *testing.T) {
+	cache, err := New(t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config, err := os.ReadFile(cache.ConfigPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Contains(string(config), "plugin_cache_may_break_dependency_lock_file") {
+		t.Errorf("expected cached providers to be checked against the lock file, got %s", config)
+	}
+	for _, want := range []string{cache.PluginCacheDir, cache.MirrorDir, "direct {}"} {
+		if !strings.Contains(string(config), want) {
+			t.Errorf("expected %s in the CLI configuration, got %s", want, config)
+		}
+	}
+}
+
+func TestCLIConfigOffline(t *testing.T) {
+	cache, err := New(t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config := cache.cliConfig(); strings.Contains(config, "direct") {
+		t.Errorf("expected no registry downloads offline, got %s", config)
+	}
+}
+
+// fakeBucket serves objects from memory and records which ones were downloaded.
+type fakeBucket struct {
+	objects    map[string]string
+	downloaded []string
+	// duringDownload runs while an object is downloaded
+	duringDownload func()
+}
+
+func (b *fakeBucket) ListObjectNames(ctx context.Context, bucketName, prefix string) ([]string, error) {
+	names := []string{}
+	for name := range b.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *fakeBucket) DownloadFileObject(ctx context.Context, bucketName, objectName, filePath string) error {
+	b.downloaded = append(b.downloaded, objectName)
+	if b.duringDownload != nil {
+		b.duringDownload()
+	}
+	return os.WriteFile(filePath, []byte(b.objects[objectName]), 0644)
+}
+
+func TestSeedKeepsPackagesInsideTheMirror(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := New(filepath.Join(dir, "cache"), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bucket := &fakeBucket{objects: map[string]string{
+		"mirror/registry.terraform.io/hashicorp/aws/terraform-provider-aws_5.0.0_linux_amd64.zip": "aws",
+		"mirror/registry.terraform.io/hashicorp/null/":                                            "",
+		"mirror/../escape.zip":                              "escape",
+		"mirror/registry.terraform.io/../../../outside.zip": "outside",
+		"mirror//absolute.zip":                              "absolute",
+		"other/registry.terraform.io/hashicorp/google/terraform-provider-google_5.0.0_linux_amd64.zip": "google",
+	}}
+
+	seeded, err := cache.Seed(context.Background(), bucket, "providers", "mirror")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if seeded != 1 {
+		t.Errorf("expected only the package inside the mirror to be seeded, got %d from %v", seeded, bucket.downloaded)
+	}
+	content, err := os.ReadFile(filepath.Join(cache.MirrorDir, "registry.terraform.io/hashicorp/aws/terraform-provider-aws_5.0.0_linux_amd64.zip"))
+	if err != nil || string(content) != "aws" {
+		t.Errorf("expected the package in the mirror, got %q %v", content, err)
+	}
+	for _, escaped := range []string{filepath.Join(dir, "cache", "escape.zip"), filepath.Join(dir, "outside.zip"), filepath.Join(dir, "cache", "outside.zip"), "/absolute.zip"} {
+		if _, err := os.Stat(escaped); !os.IsNotExist(err) {
+			t.Errorf("expected nothing to be written to %s, got %v", escaped, err)
+		}
+	}
+	if want := []string{"mirror/registry.terraform.io/hashicorp/aws/terraform-provider-aws_5.0.0_linux_amd64.zip"}; fmt.Sprint(bucket.downloaded) != fmt.Sprint(want) {
+		t.Errorf("expected only %v to be downloaded, got %v", want, bucket.downloaded)
+	}
+
+	bucket.downloaded = nil
+	if seeded, err := cache.Seed(context.Background(), bucket, "providers", "mirror"); err != nil || seeded != 0 || len(bucket.downloaded) != 0 {
+		t.Errorf("expected seeded packages not to be downloaded again, got %d %v %v", seeded, bucket.downloaded, err)
+	}
+}
+
+func TestSeedDownloadsWithoutTheLock(t *testing.T) {
+	cache, err := New(t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bucket := &fakeBucket{objects: map[string]string{"mirror/provider.zip": "provider"}}
+	bucket.duringDownload = func() {
+		fileLock := flock.New(cache.lockPath)
+		locked, err := fileLock.TryLock()
+		if err != nil || !locked {
+			t.Errorf("expected the cache to be free while downloading, got %v %v", locked, err)
+		}
+		fileLock.Unlock()
+	}
+
+	if _, err := cache.Seed(context.Background(), bucket, "providers", "mirror"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCached(t *testing.T) {
+	cache, err := New(t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	platformDir := filepath.Join(cache.PluginCacheDir, "registry.terraform.io/hashicorp/aws/5.0.0", runtime.GOOS+"_"+runtime.GOARCH)
+	if err := os.MkdirAll(platformDir, 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	module := func(lockFile string) string {
+		dir := t.TempDir()
+		if lockFile != "" {
+			os.WriteFile(filepath.Join(dir, ".terraform.lock.hcl"), []byte(lockFile), 0644)
+		}
+		return dir
+	}
+	provider := func(source, version string) string {
+		return fmt.Sprintf("provider %q {\n  version     = %q\n  constraints = \"~> 5.0\"\n  hashes = [\n    \"h1:abc=\",\n  ]\n}\n", source, version)
+	}
+
+	tests := []struct {
+		name string
+		dirs []string
+		want bool
+	}{
+		{name: "cached provider", dirs: []string{module(provider("registry.terraform.io/hashicorp/aws", "5.0.0"))}, want: true},
+		{name: "no providers", dirs: []string{module("# empty\n")}, want: true},
+		{name: "no lock file", dirs: []string{module("")}, want: false},
+		{name: "other version", dirs: []string{module(provider("registry.terraform.io/hashicorp/aws", "5.1.0"))}, want: false},
+		{name: "one module missing a provider", dirs: []string{
+			module(provider("registry.terraform.io/hashicorp/aws", "5.0.0")),
+			module(provider("registry.terraform.io/hashicorp/aws", "5.0.0") + provider("registry.terraform.io/hashicorp/null", "3.2.0")),
+		}, want: false},
+		{name: "source outside the cache", dirs: []string{module(provider("registry.terraform.io/hashicorp/../../registry.terraform.io/hashicorp/aws", "5.0.0"))}, want: false},
+	}
+	for _, test := range tests {
+		if got := cache.Cached(test.dirs...); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestInitsOnlyReadingShareTheCache(t *testing.T) {
+	cache, err := New(t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unlockFirst, err := cache.RLock()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unlockSecond, err := cache.RLock()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := cache.Lock()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			close(locked)
+			return
+		}
+		close(locked)
+		unlock()
+	}()
+
+	unlockFirst()
+	select {
+	case <-locked:
+		t.Fatal("expected Lock to wait for every reader")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlockSecond()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Lock once the readers released the cache")
+	}
+}
@@ -0,0 +1,80 @@
+package redact
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const skipKey = "redact.skip"
+
+// Skip lets a handler send values a caller was authorized to reveal, the rest of its response
+// is no longer redacted.
+func Skip(c echo.Context) {
+	c.Set(skipKey, true)
+}
+
+// Middleware gives every request a scope of r on its context, for the values of the run it starts,
+// and redacts response bodies, streamed ones included, and the errors handlers return with it.
+func Middleware(r *Redactor) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			scope := r.Scope()
+			defer scope.Close()
+			c.SetRequest(c.Request().WithContext(NewContext(c.Request().Context(), scope)))
+
+			original := c.Response().Writer
+			writer := &responseWriter{ResponseWriter: original, context: c, lines: scope.Writer(original)}
+			c.Response().Writer = writer
+
+			err := next(c)
+			writer.lines.Flush()
+			c.Response().Writer = original
+			// The error handler writes the response of an error after this returns
+			return redactError(scope, err)
+		}
+	}
+}
+
+// redactError keeps an *echo.HTTPError one, the error handler only takes its status from it.
+func redactError(r *Redactor, err error) error {
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) {
+		return r.Error(err)
+	}
+	redacted := *httpErr
+	switch message := httpErr.Message.(type) {
+	case string:
+		redacted.Message = r.String(message)
+	case echo.Map:
+		redacted.Message = r.Value(map[string]interface{}(message))
+	}
+	redacted.Internal = r.Error(httpErr.Internal)
+	return &redacted
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	context echo.Context
+	lines   *Writer
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if skip, _ := w.context.Get(skipKey).(bool); skip {
+		if err := w.lines.Flush(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	return w.lines.Write(p)
+}
+
+// Flush sends the complete lines written so far, for streamed responses. Text after the last
+// newline is held back until the line is complete or the handler returns, a value split over
+// two flushed writes would otherwise be sent in parts no redactor recognizes.
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,92 @@
+package redact
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func serve(t *testing.T, parent *Redactor, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	e := echo.New()
+	e.Use(Middleware(parent))
+	e.GET("/", handler)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec
+}
+
+func TestMiddlewareRedactsResponses(t *testing.T) {
+	parent := New(0)
+	parent.Add(knownToken)
+
+	rec := serve(t, parent, func(c echo.Context) error {
+		// The run of the request fetches its secrets into the scope on the context
+		FromContext(c.Request().Context()).Add(knownSecret)
+		return c.JSON(http.StatusOK, echo.Map{"plan": "password = " + knownSecret, "token": knownToken})
+	})
+
+	body := rec.Body.String()
+	if strings.Contains(body, knownSecret) || strings.Contains(body, knownToken) {
+		t.Errorf("expected the response to be redacted, got %s", body)
+	}
+	if parent.String(knownSecret) != knownSecret {
+		t.Error("expected the values of the request to be dropped once it is done")
+	}
+}
+
+func TestMiddlewareRedactsStreamedResponses(t *testing.T) {
+	rec := serve(t, New(0), func(c echo.Context) error {
+		FromContext(c.Request().Context()).Add(knownSecret)
+		c.Response().Header().Set(echo.HeaderContentType, "text/plain")
+		c.Response().WriteHeader(http.StatusOK)
+
+		half := len(knownSecret) / 2
+		for _, chunk := range []string{"Terraform will perform the following actions:\n  + password = \"", knownSecret[:half], knownSecret[half:], "\"\n", "Plan: 1 to add"} {
+			if _, err := c.Response().Write([]byte(chunk)); err != nil {
+				return err
+			}
+			c.Response().Flush()
+		}
+		return nil
+	})
+
+	body := rec.Body.String()
+	if strings.Contains(body, knownSecret) {
+		t.Errorf("expected the streamed plan to be redacted, got %q", body)
+	}
+	if !strings.Contains(body, "password = \"[REDACTED]\"") || !strings.HasSuffix(body, "Plan: 1 to add") {
+		t.Errorf("expected the whole stream to be written, got %q", body)
+	}
+}
+
+func TestMiddlewareRedactsErrors(t *testing.T) {
+	rec := serve(t, New(0), func(c echo.Context) error {
+		FromContext(c.Request().Context()).Add(knownToken)
+		return echo.NewHTTPError(http.StatusBadGateway, fmt.Sprintf("push with %s failed", knownToken))
+	})
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected the status of the error, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), knownToken) {
+		t.Errorf("expected the error to be redacted, got %s", rec.Body.String())
+	}
+}
+
+func TestMiddlewareSkip(t *testing.T) {
+	rec := serve(t, New(0), func(c echo.Context) error {
+		FromContext(c.Request().Context()).Add(knownSecret)
+		Skip(c)
+		return c.String(http.StatusOK, knownSecret)
+	})
+
+	if rec.Body.String() != knownSecret {
+		t.Errorf("expected a revealed value to be sent as is, got %q", rec.Body.String())
+	}
+}
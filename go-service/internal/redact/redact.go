@@ -0,0 +1,270 @@
+package redact
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Placeholder replaces every redacted value
+const Placeholder = "[REDACTED]"
+
+// minLength keeps short values such as "1" or "dev" from garbling all output, they are not redacted
+const minLength = 4
+
+// DefaultRetention is how long the Default redactor keeps a value added to it directly after it
+// was last added. Values of scopes are dropped when the scope is closed instead.
+const DefaultRetention = 24 * time.Hour
+
+// Default is the fallback for code without a run or request, the process logs go through it.
+// It redacts the values added to it and those of every open scope.
+var Default = New(DefaultRetention)
+
+// Redactor replaces known secret values in text.
+type Redactor struct {
+	retention time.Duration
+	// parent is the redactor a scope lends its values to, nil for New
+	parent *Redactor
+
+	mu     sync.RWMutex
+	values map[string]time.Time
+	// lent counts the open scopes holding each of their values
+	lent   map[string]int
+	closed bool
+	// replacer is rebuilt by the first String after the values changed
+	replacer *strings.Replacer
+	stale    bool
+}
+
+// New creates a redactor that forgets values retention after they were last added, never when it is 0.
+func New(retention time.Duration) *Redactor {
+	return &Redactor{retention: retention, values: map[string]time.Time{}, lent: map[string]int{}}
+}
+
+// Scope creates a redactor for a single run or request. It redacts its own values and those of
+// r, and lends its values to r until Close, so what the process logs meanwhile is redacted too.
+func (r *Redactor) Scope() *Redactor {
+	scope := New(0)
+	scope.parent = r
+	return scope
+}
+
+// Close returns the values lent to the parent. The scope itself keeps redacting them, for
+// errors and output of the run that are handled after it ended.
+func (r *Redactor) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.parent == nil || r.closed {
+		return
+	}
+	r.closed = true
+	values := make([]string, 0, len(r.values))
+	for value := range r.values {
+		values = append(values, value)
+	}
+	r.parent.release(values)
+}
+
+// Add makes the redactor replace values, along with their JSON escaped form and the lines of
+// multiline values, which is how they show up in JSON output and indented plan output.
+func (r *Redactor) Add(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	added := []string{}
+	for _, value := range values {
+		for _, variant := range variants(value) {
+			if _, ok := r.values[variant]; !ok {
+				added = append(added, variant)
+			}
+			r.values[variant] = now
+		}
+	}
+	changed := len(added) > 0
+	if r.retention > 0 {
+		for value, addedAt := range r.values {
+			if now.Sub(addedAt) > r.retention {
+				delete(r.values, value)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		r.stale = true
+	}
+
+	if r.parent != nil && !r.closed && len(added) > 0 {
+		r.parent.borrow(added)
+	}
+}
+
+func (r *Redactor) borrow(values []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, value := range values {
+		r.lent[value]++
+	}
+	r.stale = true
+}
+
+func (r *Redactor) release(values []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, value := range values {
+		if r.lent[value] <= 1 {
+			delete(r.lent, value)
+		} else {
+			r.lent[value]--
+		}
+	}
+	r.stale = true
+}
+
+func variants(value string) []string {
+	candidates := []string{value}
+	if escaped, err := json.Marshal(value); err == nil {
+		candidates = append(candidates, string(escaped[1:len(escaped)-1]))
+	}
+	if strings.Contains(value, "\n") {
+		for _, line := range strings.Split(value, "\n") {
+			candidates = append(candidates, strings.TrimSpace(line))
+		}
+	}
+
+	result := []string{}
+	for _, candidate := range candidates {
+		if len(candidate) >= minLength {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
+
+// rebuild orders the values longest first, so a value is replaced as a whole before any part of it.
+func (r *Redactor) rebuild() {
+	r.stale = false
+	unique := map[string]bool{}
+	for value := range r.values {
+		unique[value] = true
+	}
+	for value := range r.lent {
+		unique[value] = true
+	}
+	if len(unique) == 0 {
+		r.replacer = nil
+		return
+	}
+	values := make([]string, 0, len(unique))
+	for value := range unique {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, Placeholder)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+func (r *Redactor) currentReplacer() *strings.Replacer {
+	r.mu.RLock()
+	replacer, stale := r.replacer, r.stale
+	r.mu.RUnlock()
+	if !stale {
+		return replacer
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stale {
+		r.rebuild()
+	}
+	return r.replacer
+}
+
+func (r *Redactor) String(s string) string {
+	if replacer := r.currentReplacer(); replacer != nil {
+		s = replacer.Replace(s)
+	}
+	if r.parent != nil {
+		return r.parent.String(s)
+	}
+	return s
+}
+
+func (r *Redactor) Bytes(b []byte) []byte {
+	return []byte(r.String(string(b)))
+}
+
+// Error returns err with a redacted message. errors.Is and errors.As still see the original
+// error, whose own message is not redacted.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := r.String(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{message: message, err: err}
+}
+
+// Value redacts the strings in decoded JSON, leaving map keys alone. The value is changed in place.
+func (r *Redactor) Value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.String(v)
+	case map[string]interface{}:
+		for key, element := range v {
+			v[key] = r.Value(element)
+		}
+		return v
+	case []interface{}:
+		for i, element := range v {
+			v[i] = r.Value(element)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the redactor of a run or request.
+func NewContext(ctx context.Context, r *Redactor) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext returns the redactor carried by ctx, Default when there is none.
+func FromContext(ctx context.Context) *Redactor {
+	if ctx != nil {
+		if r, ok := ctx.Value(contextKey{}).(*Redactor); ok {
+			return r
+		}
+	}
+	return Default
+}
@@ -0,0 +1,50 @@
+package redact
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Writer redacts what is written through it line by line, so a value split over two writes is
+// still found. Text after the last newline is held back until the next newline or Flush.
+type Writer struct {
+	redactor *Redactor
+	out      io.Writer
+
+	mu     sync.Mutex
+	buffer []byte
+}
+
+func (r *Redactor) Writer(out io.Writer) *Writer {
+	return &Writer{redactor: r, out: out}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buffer = append(w.buffer, p...)
+	end := bytes.LastIndexByte(w.buffer, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	if _, err := w.out.Write(w.redactor.Bytes(w.buffer[:end+1])); err != nil {
+		return 0, err
+	}
+	w.buffer = append(w.buffer[:0], w.buffer[end+1:]...)
+	return len(p), nil
+}
+
+// Flush writes the text held back since the last newline.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	_, err := w.out.Write(w.redactor.Bytes(w.buffer))
+	w.buffer = w.buffer[:0]
+	return err
+}
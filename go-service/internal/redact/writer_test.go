@@ -0,0 +1,52 @@
+package redact
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestWriterRedactsValuesSplitOverWrites(t *testing.T) {
+	r := New(0)
+	r.Add(knownSecret)
+	var out bytes.Buffer
+	w := r.Writer(&out)
+
+	// Streamed plan output arrives in chunks that do not respect values
+	half := len(knownSecret) / 2
+	for _, chunk := range []string{"  + password = \"", knownSecret[:half], knownSecret[half:], "\"\n", "done: ", knownSecret} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if out.String() != "  + password = \"[REDACTED]\"\n" {
+		t.Errorf("expected complete lines to be written redacted, got %q", out.String())
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), "done: [REDACTED]") {
+		t.Errorf("expected Flush to write the held back text redacted, got %q", out.String())
+	}
+}
+
+func TestLogOutputIsRedacted(t *testing.T) {
+	parent := New(0)
+	var out bytes.Buffer
+	logger := log.New(parent.Writer(&out), "", 0)
+
+	// Process logs go through the parent, which sees the values of open scopes
+	scope := parent.Scope()
+	scope.Add(knownSecret, knownToken)
+	logger.Printf("Cloning https://x-access-token:%s@github.com/acme/infra.git with %s", knownToken, knownSecret)
+	scope.Close()
+
+	if strings.Contains(out.String(), knownToken) || strings.Contains(out.String(), knownSecret) {
+		t.Errorf("expected the log line to be redacted, got %q", out.String())
+	}
+	if strings.Count(out.String(), Placeholder) != 2 {
+		t.Errorf("expected both values to be replaced, got %q", out.String())
+	}
+}
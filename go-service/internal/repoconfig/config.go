@@ -0,0 +1,508 @@
+package repoconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/engine"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the optional configuration file at the root of a repository
+const FileName = ".prism.yaml"
+
+// Defaults used when neither the repository nor the root module sets a value
+const (
+	DefaultInfisicalEnvironment = "dev"
+	DefaultSecretPath           = "/"
+	DefaultBaseBranch           = "main"
+	DefaultWorkingDir           = "."
+)
+
+type Infisical struct {
+	// Project is the secrets project of the mapped secrets. Runs Prism starts by itself, from
+	// webhooks, comments and drift checks, have no other way to know it.
+	Project     string `yaml:"project"`
+	Environment string `yaml:"environment"`
+	SecretPath  string `yaml:"secret_path"`
+}
+
+// SecretMapping hands one secret to the engine. Exactly one of Env, TFVar and File is set, except
+// that Env may accompany File to receive the path of the written file.
+type SecretMapping struct {
+	// Secret is the key of the secret in the secret path of the root module
+	Secret string `yaml:"secret"`
+	// Env is the environment variable set to the value
+	Env string `yaml:"env"`
+	// TFVar is the input variable set through TF_VAR_<name>
+	TFVar string `yaml:"tf_var"`
+	// File is the name of a credentials file written with the value outside the repository
+	File string `yaml:"file"`
+}
+
+// Settings can be given for the whole repository and overridden per root module.
+type Settings struct {
+	// Engine is terraform or opentofu
+	Engine    string    `yaml:"engine"`
+	Infisical Infisical `yaml:"infisical"`
+	// TerraformVersion pins the version of the engine binary, also for OpenTofu
+	TerraformVersion string `yaml:"terraform_version"`
+	// VarFiles are passed to terraform with -var-file, relative to the repository root
+	VarFiles []string `yaml:"var_files"`
+	// Policies are files or directories with policies, relative to the repository root
+	Policies []string `yaml:"policies"`
+	// Secrets map secrets to the engine, runs get no other secret
+	Secrets []SecretMapping `yaml:"secrets"`
+}
+
+type Root struct {
+	Path     string `yaml:"path"`
+	Settings `yaml:",inline"`
+}
+
+// rootKeys are the keys a root accepts. Decoding a node ignores the decoder's KnownFields, so
+// UnmarshalYAML rejects unknown keys itself.
+var rootKeys = yamlKeys(reflect.TypeOf(Root{}))
+
+// UnmarshalYAML also accepts a bare path, so that a plain list of roots stays valid.
+func (r *Root) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.Path = value.Value
+		return nil
+	}
+
+	if value.Kind == yaml.MappingNode {
+		unknown := []string{}
+		for i := 0; i < len(value.Content); i += 2 {
+			if key := value.Content[i]; !rootKeys[key.Value] {
+				unknown = append(unknown, fmt.Sprintf("line %d: field %s not found in type repoconfig.Root", key.Line, key.Value))
+			}
+		}
+		if len(unknown) > 0 {
+			return &yaml.TypeError{Errors: unknown}
+		}
+	}
+
+	// Decode through a type without this method to avoid recursing
+	type plainRoot Root
+	return value.Decode((*plainRoot)(r))
+}
+
+// yamlKeys collects the keys of the fields of a struct type, including inlined ones.
+func yamlKeys(t reflect.Type) map[string]bool {
+	keys := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if options == "inline" {
+			for key := range yamlKeys(field.Type) {
+				keys[key] = true
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		keys[name] = true
+	}
+	return keys
+}
+
+// Approvals a pull request needs before it can be applied to an environment.
+type Approvals struct {
+	Required int `yaml:"required"`
+	// Approvers limits whose approvals count, anyone's by default
+	Approvers []string `yaml:"approvers"`
+}
+
+// Count returns how many of the users who approved a change count towards the requirement.
+// GitHub logins are case-insensitive, so approvers are compared without case.
+func (a Approvals) Count(approvedBy []string) int {
+	count := 0
+	for _, user := range approvedBy {
+		if len(a.Approvers) == 0 {
+			count++
+			continue
+		}
+		for _, approver := range a.Approvers {
+			if strings.EqualFold(user, approver) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// Environment is a stage such as dev, staging or prod, with its own secrets, variables and
+// state. Environments are listed in promotion order.
+type Environment struct {
+	Name string `yaml:"name"`
+	// Infisical defaults to the Infisical environment with the same slug as Name
+	Infisical Infisical `yaml:"infisical"`
+	// VarFiles are passed after the var files of the root module, relative to the repository root
+	VarFiles  []string  `yaml:"var_files"`
+	Approvals Approvals `yaml:"approvals"`
+}
+
+type Config struct {
+	Version    int    `yaml:"version"`
+	BaseBranch string `yaml:"base_branch"`
+	// WorkingDir is the root module planned when no roots are requested
+	WorkingDir   string `yaml:"working_dir"`
+	Settings     `yaml:",inline"`
+	Roots        []Root        `yaml:"roots"`
+	Environments []Environment `yaml:"environments"`
+}
+
+// RootSettings are the effective settings of one root module.
+type RootSettings struct {
+	Root string
+	// Environment is empty when the repository declares no environments
+	Environment          string
+	Approvals            Approvals
+	Engine               string
+	InfisicalProject     string
+	InfisicalEnvironment string
+	SecretPath           string
+	TerraformVersion     string
+	VarFiles             []string
+	Policies             []string
+	Secrets              []SecretMapping
+}
+
+// Default is the configuration of a repository without a configuration file.
+func Default() *Config {
+	return &Config{
+		Version:    1,
+		BaseBranch: DefaultBaseBranch,
+		WorkingDir: DefaultWorkingDir,
+	}
+}
+
+// ValidationError lists every problem found in a configuration file.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", FileName, strings.Join(e.Problems, "; "))
+}
+
+// Load reads the configuration file in dir, falling back to the defaults if there is none.
+func Load(dir string) (*Config, error) {
+	content, err := os.ReadFile(filepath.Join(dir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return Default(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", FileName, err)
+	}
+
+	return Parse(content)
+}
+
+// Parse decodes and validates a configuration file. Unknown keys are rejected so that typos
+// do not silently fall back to defaults.
+func Parse(content []byte) (*Config, error) {
+	config := Default()
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		// An empty file decodes to EOF, which just means all defaults
+		if errors.Is(err, io.EOF) {
+			return config, nil
+		}
+		var typeError *yaml.TypeError
+		if errors.As(err, &typeError) {
+			return nil, &ValidationError{Problems: typeError.Errors}
+		}
+		return nil, fmt.Errorf("failed to parse %s: %w", FileName, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+var (
+	terraformVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+(-[0-9A-Za-z.]+)?$`)
+	envNamePattern          = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	tfVarPattern            = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+	fileNamePattern         = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+	branchPattern           = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	environmentPattern      = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Validate checks the values that decoding cannot, collecting every problem at once.
+func (c *Config) Validate() error {
+	problems := []string{}
+
+	if c.Version != 1 {
+		problems = append(problems, fmt.Sprintf("version: unsupported version %d, expected 1", c.Version))
+	}
+	if !branchPattern.MatchString(c.BaseBranch) || strings.Contains(c.BaseBranch, "..") {
+		problems = append(problems, fmt.Sprintf("base_branch: %q is not a valid branch name", c.BaseBranch))
+	}
+	if _, err := CleanPath(c.WorkingDir); err != nil {
+		problems = append(problems, fmt.Sprintf("working_dir: %v", err))
+	}
+	problems = append(problems, c.Settings.validate("")...)
+
+	seen := map[string]bool{}
+	for i, root := range c.Roots {
+		field := fmt.Sprintf("roots[%d]", i)
+		if root.Path == "" {
+			problems = append(problems, field+".path: is required")
+			continue
+		}
+		cleaned, err := CleanPath(root.Path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.path: %v", field, err))
+			continue
+		}
+		if seen[cleaned] {
+			problems = append(problems, fmt.Sprintf("%s.path: %q is listed more than once", field, root.Path))
+		}
+		seen[cleaned] = true
+		problems = append(problems, root.Settings.validate(field+".")...)
+	}
+
+	environments := map[string]bool{}
+	for i, environment := range c.Environments {
+		field := fmt.Sprintf("environments[%d]", i)
+		if !environmentPattern.MatchString(environment.Name) {
+			problems = append(problems, fmt.Sprintf("%s.name: %q is not a valid environment name", field, environment.Name))
+		}
+		if environments[environment.Name] {
+			problems = append(problems, fmt.Sprintf("%s.name: %q is listed more than once", field, environment.Name))
+		}
+		environments[environment.Name] = true
+		if environment.Approvals.Required < 0 {
+			problems = append(problems, fmt.Sprintf("%s.approvals.required: must not be negative", field))
+		}
+		if len(environment.Approvals.Approvers) > 0 && len(environment.Approvals.Approvers) < environment.Approvals.Required {
+			problems = append(problems, fmt.Sprintf("%s.approvals: requires %d approvals from only %d approvers", field, environment.Approvals.Required, len(environment.Approvals.Approvers)))
+		}
+		settings := Settings{Infisical: environment.Infisical, VarFiles: environment.VarFiles}
+		problems = append(problems, settings.validate(field+".")...)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (s *Settings) validate(prefix string) []string {
+	problems := []string{}
+
+	if s.Engine != "" && s.Engine != engine.Terraform && s.Engine != engine.OpenTofu {
+		problems = append(problems, fmt.Sprintf("%sengine: %q must be %s or %s", prefix, s.Engine, engine.Terraform, engine.OpenTofu))
+	}
+	if s.Infisical.Project != "" && !environmentPattern.MatchString(s.Infisical.Project) {
+		problems = append(problems, fmt.Sprintf("%sinfisical.project: %q is not a valid project id", prefix, s.Infisical.Project))
+	}
+	if s.Infisical.Environment != "" && !environmentPattern.MatchString(s.Infisical.Environment) {
+		problems = append(problems, fmt.Sprintf("%sinfisical.environment: %q is not a valid environment slug", prefix, s.Infisical.Environment))
+	}
+	if s.Infisical.SecretPath != "" && !strings.HasPrefix(s.Infisical.SecretPath, "/") {
+		problems = append(problems, fmt.Sprintf("%sinfisical.secret_path: %q must start with /", prefix, s.Infisical.SecretPath))
+	}
+	if s.TerraformVersion != "" && !terraformVersionPattern.MatchString(s.TerraformVersion) {
+		problems = append(problems, fmt.Sprintf("%sterraform_version: %q must be an exact version such as 1.9.8", prefix, s.TerraformVersion))
+	}
+	for i, varFile := range s.VarFiles {
+		if _, err := CleanPath(varFile); err != nil {
+			problems = append(problems, fmt.Sprintf("%svar_files[%d]: %v", prefix, i, err))
+		}
+	}
+	for i, policy := range s.Policies {
+		if _, err := CleanPath(policy); err != nil {
+			problems = append(problems, fmt.Sprintf("%spolicies[%d]: %v", prefix, i, err))
+		}
+	}
+	problems = append(problems, validateSecrets(prefix, s.Secrets)...)
+
+	return problems
+}
+
+func validateSecrets(prefix string, mappings []SecretMapping) []string {
+	problems := []string{}
+	targets := map[string]bool{}
+	for i, mapping := range mappings {
+		field := fmt.Sprintf("%ssecrets[%d]", prefix, i)
+		if !envNamePattern.MatchString(mapping.Secret) {
+			problems = append(problems, fmt.Sprintf("%s.secret: %q is not a valid secret key", field, mapping.Secret))
+		}
+
+		switch {
+		case mapping.Env == "" && mapping.TFVar == "" && mapping.File == "":
+			problems = append(problems, field+": one of env, tf_var or file is required")
+		case mapping.TFVar != "" && (mapping.Env != "" || mapping.File != ""):
+			problems = append(problems, field+": tf_var can not be combined with env or file")
+		}
+
+		if mapping.Env != "" {
+			if !envNamePattern.MatchString(mapping.Env) {
+				problems = append(problems, fmt.Sprintf("%s.env: %q is not a valid environment variable name", field, mapping.Env))
+			} else if targets["env:"+mapping.Env] {
+				problems = append(problems, fmt.Sprintf("%s.env: %s is mapped more than once", field, mapping.Env))
+			}
+			targets["env:"+mapping.Env] = true
+		}
+		if mapping.TFVar != "" {
+			if !tfVarPattern.MatchString(mapping.TFVar) {
+				problems = append(problems, fmt.Sprintf("%s.tf_var: %q is not a valid variable name", field, mapping.TFVar))
+			} else if targets["env:TF_VAR_"+mapping.TFVar] {
+				problems = append(problems, fmt.Sprintf("%s.tf_var: %s is mapped more than once", field, mapping.TFVar))
+			}
+			targets["env:TF_VAR_"+mapping.TFVar] = true
+		}
+		if mapping.File != "" {
+			if !fileNamePattern.MatchString(mapping.File) {
+				problems = append(problems, fmt.Sprintf("%s.file: %q must be a file name without directories", field, mapping.File))
+			} else if targets["file:"+mapping.File] {
+				problems = append(problems, fmt.Sprintf("%s.file: %s is mapped more than once", field, mapping.File))
+			}
+			targets["file:"+mapping.File] = true
+		}
+	}
+	return problems
+}
+
+// ValidEnvironment reports whether slug can name an environment.
+func ValidEnvironment(slug string) bool {
+	return environmentPattern.MatchString(slug)
+}
+
+// CleanPath normalizes a slash-separated path from a user or the configuration file and
+// keeps it inside the repository.
+func CleanPath(path string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(path))
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside the repository", path)
+	}
+	return filepath.ToSlash(cleaned), nil
+}
+
+// RootPaths returns the cleaned paths of the configured root modules, sorted.
+func (c *Config) RootPaths() []string {
+	paths := []string{}
+	for _, root := range c.Roots {
+		// Validate already rejected paths that do not clean
+		cleaned, _ := CleanPath(root.Path)
+		paths = append(paths, cleaned)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ForRoot merges the repository settings with the overrides of the root module at path.
+func (c *Config) ForRoot(path string) *RootSettings {
+	settings := &RootSettings{
+		Root:                 path,
+		Engine:               firstNonEmpty(c.Engine, engine.Terraform),
+		InfisicalProject:     c.Infisical.Project,
+		InfisicalEnvironment: firstNonEmpty(c.Infisical.Environment, DefaultInfisicalEnvironment),
+		SecretPath:           firstNonEmpty(c.Infisical.SecretPath, DefaultSecretPath),
+		TerraformVersion:     c.TerraformVersion,
+		VarFiles:             c.VarFiles,
+		Policies:             c.Policies,
+		Secrets:              c.Secrets,
+	}
+
+	for _, root := range c.Roots {
+		if cleaned, _ := CleanPath(root.Path); cleaned != path {
+			continue
+		}
+		settings.Engine = firstNonEmpty(root.Engine, settings.Engine)
+		settings.InfisicalProject = firstNonEmpty(root.Infisical.Project, settings.InfisicalProject)
+		settings.InfisicalEnvironment = firstNonEmpty(root.Infisical.Environment, settings.InfisicalEnvironment)
+		settings.SecretPath = firstNonEmpty(root.Infisical.SecretPath, settings.SecretPath)
+		settings.TerraformVersion = firstNonEmpty(root.TerraformVersion, settings.TerraformVersion)
+		if len(root.VarFiles) > 0 {
+			settings.VarFiles = root.VarFiles
+		}
+		if len(root.Policies) > 0 {
+			settings.Policies = root.Policies
+		}
+		if len(root.Secrets) > 0 {
+			settings.Secrets = root.Secrets
+		}
+	}
+
+	return settings
+}
+
+// Environment returns the named environment, the first one for an empty name, or nil if the
+// repository declares no such environment.
+func (c *Config) Environment(name string) *Environment {
+	for i, environment := range c.Environments {
+		if name == "" || environment.Name == name {
+			return &c.Environments[i]
+		}
+	}
+	return nil
+}
+
+// NextEnvironment returns the environment a change is promoted to after the named one, or nil
+// for the last environment.
+func (c *Config) NextEnvironment(name string) *Environment {
+	for i, environment := range c.Environments {
+		if environment.Name == name && i+1 < len(c.Environments) {
+			return &c.Environments[i+1]
+		}
+	}
+	return nil
+}
+
+// ForEnvironment merges the settings of the root module at path with the overrides of an
+// environment, the first one for an empty name. Repositories without environments only
+// accept an empty name.
+func (c *Config) ForEnvironment(path, name string) (*RootSettings, error) {
+	settings := c.ForRoot(path)
+	if len(c.Environments) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("environment %q is not declared in %s", name, FileName)
+		}
+		return settings, nil
+	}
+
+	environment := c.Environment(name)
+	if environment == nil {
+		return nil, fmt.Errorf("environment %q is not declared in %s", name, FileName)
+	}
+	settings.Environment = environment.Name
+	settings.Approvals = environment.Approvals
+	settings.InfisicalProject = firstNonEmpty(environment.Infisical.Project, settings.InfisicalProject)
+	settings.InfisicalEnvironment = firstNonEmpty(environment.Infisical.Environment, environment.Name)
+	settings.SecretPath = firstNonEmpty(environment.Infisical.SecretPath, settings.SecretPath)
+	settings.VarFiles = append(append([]string{}, settings.VarFiles...), environment.VarFiles...)
+	return settings, nil
+}
+
+// VariableEnvironment names the variable set of the root module, its environment or, without
+// environments, its Infisical environment.
+func (s *RootSettings) VariableEnvironment() string {
+	return firstNonEmpty(s.Environment, s.InfisicalEnvironment)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
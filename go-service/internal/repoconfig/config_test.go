@@ -0,0 +1,134 @@
+package repoconfig
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRejectsInvalidConfigurations(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		problems []string
+	}{
+		{
+			name:     "unknown key",
+			content:  "version: 1\nbase_brnch: main\n",
+			problems: []string{"line 2: field base_brnch not found in type repoconfig.Config"},
+		},
+		{
+			name:     "unknown key of a root",
+			content:  "roots:\n  - infra\n  - path: apps\n    engin: opentofu\n    var_file: [apps.tfvars]\n",
+			problems: []string{"line 4: field engin not found in type repoconfig.Root", "line 5: field var_file not found in type repoconfig.Root"},
+		},
+		{
+			name:     "engine",
+			content:  "engine: pulumi\n",
+			problems: []string{`engine: "pulumi" must be terraform or opentofu`},
+		},
+		{
+			name:     "version constraint",
+			content:  "terraform_version: \"~> 1.9\"\n",
+			problems: []string{`terraform_version: "~> 1.9" must be an exact version such as 1.9.8`},
+		},
+		{
+			name:     "version constraint of a root",
+			content:  "roots:\n  - path: infra\n    terraform_version: \">= 1.5\"\n",
+			problems: []string{`roots[0].terraform_version: ">= 1.5" must be an exact version such as 1.9.8`},
+		},
+		{
+			name:    "duplicate roots",
+			content: "roots:\n  - infra\n  - path: ./infra/\n  - infra/../infra\n",
+			problems: []string{
+				`roots[1].path: "./infra/" is listed more than once`,
+				`roots[2].path: "infra/../infra" is listed more than once`,
+			},
+		},
+		{
+			name:    "every problem at once",
+			content: "version: 2\nengine: pulumi\nroots:\n  - ../outside\n",
+			problems: []string{
+				"version: unsupported version 2, expected 1",
+				`engine: "pulumi" must be terraform or opentofu`,
+				`roots[0].path: path "../outside" is outside the repository`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := Parse([]byte(test.content))
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected a ValidationError, got %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(validationErr.Problems, test.problems) {
+			t.Errorf("%s: expected problems %q, got %q", test.name, test.problems, validationErr.Problems)
+		}
+		if want := "invalid .prism.yaml: " + strings.Join(test.problems, "; "); err.Error() != want {
+			t.Errorf("%s: expected error %q, got %q", test.name, want, err.Error())
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(`
+version: 1
+engine: opentofu
+terraform_version: 1.8.5
+infisical:
+  project: infra
+var_files: [common.tfvars]
+roots:
+  - network
+  - path: ./apps/web/
+    engine: terraform
+    terraform_version: 1.9.8
+    var_files: [apps/web.tfvars]
+environments:
+  - name: staging
+  - name: prod
+    var_files: [prod.tfvars]
+    approvals:
+      required: 1
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if paths := config.RootPaths(); !reflect.DeepEqual(paths, []string{"apps/web", "network"}) {
+		t.Errorf("expected the cleaned root paths, got %v", paths)
+	}
+
+	web := config.ForRoot("apps/web")
+	if web.Engine != "terraform" || web.TerraformVersion != "1.9.8" || !reflect.DeepEqual(web.VarFiles, []string{"apps/web.tfvars"}) {
+		t.Errorf("expected the root to override the repository settings, got %+v", web)
+	}
+	network := config.ForRoot("network")
+	if network.Engine != "opentofu" || network.TerraformVersion != "1.8.5" || network.InfisicalProject != "infra" || network.SecretPath != DefaultSecretPath {
+		t.Errorf("expected the repository settings, got %+v", network)
+	}
+
+	prod, err := config.ForEnvironment("network", "prod")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prod.InfisicalEnvironment != "prod" || prod.Approvals.Required != 1 || !reflect.DeepEqual(prod.VarFiles, []string{"common.tfvars", "prod.tfvars"}) {
+		t.Errorf("expected the environment to extend the root settings, got %+v", prod)
+	}
+	if _, err := config.ForEnvironment("network", "dev"); err == nil || err.Error() != `environment "dev" is not declared in .prism.yaml` {
+		t.Errorf("expected undeclared environments to be rejected, got %v", err)
+	}
+}
+
+func TestParseEmptyFileUsesDefaults(t *testing.T) {
+	config, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(config, Default()) {
+		t.Errorf("expected the defaults, got %+v", config)
+	}
+}
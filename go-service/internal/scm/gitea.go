@@ -0,0 +1,275 @@
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Gitea also covers Forgejo instances such as codeberg.org, which share its API.
+type Gitea struct {
+	api *apiClient
+}
+
+// NewGitea talks to the v1 REST API at baseURL, e.g. https://codeberg.org/api/v1.
+func NewGitea(baseURL, token string) *Gitea {
+	return &Gitea{api: &apiClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		authHeader: "Authorization",
+		authValue:  "token " + token,
+		httpClient: &http.Client{},
+	}}
+}
+
+type giteaBranch struct {
+	Ref string `json:"ref"`
+	SHA string `json:"sha"`
+}
+
+type giteaPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	State   string `json:"state"`
+	Merged  bool   `json:"merged"`
+	// Gitea reports false until it has checked for conflicts
+	Mergeable bool        `json:"mergeable"`
+	Head      giteaBranch `json:"head"`
+	Base      giteaBranch `json:"base"`
+}
+
+func (pr *giteaPullRequest) toMergeRequest() *MergeRequest {
+	return &MergeRequest{
+		Number:       pr.Number,
+		URL:          pr.HTMLURL,
+		Title:        pr.Title,
+		Body:         pr.Body,
+		State:        pr.State,
+		SourceBranch: pr.Head.Ref,
+		TargetBranch: pr.Base.Ref,
+		HeadSHA:      pr.Head.SHA,
+	}
+}
+
+func repoPath(repo *Repository) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(repo.Owner), url.PathEscape(repo.Name))
+}
+
+func (g *Gitea) ParseRepoURL(repoURL string) (*Repository, error) {
+	return parseRepoURL(repoURL, false)
+}
+
+func (g *Gitea) BranchExists(repo *Repository, branch string) (bool, error) {
+	err := g.api.do("GET", fmt.Sprintf("%s/branches/%s", repoPath(repo), url.PathEscape(branch)), nil, nil, http.StatusOK)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// giteaPageSize is the maximum page size Gitea allows by default
+const giteaPageSize = 50
+
+func (g *Gitea) FindOpenMergeRequest(repo *Repository, sourceBranch string) (*MergeRequest, error) {
+	// Gitea cannot filter pull requests by head branch, page through the open ones
+	for page := 1; ; page++ {
+		var prs []giteaPullRequest
+		path := fmt.Sprintf("%s/pulls?state=open&limit=%d&page=%d", repoPath(repo), giteaPageSize, page)
+		if err := g.api.do("GET", path, nil, &prs, http.StatusOK); err != nil {
+			return nil, fmt.Errorf("failed to list pull requests: %w", err)
+		}
+
+		for _, pr := range prs {
+			if pr.Head.Ref == sourceBranch {
+				return pr.toMergeRequest(), nil
+			}
+		}
+		if len(prs) < giteaPageSize {
+			return nil, nil
+		}
+	}
+}
+
+func (g *Gitea) CreateMergeRequest(repo *Repository, input *MergeRequestInput) (*MergeRequest, error) {
+	payload := map[string]string{
+		"head":  input.SourceBranch,
+		"base":  input.TargetBranch,
+		"title": input.Title,
+		"body":  input.Body,
+	}
+
+	var pr giteaPullRequest
+	if err := g.api.do("POST", repoPath(repo)+"/pulls", payload, &pr, http.StatusCreated); err != nil {
+		return nil, fmt.Errorf("failed to create pull request: %w", err)
+	}
+	if err := g.addLabels(repo, pr.Number, input.Labels); err != nil {
+		return nil, err
+	}
+	return pr.toMergeRequest(), nil
+}
+
+func (g *Gitea) UpdateMergeRequest(repo *Repository, number int, input *MergeRequestInput) (*MergeRequest, error) {
+	payload := map[string]string{}
+	if input.Title != "" {
+		payload["title"] = input.Title
+	}
+	if input.Body != "" {
+		payload["body"] = input.Body
+	}
+
+	var pr giteaPullRequest
+	// Gitea answers edits with 201
+	if err := g.api.do("PATCH", fmt.Sprintf("%s/pulls/%d", repoPath(repo), number), payload, &pr, http.StatusCreated, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to update pull request #%d: %w", number, err)
+	}
+	if err := g.addLabels(repo, number, input.Labels); err != nil {
+		return nil, err
+	}
+	return pr.toMergeRequest(), nil
+}
+
+type giteaLabel struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// addLabels resolves label names to IDs, Gitea does not accept names. Labels missing from
+// the repository are skipped rather than created.
+func (g *Gitea) addLabels(repo *Repository, number int, labels []string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	var repoLabels []giteaLabel
+	if err := g.api.do("GET", fmt.Sprintf("%s/labels?limit=%d", repoPath(repo), giteaPageSize), nil, &repoLabels, http.StatusOK); err != nil {
+		return fmt.Errorf("failed to list labels: %w", err)
+	}
+
+	ids := []int64{}
+	for _, label := range repoLabels {
+		for _, name := range labels {
+			if label.Name == name {
+				ids = append(ids, label.ID)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	payload := map[string][]int64{"labels": ids}
+	if err := g.api.do("POST", fmt.Sprintf("%s/issues/%d/labels", repoPath(repo), number), payload, nil, http.StatusOK); err != nil {
+		return fmt.Errorf("failed to label pull request #%d: %w", number, err)
+	}
+	return nil
+}
+
+type giteaCombinedStatus struct {
+	Statuses []struct {
+		Context string `json:"context"`
+		Status  string `json:"status"`
+	} `json:"statuses"`
+}
+
+type giteaReview struct {
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	State string `json:"state"`
+}
+
+func (g *Gitea) GetMergeRequestStatus(repo *Repository, number int) (*MergeRequestStatus, error) {
+	var pr giteaPullRequest
+	if err := g.api.do("GET", fmt.Sprintf("%s/pulls/%d", repoPath(repo), number), nil, &pr, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to get pull request #%d: %w", number, err)
+	}
+
+	var combinedStatus giteaCombinedStatus
+	if err := g.api.do("GET", fmt.Sprintf("%s/commits/%s/status", repoPath(repo), pr.Head.SHA), nil, &combinedStatus, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to get commit status: %w", err)
+	}
+	checks := []Check{}
+	for _, status := range combinedStatus.Statuses {
+		state := status.Status
+		if state != StatusSuccess && state != StatusPending {
+			state = StatusFailure
+		}
+		checks = append(checks, Check{Name: status.Context, State: state})
+	}
+
+	var reviews []giteaReview
+	if err := g.api.do("GET", fmt.Sprintf("%s/pulls/%d/reviews", repoPath(repo), number), nil, &reviews, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	latestReviews := []Review{}
+	reviewIndex := map[string]int{}
+	for _, review := range reviews {
+		var state string
+		switch review.State {
+		case "APPROVED":
+			state = "approved"
+		case "REQUEST_CHANGES":
+			state = "changes_requested"
+		default:
+			// Comments and pending reviews leave the verdict of the reviewer as it was
+			continue
+		}
+		if i, ok := reviewIndex[review.User.Login]; ok {
+			latestReviews[i].State = state
+			continue
+		}
+		reviewIndex[review.User.Login] = len(latestReviews)
+		latestReviews = append(latestReviews, Review{User: review.User.Login, State: state})
+	}
+
+	mergeable := pr.Mergeable
+	return &MergeRequestStatus{
+		MergeRequest: *pr.toMergeRequest(),
+		Merged:       pr.Merged,
+		Mergeable:    &mergeable,
+		ChecksState:  combineChecks(checks),
+		Checks:       checks,
+		Reviews:      latestReviews,
+	}, nil
+}
+
+func (g *Gitea) CloseMergeRequest(repo *Repository, number int) error {
+	payload := map[string]string{"state": "closed"}
+	if err := g.api.do("PATCH", fmt.Sprintf("%s/pulls/%d", repoPath(repo), number), payload, nil, http.StatusCreated, http.StatusOK); err != nil {
+		return fmt.Errorf("failed to close pull request #%d: %w", number, err)
+	}
+	return nil
+}
+
+func (g *Gitea) DeleteBranch(repo *Repository, branch string) error {
+	if err := g.api.do("DELETE", fmt.Sprintf("%s/branches/%s", repoPath(repo), url.PathEscape(branch)), nil, nil, http.StatusNoContent); err != nil {
+		return fmt.Errorf("failed to delete branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+func (g *Gitea) Comment(repo *Repository, number int, body string) error {
+	payload := map[string]string{"body": body}
+	if err := g.api.do("POST", fmt.Sprintf("%s/issues/%d/comments", repoPath(repo), number), payload, nil, http.StatusCreated); err != nil {
+		return fmt.Errorf("failed to comment on pull request #%d: %w", number, err)
+	}
+	return nil
+}
+
+func (g *Gitea) SetStatus(repo *Repository, sha string, status *CommitStatus) error {
+	payload := map[string]string{
+		"state":       status.State,
+		"context":     status.Context,
+		"description": status.Description,
+	}
+	if status.TargetURL != "" {
+		payload["target_url"] = status.TargetURL
+	}
+
+	if err := g.api.do("POST", fmt.Sprintf("%s/statuses/%s", repoPath(repo), sha), payload, nil, http.StatusCreated); err != nil {
+		return fmt.Errorf("failed to set commit status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+var giteaRepo = &Repository{Host: "codeberg.org", Owner: "acme", Name: "infra"}
+
+func giteaPullRequestBody(number int, headRef string) map[string]interface{} {
+	return map[string]interface{}{
+		"number":    number,
+		"html_url":  fmt.Sprintf("https://codeberg.org/acme/infra/pulls/%d", number),
+		"title":     "Add bucket",
+		"body":      "body",
+		"state":     "open",
+		"mergeable": true,
+		"head":      map[string]interface{}{"ref": headRef, "sha": "abc123"},
+		"base":      map[string]interface{}{"ref": "main", "sha": "def456"},
+	}
+}
+
+func TestGiteaParseRepoURL(t *testing.T) {
+	repo, err := NewGitea("https://codeberg.org/api/v1", "token").ParseRepoURL("https://codeberg.org/acme/infra.git")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *repo != *giteaRepo {
+		t.Errorf("expected %+v, got %+v", giteaRepo, repo)
+	}
+}
+
+func TestGiteaFindOpenMergeRequest(t *testing.T) {
+	// The first page is full, so the match on the second page must be found
+	firstPage := []interface{}{}
+	for i := 0; i < giteaPageSize; i++ {
+		firstPage = append(firstPage, giteaPullRequestBody(100+i, fmt.Sprintf("other-%d", i)))
+	}
+	pages := map[string][]interface{}{
+		"1": firstPage,
+		"2": {giteaPullRequestBody(7, "prism/add-bucket")},
+	}
+
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET /repos/acme/infra/pulls": {status: http.StatusOK, body: func(r *http.Request) interface{} {
+			return pages[r.URL.Query().Get("page")]
+		}},
+	})
+
+	provider := NewGitea(baseURL, "secret")
+	mr, err := provider.FindOpenMergeRequest(giteaRepo, "prism/add-bucket")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mr == nil || mr.Number != 7 || mr.SourceBranch != "prism/add-bucket" {
+		t.Errorf("unexpected merge request %+v", mr)
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("expected two pages to be requested, got %d", len(fake.requests))
+	}
+	if fake.requests[0].header.Get("Authorization") != "token secret" {
+		t.Errorf("unexpected authorization header %q", fake.requests[0].header.Get("Authorization"))
+	}
+
+	missing, err := provider.FindOpenMergeRequest(giteaRepo, "missing")
+	if err != nil || missing != nil {
+		t.Errorf("expected no merge request, got %+v, %v", missing, err)
+	}
+}
+
+func TestGiteaCreateUpdateAndCloseMergeRequest(t *testing.T) {
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"POST /repos/acme/infra/pulls":    {status: http.StatusCreated, body: giteaPullRequestBody(7, "prism/add-bucket")},
+		"PATCH /repos/acme/infra/pulls/7": {status: http.StatusCreated, body: giteaPullRequestBody(7, "prism/add-bucket")},
+		"GET /repos/acme/infra/labels": {status: http.StatusOK, body: []interface{}{
+			map[string]interface{}{"id": 1, "name": "bug"},
+			map[string]interface{}{"id": 2, "name": "prism"},
+		}},
+		"POST /repos/acme/infra/issues/7/labels": {status: http.StatusOK, body: []interface{}{}},
+	})
+	provider := NewGitea(baseURL, "token")
+
+	mr, err := provider.CreateMergeRequest(giteaRepo, &MergeRequestInput{
+		Title:        "Add bucket",
+		Body:         "body",
+		SourceBranch: "prism/add-bucket",
+		TargetBranch: "main",
+		Labels:       []string{"prism", "missing"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mr.Number != 7 {
+		t.Errorf("unexpected merge request %+v", mr)
+	}
+	created := fake.request(t, "POST", "/repos/acme/infra/pulls").body
+	if created["head"] != "prism/add-bucket" || created["base"] != "main" {
+		t.Errorf("unexpected create payload %v", created)
+	}
+	labels := fake.request(t, "POST", "/repos/acme/infra/issues/7/labels").body
+	if ids, _ := labels["labels"].([]interface{}); len(ids) != 1 || ids[0] != float64(2) {
+		t.Errorf("expected only the existing label to be added, got %v", labels)
+	}
+
+	if _, err := provider.UpdateMergeRequest(giteaRepo, 7, &MergeRequestInput{Body: "new body"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated := fake.requests[len(fake.requests)-1].body; updated["body"] != "new body" {
+		t.Errorf("unexpected update payload %v", updated)
+	}
+
+	if err := provider.CloseMergeRequest(giteaRepo, 7); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if closed := fake.requests[len(fake.requests)-1].body; closed["state"] != "closed" {
+		t.Errorf("unexpected close payload %v", closed)
+	}
+}
+
+func TestGiteaBranches(t *testing.T) {
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET /repos/acme/infra/branches/main":             {status: http.StatusOK, body: map[string]interface{}{"name": "main"}},
+		"DELETE /repos/acme/infra/branches/prism%2Ftopic": {status: http.StatusNoContent},
+	})
+	provider := NewGitea(baseURL, "token")
+
+	if exists, err := provider.BranchExists(giteaRepo, "main"); err != nil || !exists {
+		t.Errorf("expected main to exist, got %v, %v", exists, err)
+	}
+	if exists, err := provider.BranchExists(giteaRepo, "missing"); err != nil || exists {
+		t.Errorf("expected missing branch not to exist, got %v, %v", exists, err)
+	}
+	if err := provider.DeleteBranch(giteaRepo, "prism/topic"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	fake.request(t, "DELETE", "/repos/acme/infra/branches/prism%2Ftopic")
+}
+
+func TestGiteaGetMergeRequestStatus(t *testing.T) {
+	_, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET /repos/acme/infra/pulls/7": {status: http.StatusOK, body: giteaPullRequestBody(7, "prism/add-bucket")},
+		"GET /repos/acme/infra/commits/abc123/status": {status: http.StatusOK, body: map[string]interface{}{
+			"statuses": []interface{}{
+				map[string]string{"context": "ci/lint", "status": "success"},
+				map[string]string{"context": "ci/test", "status": "warning"},
+			},
+		}},
+		"GET /repos/acme/infra/pulls/7/reviews": {status: http.StatusOK, body: []interface{}{
+			map[string]interface{}{"user": map[string]string{"login": "alice"}, "state": "REQUEST_CHANGES"},
+			map[string]interface{}{"user": map[string]string{"login": "bob"}, "state": "PENDING"},
+			map[string]interface{}{"user": map[string]string{"login": "alice"}, "state": "APPROVED"},
+			map[string]interface{}{"user": map[string]string{"login": "alice"}, "state": "COMMENT"},
+		}},
+	})
+
+	status, err := NewGitea(baseURL, "token").GetMergeRequestStatus(giteaRepo, 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.Mergeable == nil || !*status.Mergeable {
+		t.Errorf("unexpected merge request %+v", status)
+	}
+	if status.ChecksState != StatusFailure || len(status.Checks) != 2 || status.Checks[1] != (Check{Name: "ci/test", State: StatusFailure}) {
+		t.Errorf("unexpected checks %s %+v", status.ChecksState, status.Checks)
+	}
+	if len(status.Reviews) != 1 || status.Reviews[0] != (Review{User: "alice", State: "approved"}) {
+		t.Errorf("unexpected reviews %+v", status.Reviews)
+	}
+}
@@ -0,0 +1,183 @@
+package scm
+
+import (
+	"strings"
+
+	"github.com/benkamin03/prism/internal/github"
+)
+
+type GitHub struct {
+	Client *github.Client
+}
+
+func NewGitHub(token string) *GitHub {
+	return &GitHub{Client: github.NewClient(token)}
+}
+
+// NewGitHubEnterprise talks to the REST API of a GitHub Enterprise Server instance, e.g. https://ghe.example.com/api/v3.
+func NewGitHubEnterprise(baseURL, token string) *GitHub {
+	client := github.NewClient(token)
+	client.BaseURL = strings.TrimSuffix(baseURL, "/")
+	return &GitHub{Client: client}
+}
+
+func (g *GitHub) ParseRepoURL(repoURL string) (*Repository, error) {
+	return parseRepoURL(repoURL, false)
+}
+
+func (g *GitHub) BranchExists(repo *Repository, branch string) (bool, error) {
+	return g.Client.BranchExists(repo.Owner, repo.Name, branch)
+}
+
+func (g *GitHub) FindOpenMergeRequest(repo *Repository, sourceBranch string) (*MergeRequest, error) {
+	pr, err := g.Client.FindOpenPullRequest(repo.Owner, repo.Name, sourceBranch)
+	if err != nil || pr == nil {
+		return nil, err
+	}
+	return fromGitHubPullRequest(pr), nil
+}
+
+func (g *GitHub) CreateMergeRequest(repo *Repository, input *MergeRequestInput) (*MergeRequest, error) {
+	pr, err := g.Client.CreatePullRequest(repo.Owner, repo.Name, &github.CreatePRRequest{
+		Title: input.Title,
+		Head:  input.SourceBranch,
+		Base:  input.TargetBranch,
+		Body:  input.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := g.addLabels(repo, pr.Number, input.Labels); err != nil {
+		return nil, err
+	}
+	return fromGitHubPullRequest(pr), nil
+}
+
+func (g *GitHub) UpdateMergeRequest(repo *Repository, number int, input *MergeRequestInput) (*MergeRequest, error) {
+	pr, err := g.Client.UpdatePullRequest(repo.Owner, repo.Name, number, &github.UpdatePRRequest{
+		Title: input.Title,
+		Body:  input.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := g.addLabels(repo, number, input.Labels); err != nil {
+		return nil, err
+	}
+	return fromGitHubPullRequest(pr), nil
+}
+
+func (g *GitHub) addLabels(repo *Repository, number int, labels []string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	return g.Client.AddLabels(repo.Owner, repo.Name, number, labels)
+}
+
+func (g *GitHub) GetMergeRequestStatus(repo *Repository, number int) (*MergeRequestStatus, error) {
+	pr, err := g.Client.GetPullRequest(repo.Owner, repo.Name, number)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checks come from both the legacy status API and check runs
+	checks := []Check{}
+	combinedStatus, err := g.Client.GetCombinedStatus(repo.Owner, repo.Name, pr.Head.SHA)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range combinedStatus.Statuses {
+		state := status.State
+		if state == StatusError {
+			state = StatusFailure
+		}
+		checks = append(checks, Check{Name: status.Context, State: state})
+	}
+
+	checkRuns, err := g.Client.ListCheckRuns(repo.Owner, repo.Name, pr.Head.SHA)
+	if err != nil {
+		return nil, err
+	}
+	for _, checkRun := range checkRuns {
+		state := StatusPending
+		if checkRun.Status == "completed" {
+			switch checkRun.Conclusion {
+			case "success", "neutral", "skipped":
+				state = StatusSuccess
+			default:
+				state = StatusFailure
+			}
+		}
+		checks = append(checks, Check{Name: checkRun.Name, State: state})
+	}
+
+	reviews, err := g.Client.ListReviews(repo.Owner, repo.Name, number)
+	if err != nil {
+		return nil, err
+	}
+	latestReviews := []Review{}
+	reviewIndex := map[string]int{}
+	for _, review := range reviews {
+		// Comments, drafts and dismissed reviews leave the verdict of the reviewer as it was
+		state := strings.ToLower(review.State)
+		if state != "approved" && state != "changes_requested" {
+			continue
+		}
+		if i, ok := reviewIndex[review.User.Login]; ok {
+			latestReviews[i].State = state
+			continue
+		}
+		reviewIndex[review.User.Login] = len(latestReviews)
+		latestReviews = append(latestReviews, Review{User: review.User.Login, State: state})
+	}
+
+	return &MergeRequestStatus{
+		MergeRequest: *fromGitHubPullRequest(pr),
+		Merged:       pr.Merged,
+		Mergeable:    pr.Mergeable,
+		ChecksState:  combineChecks(checks),
+		Checks:       checks,
+		Reviews:      latestReviews,
+	}, nil
+}
+
+func (g *GitHub) CloseMergeRequest(repo *Repository, number int) error {
+	_, err := g.Client.UpdatePullRequest(repo.Owner, repo.Name, number, &github.UpdatePRRequest{State: "closed"})
+	return err
+}
+
+func (g *GitHub) DeleteBranch(repo *Repository, branch string) error {
+	return g.Client.DeleteBranch(repo.Owner, repo.Name, branch)
+}
+
+func (g *GitHub) Comment(repo *Repository, number int, body string) error {
+	_, err := g.Client.CreateIssueComment(repo.Owner, repo.Name, number, body)
+	return err
+}
+
+func (g *GitHub) SetStatus(repo *Repository, sha string, status *CommitStatus) error {
+	return g.Client.CreateCommitStatus(repo.Owner, repo.Name, sha, &github.CommitStatus{
+		State:       status.State,
+		TargetURL:   status.TargetURL,
+		Description: status.Description,
+		Context:     status.Context,
+	})
+}
+
+// ReportPlan publishes a check run with annotations, which only GitHub supports.
+func (g *GitHub) ReportPlan(repo *Repository, report *github.PlanReport) error {
+	return g.Client.ReportPlan(repo.Owner, repo.Name, report)
+}
+
+func fromGitHubPullRequest(pr *github.PullRequest) *MergeRequest {
+	return &MergeRequest{
+		Number:       pr.Number,
+		URL:          pr.HTMLURL,
+		Title:        pr.Title,
+		Body:         pr.Body,
+		State:        strings.ToLower(pr.State),
+		SourceBranch: pr.Head.Ref,
+		TargetBranch: pr.Base.Ref,
+		HeadSHA:      pr.Head.SHA,
+	}
+}
@@ -0,0 +1,220 @@
+package scm
+
+import (
+	"net/http"
+	"testing"
+)
+
+var githubRepo = &Repository{Host: "github.com", Owner: "acme", Name: "infra"}
+
+func githubPullRequest(number int, state string) map[string]interface{} {
+	return map[string]interface{}{
+		"number":   number,
+		"html_url": "https://github.com/acme/infra/pull/7",
+		"title":    "Add bucket",
+		"body":     "body",
+		"state":    state,
+		"head":     map[string]interface{}{"ref": "prism/add-bucket", "sha": "abc123"},
+		"base":     map[string]interface{}{"ref": "main", "sha": "def456"},
+	}
+}
+
+func TestGitHubParseRepoURL(t *testing.T) {
+	repo, err := NewGitHub("token").ParseRepoURL("https://github.com/acme/infra.git")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *repo != *githubRepo {
+		t.Errorf("expected %+v, got %+v", githubRepo, repo)
+	}
+	if _, err := NewGitHub("token").ParseRepoURL("https://github.com/acme/platform/infra.git"); err == nil {
+		t.Error("expected nested owners to be rejected")
+	}
+}
+
+func TestGitHubFindOpenMergeRequest(t *testing.T) {
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET /repos/acme/infra/pulls": {status: http.StatusOK, body: []interface{}{githubPullRequest(7, "open")}},
+	})
+
+	mr, err := NewGitHubEnterprise(baseURL, "token").FindOpenMergeRequest(githubRepo, "prism/add-bucket")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mr == nil || mr.Number != 7 || mr.SourceBranch != "prism/add-bucket" || mr.TargetBranch != "main" || mr.HeadSHA != "abc123" {
+		t.Errorf("unexpected merge request %+v", mr)
+	}
+
+	request := fake.request(t, "GET", "/repos/acme/infra/pulls")
+	if request.query.Get("head") != "acme:prism/add-bucket" || request.query.Get("state") != "open" {
+		t.Errorf("unexpected query %v", request.query)
+	}
+	if request.header.Get("Authorization") != "token token" {
+		t.Errorf("unexpected authorization header %q", request.header.Get("Authorization"))
+	}
+}
+
+func TestGitHubFindOpenMergeRequestNone(t *testing.T) {
+	_, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET /repos/acme/infra/pulls": {status: http.StatusOK, body: []interface{}{}},
+	})
+
+	mr, err := NewGitHubEnterprise(baseURL, "token").FindOpenMergeRequest(githubRepo, "prism/add-bucket")
+	if err != nil || mr != nil {
+		t.Errorf("expected no merge request, got %+v, %v", mr, err)
+	}
+}
+
+func TestGitHubCreateMergeRequest(t *testing.T) {
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"POST /repos/acme/infra/pulls":           {status: http.StatusCreated, body: githubPullRequest(7, "open")},
+		"POST /repos/acme/infra/issues/7/labels": {status: http.StatusOK, body: []interface{}{}},
+	})
+
+	mr, err := NewGitHubEnterprise(baseURL, "token").CreateMergeRequest(githubRepo, &MergeRequestInput{
+		Title:        "Add bucket",
+		Body:         "body",
+		SourceBranch: "prism/add-bucket",
+		TargetBranch: "main",
+		Labels:       []string{"prism"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mr.Number != 7 || mr.State != "open" {
+		t.Errorf("unexpected merge request %+v", mr)
+	}
+
+	created := fake.request(t, "POST", "/repos/acme/infra/pulls").body
+	if created["head"] != "prism/add-bucket" || created["base"] != "main" || created["title"] != "Add bucket" {
+		t.Errorf("unexpected create payload %v", created)
+	}
+	labels := fake.request(t, "POST", "/repos/acme/infra/issues/7/labels").body
+	if got, _ := labels["labels"].([]interface{}); len(got) != 1 || got[0] != "prism" {
+		t.Errorf("unexpected labels payload %v", labels)
+	}
+}
+
+func TestGitHubUpdateAndCloseMergeRequest(t *testing.T) {
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"PATCH /repos/acme/infra/pulls/7": {status: http.StatusOK, body: githubPullRequest(7, "open")},
+	})
+	provider := NewGitHubEnterprise(baseURL, "token")
+
+	if _, err := provider.UpdateMergeRequest(githubRepo, 7, &MergeRequestInput{Body: "new body"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated := fake.request(t, "PATCH", "/repos/acme/infra/pulls/7").body
+	if updated["body"] != "new body" {
+		t.Errorf("unexpected update payload %v", updated)
+	}
+	if _, ok := updated["title"]; ok {
+		t.Errorf("expected an empty title to be left out, got %v", updated)
+	}
+
+	if err := provider.CloseMergeRequest(githubRepo, 7); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if closed := fake.requests[len(fake.requests)-1].body; closed["state"] != "closed" {
+		t.Errorf("unexpected close payload %v", closed)
+	}
+}
+
+func TestGitHubBranches(t *testing.T) {
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET /repos/acme/infra/branches/main":                 {status: http.StatusOK, body: map[string]interface{}{"name": "main"}},
+		"DELETE /repos/acme/infra/git/refs/heads/prism/topic": {status: http.StatusNoContent},
+	})
+	provider := NewGitHubEnterprise(baseURL, "token")
+
+	if exists, err := provider.BranchExists(githubRepo, "main"); err != nil || !exists {
+		t.Errorf("expected main to exist, got %v, %v", exists, err)
+	}
+	if exists, err := provider.BranchExists(githubRepo, "missing"); err != nil || exists {
+		t.Errorf("expected missing branch not to exist, got %v, %v", exists, err)
+	}
+	if err := provider.DeleteBranch(githubRepo, "prism/topic"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	fake.request(t, "DELETE", "/repos/acme/infra/git/refs/heads/prism/topic")
+	if err := provider.DeleteBranch(githubRepo, "missing"); err == nil {
+		t.Error("expected deleting a missing branch to fail")
+	}
+}
+
+func TestGitHubGetMergeRequestStatus(t *testing.T) {
+	pr := githubPullRequest(7, "open")
+	pr["mergeable"] = true
+	_, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET /repos/acme/infra/pulls/7": {status: http.StatusOK, body: pr},
+		"GET /repos/acme/infra/commits/abc123/status": {status: http.StatusOK, body: map[string]interface{}{
+			"state": "failure",
+			"statuses": []interface{}{
+				map[string]interface{}{"context": "ci/lint", "state": "success"},
+				map[string]interface{}{"context": "ci/test", "state": "error"},
+			},
+		}},
+		"GET /repos/acme/infra/commits/abc123/check-runs": {status: http.StatusOK, body: map[string]interface{}{
+			"check_runs": []interface{}{
+				map[string]interface{}{"name": "build", "status": "completed", "conclusion": "neutral"},
+				map[string]interface{}{"name": "deploy", "status": "in_progress"},
+			},
+		}},
+		"GET /repos/acme/infra/pulls/7/reviews": {status: http.StatusOK, body: []interface{}{
+			map[string]interface{}{"user": map[string]string{"login": "alice"}, "state": "APPROVED"},
+			map[string]interface{}{"user": map[string]string{"login": "bob"}, "state": "CHANGES_REQUESTED"},
+			map[string]interface{}{"user": map[string]string{"login": "carol"}, "state": "DISMISSED"},
+			map[string]interface{}{"user": map[string]string{"login": "bob"}, "state": "APPROVED"},
+			// A later comment or draft does not take back the approval
+			map[string]interface{}{"user": map[string]string{"login": "alice"}, "state": "COMMENTED"},
+			map[string]interface{}{"user": map[string]string{"login": "bob"}, "state": "PENDING"},
+			map[string]interface{}{"user": map[string]string{"login": "dave"}, "state": "COMMENTED"},
+		}},
+	})
+
+	status, err := NewGitHubEnterprise(baseURL, "token").GetMergeRequestStatus(githubRepo, 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.Number != 7 || status.Merged || status.Mergeable == nil || !*status.Mergeable {
+		t.Errorf("unexpected merge request %+v", status)
+	}
+
+	wantChecks := []Check{
+		{Name: "ci/lint", State: StatusSuccess},
+		{Name: "ci/test", State: StatusFailure},
+		{Name: "build", State: StatusSuccess},
+		{Name: "deploy", State: StatusPending},
+	}
+	if len(status.Checks) != len(wantChecks) {
+		t.Fatalf("expected checks %+v, got %+v", wantChecks, status.Checks)
+	}
+	for i, check := range wantChecks {
+		if status.Checks[i] != check {
+			t.Errorf("expected check %+v, got %+v", check, status.Checks[i])
+		}
+	}
+	if status.ChecksState != StatusFailure {
+		t.Errorf("expected combined state failure, got %s", status.ChecksState)
+	}
+
+	wantReviews := []Review{{User: "alice", State: "approved"}, {User: "bob", State: "approved"}}
+	if len(status.Reviews) != len(wantReviews) {
+		t.Fatalf("expected reviews %+v, got %+v", wantReviews, status.Reviews)
+	}
+	for i, review := range wantReviews {
+		if status.Reviews[i] != review {
+			t.Errorf("expected review %+v, got %+v", review, status.Reviews[i])
+		}
+	}
+}
+
+func TestGitHubAPIError(t *testing.T) {
+	_, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET /repos/acme/infra/pulls": {status: http.StatusInternalServerError, body: map[string]string{"message": "boom"}},
+	})
+
+	if _, err := NewGitHubEnterprise(baseURL, "token").FindOpenMergeRequest(githubRepo, "prism/add-bucket"); err == nil {
+		t.Error("expected an error for a failing API")
+	}
+}
@@ -0,0 +1,229 @@
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type GitLab struct {
+	api *apiClient
+}
+
+// NewGitLab talks to the v4 REST API at baseURL, e.g. https://gitlab.com/api/v4.
+func NewGitLab(baseURL, token string) *GitLab {
+	return &GitLab{api: &apiClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		authHeader: "PRIVATE-TOKEN",
+		authValue:  token,
+		httpClient: &http.Client{},
+	}}
+}
+
+type gitlabMergeRequest struct {
+	IID          int    `json:"iid"`
+	WebURL       string `json:"web_url"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	State        string `json:"state"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	SHA          string `json:"sha"`
+	MergeStatus  string `json:"merge_status"`
+}
+
+func (mr *gitlabMergeRequest) toMergeRequest() *MergeRequest {
+	state := mr.State
+	if state == "opened" {
+		state = "open"
+	}
+	return &MergeRequest{
+		Number:       mr.IID,
+		URL:          mr.WebURL,
+		Title:        mr.Title,
+		Body:         mr.Description,
+		State:        state,
+		SourceBranch: mr.SourceBranch,
+		TargetBranch: mr.TargetBranch,
+		HeadSHA:      mr.SHA,
+	}
+}
+
+// projectPath is the URL-encoded "group/subgroup/name" GitLab accepts in place of a numeric project ID.
+func projectPath(repo *Repository) string {
+	return "/projects/" + url.PathEscape(repo.FullName())
+}
+
+func (g *GitLab) ParseRepoURL(repoURL string) (*Repository, error) {
+	return parseRepoURL(repoURL, true)
+}
+
+func (g *GitLab) BranchExists(repo *Repository, branch string) (bool, error) {
+	err := g.api.do("GET", fmt.Sprintf("%s/repository/branches/%s", projectPath(repo), url.PathEscape(branch)), nil, nil, http.StatusOK)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (g *GitLab) FindOpenMergeRequest(repo *Repository, sourceBranch string) (*MergeRequest, error) {
+	query := url.Values{}
+	query.Set("state", "opened")
+	query.Set("source_branch", sourceBranch)
+
+	var mrs []gitlabMergeRequest
+	if err := g.api.do("GET", fmt.Sprintf("%s/merge_requests?%s", projectPath(repo), query.Encode()), nil, &mrs, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to list merge requests: %w", err)
+	}
+	if len(mrs) == 0 {
+		return nil, nil
+	}
+	return mrs[0].toMergeRequest(), nil
+}
+
+func (g *GitLab) CreateMergeRequest(repo *Repository, input *MergeRequestInput) (*MergeRequest, error) {
+	payload := map[string]string{
+		"source_branch": input.SourceBranch,
+		"target_branch": input.TargetBranch,
+		"title":         input.Title,
+		"description":   input.Body,
+	}
+	if len(input.Labels) > 0 {
+		payload["labels"] = strings.Join(input.Labels, ",")
+	}
+
+	var mr gitlabMergeRequest
+	if err := g.api.do("POST", projectPath(repo)+"/merge_requests", payload, &mr, http.StatusCreated); err != nil {
+		return nil, fmt.Errorf("failed to create merge request: %w", err)
+	}
+	return mr.toMergeRequest(), nil
+}
+
+func (g *GitLab) UpdateMergeRequest(repo *Repository, number int, input *MergeRequestInput) (*MergeRequest, error) {
+	payload := map[string]string{}
+	if input.Title != "" {
+		payload["title"] = input.Title
+	}
+	if input.Body != "" {
+		payload["description"] = input.Body
+	}
+	if len(input.Labels) > 0 {
+		payload["add_labels"] = strings.Join(input.Labels, ",")
+	}
+
+	var mr gitlabMergeRequest
+	if err := g.api.do("PUT", fmt.Sprintf("%s/merge_requests/%d", projectPath(repo), number), payload, &mr, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to update merge request !%d: %w", number, err)
+	}
+	return mr.toMergeRequest(), nil
+}
+
+type gitlabCommitStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type gitlabApprovals struct {
+	ApprovedBy []struct {
+		User struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	} `json:"approved_by"`
+}
+
+func (g *GitLab) GetMergeRequestStatus(repo *Repository, number int) (*MergeRequestStatus, error) {
+	var mr gitlabMergeRequest
+	if err := g.api.do("GET", fmt.Sprintf("%s/merge_requests/%d", projectPath(repo), number), nil, &mr, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to get merge request !%d: %w", number, err)
+	}
+
+	var statuses []gitlabCommitStatus
+	if err := g.api.do("GET", fmt.Sprintf("%s/repository/commits/%s/statuses", projectPath(repo), mr.SHA), nil, &statuses, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to list commit statuses: %w", err)
+	}
+	checks := []Check{}
+	for _, status := range statuses {
+		state := StatusPending
+		switch status.Status {
+		case "success", "skipped":
+			state = StatusSuccess
+		case "failed", "canceled":
+			state = StatusFailure
+		}
+		checks = append(checks, Check{Name: status.Name, State: state})
+	}
+
+	// GitLab has no review states, approvals are the closest equivalent
+	var approvals gitlabApprovals
+	if err := g.api.do("GET", fmt.Sprintf("%s/merge_requests/%d/approvals", projectPath(repo), number), nil, &approvals, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to get merge request approvals: %w", err)
+	}
+	reviews := []Review{}
+	for _, approval := range approvals.ApprovedBy {
+		reviews = append(reviews, Review{User: approval.User.Username, State: "approved"})
+	}
+
+	var mergeable *bool
+	switch mr.MergeStatus {
+	case "can_be_merged":
+		mergeable = new(bool)
+		*mergeable = true
+	case "cannot_be_merged":
+		mergeable = new(bool)
+	}
+
+	return &MergeRequestStatus{
+		MergeRequest: *mr.toMergeRequest(),
+		Merged:       mr.State == "merged",
+		Mergeable:    mergeable,
+		ChecksState:  combineChecks(checks),
+		Checks:       checks,
+		Reviews:      reviews,
+	}, nil
+}
+
+func (g *GitLab) CloseMergeRequest(repo *Repository, number int) error {
+	payload := map[string]string{"state_event": "close"}
+	if err := g.api.do("PUT", fmt.Sprintf("%s/merge_requests/%d", projectPath(repo), number), payload, nil, http.StatusOK); err != nil {
+		return fmt.Errorf("failed to close merge request !%d: %w", number, err)
+	}
+	return nil
+}
+
+func (g *GitLab) DeleteBranch(repo *Repository, branch string) error {
+	if err := g.api.do("DELETE", fmt.Sprintf("%s/repository/branches/%s", projectPath(repo), url.PathEscape(branch)), nil, nil, http.StatusNoContent); err != nil {
+		return fmt.Errorf("failed to delete branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+func (g *GitLab) Comment(repo *Repository, number int, body string) error {
+	payload := map[string]string{"body": body}
+	if err := g.api.do("POST", fmt.Sprintf("%s/merge_requests/%d/notes", projectPath(repo), number), payload, nil, http.StatusCreated); err != nil {
+		return fmt.Errorf("failed to comment on merge request !%d: %w", number, err)
+	}
+	return nil
+}
+
+func (g *GitLab) SetStatus(repo *Repository, sha string, status *CommitStatus) error {
+	// GitLab has no separate error state
+	state := status.State
+	if state == StatusFailure || state == StatusError {
+		state = "failed"
+	}
+
+	payload := map[string]string{
+		"state":       state,
+		"name":        status.Context,
+		"description": status.Description,
+	}
+	if status.TargetURL != "" {
+		payload["target_url"] = status.TargetURL
+	}
+
+	if err := g.api.do("POST", fmt.Sprintf("%s/statuses/%s", projectPath(repo), sha), payload, nil, http.StatusCreated, http.StatusOK); err != nil {
+		return fmt.Errorf("failed to set commit status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package scm
+
+import (
+	"net/http"
+	"testing"
+)
+
+var gitlabRepo = &Repository{Host: "gitlab.com", Owner: "acme/platform", Name: "infra"}
+
+const gitlabProject = "/projects/acme%2Fplatform%2Finfra"
+
+func gitlabMergeRequestBody(iid int, state string) map[string]interface{} {
+	return map[string]interface{}{
+		"iid":           iid,
+		"web_url":       "https://gitlab.com/acme/platform/infra/-/merge_requests/7",
+		"title":         "Add bucket",
+		"description":   "body",
+		"state":         state,
+		"source_branch": "prism/add-bucket",
+		"target_branch": "main",
+		"sha":           "abc123",
+		"merge_status":  "can_be_merged",
+	}
+}
+
+func TestGitLabParseRepoURL(t *testing.T) {
+	repo, err := NewGitLab("https://gitlab.com/api/v4", "token").ParseRepoURL("https://gitlab.com/acme/platform/infra.git")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *repo != *gitlabRepo {
+		t.Errorf("expected %+v, got %+v", gitlabRepo, repo)
+	}
+}
+
+func TestGitLabFindOpenMergeRequest(t *testing.T) {
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + gitlabProject + "/merge_requests": {status: http.StatusOK, body: []interface{}{gitlabMergeRequestBody(7, "opened")}},
+	})
+
+	mr, err := NewGitLab(baseURL, "secret").FindOpenMergeRequest(gitlabRepo, "prism/add-bucket")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mr == nil || mr.Number != 7 || mr.State != "open" || mr.Body != "body" || mr.HeadSHA != "abc123" {
+		t.Errorf("unexpected merge request %+v", mr)
+	}
+
+	request := fake.request(t, "GET", gitlabProject+"/merge_requests")
+	if request.query.Get("source_branch") != "prism/add-bucket" || request.query.Get("state") != "opened" {
+		t.Errorf("unexpected query %v", request.query)
+	}
+	if request.header.Get("PRIVATE-TOKEN") != "secret" {
+		t.Errorf("unexpected token header %q", request.header.Get("PRIVATE-TOKEN"))
+	}
+}
+
+func TestGitLabCreateUpdateAndCloseMergeRequest(t *testing.T) {
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"POST " + gitlabProject + "/merge_requests":  {status: http.StatusCreated, body: gitlabMergeRequestBody(7, "opened")},
+		"PUT " + gitlabProject + "/merge_requests/7": {status: http.StatusOK, body: gitlabMergeRequestBody(7, "opened")},
+	})
+	provider := NewGitLab(baseURL, "token")
+
+	mr, err := provider.CreateMergeRequest(gitlabRepo, &MergeRequestInput{
+		Title:        "Add bucket",
+		Body:         "body",
+		SourceBranch: "prism/add-bucket",
+		TargetBranch: "main",
+		Labels:       []string{"prism", "terraform"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mr.Number != 7 {
+		t.Errorf("unexpected merge request %+v", mr)
+	}
+	created := fake.request(t, "POST", gitlabProject+"/merge_requests").body
+	if created["source_branch"] != "prism/add-bucket" || created["description"] != "body" || created["labels"] != "prism,terraform" {
+		t.Errorf("unexpected create payload %v", created)
+	}
+
+	if _, err := provider.UpdateMergeRequest(gitlabRepo, 7, &MergeRequestInput{Title: "New title", Labels: []string{"prism"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated := fake.requests[len(fake.requests)-1].body
+	if updated["title"] != "New title" || updated["add_labels"] != "prism" {
+		t.Errorf("unexpected update payload %v", updated)
+	}
+	if _, ok := updated["description"]; ok {
+		t.Errorf("expected an empty body to be left out, got %v", updated)
+	}
+
+	if err := provider.CloseMergeRequest(gitlabRepo, 7); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if closed := fake.requests[len(fake.requests)-1].body; closed["state_event"] != "close" {
+		t.Errorf("unexpected close payload %v", closed)
+	}
+}
+
+func TestGitLabBranches(t *testing.T) {
+	fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + gitlabProject + "/repository/branches/main":             {status: http.StatusOK, body: map[string]interface{}{"name": "main"}},
+		"DELETE " + gitlabProject + "/repository/branches/prism%2Ftopic": {status: http.StatusNoContent},
+	})
+	provider := NewGitLab(baseURL, "token")
+
+	if exists, err := provider.BranchExists(gitlabRepo, "main"); err != nil || !exists {
+		t.Errorf("expected main to exist, got %v, %v", exists, err)
+	}
+	if exists, err := provider.BranchExists(gitlabRepo, "missing"); err != nil || exists {
+		t.Errorf("expected missing branch not to exist, got %v, %v", exists, err)
+	}
+	if err := provider.DeleteBranch(gitlabRepo, "prism/topic"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	fake.request(t, "DELETE", gitlabProject+"/repository/branches/prism%2Ftopic")
+}
+
+func TestGitLabGetMergeRequestStatus(t *testing.T) {
+	_, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + gitlabProject + "/merge_requests/7": {status: http.StatusOK, body: gitlabMergeRequestBody(7, "opened")},
+		"GET " + gitlabProject + "/repository/commits/abc123/statuses": {status: http.StatusOK, body: []interface{}{
+			map[string]string{"name": "lint", "status": "success"},
+			map[string]string{"name": "test", "status": "running"},
+			map[string]string{"name": "deploy", "status": "skipped"},
+		}},
+		"GET " + gitlabProject + "/merge_requests/7/approvals": {status: http.StatusOK, body: map[string]interface{}{
+			"approved_by": []interface{}{
+				map[string]interface{}{"user": map[string]string{"username": "alice"}},
+			},
+		}},
+	})
+
+	status, err := NewGitLab(baseURL, "token").GetMergeRequestStatus(gitlabRepo, 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.Merged || status.Mergeable == nil || !*status.Mergeable {
+		t.Errorf("unexpected merge request %+v", status)
+	}
+	if status.ChecksState != StatusPending || len(status.Checks) != 3 || status.Checks[1] != (Check{Name: "test", State: StatusPending}) {
+		t.Errorf("unexpected checks %s %+v", status.ChecksState, status.Checks)
+	}
+	if len(status.Reviews) != 1 || status.Reviews[0] != (Review{User: "alice", State: "approved"}) {
+		t.Errorf("unexpected reviews %+v", status.Reviews)
+	}
+}
+
+func TestGitLabGetMergedMergeRequestStatus(t *testing.T) {
+	mr := gitlabMergeRequestBody(7, "merged")
+	mr["merge_status"] = "unchecked"
+	_, baseURL := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + gitlabProject + "/merge_requests/7":                   {status: http.StatusOK, body: mr},
+		"GET " + gitlabProject + "/repository/commits/abc123/statuses": {status: http.StatusOK, body: []interface{}{}},
+		"GET " + gitlabProject + "/merge_requests/7/approvals":         {status: http.StatusOK, body: map[string]interface{}{}},
+	})
+
+	status, err := NewGitLab(baseURL, "token").GetMergeRequestStatus(gitlabRepo, 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !status.Merged || status.Mergeable != nil || status.ChecksState != "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
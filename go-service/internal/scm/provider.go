@@ -0,0 +1,200 @@
+package scm
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Repository identifies a repository on a git host. Owner may contain
+// several path segments for hosts with nested groups such as GitLab.
+type Repository struct {
+	Host  string
+	Owner string
+	Name  string
+}
+
+func (r *Repository) FullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+// RepositoryKey identifies the repository at repoURL as host/owner/name, the same for every
+// spelling of its clone URL.
+func RepositoryKey(repoURL string) (string, error) {
+	repo, err := parseRepoURL(repoURL, true)
+	if err != nil {
+		return "", err
+	}
+	return repo.Host + "/" + repo.FullName(), nil
+}
+
+// MergeRequest is a GitHub or Gitea pull request, or a GitLab merge request.
+type MergeRequest struct {
+	Number       int    `json:"number"`
+	URL          string `json:"url"`
+	Title        string `json:"title"`
+	Body         string `json:"body"`
+	State        string `json:"state"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	HeadSHA      string `json:"head_sha"`
+}
+
+type MergeRequestInput struct {
+	Title        string
+	Body         string
+	SourceBranch string
+	TargetBranch string
+	Labels       []string // added to the merge request, existing labels are kept
+}
+
+type Check struct {
+	Name  string `json:"name"`
+	State string `json:"state"` // pending, success or failure
+}
+
+type Review struct {
+	User  string `json:"user"`
+	State string `json:"state"` // approved or changes_requested, the latest verdict of the reviewer
+}
+
+type MergeRequestStatus struct {
+	MergeRequest
+	Merged bool `json:"merged"`
+	// nil while the host is still computing it
+	Mergeable *bool `json:"mergeable"`
+	// Combined state of all checks, empty when the head commit has none
+	ChecksState string   `json:"checks_state"`
+	Checks      []Check  `json:"checks"`
+	Reviews     []Review `json:"reviews"` // latest review of each reviewer
+}
+
+const (
+	StatusPending = "pending"
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusError   = "error"
+)
+
+type CommitStatus struct {
+	State       string // one of the Status constants
+	Context     string
+	Description string
+	TargetURL   string
+}
+
+type Provider interface {
+	ParseRepoURL(repoURL string) (*Repository, error)
+	BranchExists(repo *Repository, branch string) (bool, error)
+	// FindOpenMergeRequest returns nil when the branch has no open merge request
+	FindOpenMergeRequest(repo *Repository, sourceBranch string) (*MergeRequest, error)
+	CreateMergeRequest(repo *Repository, input *MergeRequestInput) (*MergeRequest, error)
+	// UpdateMergeRequest only changes the title and body when they are set
+	UpdateMergeRequest(repo *Repository, number int, input *MergeRequestInput) (*MergeRequest, error)
+	GetMergeRequestStatus(repo *Repository, number int) (*MergeRequestStatus, error)
+	CloseMergeRequest(repo *Repository, number int) error
+	DeleteBranch(repo *Repository, branch string) error
+	Comment(repo *Repository, number int, body string) error
+	SetStatus(repo *Repository, sha string, status *CommitStatus) error
+}
+
+// UpsertMergeRequest updates the open merge request of the source branch, or creates one
+// if there is none. The returned flag is true when a merge request was created.
+func UpsertMergeRequest(provider Provider, repo *Repository, input *MergeRequestInput) (*MergeRequest, bool, error) {
+	existing, err := provider.FindOpenMergeRequest(repo, input.SourceBranch)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if existing != nil {
+		mr, err := provider.UpdateMergeRequest(repo, existing.Number, input)
+		return mr, false, err
+	}
+
+	mr, err := provider.CreateMergeRequest(repo, input)
+	return mr, true, err
+}
+
+// combineChecks reduces individual check states the way hosts do for their merge buttons.
+func combineChecks(checks []Check) string {
+	if len(checks) == 0 {
+		return ""
+	}
+
+	combined := StatusSuccess
+	for _, check := range checks {
+		switch check.State {
+		case StatusFailure:
+			return StatusFailure
+		case StatusPending:
+			combined = StatusPending
+		}
+	}
+	return combined
+}
+
+// NewProvider picks the provider for the host of repoURL. github.com and gitlab.com are
+// recognized out of the box, self-hosted instances are listed in SCM_GITHUB_HOSTS,
+// SCM_GITLAB_HOSTS and SCM_GITEA_HOSTS as comma separated host names.
+func NewProvider(repoURL, token string) (Provider, error) {
+	host, err := repoHost(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case host == "github.com":
+		return NewGitHub(token), nil
+	case hostListed("SCM_GITHUB_HOSTS", host):
+		return NewGitHubEnterprise(fmt.Sprintf("https://%s/api/v3", host), token), nil
+	case host == "gitlab.com" || hostListed("SCM_GITLAB_HOSTS", host):
+		return NewGitLab(fmt.Sprintf("https://%s/api/v4", host), token), nil
+	case host == "gitea.com" || host == "codeberg.org" || hostListed("SCM_GITEA_HOSTS", host):
+		return NewGitea(fmt.Sprintf("https://%s/api/v1", host), token), nil
+	}
+	return nil, fmt.Errorf("no git hosting provider configured for %s", host)
+}
+
+func repoHost(repoURL string) (string, error) {
+	parsed, err := url.Parse(repoURL)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("invalid repo URL format: %s", repoURL)
+	}
+	return strings.ToLower(parsed.Hostname()), nil
+}
+
+func hostListed(envKey, host string) bool {
+	for _, listed := range strings.Split(os.Getenv(envKey), ",") {
+		if strings.EqualFold(strings.TrimSpace(listed), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRepoURL splits https://host/owner/name(.git) into its parts. Nested owners
+// are only accepted when allowNested is set.
+func parseRepoURL(repoURL string, allowNested bool) (*Repository, error) {
+	parsed, err := url.Parse(repoURL)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid repo URL format: %s", repoURL)
+	}
+
+	repoPath := strings.Trim(strings.TrimSuffix(parsed.Path, ".git"), "/")
+	parts := strings.Split(repoPath, "/")
+	if len(parts) < 2 || (!allowNested && len(parts) != 2) {
+		return nil, fmt.Errorf("invalid repo URL format: %s", repoURL)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid repo URL format: %s", repoURL)
+		}
+	}
+
+	return &Repository{
+		Host:  strings.ToLower(parsed.Hostname()),
+		Owner: strings.Join(parts[:len(parts)-1], "/"),
+		Name:  parts[len(parts)-1],
+	}, nil
+}
@@ -0,0 +1,233 @@
+package scm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+type fakeResponse struct {
+	status int
+	// body is encoded as JSON, a func(*http.Request) interface{} is called for each request first
+	body interface{}
+}
+
+type fakeRequest struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   map[string]interface{}
+}
+
+// fakeAPI answers "METHOD /escaped/path" with canned responses and records every request.
+// Unknown routes get a 404, the way hosts answer for missing branches and pull requests.
+type fakeAPI struct {
+	mu        sync.Mutex
+	responses map[string]fakeResponse
+	requests  []fakeRequest
+}
+
+func newFakeAPI(t *testing.T, responses map[string]fakeResponse) (*fakeAPI, string) {
+	t.Helper()
+	fake := &fakeAPI{responses: responses}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&payload)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{
+		method: r.Method,
+		path:   r.URL.EscapedPath(),
+		query:  r.URL.Query(),
+		header: r.Header.Clone(),
+		body:   payload,
+	})
+	response, ok := f.responses[r.Method+" "+r.URL.EscapedPath()]
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"Not Found"}`))
+		return
+	}
+	w.WriteHeader(response.status)
+	body := response.body
+	if respond, ok := body.(func(*http.Request) interface{}); ok {
+		body = respond(r)
+	}
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// request returns the first recorded request for method and path.
+func (f *fakeAPI) request(t *testing.T, method, path string) fakeRequest {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, request := range f.requests {
+		if request.method == method && request.path == path {
+			return request
+		}
+	}
+	t.Fatalf("expected a %s %s request, got %+v", method, path, f.requests)
+	return fakeRequest{}
+}
+
+func TestParseRepoURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		repoURL     string
+		allowNested bool
+		want        *Repository
+	}{
+		{name: "https", repoURL: "https://github.com/acme/infra", want: &Repository{Host: "github.com", Owner: "acme", Name: "infra"}},
+		{name: "git suffix", repoURL: "https://GitHub.com/acme/infra.git", want: &Repository{Host: "github.com", Owner: "acme", Name: "infra"}},
+		{name: "trailing slash", repoURL: "https://gitea.com/acme/infra/", want: &Repository{Host: "gitea.com", Owner: "acme", Name: "infra"}},
+		{name: "port", repoURL: "https://git.example.com:8443/acme/infra.git", want: &Repository{Host: "git.example.com", Owner: "acme", Name: "infra"}},
+		{name: "nested", repoURL: "https://gitlab.com/acme/platform/infra.git", allowNested: true, want: &Repository{Host: "gitlab.com", Owner: "acme/platform", Name: "infra"}},
+		{name: "nested not allowed", repoURL: "https://github.com/acme/platform/infra.git"},
+		{name: "owner only", repoURL: "https://github.com/acme"},
+		{name: "empty segment", repoURL: "https://github.com/acme//infra", allowNested: true},
+		{name: "no host", repoURL: "acme/infra"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRepoURL(tt.repoURL, tt.allowNested)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *got != *tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepositoryKey(t *testing.T) {
+	for _, repoURL := range []string{
+		"https://github.com/acme/infra",
+		"https://github.com/acme/infra.git",
+		"https://GITHUB.COM/acme/infra/",
+	} {
+		key, err := RepositoryKey(repoURL)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", repoURL, err)
+		}
+		if key != "github.com/acme/infra" {
+			t.Errorf("expected github.com/acme/infra for %s, got %s", repoURL, key)
+		}
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	t.Setenv("SCM_GITHUB_HOSTS", "ghe.example.com")
+	t.Setenv("SCM_GITLAB_HOSTS", "gitlab.example.com, other.example.com")
+	t.Setenv("SCM_GITEA_HOSTS", "")
+
+	tests := []struct {
+		repoURL string
+		check   func(Provider) bool
+	}{
+		{"https://github.com/acme/infra.git", func(p Provider) bool { _, ok := p.(*GitHub); return ok }},
+		{"https://ghe.example.com/acme/infra.git", func(p Provider) bool {
+			g, ok := p.(*GitHub)
+			return ok && g.Client.BaseURL == "https://ghe.example.com/api/v3"
+		}},
+		{"https://gitlab.com/acme/infra.git", func(p Provider) bool {
+			g, ok := p.(*GitLab)
+			return ok && g.api.baseURL == "https://gitlab.com/api/v4"
+		}},
+		{"https://other.example.com/acme/infra.git", func(p Provider) bool { _, ok := p.(*GitLab); return ok }},
+		{"https://codeberg.org/acme/infra.git", func(p Provider) bool {
+			g, ok := p.(*Gitea)
+			return ok && g.api.baseURL == "https://codeberg.org/api/v1"
+		}},
+	}
+
+	for _, tt := range tests {
+		provider, err := NewProvider(tt.repoURL, "token")
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", tt.repoURL, err)
+		}
+		if !tt.check(provider) {
+			t.Errorf("unexpected provider %T for %s", provider, tt.repoURL)
+		}
+	}
+
+	if _, err := NewProvider("https://unknown.example.com/acme/infra.git", "token"); err == nil {
+		t.Error("expected an error for an unconfigured host")
+	}
+}
+
+func TestCombineChecks(t *testing.T) {
+	tests := []struct {
+		checks []Check
+		want   string
+	}{
+		{nil, ""},
+		{[]Check{{State: StatusSuccess}}, StatusSuccess},
+		{[]Check{{State: StatusSuccess}, {State: StatusPending}}, StatusPending},
+		{[]Check{{State: StatusPending}, {State: StatusFailure}, {State: StatusSuccess}}, StatusFailure},
+	}
+
+	for _, tt := range tests {
+		if got := combineChecks(tt.checks); got != tt.want {
+			t.Errorf("combineChecks(%+v) = %q, want %q", tt.checks, got, tt.want)
+		}
+	}
+}
+
+func TestUpsertMergeRequest(t *testing.T) {
+	repo := &Repository{Host: "gitlab.com", Owner: "acme", Name: "infra"}
+	input := &MergeRequestInput{Title: "Add bucket", Body: "body", SourceBranch: "prism/add-bucket", TargetBranch: "main"}
+
+	t.Run("creates", func(t *testing.T) {
+		fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+			"GET /projects/acme%2Finfra/merge_requests":  {status: http.StatusOK, body: []interface{}{}},
+			"POST /projects/acme%2Finfra/merge_requests": {status: http.StatusCreated, body: map[string]interface{}{"iid": 7, "state": "opened"}},
+		})
+
+		mr, created, err := UpsertMergeRequest(NewGitLab(baseURL, "token"), repo, input)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !created || mr.Number != 7 {
+			t.Errorf("expected merge request !7 to be created, got %+v (created %v)", mr, created)
+		}
+		fake.request(t, "POST", "/projects/acme%2Finfra/merge_requests")
+	})
+
+	t.Run("updates", func(t *testing.T) {
+		fake, baseURL := newFakeAPI(t, map[string]fakeResponse{
+			"GET /projects/acme%2Finfra/merge_requests":   {status: http.StatusOK, body: []interface{}{map[string]interface{}{"iid": 3, "state": "opened"}}},
+			"PUT /projects/acme%2Finfra/merge_requests/3": {status: http.StatusOK, body: map[string]interface{}{"iid": 3, "state": "opened"}},
+		})
+
+		mr, created, err := UpsertMergeRequest(NewGitLab(baseURL, "token"), repo, input)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if created || mr.Number != 3 {
+			t.Errorf("expected merge request !3 to be updated, got %+v (created %v)", mr, created)
+		}
+		fake.request(t, "PUT", "/projects/acme%2Finfra/merge_requests/3")
+	})
+}
@@ -0,0 +1,63 @@
+package secrets
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	content := strings.Join([]string{
+		"# comment",
+		"",
+		"PLAIN=value",
+		"export EXPORTED=exported",
+		"  SPACED  =  spaced value  ",
+		"COMMENTED=value # comment",
+		"HASH=value#not-a-comment",
+		`DOUBLE="line\nbreak\ttab \"quoted\" back\\slash # kept"`,
+		`SINGLE='raw\n # kept'`,
+		"EMPTY=",
+		"EQUALS=a=b",
+		"PLAIN=overridden",
+	}, "\n")
+
+	values, err := ParseDotenv(content)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"PLAIN":     "overridden",
+		"EXPORTED":  "exported",
+		"SPACED":    "spaced value",
+		"COMMENTED": "value",
+		"HASH":      "value#not-a-comment",
+		"DOUBLE":    "line\nbreak\ttab \"quoted\" back\\slash # kept",
+		"SINGLE":    `raw\n # kept`,
+		"EMPTY":     "",
+		"EQUALS":    "a=b",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("expected %q, got %q", want, values)
+	}
+}
+
+func TestParseDotenvRejectsInvalidLines(t *testing.T) {
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{content: "KEY=value\nnot a pair", err: "line 2: expected KEY=VALUE"},
+		{content: "1KEY=value", err: `line 1: "1KEY" is not a valid key`},
+		{content: "MY-KEY=value", err: `line 1: "MY-KEY" is not a valid key`},
+		{content: `KEY="unterminated`, err: "line 1: unterminated double quote"},
+		{content: `KEY="escaped end\"`, err: "line 1: unterminated double quote"},
+		{content: "KEY='unterminated", err: "line 1: unterminated single quote"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseDotenv(test.content); err == nil || err.Error() != test.err {
+			t.Errorf("%q: expected %q, got %v", test.content, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// encryptedFile is the content of a secrets file written with a key.
+type encryptedFile struct {
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// FileProvider keeps secrets in a JSON file. With a key the file is encrypted with
+// AES-256-GCM, without one it holds the secrets in plain text.
+type FileProvider struct {
+	*MemoryProvider
+	path string
+	aead cipher.AEAD
+}
+
+// NewFileProvider loads the secrets file at path, a missing file starts out empty. key is the
+// base64 encoding of 32 random bytes, or empty to keep the file unencrypted.
+func NewFileProvider(path, key string) (*FileProvider, error) {
+	provider := &FileProvider{MemoryProvider: NewMemoryProvider(), path: path}
+	if key != "" {
+		rawKey, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("secrets file key is not base64: %w", err)
+		}
+		if len(rawKey) != 32 {
+			return nil, fmt.Errorf("secrets file key must be 32 bytes, got %d", len(rawKey))
+		}
+		block, err := aes.NewCipher(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		if provider.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+	}
+
+	if err := provider.load(); err != nil {
+		return nil, err
+	}
+	provider.persist = provider.write
+	return provider, nil
+}
+
+func (p *FileProvider) load() error {
+	content, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read secrets file %s: %w", p.path, err)
+	}
+
+	if p.aead != nil {
+		var file encryptedFile
+		if err := json.Unmarshal(content, &file); err != nil {
+			return fmt.Errorf("failed to parse secrets file %s: %w", p.path, err)
+		}
+		nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+		if err != nil {
+			return fmt.Errorf("failed to parse secrets file %s: %w", p.path, err)
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(file.Ciphertext)
+		if err != nil {
+			return fmt.Errorf("failed to parse secrets file %s: %w", p.path, err)
+		}
+		if len(nonce) != p.aead.NonceSize() {
+			return fmt.Errorf("secrets file %s has an invalid nonce", p.path)
+		}
+		if content, err = p.aead.Open(nil, nonce, ciphertext, nil); err != nil {
+			return fmt.Errorf("failed to decrypt secrets file %s, is the key right?", p.path)
+		}
+	}
+
+	if err := json.Unmarshal(content, &p.secrets); err != nil {
+		return fmt.Errorf("failed to parse secrets file %s: %w", p.path, err)
+	}
+	if p.secrets == nil {
+		p.secrets = tree{}
+	}
+	return nil
+}
+
+// write replaces the file through a temporary file, so a failed write never leaves half a file.
+func (p *FileProvider) write(secrets tree) error {
+	content, err := json.Marshal(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secrets: %w", err)
+	}
+
+	if p.aead != nil {
+		nonce := make([]byte, p.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return fmt.Errorf("failed to create nonce: %w", err)
+		}
+		content, err = json.Marshal(&encryptedFile{
+			Nonce:      base64.StdEncoding.EncodeToString(nonce),
+			Ciphertext: base64.StdEncoding.EncodeToString(p.aead.Seal(nil, nonce, content, nil)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal secrets: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", p.path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), ".secrets-*")
+	if err != nil {
+		return fmt.Errorf("failed to write secrets file %s: %w", p.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write secrets file %s: %w", p.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write secrets file %s: %w", p.path, err)
+	}
+	if err := os.Rename(tmp.Name(), p.path); err != nil {
+		return fmt.Errorf("failed to write secrets file %s: %w", p.path, err)
+	}
+	return nil
+}
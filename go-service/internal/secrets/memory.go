@@ -0,0 +1,169 @@
+package secrets
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// tree holds secrets by project, environment, folder and key.
+type tree map[string]map[string]map[string]map[string]string
+
+func (t tree) folder(options *Options, create bool) map[string]string {
+	environments, ok := t[options.ProjectID]
+	if !ok {
+		if !create {
+			return nil
+		}
+		environments = map[string]map[string]map[string]string{}
+		t[options.ProjectID] = environments
+	}
+	folders, ok := environments[options.Environment]
+	if !ok {
+		if !create {
+			return nil
+		}
+		folders = map[string]map[string]string{}
+		environments[options.Environment] = folders
+	}
+	folder, ok := folders[options.Path()]
+	if !ok && create {
+		folder = map[string]string{}
+		folders[options.Path()] = folder
+	}
+	return folder
+}
+
+// prune removes the folder of options once it is empty, and its environment and project once
+// they are, so that only folders and environments holding secrets are listed.
+func (t tree) prune(options *Options) {
+	folders := t[options.ProjectID][options.Environment]
+	if len(folders[options.Path()]) == 0 {
+		delete(folders, options.Path())
+	}
+	if len(folders) == 0 {
+		delete(t[options.ProjectID], options.Environment)
+	}
+	if len(t[options.ProjectID]) == 0 {
+		delete(t, options.ProjectID)
+	}
+}
+
+// MemoryProvider keeps secrets in memory only, for local development and tests.
+type MemoryProvider struct {
+	mu      sync.RWMutex
+	secrets tree
+	// persist is called with the lock held after every change, the file provider writes the tree with it
+	persist func(tree) error
+}
+
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{secrets: tree{}}
+}
+
+func (p *MemoryProvider) List(ctx context.Context, options *Options) (map[string]string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	secrets := map[string]string{}
+	for key, value := range p.secrets.folder(options, false) {
+		secrets[key] = value
+	}
+	return secrets, nil
+}
+
+func (p *MemoryProvider) Get(ctx context.Context, options *Options, key string) (string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	value, ok := p.secrets.folder(options, false)[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (p *MemoryProvider) Set(ctx context.Context, options *Options, key, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	folder := p.secrets.folder(options, true)
+	previous, existed := folder[key]
+	folder[key] = value
+	if err := p.save(); err != nil {
+		if existed {
+			folder[key] = previous
+		} else {
+			delete(folder, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (p *MemoryProvider) Delete(ctx context.Context, options *Options, key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	folder := p.secrets.folder(options, false)
+	previous, ok := folder[key]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(folder, key)
+	p.secrets.prune(options)
+	if err := p.save(); err != nil {
+		p.secrets.folder(options, true)[key] = previous
+		return err
+	}
+	return nil
+}
+
+// ListFolders returns the folders below the secret path that hold secrets, folders exist as
+// long as something is stored in them.
+func (p *MemoryProvider) ListFolders(ctx context.Context, options *Options) ([]string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	base := options.Path()
+	prefix := strings.TrimSuffix(base, "/") + "/"
+	names := map[string]bool{}
+	for folderPath := range p.secrets[options.ProjectID][options.Environment] {
+		if folderPath == base || !strings.HasPrefix(folderPath, prefix) {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(folderPath, prefix), "/", 2)[0]
+		if name != "" {
+			names[name] = true
+		}
+	}
+
+	folders := []string{}
+	for name := range names {
+		folders = append(folders, name)
+	}
+	sort.Strings(folders)
+	return folders, nil
+}
+
+// ListEnvironments returns the environments of a project that hold secrets, there is no
+// separate list of environments to keep.
+func (p *MemoryProvider) ListEnvironments(ctx context.Context, projectID string) ([]Environment, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	environments := []Environment{}
+	for slug := range p.secrets[projectID] {
+		environments = append(environments, Environment{Name: slug, Slug: slug})
+	}
+	sort.Slice(environments, func(i, j int) bool { return environments[i].Slug < environments[j].Slug })
+	return environments, nil
+}
+
+func (p *MemoryProvider) save() error {
+	if p.persist == nil {
+		return nil
+	}
+	return p.persist(p.secrets)
+}
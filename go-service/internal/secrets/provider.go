@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"path"
+	"regexp"
+)
+
+// Names accepted for SECRETS_BACKEND
+const (
+	Infisical = "infisical"
+	File      = "file"
+	Memory    = "memory"
+)
+
+// ErrNotFound is returned for secrets that do not exist.
+var ErrNotFound = errors.New("secret not found")
+
+// MaskedValue replaces secret values in responses, it has the same length for every value.
+const MaskedValue = "********"
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidKey reports whether key can name a secret, which is also a valid environment variable name.
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
+
+// Options address the secrets of one folder of a project environment.
+type Options struct {
+	Environment string
+	ProjectID   string
+	// SecretPath defaults to the root folder /
+	SecretPath string
+}
+
+// Path returns the cleaned secret path, / when none is set.
+func (o *Options) Path() string {
+	return path.Clean("/" + o.SecretPath)
+}
+
+type Environment struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// Provider stores the secrets that plans and applies run with.
+type Provider interface {
+	// List returns the secrets of a folder by key
+	List(ctx context.Context, options *Options) (map[string]string, error)
+	// Get returns ErrNotFound for missing secrets
+	Get(ctx context.Context, options *Options, key string) (string, error)
+	// Set creates the secret or replaces its value
+	Set(ctx context.Context, options *Options, key, value string) error
+	// Delete returns ErrNotFound for missing secrets
+	Delete(ctx context.Context, options *Options, key string) error
+	// ListFolders returns the names of the folders directly inside the secret path
+	ListFolders(ctx context.Context, options *Options) ([]string, error)
+	ListEnvironments(ctx context.Context, projectID string) ([]Environment, error)
+}
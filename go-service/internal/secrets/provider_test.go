@@ -0,0 +1,257 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// testProviders returns a new instance of every provider that runs without an external service.
+func testProviders(t *testing.T) map[string]Provider {
+	t.Helper()
+	plain, err := NewFileProvider(filepath.Join(t.TempDir(), "secrets.json"), "")
+	if err != nil {
+		t.Fatalf("failed to create file provider: %v", err)
+	}
+	encrypted, err := NewFileProvider(filepath.Join(t.TempDir(), "secrets.json"), testKey(t))
+	if err != nil {
+		t.Fatalf("failed to create encrypted file provider: %v", err)
+	}
+	return map[string]Provider{
+		"memory":         NewMemoryProvider(),
+		"file":           plain,
+		"encrypted file": encrypted,
+	}
+}
+
+func TestProviders(t *testing.T) {
+	ctx := context.Background()
+	dev := &Options{ProjectID: "infra", Environment: "dev"}
+
+	for name, provider := range testProviders(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := provider.Get(ctx, dev, "DB_PASSWORD"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound before the secret is set, got %v", err)
+			}
+
+			if err := provider.Set(ctx, dev, "DB_PASSWORD", "hunter2"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := provider.Set(ctx, dev, "DB_PASSWORD", "correct horse"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value, err := provider.Get(ctx, dev, "DB_PASSWORD"); err != nil || value != "correct horse" {
+				t.Errorf("expected the value set last, got %q %v", value, err)
+			}
+
+			if err := provider.Delete(ctx, dev, "MISSING"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected deleting a missing key to return ErrNotFound, got %v", err)
+			}
+			if err := provider.Delete(ctx, &Options{ProjectID: "other", Environment: "dev"}, "DB_PASSWORD"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected deleting from another project to return ErrNotFound, got %v", err)
+			}
+			if err := provider.Delete(ctx, dev, "DB_PASSWORD"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := provider.Get(ctx, dev, "DB_PASSWORD"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound after the secret was deleted, got %v", err)
+			}
+			if err := provider.Delete(ctx, dev, "DB_PASSWORD"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected deleting twice to return ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProvidersKeepEnvironmentsApart(t *testing.T) {
+	ctx := context.Background()
+	secrets := []struct {
+		options *Options
+		key     string
+		value   string
+	}{
+		{options: &Options{ProjectID: "infra", Environment: "dev"}, key: "TOKEN", value: "dev-token"},
+		{options: &Options{ProjectID: "infra", Environment: "dev"}, key: "REGION", value: "eu-west-1"},
+		{options: &Options{ProjectID: "infra", Environment: "prod"}, key: "TOKEN", value: "prod-token"},
+		{options: &Options{ProjectID: "infra", Environment: "dev", SecretPath: "/apps/web"}, key: "TOKEN", value: "web-token"},
+		{options: &Options{ProjectID: "infra", Environment: "dev", SecretPath: "apps/api/"}, key: "TOKEN", value: "api-token"},
+		{options: &Options{ProjectID: "other", Environment: "dev"}, key: "TOKEN", value: "other-token"},
+	}
+
+	for name, provider := range testProviders(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, secret := range secrets {
+				if err := provider.Set(ctx, secret.options, secret.key, secret.value); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			lists := []struct {
+				options *Options
+				want    map[string]string
+			}{
+				{options: &Options{ProjectID: "infra", Environment: "dev"}, want: map[string]string{"TOKEN": "dev-token", "REGION": "eu-west-1"}},
+				{options: &Options{ProjectID: "infra", Environment: "dev", SecretPath: "/"}, want: map[string]string{"TOKEN": "dev-token", "REGION": "eu-west-1"}},
+				{options: &Options{ProjectID: "infra", Environment: "prod"}, want: map[string]string{"TOKEN": "prod-token"}},
+				{options: &Options{ProjectID: "infra", Environment: "dev", SecretPath: "/apps/web/"}, want: map[string]string{"TOKEN": "web-token"}},
+				{options: &Options{ProjectID: "infra", Environment: "staging"}, want: map[string]string{}},
+				{options: &Options{ProjectID: "other", Environment: "prod"}, want: map[string]string{}},
+			}
+			for _, list := range lists {
+				got, err := provider.List(ctx, list.options)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(got, list.want) {
+					t.Errorf("%+v: expected %v, got %v", list.options, list.want, got)
+				}
+			}
+
+			folders, err := provider.ListFolders(ctx, &Options{ProjectID: "infra", Environment: "dev", SecretPath: "/apps"})
+			if err != nil || !reflect.DeepEqual(folders, []string{"api", "web"}) {
+				t.Errorf("expected the folders below /apps, got %v %v", folders, err)
+			}
+			environments, err := provider.ListEnvironments(ctx, "infra")
+			if err != nil || len(environments) != 2 || environments[0].Slug != "dev" || environments[1].Slug != "prod" {
+				t.Errorf("expected the environments holding secrets, got %v %v", environments, err)
+			}
+		})
+	}
+}
+
+func TestProvidersForgetEmptiedFolders(t *testing.T) {
+	ctx := context.Background()
+	web := &Options{ProjectID: "infra", Environment: "staging", SecretPath: "/apps/web"}
+
+	for name, provider := range testProviders(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := provider.Set(ctx, web, "TOKEN", "web-token"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := provider.Delete(ctx, web, "TOKEN"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			folders, err := provider.ListFolders(ctx, &Options{ProjectID: "infra", Environment: "staging", SecretPath: "/apps"})
+			if err != nil || len(folders) != 0 {
+				t.Errorf("expected no folders once their last secret was deleted, got %v %v", folders, err)
+			}
+			environments, err := provider.ListEnvironments(ctx, "infra")
+			if err != nil || len(environments) != 0 {
+				t.Errorf("expected no environments once their last secret was deleted, got %v %v", environments, err)
+			}
+		})
+	}
+}
+
+func TestFileProviderRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	options := &Options{ProjectID: "infra", Environment: "dev", SecretPath: "/apps/web"}
+
+	for name, key := range map[string]string{"plain": "", "encrypted": testKey(t)} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "nested", "secrets.json")
+			provider, err := NewFileProvider(path, key)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := provider.Set(ctx, options, "DB_PASSWORD", "hunter2"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("expected the secrets to be written, got %v", err)
+			}
+			if encrypted := key != ""; strings.Contains(string(content), "hunter2") == encrypted {
+				t.Errorf("expected the value in the file only without a key, got %s", content)
+			}
+
+			reopened, err := NewFileProvider(path, key)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value, err := reopened.Get(ctx, options, "DB_PASSWORD"); err != nil || value != "hunter2" {
+				t.Errorf("expected the secret to survive reopening the file, got %q %v", value, err)
+			}
+		})
+	}
+}
+
+func TestFileProviderRejectsWrongKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	provider, err := NewFileProvider(path, testKey(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := provider.Set(context.Background(), &Options{ProjectID: "infra", Environment: "dev"}, "TOKEN", "value"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := NewFileProvider(path, testKey(t)); err == nil || !strings.Contains(err.Error(), "is the key right?") {
+		t.Errorf("expected another key to fail decrypting the file, got %v", err)
+	}
+	if _, err := NewFileProvider(path, ""); err == nil {
+		t.Error("expected the encrypted file to be rejected without a key")
+	}
+
+	invalidKeys := map[string]string{
+		"not base64": "not base64!",
+		"too short":  base64.StdEncoding.EncodeToString([]byte("short")),
+	}
+	for name, key := range invalidKeys {
+		if _, err := NewFileProvider(filepath.Join(t.TempDir(), "secrets.json"), key); err == nil {
+			t.Errorf("%s: expected the key to be rejected", name)
+		}
+	}
+}
+
+func TestFileProviderStartsEmptyWithoutAFile(t *testing.T) {
+	provider, err := NewFileProvider(filepath.Join(t.TempDir(), "missing", "secrets.json"), testKey(t))
+	if err != nil {
+		t.Fatalf("expected a missing file to start out empty, got %v", err)
+	}
+	secrets, err := provider.List(context.Background(), &Options{ProjectID: "infra", Environment: "dev"})
+	if err != nil || len(secrets) != 0 {
+		t.Errorf("expected no secrets, got %v %v", secrets, err)
+	}
+}
+
+func TestFileProviderKeepsSecretsWhenWritingFails(t *testing.T) {
+	ctx := context.Background()
+	options := &Options{ProjectID: "infra", Environment: "dev"}
+	provider, err := NewFileProvider(filepath.Join(t.TempDir(), "secrets.json"), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := provider.Set(ctx, options, "TOKEN", "first"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A directory in place of the file makes every write fail
+	provider.path = t.TempDir()
+	if err := provider.Set(ctx, options, "TOKEN", "second"); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if err := provider.Delete(ctx, options, "TOKEN"); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if value, err := provider.Get(ctx, options, "TOKEN"); err != nil || value != "first" {
+		t.Errorf("expected the failed changes to be rolled back, got %q %v", value, err)
+	}
+}
@@ -0,0 +1,28 @@
+package store
+
+import "fmt"
+
+// RecordDelivery stores a webhook delivery ID and reports whether it had not been seen before.
+func (s *Store) RecordDelivery(deliveryID, event string) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO prism_webhook_delivery (delivery_id, event)
+		VALUES ($1, $2)
+		ON CONFLICT (delivery_id) DO NOTHING`, deliveryID, event)
+	if err != nil {
+		return false, fmt.Errorf("error recording delivery %s: %w", deliveryID, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error recording delivery %s: %w", deliveryID, err)
+	}
+	return rows == 1, nil
+}
+
+// ForgetDelivery removes a delivery that could not be processed so a redelivery is not treated as a duplicate.
+func (s *Store) ForgetDelivery(deliveryID string) error {
+	if _, err := s.db.Exec(`DELETE FROM prism_webhook_delivery WHERE delivery_id = $1`, deliveryID); err != nil {
+		return fmt.Errorf("error forgetting delivery %s: %w", deliveryID, err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DriftReport is the result of a scheduled refresh-only plan of an imported repository.
+type DriftReport struct {
+	ID           int64  `json:"id"`
+	RepositoryID int    `json:"repository_id"`
+	Repository   string `json:"repository"`
+	CommitSHA    string `json:"commit_sha"`
+	Environment  string `json:"environment,omitempty"`
+	Root         string `json:"root"`
+	Drifted      bool   `json:"drifted"`
+	// Resources are the drifted resources with their changed attributes
+	Resources json.RawMessage `json:"resources"`
+	PlanText  string          `json:"plan_text,omitempty"`
+	// Error is set when the check itself failed, Drifted is false then
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+const driftReportColumns = `id, repository_id, repository, commit_sha, environment, root, drifted, resources, plan_text, error, checked_at`
+
+func (s *Store) SaveDriftReport(report *DriftReport) error {
+	resources := []byte(report.Resources)
+	if len(resources) == 0 {
+		resources = []byte("[]")
+	}
+	err := s.db.QueryRow(`
+		INSERT INTO prism_drift_report (repository_id, repository, commit_sha, environment, root, drifted, resources, plan_text, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, checked_at`,
+		report.RepositoryID, report.Repository, report.CommitSHA, report.Environment, report.Root, report.Drifted,
+		resources, report.PlanText, report.Error).Scan(&report.ID, &report.CheckedAt)
+	if err != nil {
+		return fmt.Errorf("error saving drift report of %s: %w", report.Repository, err)
+	}
+	return nil
+}
+
+// GetDriftReport returns a report by id, nil if there is none.
+func (s *Store) GetDriftReport(id int64) (*DriftReport, error) {
+	reports, err := s.queryDriftReports(`SELECT `+driftReportColumns+` FROM prism_drift_report WHERE id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting drift report %d: %w", id, err)
+	}
+	if len(reports) == 0 {
+		return nil, nil
+	}
+	return &reports[0], nil
+}
+
+// LatestDriftReports returns the last report of every repository that was checked.
+func (s *Store) LatestDriftReports() ([]DriftReport, error) {
+	reports, err := s.queryDriftReports(`
+		SELECT DISTINCT ON (repository) ` + driftReportColumns + `
+		FROM prism_drift_report
+		ORDER BY repository, id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing latest drift reports: %w", err)
+	}
+	return reports, nil
+}
+
+// ListDriftReports returns the reports of a repository, newest first.
+func (s *Store) ListDriftReports(repository string, limit int) ([]DriftReport, error) {
+	reports, err := s.queryDriftReports(`
+		SELECT `+driftReportColumns+`
+		FROM prism_drift_report
+		WHERE repository = $1
+		ORDER BY id DESC
+		LIMIT $2`, repository, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing drift reports of %s: %w", repository, err)
+	}
+	return reports, nil
+}
+
+func (s *Store) queryDriftReports(query string, args ...interface{}) ([]DriftReport, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []DriftReport{}
+	for rows.Next() {
+		var report DriftReport
+		var resources []byte
+		if err := rows.Scan(&report.ID, &report.RepositoryID, &report.Repository, &report.CommitSHA, &report.Environment, &report.Root,
+			&report.Drifted, &resources, &report.PlanText, &report.Error, &report.CheckedAt); err != nil {
+			return nil, err
+		}
+		report.Resources = resources
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Lock reserves a repository's state for one pull request between its plan and apply.
+type Lock struct {
+	RepositoryID int       `json:"repository_id"`
+	PullNumber   int       `json:"pull_number"`
+	LockedBy     string    `json:"locked_by"`
+	PlannedSHA   string    `json:"planned_sha"` // empty until a plan succeeds
+	Environment  string    `json:"environment"` // environment of the plan, empty without environments
+	PlanDigest   string    `json:"plan_digest"` // digest of the plan of PlannedSHA, the one an apply must match
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// StateOperationPullNumber holds the lock while a state operation changes the repository's state.
+// Unlike a pull request, a state operation never re-acquires a lock that is already held.
+const StateOperationPullNumber = 0
+
+func (s *Store) GetLock(repositoryID int) (*Lock, error) {
+	var lock Lock
+	err := s.db.QueryRow(`
+		SELECT repository_id, pull_number, locked_by, planned_sha, environment, plan_digest, created_at
+		FROM prism_lock
+		WHERE repository_id = $1`, repositoryID).Scan(&lock.RepositoryID, &lock.PullNumber, &lock.LockedBy, &lock.PlannedSHA, &lock.Environment, &lock.PlanDigest, &lock.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting lock for repository %d: %w", repositoryID, err)
+	}
+	return &lock, nil
+}
+
+// AcquireLock locks the repository for the pull request, or returns the lock held by another one.
+// Re-acquiring a lock the pull request already holds resets its planned SHA, environment and plan digest.
+func (s *Store) AcquireLock(repositoryID, pullNumber int, lockedBy string) (*Lock, bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO prism_lock (repository_id, pull_number, locked_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (repository_id) DO UPDATE
+		SET locked_by = EXCLUDED.locked_by, planned_sha = '', environment = '', plan_digest = ''
+		WHERE prism_lock.pull_number = EXCLUDED.pull_number AND EXCLUDED.pull_number <> 0`, repositoryID, pullNumber, lockedBy)
+	if err != nil {
+		return nil, false, fmt.Errorf("error acquiring lock for repository %d: %w", repositoryID, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("error acquiring lock for repository %d: %w", repositoryID, err)
+	}
+
+	lock, err := s.GetLock(repositoryID)
+	if err != nil {
+		return nil, false, err
+	}
+	return lock, rows == 1, nil
+}
+
+// SetLockPlannedSHA records the plan of the pull request that was posted for review.
+func (s *Store) SetLockPlannedSHA(repositoryID, pullNumber int, sha, environment, planDigest string) error {
+	if _, err := s.db.Exec(`
+		UPDATE prism_lock SET planned_sha = $3, environment = $4, plan_digest = $5
+		WHERE repository_id = $1 AND pull_number = $2`, repositoryID, pullNumber, sha, environment, planDigest); err != nil {
+		return fmt.Errorf("error updating lock for repository %d: %w", repositoryID, err)
+	}
+	return nil
+}
+
+// ReleaseLock removes the pull request's lock and reports whether it held one.
+func (s *Store) ReleaseLock(repositoryID, pullNumber int) (bool, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM prism_lock
+		WHERE repository_id = $1 AND pull_number = $2`, repositoryID, pullNumber)
+	if err != nil {
+		return false, fmt.Errorf("error releasing lock for repository %d: %w", repositoryID, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error releasing lock for repository %d: %w", repositoryID, err)
+	}
+	return rows == 1, nil
+}
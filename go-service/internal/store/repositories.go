@@ -0,0 +1,129 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type ImportedRepository struct {
+	ID          int
+	UserID      string
+	RepoID      int64
+	Owner       string
+	Name        string
+	GitHubToken string // access token of the user who imported the repository, unless replaced by an installation token
+}
+
+func (r *ImportedRepository) CloneURL() string {
+	return fmt.Sprintf("https://github.com/%s/%s.git", r.Owner, r.Name)
+}
+
+// FindImportedRepository looks up a repository by its GitHub ID, returning nil if it was never imported.
+func (s *Store) FindImportedRepository(repoID int64) (*ImportedRepository, error) {
+	query := fmt.Sprintf(`
+		SELECT r.id, r."userId", r."repoId", r.owner, r.name, COALESCE(a.access_token, '')
+		FROM %s r
+		LEFT JOIN %s a ON a."userId" = r."userId" AND a.provider = 'github'
+		WHERE r."repoId" = $1
+		LIMIT 1`, importedRepositoryTable, accountTable)
+
+	var repo ImportedRepository
+	err := s.db.QueryRow(query, repoID).Scan(&repo.ID, &repo.UserID, &repo.RepoID, &repo.Owner, &repo.Name, &repo.GitHubToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding imported repository %d: %w", repoID, err)
+	}
+	return &repo, nil
+}
+
+// GetImportedRepository looks up a repository by its id in the imported repositories, returning nil if there is none.
+func (s *Store) GetImportedRepository(id int) (*ImportedRepository, error) {
+	query := fmt.Sprintf(`
+		SELECT r.id, r."userId", r."repoId", r.owner, r.name, COALESCE(a.access_token, '')
+		FROM %s r
+		LEFT JOIN %s a ON a."userId" = r."userId" AND a.provider = 'github'
+		WHERE r.id = $1
+		LIMIT 1`, importedRepositoryTable, accountTable)
+
+	var repo ImportedRepository
+	err := s.db.QueryRow(query, id).Scan(&repo.ID, &repo.UserID, &repo.RepoID, &repo.Owner, &repo.Name, &repo.GitHubToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting imported repository %d: %w", id, err)
+	}
+	return &repo, nil
+}
+
+// FindImportedRepositoryByName looks up a repository by its GitHub owner and name, ignoring case as
+// GitHub does, returning nil if it was never imported.
+func (s *Store) FindImportedRepositoryByName(owner, name string) (*ImportedRepository, error) {
+	query := fmt.Sprintf(`
+		SELECT r.id, r."userId", r."repoId", r.owner, r.name, COALESCE(a.access_token, '')
+		FROM %s r
+		LEFT JOIN %s a ON a."userId" = r."userId" AND a.provider = 'github'
+		WHERE lower(r.owner) = lower($1) AND lower(r.name) = lower($2)
+		ORDER BY r.id
+		LIMIT 1`, importedRepositoryTable, accountTable)
+
+	var repo ImportedRepository
+	err := s.db.QueryRow(query, owner, name).Scan(&repo.ID, &repo.UserID, &repo.RepoID, &repo.Owner, &repo.Name, &repo.GitHubToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding imported repository %s/%s: %w", owner, name, err)
+	}
+	return &repo, nil
+}
+
+// ListImportedRepositories returns every imported repository with the token of the user who imported it.
+func (s *Store) ListImportedRepositories() ([]ImportedRepository, error) {
+	query := fmt.Sprintf(`
+		SELECT DISTINCT ON (r.id) r.id, r."userId", r."repoId", r.owner, r.name, COALESCE(a.access_token, '')
+		FROM %s r
+		LEFT JOIN %s a ON a."userId" = r."userId" AND a.provider = 'github'
+		ORDER BY r.id`, importedRepositoryTable, accountTable)
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error listing imported repositories: %w", err)
+	}
+	defer rows.Close()
+
+	repositories := []ImportedRepository{}
+	for rows.Next() {
+		var repo ImportedRepository
+		if err := rows.Scan(&repo.ID, &repo.UserID, &repo.RepoID, &repo.Owner, &repo.Name, &repo.GitHubToken); err != nil {
+			return nil, fmt.Errorf("error listing imported repositories: %w", err)
+		}
+		repositories = append(repositories, repo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing imported repositories: %w", err)
+	}
+	return repositories, nil
+}
+
+// SaveTerraformPlan records the plan of a commit so the dashboard can show it.
+func (s *Store) SaveTerraformPlan(repositoryID int, commitHash, name string, plan map[string]interface{}) error {
+	planJSON, err := json.Marshal(plan)
+	if err != nil {
+		return fmt.Errorf("error marshalling plan: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s ("repositoryId", "commitHash", name, plan)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("repositoryId", "commitHash") DO UPDATE SET name = EXCLUDED.name, plan = EXCLUDED.plan`, terraformPlanTable)
+
+	if _, err := s.db.Exec(query, repositoryID, commitHash, name, planJSON); err != nil {
+		return fmt.Errorf("error saving plan for %s: %w", commitHash, err)
+	}
+	return nil
+}
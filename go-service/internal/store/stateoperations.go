@@ -0,0 +1,68 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// StateOperation is the audit record of a change made to a state outside of a plan and apply.
+type StateOperation struct {
+	ID          int64  `json:"id"`
+	Repository  string `json:"repository"`
+	Environment string `json:"environment,omitempty"`
+	Root        string `json:"root"`
+	// Workspace is the conversation whose state was changed, empty for the repository's state
+	Workspace string          `json:"workspace,omitempty"`
+	Operation string          `json:"operation"`
+	Arguments json.RawMessage `json:"arguments"`
+	Actor     string          `json:"actor,omitempty"`
+	// SnapshotObject holds the state from before the operation, in the bucket of the state
+	SnapshotObject string `json:"snapshot_object"`
+	// Error is empty when the operation succeeded
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *Store) RecordStateOperation(operation *StateOperation) error {
+	err := s.db.QueryRow(`
+		INSERT INTO prism_state_operation (repository, environment, root, workspace, operation, arguments, actor, snapshot_object, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at`,
+		operation.Repository, operation.Environment, operation.Root, operation.Workspace, operation.Operation,
+		[]byte(operation.Arguments), operation.Actor, operation.SnapshotObject, operation.Error).Scan(&operation.ID, &operation.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error recording %s operation on %s: %w", operation.Operation, operation.Repository, err)
+	}
+	return nil
+}
+
+// ListStateOperations returns the latest state operations of a repository, newest first.
+func (s *Store) ListStateOperations(repository string, limit int) ([]StateOperation, error) {
+	rows, err := s.db.Query(`
+		SELECT id, repository, environment, root, workspace, operation, arguments, actor, snapshot_object, error, created_at
+		FROM prism_state_operation
+		WHERE repository = $1
+		ORDER BY id DESC
+		LIMIT $2`, repository, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing state operations of %s: %w", repository, err)
+	}
+	defer rows.Close()
+
+	operations := []StateOperation{}
+	for rows.Next() {
+		var operation StateOperation
+		var arguments []byte
+		if err := rows.Scan(&operation.ID, &operation.Repository, &operation.Environment, &operation.Root, &operation.Workspace,
+			&operation.Operation, &arguments, &operation.Actor, &operation.SnapshotObject, &operation.Error, &operation.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error listing state operations of %s: %w", repository, err)
+		}
+		operation.Arguments = arguments
+		operations = append(operations, operation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing state operations of %s: %w", repository, err)
+	}
+	return operations, nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Tables shared with the Next.js app are prefixed by its drizzle schema
+const (
+	importedRepositoryTable = `"prism-nextapp_imported_repository"`
+	accountTable            = `"prism-nextapp_account"`
+	terraformPlanTable      = `"prism-nextapp_terraform_plan"`
+)
+
+// migrations create the tables owned by go-service, the ones above are managed by drizzle
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS prism_webhook_delivery (
+		delivery_id TEXT PRIMARY KEY,
+		event TEXT NOT NULL,
+		received_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS prism_lock (
+		repository_id INTEGER PRIMARY KEY,
+		pull_number INTEGER NOT NULL,
+		locked_by TEXT NOT NULL,
+		planned_sha TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS prism_variable (
+		repository TEXT NOT NULL,
+		environment TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value JSONB NOT NULL,
+		sensitive BOOLEAN NOT NULL DEFAULT false,
+		description TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (repository, environment, key)
+	)`,
+	// The environment a pull request planned against is the one its apply must use
+	`ALTER TABLE prism_lock ADD COLUMN IF NOT EXISTS environment TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS prism_workspace (
+		repository TEXT NOT NULL,
+		conversation_id TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		last_planned_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (repository, conversation_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS prism_state_operation (
+		id SERIAL PRIMARY KEY,
+		repository TEXT NOT NULL,
+		environment TEXT NOT NULL DEFAULT '',
+		root TEXT NOT NULL,
+		workspace TEXT NOT NULL DEFAULT '',
+		operation TEXT NOT NULL,
+		arguments JSONB NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		snapshot_object TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS prism_drift_report (
+		id SERIAL PRIMARY KEY,
+		repository_id INTEGER NOT NULL,
+		repository TEXT NOT NULL,
+		commit_sha TEXT NOT NULL DEFAULT '',
+		environment TEXT NOT NULL DEFAULT '',
+		root TEXT NOT NULL DEFAULT '',
+		drifted BOOLEAN NOT NULL,
+		resources JSONB NOT NULL,
+		plan_text TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		checked_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS prism_drift_report_repository_idx ON prism_drift_report (repository, id)`,
+	// An apply only goes ahead when its plan is the one that was reviewed
+	`ALTER TABLE prism_lock ADD COLUMN IF NOT EXISTS plan_digest TEXT NOT NULL DEFAULT ''`,
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Migrate() error {
+	for i, migration := range migrations {
+		if _, err := s.db.Exec(migration); err != nil {
+			return fmt.Errorf("error running migration %d: %w", i, err)
+		}
+	}
+	log.Printf("Applied %d migrations", len(migrations))
+	return nil
+}
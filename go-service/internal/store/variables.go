@@ -0,0 +1,78 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Variable is a terraform input variable of one repository environment. Value holds any JSON
+// value, as it is written to the generated tfvars file.
+type Variable struct {
+	Repository  string          `json:"repository"`
+	Environment string          `json:"environment"`
+	Key         string          `json:"key"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	Sensitive   bool            `json:"sensitive"`
+	Description string          `json:"description"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// ListVariables returns the variable set of a repository environment, sorted by key.
+func (s *Store) ListVariables(repository, environment string) ([]Variable, error) {
+	rows, err := s.db.Query(`
+		SELECT repository, environment, key, value, sensitive, description, updated_at
+		FROM prism_variable
+		WHERE repository = $1 AND environment = $2
+		ORDER BY key`, repository, environment)
+	if err != nil {
+		return nil, fmt.Errorf("error listing variables of %s: %w", repository, err)
+	}
+	defer rows.Close()
+
+	variables := []Variable{}
+	for rows.Next() {
+		var variable Variable
+		var value []byte
+		if err := rows.Scan(&variable.Repository, &variable.Environment, &variable.Key, &value, &variable.Sensitive, &variable.Description, &variable.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error listing variables of %s: %w", repository, err)
+		}
+		variable.Value = value
+		variables = append(variables, variable)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing variables of %s: %w", repository, err)
+	}
+	return variables, nil
+}
+
+// SetVariable creates the variable or replaces its value, sensitivity and description.
+func (s *Store) SetVariable(variable *Variable) error {
+	err := s.db.QueryRow(`
+		INSERT INTO prism_variable (repository, environment, key, value, sensitive, description)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (repository, environment, key) DO UPDATE
+		SET value = EXCLUDED.value, sensitive = EXCLUDED.sensitive, description = EXCLUDED.description, updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at`,
+		variable.Repository, variable.Environment, variable.Key, string(variable.Value), variable.Sensitive, variable.Description).Scan(&variable.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error setting variable %s of %s: %w", variable.Key, variable.Repository, err)
+	}
+	return nil
+}
+
+// DeleteVariable removes a variable and reports whether it existed.
+func (s *Store) DeleteVariable(repository, environment, key string) (bool, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM prism_variable
+		WHERE repository = $1 AND environment = $2 AND key = $3`, repository, environment, key)
+	if err != nil {
+		return false, fmt.Errorf("error deleting variable %s of %s: %w", key, repository, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error deleting variable %s of %s: %w", key, repository, err)
+	}
+	return rows == 1, nil
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Workspace is the state of one conversation, kept apart from the repository's own state.
+type Workspace struct {
+	Repository     string    `json:"repository"`
+	ConversationID string    `json:"conversation_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastPlannedAt  time.Time `json:"last_planned_at"`
+}
+
+// TouchWorkspace records a plan in the conversation's workspace, creating the workspace on its
+// first plan, and reports whether it was created.
+func (s *Store) TouchWorkspace(repository, conversationID string) (*Workspace, bool, error) {
+	workspace := Workspace{Repository: repository, ConversationID: conversationID}
+	var created bool
+	// xmax is only zero for rows the statement inserted rather than updated
+	err := s.db.QueryRow(`
+		INSERT INTO prism_workspace (repository, conversation_id)
+		VALUES ($1, $2)
+		ON CONFLICT (repository, conversation_id) DO UPDATE SET last_planned_at = CURRENT_TIMESTAMP
+		RETURNING created_at, last_planned_at, xmax = 0`, repository, conversationID).Scan(&workspace.CreatedAt, &workspace.LastPlannedAt, &created)
+	if err != nil {
+		return nil, false, fmt.Errorf("error recording workspace %s of %s: %w", conversationID, repository, err)
+	}
+	return &workspace, created, nil
+}
+
+func (s *Store) ListWorkspaces(repository string) ([]Workspace, error) {
+	rows, err := s.db.Query(`
+		SELECT repository, conversation_id, created_at, last_planned_at
+		FROM prism_workspace
+		WHERE repository = $1
+		ORDER BY last_planned_at DESC`, repository)
+	if err != nil {
+		return nil, fmt.Errorf("error listing workspaces of %s: %w", repository, err)
+	}
+	defer rows.Close()
+
+	workspaces := []Workspace{}
+	for rows.Next() {
+		var workspace Workspace
+		if err := rows.Scan(&workspace.Repository, &workspace.ConversationID, &workspace.CreatedAt, &workspace.LastPlannedAt); err != nil {
+			return nil, fmt.Errorf("error listing workspaces of %s: %w", repository, err)
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing workspaces of %s: %w", repository, err)
+	}
+	return workspaces, nil
+}
+
+// DeleteWorkspace removes a workspace record and reports whether it existed.
+func (s *Store) DeleteWorkspace(repository, conversationID string) (bool, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM prism_workspace
+		WHERE repository = $1 AND conversation_id = $2`, repository, conversationID)
+	if err != nil {
+		return false, fmt.Errorf("error deleting workspace %s of %s: %w", conversationID, repository, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error deleting workspace %s of %s: %w", conversationID, repository, err)
+	}
+	return rows == 1, nil
+}
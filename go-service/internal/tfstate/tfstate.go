@@ -0,0 +1,320 @@
+package tfstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SensitiveValue replaces every value terraform marks as sensitive, like `terraform show` does.
+const SensitiveValue = "(sensitive value)"
+
+// State is the part of a terraform state file (format version 4) that can be inspected.
+type State struct {
+	Version          int               `json:"version"`
+	TerraformVersion string            `json:"terraform_version"`
+	Serial           int64             `json:"serial"`
+	Lineage          string            `json:"lineage"`
+	Outputs          map[string]Output `json:"outputs"`
+	Resources        []resourceEntry   `json:"resources"`
+}
+
+type Output struct {
+	Value     interface{}     `json:"value"`
+	Type      json.RawMessage `json:"type,omitempty"`
+	Sensitive bool            `json:"sensitive,omitempty"`
+}
+
+type resourceEntry struct {
+	Module    string          `json:"module,omitempty"`
+	Mode      string          `json:"mode"`
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	Provider  string          `json:"provider"`
+	Instances []instanceEntry `json:"instances"`
+}
+
+type instanceEntry struct {
+	IndexKey            interface{}            `json:"index_key,omitempty"`
+	Status              string                 `json:"status,omitempty"`
+	Attributes          map[string]interface{} `json:"attributes"`
+	SensitiveAttributes []json.RawMessage      `json:"sensitive_attributes,omitempty"`
+	Dependencies        []string               `json:"dependencies,omitempty"`
+}
+
+// Parse reads a state file. Prism creates empty state files before the first apply, those parse to a state without resources.
+func Parse(content []byte) (*State, error) {
+	state := &State{Outputs: map[string]Output{}}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return state, nil
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state: %w", err)
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("unsupported state format version %d", state.Version)
+	}
+	if state.Outputs == nil {
+		state.Outputs = map[string]Output{}
+	}
+	return state, nil
+}
+
+// Resource is one resource instance of the state, identified by its address.
+type Resource struct {
+	Address string `json:"address"`
+	// Module is empty for resources of the root module
+	Module   string `json:"module,omitempty"`
+	Mode     string `json:"mode"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	// Tainted instances are replaced by the next apply
+	Status string `json:"status,omitempty"`
+}
+
+// ResourceDetail adds the attributes of a resource instance, with sensitive values masked.
+type ResourceDetail struct {
+	Resource
+	Attributes   map[string]interface{} `json:"attributes"`
+	Dependencies []string               `json:"dependencies,omitempty"`
+}
+
+// Filter narrows the resources listed, empty fields match everything.
+type Filter struct {
+	Type string
+	// Module matches the resources of a module and of the modules it calls, "root" matches the root module only
+	Module string
+	// Provider matches the full source address, namespace/type or only the provider type, e.g. "aws"
+	Provider string
+}
+
+func (f *Filter) matches(resource *Resource) bool {
+	if f.Type != "" && resource.Type != f.Type {
+		return false
+	}
+	if f.Module == "root" && resource.Module != "" {
+		return false
+	}
+	if f.Module != "" && f.Module != "root" && resource.Module != f.Module &&
+		!strings.HasPrefix(resource.Module, f.Module+".") && !strings.HasPrefix(resource.Module, f.Module+"[") {
+		return false
+	}
+	if f.Provider != "" && resource.Provider != f.Provider && !strings.HasSuffix(resource.Provider, "/"+f.Provider) {
+		return false
+	}
+	return true
+}
+
+// ListResources lists the resource instances matching the filter, sorted by address.
+func (s *State) ListResources(filter *Filter) []Resource {
+	resources := []Resource{}
+	for i := range s.Resources {
+		entry := &s.Resources[i]
+		for j := range entry.Instances {
+			resource := entry.resource(&entry.Instances[j])
+			if filter == nil || filter.matches(&resource) {
+				resources = append(resources, resource)
+			}
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Address < resources[j].Address })
+	return resources
+}
+
+// FindResource returns the instance at address, nil when the state has none.
+func (s *State) FindResource(address string) *ResourceDetail {
+	for i := range s.Resources {
+		entry := &s.Resources[i]
+		for j := range entry.Instances {
+			instance := &entry.Instances[j]
+			resource := entry.resource(instance)
+			if resource.Address != address {
+				continue
+			}
+			return &ResourceDetail{
+				Resource:     resource,
+				Attributes:   maskAttributes(instance.Attributes, instance.SensitiveAttributes),
+				Dependencies: instance.Dependencies,
+			}
+		}
+	}
+	return nil
+}
+
+// MaskedOutputs returns the outputs with the values of sensitive ones replaced.
+func (s *State) MaskedOutputs() map[string]Output {
+	outputs := map[string]Output{}
+	for name, output := range s.Outputs {
+		if output.Sensitive {
+			output.Value = SensitiveValue
+		}
+		outputs[name] = output
+	}
+	return outputs
+}
+
+func (r *resourceEntry) resource(instance *instanceEntry) Resource {
+	address := r.Type + "." + r.Name
+	if r.Mode == "data" {
+		address = "data." + address
+	}
+	address += indexSuffix(instance.IndexKey)
+	if r.Module != "" {
+		address = r.Module + "." + address
+	}
+	return Resource{
+		Address:  address,
+		Module:   r.Module,
+		Mode:     r.Mode,
+		Type:     r.Type,
+		Name:     r.Name,
+		Provider: providerSource(r.Provider),
+		Status:   instance.Status,
+	}
+}
+
+// indexSuffix formats count and for_each keys the way terraform addresses them.
+func indexSuffix(key interface{}) string {
+	switch key := key.(type) {
+	case nil:
+		return ""
+	case string:
+		quoted, _ := json.Marshal(key)
+		return "[" + string(quoted) + "]"
+	case float64:
+		return fmt.Sprintf("[%d]", int64(key))
+	default:
+		return fmt.Sprintf("[%v]", key)
+	}
+}
+
+// providerSource turns provider["registry.terraform.io/hashicorp/aws"].alias into registry.terraform.io/hashicorp/aws.
+func providerSource(provider string) string {
+	start := strings.Index(provider, `["`)
+	end := strings.LastIndex(provider, `"]`)
+	if start < 0 || end <= start {
+		return provider
+	}
+	return provider[start+2 : end]
+}
+
+// pathStep is one step of a sensitive attribute path, an attribute name or a list, set or map index.
+type pathStep struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// maskAttributes copies the attributes and replaces the values at the sensitive paths.
+func maskAttributes(attributes map[string]interface{}, sensitive []json.RawMessage) map[string]interface{} {
+	masked, _ := deepCopy(attributes).(map[string]interface{})
+	if masked == nil {
+		masked = map[string]interface{}{}
+	}
+
+	for _, raw := range sensitive {
+		var path []pathStep
+		if err := json.Unmarshal(raw, &path); err != nil || len(path) == 0 {
+			// A path of an unknown format can not be followed, hide everything rather than leak it
+			return maskAll(masked)
+		}
+		if !maskPath(masked, path) {
+			return maskAll(masked)
+		}
+	}
+	return masked
+}
+
+// maskPath replaces the value at path and reports whether the path could be understood.
+func maskPath(value interface{}, path []pathStep) bool {
+	step := path[0]
+	last := len(path) == 1
+	switch step.Type {
+	case "get_attr":
+		var name string
+		if err := json.Unmarshal(step.Value, &name); err != nil {
+			return false
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return true
+		}
+		if _, ok := object[name]; !ok {
+			return true
+		}
+		if last {
+			object[name] = SensitiveValue
+			return true
+		}
+		return maskPath(object[name], path[1:])
+	case "index":
+		var key struct {
+			Value interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(step.Value, &key); err != nil {
+			return false
+		}
+		switch collection := value.(type) {
+		case map[string]interface{}:
+			name, ok := key.Value.(string)
+			if !ok {
+				return false
+			}
+			if _, ok := collection[name]; !ok {
+				return true
+			}
+			if last {
+				collection[name] = SensitiveValue
+				return true
+			}
+			return maskPath(collection[name], path[1:])
+		case []interface{}:
+			index, ok := key.Value.(float64)
+			if !ok {
+				// Set elements are addressed by value, mask the whole set
+				for i := range collection {
+					collection[i] = SensitiveValue
+				}
+				return true
+			}
+			if int(index) < 0 || int(index) >= len(collection) {
+				return true
+			}
+			if last {
+				collection[int(index)] = SensitiveValue
+				return true
+			}
+			return maskPath(collection[int(index)], path[1:])
+		}
+		return true
+	}
+	return false
+}
+
+func maskAll(attributes map[string]interface{}) map[string]interface{} {
+	for name := range attributes {
+		attributes[name] = SensitiveValue
+	}
+	return attributes
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			copied[key] = deepCopy(element)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, element := range value {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	default:
+		return value
+	}
+}
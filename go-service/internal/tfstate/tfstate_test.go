@@ -0,0 +1,149 @@
+package tfstate
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func sensitivePaths(t *testing.T, paths ...string) []json.RawMessage {
+	t.Helper()
+	raw := []json.RawMessage{}
+	for _, path := range paths {
+		raw = append(raw, json.RawMessage(path))
+	}
+	return raw
+}
+
+func TestMaskAttributes(t *testing.T) {
+	attributes := func() map[string]interface{} {
+		return map[string]interface{}{
+			"name":     "main",
+			"password": "hunter2",
+			"settings": map[string]interface{}{"user": "admin", "token": "abc"},
+			"keys":     []interface{}{"public", "private"},
+			"tags":     map[string]interface{}{"team": "infra", "api_key": "xyz"},
+			"rules": []interface{}{
+				map[string]interface{}{"port": float64(22), "secret": "s1"},
+				map[string]interface{}{"port": float64(443), "secret": "s2"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		sensitive []string
+		want      map[string]interface{}
+	}{
+		{
+			name:      "top level attribute",
+			sensitive: []string{`[{"type":"get_attr","value":"password"}]`},
+			want: map[string]interface{}{
+				"password": SensitiveValue,
+			},
+		},
+		{
+			name:      "nested attribute",
+			sensitive: []string{`[{"type":"get_attr","value":"settings"},{"type":"get_attr","value":"token"}]`},
+			want: map[string]interface{}{
+				"settings": map[string]interface{}{"user": "admin", "token": SensitiveValue},
+			},
+		},
+		{
+			name:      "list element",
+			sensitive: []string{`[{"type":"get_attr","value":"keys"},{"type":"index","value":{"value":1,"type":"number"}}]`},
+			want: map[string]interface{}{
+				"keys": []interface{}{"public", SensitiveValue},
+			},
+		},
+		{
+			name:      "map key",
+			sensitive: []string{`[{"type":"get_attr","value":"tags"},{"type":"index","value":{"value":"api_key","type":"string"}}]`},
+			want: map[string]interface{}{
+				"tags": map[string]interface{}{"team": "infra", "api_key": SensitiveValue},
+			},
+		},
+		{
+			name:      "attribute of a list element",
+			sensitive: []string{`[{"type":"get_attr","value":"rules"},{"type":"index","value":{"value":0,"type":"number"}},{"type":"get_attr","value":"secret"}]`},
+			want: map[string]interface{}{
+				"rules": []interface{}{
+					map[string]interface{}{"port": float64(22), "secret": SensitiveValue},
+					map[string]interface{}{"port": float64(443), "secret": "s2"},
+				},
+			},
+		},
+		{
+			name:      "set element addressed by value",
+			sensitive: []string{`[{"type":"get_attr","value":"keys"},{"type":"index","value":{"value":{"id":"x"},"type":"object"}}]`},
+			want: map[string]interface{}{
+				"keys": []interface{}{SensitiveValue, SensitiveValue},
+			},
+		},
+		{
+			name:      "path to a missing attribute",
+			sensitive: []string{`[{"type":"get_attr","value":"missing"}]`},
+			want:      map[string]interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := attributes()
+			masked := maskAttributes(original, sensitivePaths(t, tt.sensitive...))
+
+			want := attributes()
+			for name, value := range tt.want {
+				want[name] = value
+			}
+			if !reflect.DeepEqual(masked, want) {
+				t.Errorf("expected %v, got %v", want, masked)
+			}
+			if !reflect.DeepEqual(original, attributes()) {
+				t.Errorf("expected the attributes of the state to stay as they were, got %v", original)
+			}
+		})
+	}
+}
+
+func TestMaskAttributesHidesEverythingForUnknownPaths(t *testing.T) {
+	for _, path := range []string{`"password"`, `[]`, `[{"type":"unknown","value":"password"}]`} {
+		masked := maskAttributes(map[string]interface{}{"name": "main", "password": "hunter2"}, sensitivePaths(t, path))
+		for name, value := range masked {
+			if value != SensitiveValue {
+				t.Errorf("expected %s to be masked for path %s, got %v", name, path, value)
+			}
+		}
+	}
+}
+
+func TestFindResourceMasksSensitiveAttributes(t *testing.T) {
+	state, err := Parse([]byte(`{
+		"version": 4,
+		"resources": [{
+			"module": "module.db",
+			"mode": "managed",
+			"type": "aws_db_instance",
+			"name": "main",
+			"provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+			"instances": [{
+				"index_key": "primary",
+				"attributes": {"identifier": "main", "password": "hunter2"},
+				"sensitive_attributes": [[{"type":"get_attr","value":"password"}]]
+			}]
+		}]
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resource := state.FindResource(`module.db.aws_db_instance.main["primary"]`)
+	if resource == nil {
+		t.Fatal("expected the resource to be found by its address")
+	}
+	if resource.Provider != "registry.terraform.io/hashicorp/aws" {
+		t.Errorf("unexpected provider %q", resource.Provider)
+	}
+	if resource.Attributes["password"] != SensitiveValue || resource.Attributes["identifier"] != "main" {
+		t.Errorf("expected only the password to be masked, got %v", resource.Attributes)
+	}
+}
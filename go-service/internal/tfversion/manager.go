@@ -0,0 +1,393 @@
+package tfversion
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-version"
+	"golang.org/x/crypto/openpgp"
+)
+
+const (
+	DefaultReleasesURL         = "https://releases.hashicorp.com"
+	DefaultOpenTofuReleasesURL = "https://github.com/opentofu/opentofu/releases/download"
+	openTofuIndexURL           = "https://get.opentofu.org/tofu/api.json"
+)
+
+// Manager keeps one binary per version in VersionsDir, laid out as <version>/<binary> so that
+// terraform and tofu of the same version share a directory. Archives, SHA256SUMS files and their
+// signatures placed in VersionsDir/downloads are installed from there instead of being
+// downloaded, which is how an offline cache is pre-seeded.
+type Manager struct {
+	// Binary is the executable name, also used when a root module neither pins nor constrains a version
+	Binary      string
+	VersionsDir string
+	ReleasesURL string
+	// IndexURL lists all releases, used to satisfy version constraints
+	IndexURL string
+	// Offline never reaches the releases server, only pre-seeded versions and archives are used
+	Offline    bool
+	HTTPClient *http.Client
+	// Keyring holds the release signing keys the SHA256SUMS file of every release must be signed
+	// with. Without it the checksums are trusted as served, which catches corrupted downloads but
+	// not a compromised releases server or mirror.
+	Keyring openpgp.EntityList
+
+	// releaseDir is the path of one release below ReleasesURL
+	releaseDir func(v *version.Version) string
+	// signatureName is the detached GPG signature of a SHA256SUMS file, next to it in the release
+	signatureName func(sumsName string) string
+
+	// Held while installing so concurrent runs do not download the same version twice
+	mu sync.Mutex
+}
+
+// NewManager manages terraform releases published at releasesURL.
+func NewManager(versionsDir, releasesURL string, offline bool) *Manager {
+	if releasesURL == "" {
+		releasesURL = DefaultReleasesURL
+	}
+	releasesURL = strings.TrimSuffix(releasesURL, "/")
+	return &Manager{
+		Binary:        "terraform",
+		VersionsDir:   versionsDir,
+		ReleasesURL:   releasesURL,
+		IndexURL:      releasesURL + "/terraform/index.json",
+		Offline:       offline,
+		HTTPClient:    &http.Client{},
+		releaseDir:    func(v *version.Version) string { return "terraform/" + v.String() },
+		signatureName: func(sumsName string) string { return sumsName + ".sig" },
+	}
+}
+
+// NewOpenTofuManager manages OpenTofu releases published at releasesURL, GitHub releases by default.
+func NewOpenTofuManager(versionsDir, releasesURL string, offline bool) *Manager {
+	if releasesURL == "" {
+		releasesURL = DefaultOpenTofuReleasesURL
+	}
+	return &Manager{
+		Binary:      "tofu",
+		VersionsDir: versionsDir,
+		ReleasesURL: strings.TrimSuffix(releasesURL, "/"),
+		IndexURL:    openTofuIndexURL,
+		Offline:     offline,
+		HTTPClient:  &http.Client{},
+		releaseDir:  func(v *version.Version) string { return "v" + v.String() },
+		// The .sig of OpenTofu releases is a cosign signature
+		signatureName: func(sumsName string) string { return sumsName + ".gpgsig" },
+	}
+}
+
+// LoadKeyring reads the armored public keys releases are verified with from path, e.g.
+// HashiCorp's and OpenTofu's release signing keys concatenated.
+func LoadKeyring(path string) (openpgp.EntityList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open release keyring: %w", err)
+	}
+	defer file.Close()
+
+	keyring, err := openpgp.ReadArmoredKeyRing(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read release keyring %s: %w", path, err)
+	}
+	return keyring, nil
+}
+
+var requiredVersionPattern = regexp.MustCompile(`(?m)^\s*required_version\s*=\s*"([^"]*)"`)
+
+// RequiredVersion collects the required_version constraints of the root module in dir.
+func RequiredVersion(dir string) (string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return "", err
+	}
+
+	constraints := []string{}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		for _, match := range requiredVersionPattern.FindAllSubmatch(content, -1) {
+			constraints = append(constraints, string(match[1]))
+		}
+	}
+	return strings.Join(constraints, ", "), nil
+}
+
+// Resolve returns the binary for the root module in dir. A pinned version wins, otherwise the
+// newest version satisfying required_version is used. Without either it returns the binary
+// from PATH.
+func (m *Manager) Resolve(dir, pinned string) (string, error) {
+	if pinned != "" {
+		v, err := version.NewVersion(pinned)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s version %q: %w", m.Binary, pinned, err)
+		}
+		return m.ensure(v)
+	}
+
+	required, err := RequiredVersion(dir)
+	if err != nil {
+		return "", err
+	}
+	if required == "" {
+		return m.Binary, nil
+	}
+
+	constraints, err := version.NewConstraint(required)
+	if err != nil {
+		return "", fmt.Errorf("invalid required_version %q: %w", required, err)
+	}
+
+	if v := newestMatching(m.installedVersions(), constraints); v != nil {
+		return m.binaryPath(v), nil
+	}
+	if m.Offline {
+		return "", fmt.Errorf("no installed %s version satisfies %q", m.Binary, required)
+	}
+
+	available, err := m.availableVersions()
+	if err != nil {
+		return "", err
+	}
+	v := newestMatching(available, constraints)
+	if v == nil {
+		return "", fmt.Errorf("no %s release satisfies %q", m.Binary, required)
+	}
+	return m.ensure(v)
+}
+
+func newestMatching(versions []*version.Version, constraints version.Constraints) *version.Version {
+	sort.Sort(sort.Reverse(version.Collection(versions)))
+	for _, v := range versions {
+		// Pre-releases are only used when pinned
+		if v.Prerelease() == "" && constraints.Check(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+func (m *Manager) binaryPath(v *version.Version) string {
+	return filepath.Join(m.VersionsDir, v.String(), m.Binary)
+}
+
+func (m *Manager) installedVersions() []*version.Version {
+	entries, err := os.ReadDir(m.VersionsDir)
+	if err != nil {
+		return nil
+	}
+
+	versions := []*version.Version{}
+	for _, entry := range entries {
+		v, err := version.NewVersion(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(m.binaryPath(v)); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+func (m *Manager) availableVersions() ([]*version.Version, error) {
+	content, err := m.fetch(m.IndexURL, m.Binary+"_index.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s releases: %w", m.Binary, err)
+	}
+
+	// HashiCorp keys releases by version, OpenTofu lists them with an id
+	var index struct {
+		Versions json.RawMessage `json:"versions"`
+	}
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s releases: %w", m.Binary, err)
+	}
+	names := []string{}
+	var keyed map[string]json.RawMessage
+	var listed []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(index.Versions, &keyed); err == nil {
+		for name := range keyed {
+			names = append(names, name)
+		}
+	} else if err := json.Unmarshal(index.Versions, &listed); err == nil {
+		for _, release := range listed {
+			names = append(names, release.ID)
+		}
+	} else {
+		return nil, fmt.Errorf("failed to parse %s releases: %w", m.Binary, err)
+	}
+
+	versions := []*version.Version{}
+	for _, name := range names {
+		if v, err := version.NewVersion(name); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+// ensure installs a version unless it is already present and returns its binary.
+func (m *Manager) ensure(v *version.Version) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	binaryPath := m.binaryPath(v)
+	if _, err := os.Stat(binaryPath); err == nil {
+		return binaryPath, nil
+	}
+
+	if err := m.install(v); err != nil {
+		return "", fmt.Errorf("failed to install %s %s: %w", m.Binary, v, err)
+	}
+	return binaryPath, nil
+}
+
+func (m *Manager) install(v *version.Version) error {
+	archiveName := fmt.Sprintf("%s_%s_%s_%s.zip", m.Binary, v, runtime.GOOS, runtime.GOARCH)
+	sumsName := fmt.Sprintf("%s_%s_SHA256SUMS", m.Binary, v)
+	releaseURL := m.ReleasesURL + "/" + m.releaseDir(v)
+	log.Printf("Installing %s %s into %s", m.Binary, v, m.VersionsDir)
+
+	sums, err := m.fetch(releaseURL+"/"+sumsName, sumsName)
+	if err != nil {
+		return err
+	}
+	if err := m.verifySignature(releaseURL, sumsName, sums); err != nil {
+		return err
+	}
+	expected, err := checksumFor(sums, archiveName)
+	if err != nil {
+		return err
+	}
+
+	archive, err := m.fetch(releaseURL+"/"+archiveName, archiveName)
+	if err != nil {
+		return err
+	}
+	actual := sha256.Sum256(archive)
+	if hex.EncodeToString(actual[:]) != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", archiveName, expected, hex.EncodeToString(actual[:]))
+	}
+
+	return m.extract(v, archive)
+}
+
+// verifySignature checks the SHA256SUMS file of a release against the Keyring, when there is one.
+func (m *Manager) verifySignature(releaseURL, sumsName string, sums []byte) error {
+	if m.Keyring == nil {
+		return nil
+	}
+
+	signatureName := m.signatureName(sumsName)
+	signature, err := m.fetch(releaseURL+"/"+signatureName, signatureName)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(m.Keyring, bytes.NewReader(sums), bytes.NewReader(signature))
+	} else {
+		_, err = openpgp.CheckDetachedSignature(m.Keyring, bytes.NewReader(sums), bytes.NewReader(signature))
+	}
+	if err != nil {
+		return fmt.Errorf("signature of %s does not verify: %w", sumsName, err)
+	}
+	return nil
+}
+
+// fetch reads a release file from the pre-seeded downloads directory, or downloads it from fileURL.
+func (m *Manager) fetch(fileURL, name string) ([]byte, error) {
+	content, err := os.ReadFile(filepath.Join(m.VersionsDir, "downloads", name))
+	if err == nil {
+		return content, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if m.Offline {
+		return nil, fmt.Errorf("%s is not in the offline cache", name)
+	}
+
+	resp, err := m.HTTPClient.Get(fileURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: status %d", name, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func checksumFor(sums []byte, archiveName string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == archiveName {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", archiveName)
+}
+
+// extract writes the binary next to its final path first, so a crash never leaves a partial binary behind.
+func (m *Manager) extract(v *version.Version, archive []byte) error {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	for _, file := range reader.File {
+		if file.Name != m.Binary {
+			continue
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		binaryPath := m.binaryPath(v)
+		if err := os.MkdirAll(filepath.Dir(binaryPath), 0755); err != nil {
+			return err
+		}
+		tmpPath := binaryPath + ".tmp"
+		dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+		if err := dst.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmpPath, binaryPath)
+	}
+
+	return fmt.Errorf("archive does not contain a %s binary", m.Binary)
+}
@@ -0,0 +1,239 @@
+package tfversion
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+	"golang.org/x/crypto/openpgp"
+)
+
+// testArchive zips a fake terraform binary printing its version.
+func testArchive(t *testing.T, v string) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	file, err := writer.Create("terraform")
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	fmt.Fprintf(file, "#!/bin/sh\necho %s\n", v)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	return archive.Bytes()
+}
+
+func archiveName(v string) string {
+	return fmt.Sprintf("terraform_%s_%s_%s.zip", v, runtime.GOOS, runtime.GOARCH)
+}
+
+func sumsFor(v string, archive []byte) []byte {
+	sum := sha256.Sum256(archive)
+	return []byte(fmt.Sprintf("%s  %s\n%s  terraform_%s_windows_386.zip\n", hex.EncodeToString(sum[:]), archiveName(v), strings.Repeat("0", 64), v))
+}
+
+// fakeReleases serves files below the HashiCorp layout, /terraform/<version>/<name>, and counts
+// the requests it answered.
+type fakeReleases struct {
+	files    map[string][]byte
+	requests int
+}
+
+func (f *fakeReleases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests++
+	content, ok := f.files[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(content)
+}
+
+// release publishes v with an archive and SHA256SUMS matching it.
+func (f *fakeReleases) release(t *testing.T, v string) {
+	archive := testArchive(t, v)
+	f.files["/terraform/"+v+"/"+archiveName(v)] = archive
+	f.files["/terraform/"+v+"/terraform_"+v+"_SHA256SUMS"] = sumsFor(v, archive)
+}
+
+func newFakeReleases(t *testing.T, versions ...string) (*fakeReleases, *Manager) {
+	releases := &fakeReleases{files: map[string][]byte{
+		"/terraform/index.json": []byte(`{"versions": {"1.5.7": {}, "1.6.6": {}, "1.7.0-rc1": {}, "1.7.5": {}, "not-a-version": {}}}`),
+	}}
+	for _, v := range versions {
+		releases.release(t, v)
+	}
+	server := httptest.NewServer(releases)
+	t.Cleanup(server.Close)
+	return releases, NewManager(t.TempDir(), server.URL, false)
+}
+
+func writeModule(t *testing.T, requiredVersion string) string {
+	t.Helper()
+	dir := t.TempDir()
+	content := fmt.Sprintf("terraform {\n  required_version = %q\n}\n", requiredVersion)
+	if err := os.WriteFile(filepath.Join(dir, "versions.tf"), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write module: %v", err)
+	}
+	return dir
+}
+
+func TestResolveInstallsTheNewestMatchingRelease(t *testing.T) {
+	_, manager := newFakeReleases(t, "1.5.7", "1.6.6", "1.7.0-rc1", "1.7.5")
+
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{constraint: ">= 1.6", want: "1.7.5"},
+		{constraint: "~> 1.6.0", want: "1.6.6"},
+		{constraint: ">= 1.5, < 1.7", want: "1.6.6"},
+		{constraint: "1.5.7", want: "1.5.7"},
+	}
+	for _, test := range tests {
+		binary, err := manager.Resolve(writeModule(t, test.constraint), "")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.constraint, err)
+			continue
+		}
+		if want := filepath.Join(manager.VersionsDir, test.want, "terraform"); binary != want {
+			t.Errorf("%s: expected %s, got %s", test.constraint, want, binary)
+		}
+	}
+
+	if _, err := manager.Resolve(writeModule(t, "> 2.0"), ""); err == nil || !strings.Contains(err.Error(), "no terraform release satisfies") {
+		t.Errorf("expected a constraint no release satisfies to fail, got %v", err)
+	}
+	if _, err := manager.Resolve(writeModule(t, "not a constraint"), ""); err == nil || !strings.Contains(err.Error(), "invalid required_version") {
+		t.Errorf("expected an invalid constraint to fail, got %v", err)
+	}
+}
+
+func TestResolvePrefersPinnedAndInstalledVersions(t *testing.T) {
+	releases, manager := newFakeReleases(t, "1.6.6", "1.7.0-rc1")
+
+	binary, err := manager.Resolve(writeModule(t, ">= 1.6"), "1.7.0-rc1")
+	if err != nil || binary != filepath.Join(manager.VersionsDir, "1.7.0-rc1", "terraform") {
+		t.Fatalf("expected the pinned pre-release, got %s %v", binary, err)
+	}
+
+	if _, err := manager.Resolve(writeModule(t, "~> 1.6.0"), ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requests := releases.requests
+	binary, err = manager.Resolve(writeModule(t, ">= 1.6"), "")
+	if err != nil || binary != filepath.Join(manager.VersionsDir, "1.6.6", "terraform") {
+		t.Errorf("expected the installed version satisfying the constraint, got %s %v", binary, err)
+	}
+	if releases.requests != requests {
+		t.Errorf("expected an installed version to be used without reaching the releases server")
+	}
+
+	if binary, err := manager.Resolve(t.TempDir(), ""); err != nil || binary != "terraform" {
+		t.Errorf("expected a module without a version to use the binary from PATH, got %s %v", binary, err)
+	}
+}
+
+func TestInstallRejectsChecksumMismatch(t *testing.T) {
+	releases, manager := newFakeReleases(t, "1.6.6")
+	releases.files["/terraform/1.6.6/"+archiveName("1.6.6")] = testArchive(t, "tampered")
+
+	_, err := manager.Resolve(t.TempDir(), "1.6.6")
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for "+archiveName("1.6.6")) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(manager.VersionsDir, "1.6.6", "terraform")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be installed, got %v", err)
+	}
+
+	releases.files["/terraform/1.6.6/terraform_1.6.6_SHA256SUMS"] = []byte("abc  terraform_1.6.6_windows_386.zip\n")
+	if _, err := manager.Resolve(t.TempDir(), "1.6.6"); err == nil || !strings.Contains(err.Error(), "no checksum for") {
+		t.Errorf("expected an archive missing from the checksums to fail, got %v", err)
+	}
+}
+
+func TestOfflineCache(t *testing.T) {
+	manager := NewManager(t.TempDir(), "http://releases.invalid", true)
+	downloads := filepath.Join(manager.VersionsDir, "downloads")
+	if err := os.MkdirAll(downloads, 0755); err != nil {
+		t.Fatalf("failed to create downloads: %v", err)
+	}
+	archive := testArchive(t, "1.6.6")
+	os.WriteFile(filepath.Join(downloads, archiveName("1.6.6")), archive, 0644)
+	os.WriteFile(filepath.Join(downloads, "terraform_1.6.6_SHA256SUMS"), sumsFor("1.6.6", archive), 0644)
+
+	binary, err := manager.Resolve(t.TempDir(), "1.6.6")
+	if err != nil || binary != filepath.Join(manager.VersionsDir, "1.6.6", "terraform") {
+		t.Fatalf("expected the pre-seeded archive to be installed, got %s %v", binary, err)
+	}
+	if binary, err := manager.Resolve(writeModule(t, "~> 1.6"), ""); err != nil || binary != filepath.Join(manager.VersionsDir, "1.6.6", "terraform") {
+		t.Errorf("expected the installed version to satisfy the constraint offline, got %s %v", binary, err)
+	}
+
+	if _, err := manager.Resolve(writeModule(t, ">= 1.7"), ""); err == nil || !strings.Contains(err.Error(), "no installed terraform version satisfies") {
+		t.Errorf("expected constraints to only be satisfied by installed versions offline, got %v", err)
+	}
+	if _, err := manager.Resolve(t.TempDir(), "1.7.5"); err == nil || !strings.Contains(err.Error(), "not in the offline cache") {
+		t.Errorf("expected a version missing from the cache to fail offline, got %v", err)
+	}
+}
+
+func TestInstallVerifiesTheChecksumsSignature(t *testing.T) {
+	signer, err := openpgp.NewEntity("Releases", "", "releases@example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	other, err := openpgp.NewEntity("Other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	sign := func(entity *openpgp.Entity, sums []byte) []byte {
+		var signature bytes.Buffer
+		if err := openpgp.DetachSign(&signature, entity, bytes.NewReader(sums), nil); err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return signature.Bytes()
+	}
+
+	releases, manager := newFakeReleases(t, "1.5.7", "1.6.6", "1.7.5")
+	manager.Keyring = openpgp.EntityList{signer}
+	sums := func(v string) []byte { return releases.files["/terraform/"+v+"/terraform_"+v+"_SHA256SUMS"] }
+	releases.files["/terraform/1.6.6/terraform_1.6.6_SHA256SUMS.sig"] = sign(signer, sums("1.6.6"))
+	releases.files["/terraform/1.7.5/terraform_1.7.5_SHA256SUMS.sig"] = sign(other, sums("1.7.5"))
+
+	if _, err := manager.Resolve(t.TempDir(), "1.6.6"); err != nil {
+		t.Errorf("expected a release signed with the keyring to be installed, got %v", err)
+	}
+	if _, err := manager.Resolve(t.TempDir(), "1.7.5"); err == nil || !strings.Contains(err.Error(), "signature of terraform_1.7.5_SHA256SUMS does not verify") {
+		t.Errorf("expected a release signed with another key to be rejected, got %v", err)
+	}
+	if _, err := manager.Resolve(t.TempDir(), "1.5.7"); err == nil || !strings.Contains(err.Error(), "SHA256SUMS.sig") {
+		t.Errorf("expected a release without a signature to be rejected, got %v", err)
+	}
+
+	manager.Keyring = nil
+	if _, err := manager.Resolve(t.TempDir(), "1.5.7"); err != nil {
+		t.Errorf("expected releases to be installed without a keyring, got %v", err)
+	}
+}
+
+func TestNewestMatchingSkipsPrereleases(t *testing.T) {
+	versions := []*version.Version{}
+	for _, name := range []string{"1.6.6", "1.8.0-beta1", "1.7.5"} {
+		versions = append(versions, version.Must(version.NewVersion(name)))
+	}
+	if v := newestMatching(versions, version.MustConstraints(version.NewConstraint(">= 1.6"))); v == nil || v.String() != "1.7.5" {
+		t.Errorf("expected the newest release, got %v", v)
+	}
+}
@@ -0,0 +1,119 @@
+package variables
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/scm"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/labstack/echo/v4"
+)
+
+type VariablesRoutesConfig struct {
+	Echo  *echo.Echo
+	Store *store.Store
+}
+
+type SetVariableRequest struct {
+	RepoURL     string `json:"repo_url"`
+	Environment string `json:"environment"`
+	// Value is any JSON value, strings, numbers, lists and objects are passed to terraform as is
+	Value       json.RawMessage `json:"value"`
+	Sensitive   bool            `json:"sensitive"`
+	Description string          `json:"description"`
+}
+
+// variableSet resolves the repository and environment of a request, the environment defaults
+// to the one plans use without configuration.
+func variableSet(repoURL, environment string) (string, string, error) {
+	repository, err := scm.RepositoryKey(repoURL)
+	if err != nil {
+		return "", "", err
+	}
+	if environment == "" {
+		environment = repoconfig.DefaultInfisicalEnvironment
+	}
+	if !repoconfig.ValidEnvironment(environment) {
+		return "", "", fmt.Errorf("environment %q is not a valid environment slug", environment)
+	}
+	return repository, environment, nil
+}
+
+func SetupRoutes(routesConfig *VariablesRoutesConfig) {
+	e := routesConfig.Echo
+
+	// GET /variables?repo_url=...&environment=...
+	// Lists the variable set, sensitive values are never returned
+	e.GET("/variables", func(c echo.Context) error {
+		repository, environment, err := variableSet(c.QueryParam("repo_url"), c.QueryParam("environment"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+
+		set, err := routesConfig.Store.ListVariables(repository, environment)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{
+			"repository":  repository,
+			"environment": environment,
+			"variables":   Masked(set),
+		})
+	})
+
+	// PUT /variables/:key
+	// Creates or replaces a variable, body is a SetVariableRequest
+	e.PUT("/variables/:key", func(c echo.Context) error {
+		key := c.Param("key")
+		if !ValidKey(key) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "key is not a valid variable name"})
+		}
+
+		var req SetVariableRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request body"})
+		}
+		repository, environment, err := variableSet(req.RepoURL, req.Environment)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		if len(req.Value) == 0 || !json.Valid(req.Value) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "value is required"})
+		}
+
+		variable := &store.Variable{
+			Repository:  repository,
+			Environment: environment,
+			Key:         key,
+			Value:       req.Value,
+			Sensitive:   req.Sensitive,
+			Description: req.Description,
+		}
+		if err := routesConfig.Store.SetVariable(variable); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, Masked([]store.Variable{*variable})[0])
+	})
+
+	// DELETE /variables/:key?repo_url=...&environment=...
+	e.DELETE("/variables/:key", func(c echo.Context) error {
+		repository, environment, err := variableSet(c.QueryParam("repo_url"), c.QueryParam("environment"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+
+		deleted, err := routesConfig.Store.DeleteVariable(repository, environment, c.Param("key"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		if !deleted {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "variable not found"})
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{"deleted": true})
+	})
+}
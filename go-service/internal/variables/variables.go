@@ -0,0 +1,251 @@
+package variables
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/store"
+)
+
+// FileName is generated in the root module at plan time, terraform loads it automatically
+const FileName = "prism.auto.tfvars.json"
+
+var (
+	keyPattern         = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+	declarationPattern = regexp.MustCompile(`(?m)^\s*variable\s+"([^"]+)"\s*\{`)
+	defaultPattern     = regexp.MustCompile(`(?m)^\s*default\s*=`)
+	// Only top-level assignments, nested map keys are indented
+	tfvarsKeyPattern = regexp.MustCompile(`(?m)^([A-Za-z_][A-Za-z0-9_-]*)\s*=`)
+)
+
+// ValidKey reports whether key can name a terraform variable.
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
+
+// Declaration is a variable block of a root module.
+type Declaration struct {
+	Name string `json:"name"`
+	// Required variables have no default
+	Required bool `json:"required"`
+}
+
+// Declarations returns the variables declared by the .tf files in dir, sorted by name.
+func Declarations(dir string) ([]Declaration, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+
+	declarations := []Declaration{}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		for _, match := range declarationPattern.FindAllSubmatchIndex(content, -1) {
+			body := blockBody(content, match[1])
+			declarations = append(declarations, Declaration{
+				Name:     string(content[match[2]:match[3]]),
+				Required: !defaultPattern.Match(body),
+			})
+		}
+	}
+
+	sort.Slice(declarations, func(i, j int) bool { return declarations[i].Name < declarations[j].Name })
+	return declarations, nil
+}
+
+// blockBody returns the content up to the brace closing the block opened just before start.
+// Only top-level attributes of the body are looked at, so braces inside strings are not special-cased.
+func blockBody(content []byte, start int) []byte {
+	depth := 1
+	for i := start; i < len(content); i++ {
+		switch content[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return topLevel(content[start:i])
+			}
+		}
+	}
+	return topLevel(content[start:])
+}
+
+// topLevel drops nested blocks such as validation, whose attributes do not belong to the variable.
+func topLevel(body []byte) []byte {
+	result := []byte{}
+	depth := 0
+	for _, b := range body {
+		switch b {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		default:
+			if depth == 0 {
+				result = append(result, b)
+			}
+		}
+	}
+	return result
+}
+
+// Report compares the variables a root module declares with the values it gets.
+type Report struct {
+	Declared []Declaration `json:"declared"`
+	// Provided lists the declared variables that have a value from any source
+	Provided []string `json:"provided"`
+	// Missing lists the required variables without a value, which would fail the plan
+	Missing []string `json:"missing"`
+}
+
+// Check finds the declared variables of the root module in dir that get no value from the
+// variable set, TF_VAR_ environment variables, tfvars files terraform loads on its own or
+// varFiles, which are relative to dir.
+func Check(dir string, set []store.Variable, env []string, varFiles []string) (*Report, error) {
+	declarations, err := Declarations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	provided := map[string]bool{}
+	for _, variable := range set {
+		provided[variable.Key] = true
+	}
+	for _, entry := range env {
+		if key, _, ok := strings.Cut(entry, "="); ok && strings.HasPrefix(key, "TF_VAR_") {
+			provided[strings.TrimPrefix(key, "TF_VAR_")] = true
+		}
+	}
+
+	files := []string{}
+	for _, pattern := range []string{"terraform.tfvars", "terraform.tfvars.json", "*.auto.tfvars", "*.auto.tfvars.json"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	for _, varFile := range varFiles {
+		files = append(files, filepath.Join(dir, varFile))
+	}
+	for _, file := range files {
+		// The generated file only holds the variable set, which is already accounted for
+		if filepath.Base(file) == FileName {
+			continue
+		}
+		keys, err := tfvarsKeys(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			provided[key] = true
+		}
+	}
+
+	report := &Report{Declared: declarations, Provided: []string{}, Missing: []string{}}
+	for _, declaration := range declarations {
+		if provided[declaration.Name] {
+			report.Provided = append(report.Provided, declaration.Name)
+		} else if declaration.Required {
+			report.Missing = append(report.Missing, declaration.Name)
+		}
+	}
+	return report, nil
+}
+
+func tfvarsKeys(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	keys := []string{}
+	if strings.HasSuffix(path, ".json") {
+		values := map[string]json.RawMessage{}
+		if err := json.Unmarshal(content, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for key := range values {
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+
+	for _, match := range tfvarsKeyPattern.FindAllSubmatch(content, -1) {
+		keys = append(keys, string(match[1]))
+	}
+	return keys, nil
+}
+
+// Write generates the tfvars file of the variable set in dir. An empty set still writes the
+// file, so a stale one from an earlier plan never survives.
+func Write(dir string, set []store.Variable) error {
+	values := map[string]json.RawMessage{}
+	for _, variable := range set {
+		values[variable.Key] = variable.Value
+	}
+
+	content, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", FileName, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, FileName), content, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", FileName, err)
+	}
+	return nil
+}
+
+// MissingError fails a plan before terraform would, naming every variable without a value.
+type MissingError struct {
+	Variables []string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("no value for required variables: %s", strings.Join(e.Variables, ", "))
+}
+
+// Prepare writes the variable set of the repository environment into the root module in dir
+// and fails with a MissingError if a required variable still has no value. Without a store only
+// the other sources are checked.
+func Prepare(s *store.Store, repository, environment, dir string, env, varFiles []string) (*Report, error) {
+	set := []store.Variable{}
+	if s != nil {
+		var err error
+		if set, err = s.ListVariables(repository, environment); err != nil {
+			return nil, err
+		}
+		if err := Write(dir, set); err != nil {
+			return nil, err
+		}
+	}
+
+	report, err := Check(dir, set, env, varFiles)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Missing) > 0 {
+		return report, &MissingError{Variables: report.Missing}
+	}
+	return report, nil
+}
+
+// Masked hides the values of sensitive variables, which are write-only through the API.
+func Masked(set []store.Variable) []store.Variable {
+	masked := make([]store.Variable, len(set))
+	for i, variable := range set {
+		if variable.Sensitive {
+			variable.Value = nil
+		}
+		masked[i] = variable
+	}
+	return masked
+}
@@ -0,0 +1,66 @@
+package webhooks
+
+import (
+	"fmt"
+	"strings"
+)
+
+const commandPrefix = "prism"
+
+const (
+	CommandPlan   = "plan"
+	CommandApply  = "apply"
+	CommandUnlock = "unlock"
+	CommandHelp   = "help"
+)
+
+const commandUsage = "Prism understands the following commands:\n\n" +
+	"- `prism plan [environment]` plans the head of this pull request and locks the state for it\n" +
+	"- `prism apply` applies the last plan of this pull request once its environment's approvals are met, and releases the lock\n" +
+	"- `prism unlock` releases the lock without applying\n" +
+	"- `prism help` shows this message"
+
+// commandArgs is the number of arguments each command takes at most.
+var commandArgs = map[string]int{
+	CommandPlan:   1,
+	CommandApply:  1,
+	CommandUnlock: 0,
+	CommandHelp:   0,
+}
+
+type Command struct {
+	Name string
+	Args []string
+}
+
+// Validate reports a command Prism does not know, or one given more arguments than it takes.
+func (c *Command) Validate() error {
+	maxArgs, ok := commandArgs[c.Name]
+	if !ok {
+		return fmt.Errorf("unknown command `%s`", c.Name)
+	}
+	if len(c.Args) > maxArgs {
+		return fmt.Errorf("`%s %s` takes at most %d argument(s), got %d", commandPrefix, c.Name, maxArgs, len(c.Args))
+	}
+	return nil
+}
+
+// ParseCommand returns the first command found in a comment body, or nil if it does not address Prism.
+// Commands must start a line, e.g. "prism plan", so mentioning prism in prose is not a command.
+func ParseCommand(body string) *Command {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], commandPrefix) {
+			continue
+		}
+
+		if len(fields) == 1 {
+			return &Command{Name: CommandHelp}
+		}
+		return &Command{
+			Name: strings.ToLower(fields[1]),
+			Args: fields[2:],
+		}
+	}
+	return nil
+}
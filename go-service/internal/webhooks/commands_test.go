@@ -0,0 +1,56 @@
+package webhooks
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *Command
+	}{
+		{name: "plan", body: "prism plan", want: &Command{Name: CommandPlan, Args: []string{}}},
+		{name: "environment", body: "prism plan staging", want: &Command{Name: CommandPlan, Args: []string{"staging"}}},
+		{name: "case and spacing", body: "  Prism   APPLY  ", want: &Command{Name: CommandApply, Args: []string{}}},
+		{name: "bare prefix", body: "prism", want: &Command{Name: CommandHelp}},
+		{name: "later line", body: "Looks good to me.\n\nprism apply\nprism unlock", want: &Command{Name: CommandApply, Args: []string{}}},
+		{name: "unknown command", body: "prism destroy", want: &Command{Name: "destroy", Args: []string{}}},
+		{name: "extra args", body: "prism plan staging now please", want: &Command{Name: CommandPlan, Args: []string{"staging", "now", "please"}}},
+		{name: "quoted", body: "> prism apply\n\nWhy was this applied?"},
+		{name: "inline code", body: "Run `prism plan` again."},
+		{name: "prose", body: "I think prism plan is broken."},
+		{name: "prefix of a word", body: "prismatic plan"},
+		{name: "empty", body: ""},
+	}
+
+	for _, test := range tests {
+		if got := ParseCommand(test.body); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestCommandValidate(t *testing.T) {
+	tests := []struct {
+		body  string
+		valid bool
+	}{
+		{body: "prism plan", valid: true},
+		{body: "prism plan staging", valid: true},
+		{body: "prism apply staging", valid: true},
+		{body: "prism unlock", valid: true},
+		{body: "prism", valid: true},
+		{body: "prism destroy"},
+		{body: "prism plan staging production"},
+		{body: "prism apply now please"},
+		{body: "prism unlock #12"},
+	}
+
+	for _, test := range tests {
+		if err := ParseCommand(test.body).Validate(); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got %v", test.body, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/github"
+	"github.com/benkamin03/prism/internal/orchestrator"
+	"github.com/benkamin03/prism/internal/planstatus"
+	"github.com/benkamin03/prism/internal/scm"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/benkamin03/prism/internal/worker"
+	"github.com/labstack/echo/v4"
+)
+
+// Comments are limited to 65536 characters, leave room for the text around the output
+const maxCommentOutputLength = 60000
+
+type commentJob struct {
+	repository *store.ImportedRepository
+	client     *github.Client
+	command    *Command
+	number     int
+	commenter  string
+}
+
+// handleIssueComment queues the command of a comment. Deliveries that fail before the command is
+// queued are forgotten, so redelivering them runs the command after all.
+func handleIssueComment(c echo.Context, routesConfig *WebhooksRoutesConfig, deliveryID string, payload []byte) error {
+	var event IssueCommentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": fmt.Sprintf("invalid issue_comment payload: %v", err)})
+	}
+
+	// Only new comments on pull requests can carry commands
+	if event.Action != "created" || event.Issue.PullRequest == nil {
+		return c.JSON(http.StatusAccepted, echo.Map{"status": "ignored"})
+	}
+
+	command := ParseCommand(event.Comment.Body)
+	if command == nil {
+		return c.JSON(http.StatusAccepted, echo.Map{"status": "ignored"})
+	}
+
+	repository, err := findRepository(routesConfig, event.Repository.ID)
+	if err != nil {
+		forgetDelivery(routesConfig, deliveryID)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	if repository == nil {
+		return c.JSON(http.StatusAccepted, echo.Map{"status": "ignored", "reason": "repository is not imported"})
+	}
+
+	job := &commentJob{
+		repository: repository,
+		client:     github.NewClient(repository.GitHubToken),
+		command:    command,
+		number:     event.Issue.Number,
+		commenter:  event.Comment.User.Login,
+	}
+
+	allowed, err := job.client.CanPush(repository.Owner, repository.Name, job.commenter)
+	if err != nil {
+		forgetDelivery(routesConfig, deliveryID)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	if !allowed {
+		job.reply(fmt.Sprintf("@%s you need write access to this repository to run Prism commands.", job.commenter))
+		return c.JSON(http.StatusAccepted, echo.Map{"status": "unauthorized"})
+	}
+
+	if err := routesConfig.WorkerPool.Submit(func(ctx context.Context) {
+		runCommentJob(ctx, routesConfig, job)
+	}); err != nil {
+		forgetDelivery(routesConfig, deliveryID)
+		if errors.Is(err, worker.ErrQueueFull) {
+			job.reply("Prism is busy right now, please try again in a few minutes.")
+			return c.JSON(http.StatusServiceUnavailable, echo.Map{"error": "command queue is full"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	log.Printf("Queued command %q from %s on %s/%s#%d", command.Name, job.commenter, repository.Owner, repository.Name, job.number)
+
+	return c.JSON(http.StatusAccepted, echo.Map{"status": "queued", "command": command.Name})
+}
+
+func (j *commentJob) reply(body string) {
+	if _, err := j.client.CreateIssueComment(j.repository.Owner, j.repository.Name, j.number, body); err != nil {
+		log.Printf("Failed to reply on %s/%s#%d: %v", j.repository.Owner, j.repository.Name, j.number, err)
+	}
+}
+
+func runCommentJob(ctx context.Context, routesConfig *WebhooksRoutesConfig, job *commentJob) {
+	if err := job.command.Validate(); err != nil {
+		job.reply(fmt.Sprintf("Prism can not run this, %v.\n\n%s", err, commandUsage))
+		return
+	}
+
+	switch job.command.Name {
+	case CommandHelp:
+		job.reply(commandUsage)
+		return
+	case CommandUnlock:
+		runUnlock(routesConfig, job)
+		return
+	}
+
+	pr, err := job.client.GetPullRequest(job.repository.Owner, job.repository.Name, job.number)
+	if err != nil {
+		job.reply(fmt.Sprintf("Failed to load pull request: %v", err))
+		return
+	}
+	if pr.Head.Repo.ID != job.repository.RepoID {
+		job.reply("Prism cannot run commands on pull requests from forks.")
+		return
+	}
+
+	orch := newOrchestrator(ctx, routesConfig, job.repository)
+
+	if job.command.Name == CommandPlan {
+		runPlan(routesConfig, job, orch, pr)
+	} else {
+		runApply(routesConfig, job, orch, pr)
+	}
+}
+
+func runPlan(routesConfig *WebhooksRoutesConfig, job *commentJob, orch *orchestrator.Orchestrator, pr *github.PullRequest) {
+	lock, acquired, err := routesConfig.Store.AcquireLock(job.repository.ID, job.number, job.commenter)
+	if err != nil {
+		job.reply(fmt.Sprintf("Failed to lock the state: %v", err))
+		return
+	}
+	if !acquired {
+		if lock != nil && lock.PullNumber == store.StateOperationPullNumber {
+			job.reply("The state is being changed by a state operation, comment `prism plan` again once it is done.")
+			return
+		}
+		job.reply(fmt.Sprintf("The state is locked by #%d. Apply or `prism unlock` it there first.", lock.PullNumber))
+		return
+	}
+
+	// prism plan <environment> plans against an environment other than the first one
+	environment := ""
+	if len(job.command.Args) > 0 {
+		environment = job.command.Args[0]
+	}
+	orch.Environment = environment
+
+	sha := pr.Head.SHA
+	statusReporter := planstatus.NewReporter(&scm.GitHub{Client: job.client}, job.repository.CloneURL(), sha)
+	revisionPlan, err := orch.PlanRevision(sha, "")
+	if err != nil {
+		// There is no plan to apply, so nothing to hold the state for
+		releaseLock(routesConfig, job)
+		statusReporter.Failure("Plan failed", err.Error(), nil)
+		job.reply(fmt.Sprintf("### :x: Plan failed for `%s`\n\n%s\n\nThe state lock was released.", shortSHA(sha), codeBlock(err.Error())))
+		return
+	}
+	statusReporter.Success(revisionPlan.Plan)
+
+	if err := routesConfig.Store.SetLockPlannedSHA(job.repository.ID, job.number, sha, environment, revisionPlan.Digest); err != nil {
+		releaseLock(routesConfig, job)
+		job.reply(fmt.Sprintf("Planned `%s` but failed to record it, the state lock was released: %v", shortSHA(sha), err))
+		return
+	}
+	if err := routesConfig.Store.SaveTerraformPlan(job.repository.ID, sha, pr.Head.Ref, revisionPlan.Plan); err != nil {
+		log.Printf("Failed to save plan for %s: %v", sha, err)
+	}
+
+	summary := orchestrator.SummarizePlan(revisionPlan.Plan)
+	job.reply(fmt.Sprintf("### Plan for `%s`%s\n\n**%s**\n\n<details>\n<summary>Show output</summary>\n\n%s\n</details>\n\nComment `prism apply` to apply this plan.",
+		shortSHA(sha), inEnvironment(environment), summary.String(), codeBlock(revisionPlan.PlanText)))
+}
+
+// releaseLock gives up the lock of the pull request after a plan that can not be applied.
+func releaseLock(routesConfig *WebhooksRoutesConfig, job *commentJob) {
+	if _, err := routesConfig.Store.ReleaseLock(job.repository.ID, job.number); err != nil {
+		log.Printf("Failed to release lock of %s/%s#%d: %v", job.repository.Owner, job.repository.Name, job.number, err)
+	}
+}
+
+func runApply(routesConfig *WebhooksRoutesConfig, job *commentJob, orch *orchestrator.Orchestrator, pr *github.PullRequest) {
+	lock, err := routesConfig.Store.GetLock(job.repository.ID)
+	if err != nil {
+		job.reply(fmt.Sprintf("Failed to read the state lock: %v", err))
+		return
+	}
+	if lock == nil || lock.PullNumber != job.number || lock.PlannedSHA == "" {
+		job.reply("There is no plan to apply, comment `prism plan` first.")
+		return
+	}
+
+	sha := pr.Head.SHA
+	if lock.PlannedSHA != sha {
+		job.reply(fmt.Sprintf("New commits were pushed since `%s` was planned, comment `prism plan` again.", shortSHA(lock.PlannedSHA)))
+		return
+	}
+
+	if len(job.command.Args) > 0 && job.command.Args[0] != lock.Environment {
+		job.reply(fmt.Sprintf("The last plan was not for `%s`, comment `prism plan %s` first.", job.command.Args[0], job.command.Args[0]))
+		return
+	}
+
+	status, err := (&scm.GitHub{Client: job.client}).GetMergeRequestStatus(&scm.Repository{Owner: job.repository.Owner, Name: job.repository.Name}, job.number)
+	if err != nil {
+		job.reply(fmt.Sprintf("Failed to load the reviews of this pull request: %v", err))
+		return
+	}
+	approvedBy := []string{}
+	for _, review := range status.Reviews {
+		if review.State == "approved" {
+			approvedBy = append(approvedBy, review.User)
+		}
+	}
+
+	orch.Environment = lock.Environment
+	result, err := orch.ApplyRevision(sha, approvedBy, lock.PlanDigest)
+	var approvalErr *orchestrator.ApprovalError
+	if errors.As(err, &approvalErr) {
+		job.reply(fmt.Sprintf("Cannot apply `%s` yet, %s.", shortSHA(sha), approvalErr.Error()))
+		return
+	}
+	var changedErr *orchestrator.PlanChangedError
+	if errors.As(err, &changedErr) {
+		job.reply(fmt.Sprintf("The plan of `%s` is no longer the one that was reviewed, nothing was applied. Comment `prism plan` to review the new plan.", shortSHA(sha)))
+		return
+	}
+	if err != nil {
+		job.reply(fmt.Sprintf("### :x: Apply failed for `%s`\n\n%s\n\nThe state is still locked by this pull request.", shortSHA(sha), codeBlock(err.Error())))
+		return
+	}
+
+	if _, err := routesConfig.Store.ReleaseLock(job.repository.ID, job.number); err != nil {
+		log.Printf("Failed to release lock after apply: %v", err)
+	}
+
+	job.reply(fmt.Sprintf("### :white_check_mark: Applied `%s`\n\n<details>\n<summary>Show output</summary>\n\n%s\n</details>",
+		shortSHA(sha), codeBlock(result.ApplyOutput)))
+}
+
+func runUnlock(routesConfig *WebhooksRoutesConfig, job *commentJob) {
+	released, err := routesConfig.Store.ReleaseLock(job.repository.ID, job.number)
+	if err != nil {
+		job.reply(fmt.Sprintf("Failed to unlock: %v", err))
+		return
+	}
+	if !released {
+		job.reply("This pull request does not hold the state lock.")
+		return
+	}
+	job.reply("Unlocked the state, the previous plan of this pull request was discarded.")
+}
+
+func inEnvironment(environment string) string {
+	if environment == "" {
+		return ""
+	}
+	return fmt.Sprintf(" in `%s`", environment)
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func codeBlock(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > maxCommentOutputLength {
+		output = output[:maxCommentOutputLength] + "\n\n... (truncated)"
+	}
+	return fmt.Sprintf("```text\n%s\n```", output)
+}
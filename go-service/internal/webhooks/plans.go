@@ -0,0 +1,65 @@
+package webhooks
+
+import (
+	"fmt"
+	"sync"
+)
+
+// planQueue holds the plans that are queued or running, one per repository and commit. A push
+// to the branch of a pull request and the synchronize delivery of the pull request both ask to
+// plan the same head, which is planned once.
+type planQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*planJob
+	// started are the queued jobs whose plan already runs
+	started map[*planJob]bool
+}
+
+func newPlanQueue() *planQueue {
+	return &planQueue{jobs: map[string]*planJob{}, started: map[*planJob]bool{}}
+}
+
+func planKey(job *planJob) string {
+	return fmt.Sprintf("%d@%s", job.repository.ID, job.sha)
+}
+
+// add queues the job and reports whether it has to be submitted. A job waiting for the commit
+// takes over the pull request of the new one, so its plan also refreshes the pull request body.
+// Only a plan that already started without the pull request's base branch is not enough for it.
+func (q *planQueue) add(job *planJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued, ok := q.jobs[planKey(job)]
+	if ok && (job.baseBranch == "" || queued.baseBranch != "") {
+		return false
+	}
+	if ok && !q.started[queued] {
+		queued.baseBranch = job.baseBranch
+		return false
+	}
+	q.jobs[planKey(job)] = job
+	return true
+}
+
+// start marks the job as running and returns the base branch of the pull request its plan also
+// describes, empty when there is none.
+func (q *planQueue) start(job *planJob) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.started[job] = true
+	return job.baseBranch
+}
+
+// finish takes the job off the queue once its plan ran. Deliveries for the commit that arrive
+// after it plan it again.
+func (q *planQueue) finish(job *planJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.started, job)
+	if q.jobs[planKey(job)] == job {
+		delete(q.jobs, planKey(job))
+	}
+}
@@ -0,0 +1,63 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/benkamin03/prism/internal/store"
+)
+
+func TestPlanQueuePlansEachCommitOnce(t *testing.T) {
+	plans := newPlanQueue()
+	repository := &store.ImportedRepository{ID: 1}
+
+	push := &planJob{repository: repository, ref: "feature", sha: "abc123"}
+	synchronize := &planJob{repository: repository, ref: "feature", sha: "abc123", baseBranch: "main"}
+	if !plans.add(push) {
+		t.Fatal("expected the first delivery of a commit to be queued")
+	}
+	if plans.add(synchronize) {
+		t.Error("expected the pull request delivery of the same head not to be queued again")
+	}
+	if !plans.add(&planJob{repository: &store.ImportedRepository{ID: 2}, sha: "abc123"}) {
+		t.Error("expected the same commit of another repository to be queued")
+	}
+	if !plans.add(&planJob{repository: repository, sha: "def456"}) {
+		t.Error("expected another commit of the repository to be queued")
+	}
+
+	if got := plans.start(push); got != "main" {
+		t.Errorf("expected the queued plan to describe the pull request it absorbed, got %q", got)
+	}
+	plans.finish(push)
+	if !plans.add(push) {
+		t.Error("expected a commit to be planned again once its plan ran")
+	}
+}
+
+func TestPlanQueueReplansForPullRequestsOfStartedPlans(t *testing.T) {
+	plans := newPlanQueue()
+	repository := &store.ImportedRepository{ID: 1}
+
+	push := &planJob{repository: repository, ref: "feature", sha: "abc123"}
+	synchronize := &planJob{repository: repository, ref: "feature", sha: "abc123", baseBranch: "main"}
+	plans.add(push)
+	if got := plans.start(push); got != "" {
+		t.Fatalf("expected a push to describe no pull request, got %q", got)
+	}
+
+	if !plans.add(synchronize) {
+		t.Fatal("expected a plan started without the pull request to leave it to a plan of its own")
+	}
+	if plans.add(&planJob{repository: repository, ref: "feature", sha: "abc123"}) {
+		t.Error("expected another push of the head to wait for the pull request's plan")
+	}
+
+	plans.finish(push)
+	if plans.add(&planJob{repository: repository, ref: "feature", sha: "abc123", baseBranch: "main"}) {
+		t.Error("expected the earlier plan finishing to keep the pull request's plan queued")
+	}
+	plans.finish(synchronize)
+	if !plans.add(push) {
+		t.Error("expected a commit to be planned again once every plan of it ran")
+	}
+}
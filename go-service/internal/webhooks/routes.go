@@ -0,0 +1,282 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
+	"github.com/benkamin03/prism/internal/minio"
+	"github.com/benkamin03/prism/internal/orchestrator"
+	"github.com/benkamin03/prism/internal/planstatus"
+	"github.com/benkamin03/prism/internal/prbody"
+	"github.com/benkamin03/prism/internal/scm"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/benkamin03/prism/internal/worker"
+	"github.com/labstack/echo/v4"
+)
+
+// Deliveries remembers the webhook deliveries Prism acted on, store.Store implements it.
+type Deliveries interface {
+	RecordDelivery(deliveryID, event string) (bool, error)
+	ForgetDelivery(deliveryID string) error
+}
+
+type WebhooksRoutesConfig struct {
+	Echo          *echo.Echo
+	Store         *store.Store
+	Deliveries    Deliveries
+	WorkerPool    *worker.Pool
+	MinioClient   minio.MinioClient
+	Secrets       secrets.Provider
+	GitHubApp     *github.App
+	Engines       *engine.Selector
+	WebhookSecret string
+}
+
+// forgetDelivery lets GitHub redeliver a delivery that failed before it was queued, which would
+// be ignored as a duplicate otherwise.
+func forgetDelivery(routesConfig *WebhooksRoutesConfig, deliveryID string) {
+	if err := routesConfig.Deliveries.ForgetDelivery(deliveryID); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// newOrchestrator runs a job on an imported repository. Webhook jobs have no request to take a
+// secrets project from, the repository configuration names it.
+func newOrchestrator(ctx context.Context, routesConfig *WebhooksRoutesConfig, repository *store.ImportedRepository) *orchestrator.Orchestrator {
+	return orchestrator.NewOrchestrator(&orchestrator.NewOrchestratorInput{
+		RepoURL:     repository.CloneURL(),
+		GitHubToken: repository.GitHubToken,
+		UserID:      repository.UserID,
+		MinioClient: routesConfig.MinioClient,
+		Secrets:     routesConfig.Secrets,
+		Engines:     routesConfig.Engines,
+		Store:       routesConfig.Store,
+		Context:     ctx,
+	})
+}
+
+// planJob describes a revision of an imported repository that should be planned.
+type planJob struct {
+	repository *store.ImportedRepository
+	ref        string
+	sha        string
+	// baseBranch is set for pull_request deliveries, whose generated body is kept up to date
+	baseBranch string
+}
+
+func SetupRoutes(routesConfig *WebhooksRoutesConfig) {
+	e := routesConfig.Echo
+	plans := newPlanQueue()
+
+	// POST /webhooks/github
+	// Receives push and pull_request deliveries, verifies them against the shared
+	// webhook secret and queues a plan for the head commit of imported repositories.
+	// issue_comment deliveries on pull requests carry commands such as "prism plan".
+	e.POST("/webhooks/github", func(c echo.Context) error {
+		if routesConfig.WebhookSecret == "" {
+			return c.JSON(http.StatusServiceUnavailable, echo.Map{"error": "webhook secret is not configured"})
+		}
+
+		payload, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "failed to read body"})
+		}
+
+		if !VerifySignature(routesConfig.WebhookSecret, payload, c.Request().Header.Get("X-Hub-Signature-256")) {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid signature"})
+		}
+
+		event := c.Request().Header.Get("X-GitHub-Event")
+		deliveryID := c.Request().Header.Get("X-GitHub-Delivery")
+		if event == "" || deliveryID == "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "missing X-GitHub-Event or X-GitHub-Delivery header"})
+		}
+
+		if event == "ping" {
+			return c.JSON(http.StatusOK, echo.Map{"status": "pong"})
+		}
+
+		// GitHub redelivers on timeouts and users can redeliver by hand, only act once
+		isNew, err := routesConfig.Deliveries.RecordDelivery(deliveryID, event)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		if !isNew {
+			log.Printf("Ignoring duplicate delivery %s (%s)", deliveryID, event)
+			return c.JSON(http.StatusOK, echo.Map{"status": "duplicate"})
+		}
+
+		var job *planJob
+		switch event {
+		case "push":
+			job, err = planJobFromPush(routesConfig, payload)
+		case "pull_request":
+			job, err = planJobFromPullRequest(routesConfig, payload)
+		case "issue_comment":
+			return handleIssueComment(c, routesConfig, deliveryID, payload)
+		default:
+			return c.JSON(http.StatusAccepted, echo.Map{"status": "ignored", "reason": fmt.Sprintf("event %s is not handled", event)})
+		}
+		if err != nil {
+			forgetDelivery(routesConfig, deliveryID)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		if job == nil {
+			return c.JSON(http.StatusAccepted, echo.Map{"status": "ignored"})
+		}
+
+		if !plans.add(job) {
+			log.Printf("Plan for %s/%s at %s is already queued (delivery %s)", job.repository.Owner, job.repository.Name, job.sha, deliveryID)
+			return c.JSON(http.StatusAccepted, echo.Map{
+				"status": "already queued",
+				"ref":    job.ref,
+				"sha":    job.sha,
+			})
+		}
+
+		statusReporter := planstatus.NewReporter(scm.NewGitHub(job.repository.GitHubToken), job.repository.CloneURL(), job.sha)
+		if err := routesConfig.WorkerPool.Submit(func(ctx context.Context) {
+			runPlanJob(ctx, routesConfig, plans, job, statusReporter)
+		}); err != nil {
+			plans.finish(job)
+			forgetDelivery(routesConfig, deliveryID)
+			if errors.Is(err, worker.ErrQueueFull) {
+				return c.JSON(http.StatusServiceUnavailable, echo.Map{"error": "plan queue is full"})
+			}
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		statusReporter.Pending("Plan queued by Prism")
+		log.Printf("Queued plan for %s/%s at %s (delivery %s)", job.repository.Owner, job.repository.Name, job.sha, deliveryID)
+
+		return c.JSON(http.StatusAccepted, echo.Map{
+			"status": "queued",
+			"ref":    job.ref,
+			"sha":    job.sha,
+		})
+	})
+}
+
+// findRepository looks up an imported repository and picks the token used to act on it.
+func findRepository(routesConfig *WebhooksRoutesConfig, repoID int64) (*store.ImportedRepository, error) {
+	repository, err := routesConfig.Store.FindImportedRepository(repoID)
+	if err != nil || repository == nil {
+		return nil, err
+	}
+	repository.GitHubToken = routesConfig.GitHubApp.RepositoryToken(repository.CloneURL(), repository.GitHubToken)
+	return repository, nil
+}
+
+func planJobFromPush(routesConfig *WebhooksRoutesConfig, payload []byte) (*planJob, error) {
+	var event PushEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %w", err)
+	}
+
+	// Branch deletions and tag pushes have nothing to plan
+	if event.Deleted || !strings.HasPrefix(event.Ref, "refs/heads/") {
+		return nil, nil
+	}
+
+	repository, err := findRepository(routesConfig, event.Repository.ID)
+	if err != nil || repository == nil {
+		return nil, err
+	}
+
+	return &planJob{
+		repository: repository,
+		ref:        strings.TrimPrefix(event.Ref, "refs/heads/"),
+		sha:        event.After,
+	}, nil
+}
+
+func planJobFromPullRequest(routesConfig *WebhooksRoutesConfig, payload []byte) (*planJob, error) {
+	var event PullRequestEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid pull_request payload: %w", err)
+	}
+
+	switch event.Action {
+	case "opened", "reopened", "synchronize":
+	default:
+		return nil, nil
+	}
+
+	// Heads from forks cannot be cloned with the importing user's token
+	if event.PullRequest.Head.Repo.ID != event.Repository.ID {
+		log.Printf("Ignoring pull request #%d from fork %s", event.Number, event.PullRequest.Head.Repo.FullName)
+		return nil, nil
+	}
+
+	repository, err := findRepository(routesConfig, event.Repository.ID)
+	if err != nil || repository == nil {
+		return nil, err
+	}
+
+	return &planJob{
+		repository: repository,
+		ref:        event.PullRequest.Head.Ref,
+		sha:        event.PullRequest.Head.SHA,
+		baseBranch: event.PullRequest.Base.Ref,
+	}, nil
+}
+
+func runPlanJob(ctx context.Context, routesConfig *WebhooksRoutesConfig, plans *planQueue, job *planJob, statusReporter *planstatus.Reporter) {
+	orch := newOrchestrator(ctx, routesConfig, job.repository)
+
+	baseBranch := plans.start(job)
+	revisionPlan, err := orch.PlanRevision(job.sha, baseBranch)
+	plans.finish(job)
+	if err != nil {
+		log.Printf("Plan for %s/%s at %s failed: %v", job.repository.Owner, job.repository.Name, job.sha, err)
+		statusReporter.Failure("Plan failed", err.Error(), nil)
+		return
+	}
+	statusReporter.Success(revisionPlan.Plan)
+
+	if err := routesConfig.Store.SaveTerraformPlan(job.repository.ID, job.sha, job.ref, revisionPlan.Plan); err != nil {
+		log.Printf("Failed to save plan for %s: %v", job.sha, err)
+	}
+
+	if baseBranch != "" {
+		if err := refreshPullRequestBody(job, revisionPlan); err != nil {
+			log.Printf("Failed to refresh pull request body for %s: %v", job.ref, err)
+		}
+	}
+}
+
+// refreshPullRequestBody regenerates the body Prism wrote for the pull request of a conversation
+// branch from the plan of its head against the repository's state, the changes merging it
+// applies, so commits pushed to the branch directly show up in it as well. Branches of
+// conversations are named after them.
+func refreshPullRequestBody(job *planJob, revisionPlan *orchestrator.RevisionPlan) error {
+	provider := scm.NewGitHub(job.repository.GitHubToken)
+	repo := &scm.Repository{Owner: job.repository.Owner, Name: job.repository.Name}
+	pr, err := provider.FindOpenMergeRequest(repo, job.ref)
+	if err != nil {
+		return err
+	}
+	if pr == nil || !prbody.IsGenerated(pr.Body) {
+		// Not a conversation, or the body was written by hand
+		return nil
+	}
+
+	_, err = provider.UpdateMergeRequest(repo, pr.Number, &scm.MergeRequestInput{
+		Body: prbody.Render(&prbody.Input{
+			ConversationID: job.ref,
+			Plan:           revisionPlan.Plan,
+			PlanText:       revisionPlan.PlanText,
+			Prompts:        revisionPlan.Prompts,
+			PolicyResults:  revisionPlan.PolicyResults,
+		}),
+	})
+	return err
+}
@@ -0,0 +1,105 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/benkamin03/prism/internal/repoconfig"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/labstack/echo/v4"
+)
+
+// projectSecrets serves the secrets of a single project, the other methods are not used by plans.
+type projectSecrets struct {
+	secrets.Provider
+	projectID string
+	values    map[string]string
+}
+
+func (p *projectSecrets) List(ctx context.Context, options *secrets.Options) (map[string]string, error) {
+	if options.ProjectID != p.projectID {
+		return map[string]string{}, nil
+	}
+	return p.values, nil
+}
+
+func TestWebhookJobsMapSecretsOfTheConfiguredProject(t *testing.T) {
+	config, err := repoconfig.Parse([]byte(`
+infisical:
+  project: infra-project
+secrets:
+  - secret: DB_PASSWORD
+    tf_var: db_password
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	routesConfig := &WebhooksRoutesConfig{
+		Secrets: &projectSecrets{projectID: "infra-project", values: map[string]string{"DB_PASSWORD": "hunter2"}},
+	}
+	repository := &store.ImportedRepository{UserID: "user", Owner: "acme", Name: "infra"}
+
+	orch := newOrchestrator(context.Background(), routesConfig, repository)
+	env, _, err := orch.SecretEnv(config.ForRoot("."))
+	if err != nil {
+		t.Fatalf("expected the job to find the secrets project in the configuration, got %v", err)
+	}
+	if len(env) != 1 || env[0] != "TF_VAR_db_password=hunter2" {
+		t.Errorf("unexpected env %v", env)
+	}
+}
+
+// fakeDeliveries remembers deliveries in memory.
+type fakeDeliveries map[string]bool
+
+func (f fakeDeliveries) RecordDelivery(deliveryID, event string) (bool, error) {
+	if f[deliveryID] {
+		return false, nil
+	}
+	f[deliveryID] = true
+	return true, nil
+}
+
+func (f fakeDeliveries) ForgetDelivery(deliveryID string) error {
+	delete(f, deliveryID)
+	return nil
+}
+
+func deliver(e *echo.Echo, event, deliveryID string, payload []byte, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(payload))
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-GitHub-Delivery", deliveryID)
+	req.Header.Set("X-Hub-Signature-256", signature)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	e := echo.New()
+	SetupRoutes(&WebhooksRoutesConfig{Echo: e, Deliveries: fakeDeliveries{}, WebhookSecret: testWebhookSecret})
+	// A tag push, which is acknowledged without looking up the repository
+	payload := []byte(`{"ref":"refs/tags/v1.0.0","after":"abc123","repository":{"id":1}}`)
+
+	if rec := deliver(e, "push", "delivery-1", payload, "sha256=00"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a delivery with a wrong signature to be rejected, got %d", rec.Code)
+	}
+
+	rec := deliver(e, "push", "delivery-1", payload, sign(payload))
+	if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), `"ignored"`) {
+		t.Errorf("expected the first delivery to be handled, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = deliver(e, "push", "delivery-1", payload, sign(payload))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"duplicate"`) {
+		t.Errorf("expected the redelivery to be ignored as a duplicate, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = deliver(e, "push", "delivery-2", payload, sign(payload))
+	if rec.Code != http.StatusAccepted || strings.Contains(rec.Body.String(), `"duplicate"`) {
+		t.Errorf("expected another delivery to be handled, got %d %s", rec.Code, rec.Body.String())
+	}
+}
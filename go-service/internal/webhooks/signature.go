@@ -0,0 +1,25 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// VerifySignature checks the X-Hub-Signature-256 header GitHub sends with every delivery.
+func VerifySignature(secret string, payload []byte, signatureHeader string) bool {
+	signature, found := strings.CutPrefix(signatureHeader, "sha256=")
+	if !found {
+		return false
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,46 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+func sign(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"ref":"refs/heads/main"}`)
+	sha1MAC := hmac.New(sha1.New, []byte(testWebhookSecret))
+	sha1MAC.Write(payload)
+
+	tests := []struct {
+		name      string
+		secret    string
+		payload   []byte
+		signature string
+		want      bool
+	}{
+		{"valid signature", testWebhookSecret, payload, sign(payload), true},
+		{"missing signature", testWebhookSecret, payload, "", false},
+		{"signature without algorithm", testWebhookSecret, payload, sign(payload)[len("sha256="):], false},
+		{"sha1 signature", testWebhookSecret, payload, "sha1=" + hex.EncodeToString(sha1MAC.Sum(nil)), false},
+		{"signature that is not hex", testWebhookSecret, payload, "sha256=not-hex", false},
+		{"tampered payload", testWebhookSecret, []byte(`{"ref":"refs/heads/evil"}`), sign(payload), false},
+		{"other secret", "other-secret", payload, sign(payload), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignature(tt.secret, tt.payload, tt.signature); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
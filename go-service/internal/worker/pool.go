@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/benkamin03/prism/internal/redact"
+)
+
+var ErrQueueFull = errors.New("job queue is full")
+
+type Job func(ctx context.Context)
+
+// Pool runs background jobs on a fixed number of goroutines.
+type Pool struct {
+	jobs   chan Job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPool(workers, queueSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &Pool{
+		jobs:   make(chan Job, queueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+		go pool.run()
+	}
+	return pool
+}
+
+func (p *Pool) run() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case job := <-p.jobs:
+			p.runJob(job)
+		}
+	}
+}
+
+// runJob gives every job a redactor of its own on the context, the values of its run are
+// dropped when it returns.
+func (p *Pool) runJob(job Job) {
+	scope := redact.Default.Scope()
+	defer scope.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic in background job: %v", r)
+		}
+	}()
+	job(redact.NewContext(p.ctx, scope))
+}
+
+// Submit queues a job without blocking, failing with ErrQueueFull when the queue is at capacity.
+func (p *Pool) Submit(job Job) error {
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops the workers after their current job, dropping anything still queued.
+func (p *Pool) Shutdown() {
+	p.cancel()
+	p.wg.Wait()
+}
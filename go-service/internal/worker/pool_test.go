@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/benkamin03/prism/internal/redact"
+)
+
+func TestJobsGetARedactorOfTheirOwn(t *testing.T) {
+	pool := NewPool(1, 1)
+	defer pool.Shutdown()
+
+	done := make(chan *redact.Redactor)
+	if err := pool.Submit(func(ctx context.Context) {
+		redactor := redact.FromContext(ctx)
+		redactor.Add("hunter2")
+		// Logs of the process are redacted while the job runs
+		if got := redact.Default.String("hunter2"); got != redact.Placeholder {
+			t.Errorf("expected the process logs to redact the value of a running job, got %q", got)
+		}
+		done <- redactor
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	redactor := <-done
+	if redactor == redact.Default {
+		t.Fatal("expected the job to get a scope instead of redact.Default")
+	}
+	// The scope is closed once the job returned, which happens after it sent its redactor
+	pool.Submit(func(ctx context.Context) { done <- nil })
+	<-done
+	if got := redact.Default.String("hunter2"); got != "hunter2" {
+		t.Errorf("expected the value to be dropped after the job, got %q", got)
+	}
+}
+
+func TestSubmitFailsWhenQueueIsFull(t *testing.T) {
+	pool := NewPool(1, 1)
+	defer pool.Shutdown()
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	pool.Submit(func(ctx context.Context) {
+		close(started)
+		<-block
+	})
+	<-started
+	if err := pool.Submit(func(ctx context.Context) {}); err != nil {
+		t.Fatalf("expected the queue to take one job, got %v", err)
+	}
+	if err := pool.Submit(func(ctx context.Context) {}); err != ErrQueueFull {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	close(block)
+}
@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/benkamin03/prism/internal/drift"
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
 	"github.com/benkamin03/prism/internal/infisical"
 	"github.com/benkamin03/prism/internal/minio"
+	"github.com/benkamin03/prism/internal/model"
+	"github.com/benkamin03/prism/internal/providercache"
+	"github.com/benkamin03/prism/internal/redact"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/benkamin03/prism/internal/tfversion"
+	"github.com/benkamin03/prism/internal/worker"
 	"github.com/labstack/echo/v4"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -15,7 +29,15 @@ import (
 
 // Environment holds all configuration loaded from environment variables
 type Environment struct {
-	// Infisical (required in production)
+	// Secrets backend: infisical, file or memory
+	SecretsBackend string
+	SecretsFile    string
+	SecretsFileKey string
+	// Sent in X-Prism-Reveal-Token to read secret values and change secrets, values stay masked
+	// and secrets read-only when unset
+	SecretsRevealToken string
+
+	// Infisical (required by the infisical backend)
 	InfisicalClientID     string
 	InfisicalClientSecret string
 	InfisicalSiteURL      string
@@ -32,6 +54,48 @@ type Environment struct {
 	DBPassword string
 	DBName     string
 	DBPort     string
+
+	// GitHub webhooks (optional, the endpoint rejects deliveries without a secret)
+	GitHubWebhookSecret string
+
+	// GitHub App (optional, personal tokens from requests are used without it)
+	// The private key is read from Infisical rather than the environment
+	GitHubAppID                   string
+	GitHubAppPrivateKeySecret     string
+	GitHubAppInfisicalProjectID   string
+	GitHubAppInfisicalEnvironment string
+
+	// Background jobs
+	WorkerConcurrency int
+	WorkerQueueSize   int
+
+	// Terraform and OpenTofu versions, pre-seeded in the image and installed on demand
+	TerraformVersionsDir string
+	TerraformReleasesURL string
+	OpenTofuReleasesURL  string
+	TerraformOffline     bool
+	// Armored keys the SHA256SUMS of downloaded releases must be signed with
+	TerraformReleasesKeyring string
+
+	// Provider plugin cache, optionally seeded with a provider mirror from MinIO
+	ProviderCacheDir     string
+	ProviderMirrorBucket string
+	ProviderMirrorPrefix string
+	// The mirror is seeded again this often, so packages added to the bucket later reach it too
+	ProviderMirrorInterval time.Duration
+
+	// Drift detection, off with an interval of 0
+	DriftInterval      time.Duration
+	DriftJitter        time.Duration
+	DriftConcurrency   int
+	DriftWebhookURL    string
+	DriftWebhookSecret string
+
+	// Model provider writing HCL to codify drift (optional)
+	ModelProvider string
+	ModelBaseURL  string
+	ModelAPIKey   string
+	ModelName     string
 }
 
 // Global environment configuration accessible throughout the package
@@ -50,20 +114,54 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInt retrieves an integer environment variable or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("❌ %s must be an integer, got %q", key, value)
+	}
+	return parsed
+}
+
+// getEnvDuration retrieves a duration environment variable such as "6h" or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Fatalf("❌ %s must be a duration such as 6h, got %q", key, value)
+	}
+	return parsed
+}
+
 // loadEnvironment loads and validates all environment variables
 func loadEnvironment() *Environment {
-	// Required fields (no defaults)
+	secretsBackend := getEnv("SECRETS_BACKEND", secrets.Infisical)
+
+	// Required by the infisical backend (no defaults)
 	infisicalClientID := os.Getenv("INFISICAL_CLIENT_ID")
-	if infisicalClientID == "" {
+	if infisicalClientID == "" && secretsBackend == secrets.Infisical {
 		log.Fatal("❌ INFISICAL_CLIENT_ID environment variable is required")
 	}
 
 	infisicalClientSecret := os.Getenv("INFISICAL_CLIENT_SECRET")
-	if infisicalClientSecret == "" {
+	if infisicalClientSecret == "" && secretsBackend == secrets.Infisical {
 		log.Fatal("❌ INFISICAL_CLIENT_SECRET environment variable is required")
 	}
 
 	return &Environment{
+		// Secrets backend
+		SecretsBackend:     secretsBackend,
+		SecretsFile:        getEnv("SECRETS_FILE", "/var/lib/prism/secrets.json"),
+		SecretsFileKey:     os.Getenv("SECRETS_FILE_KEY"),
+		SecretsRevealToken: os.Getenv("SECRETS_REVEAL_TOKEN"),
+
 		// Infisical
 		InfisicalClientID:     infisicalClientID,
 		InfisicalClientSecret: infisicalClientSecret,
@@ -81,6 +179,46 @@ func loadEnvironment() *Environment {
 		DBPassword: getEnv("DB_PASSWORD", "test"),
 		DBName:     getEnv("DB_NAME", "prism"),
 		DBPort:     getEnv("DB_PORT", "5432"),
+
+		// GitHub webhooks
+		GitHubWebhookSecret: os.Getenv("GITHUB_WEBHOOK_SECRET"),
+
+		// GitHub App
+		GitHubAppID:                   os.Getenv("GITHUB_APP_ID"),
+		GitHubAppPrivateKeySecret:     getEnv("GITHUB_APP_PRIVATE_KEY_SECRET", "GITHUB_APP_PRIVATE_KEY"),
+		GitHubAppInfisicalProjectID:   os.Getenv("GITHUB_APP_INFISICAL_PROJECT_ID"),
+		GitHubAppInfisicalEnvironment: getEnv("GITHUB_APP_INFISICAL_ENVIRONMENT", "dev"),
+
+		// Background jobs
+		// Every job plans in its own clone, the concurrency only bounds the terraform processes running at once
+		WorkerConcurrency: getEnvInt("WORKER_CONCURRENCY", 1),
+		WorkerQueueSize:   getEnvInt("WORKER_QUEUE_SIZE", 100),
+
+		// Terraform and OpenTofu versions
+		TerraformVersionsDir:     getEnv("TERRAFORM_VERSIONS_DIR", "/var/tmp/terraform-versions"),
+		TerraformReleasesURL:     getEnv("TERRAFORM_RELEASES_URL", tfversion.DefaultReleasesURL),
+		OpenTofuReleasesURL:      getEnv("OPENTOFU_RELEASES_URL", tfversion.DefaultOpenTofuReleasesURL),
+		TerraformOffline:         os.Getenv("TERRAFORM_OFFLINE") == "true",
+		TerraformReleasesKeyring: os.Getenv("TERRAFORM_RELEASES_KEYRING"),
+
+		// Provider plugin cache
+		ProviderCacheDir:       getEnv("PROVIDER_CACHE_DIR", "/var/tmp/terraform-providers"),
+		ProviderMirrorBucket:   os.Getenv("PROVIDER_MIRROR_BUCKET"),
+		ProviderMirrorPrefix:   os.Getenv("PROVIDER_MIRROR_PREFIX"),
+		ProviderMirrorInterval: getEnvDuration("PROVIDER_MIRROR_INTERVAL", 10*time.Minute),
+
+		// Drift detection
+		DriftInterval:      getEnvDuration("DRIFT_INTERVAL", 6*time.Hour),
+		DriftJitter:        getEnvDuration("DRIFT_JITTER", 10*time.Minute),
+		DriftConcurrency:   getEnvInt("DRIFT_CONCURRENCY", 1),
+		DriftWebhookURL:    os.Getenv("DRIFT_WEBHOOK_URL"),
+		DriftWebhookSecret: os.Getenv("DRIFT_WEBHOOK_SECRET"),
+
+		// Model provider
+		ModelProvider: os.Getenv("MODEL_PROVIDER"),
+		ModelBaseURL:  os.Getenv("MODEL_BASE_URL"),
+		ModelAPIKey:   os.Getenv("MODEL_API_KEY"),
+		ModelName:     os.Getenv("MODEL_NAME"),
 	}
 }
 
@@ -106,19 +244,38 @@ func setupDatabaseClient() *sql.DB {
 	return sqlDB
 }
 
-func setupInfisicalClient() *infisical.InfisicalClient {
-	client, err := infisical.NewInfisicalClient(&infisical.InfisicalClientConfig{
-		SiteUrl:               env.InfisicalSiteURL,
-		InfisicalClientID:     env.InfisicalClientID,
-		InfisicalClientSecret: env.InfisicalClientSecret,
-	})
-
-	if err != nil {
-		panic(fmt.Sprintf("Authentication failed: %v", err))
+func setupSecretsProvider() secrets.Provider {
+	switch env.SecretsBackend {
+	case secrets.Infisical:
+		client := infisical.NewInfisicalClient(&infisical.InfisicalClientConfig{
+			SiteUrl:               env.InfisicalSiteURL,
+			InfisicalClientID:     env.InfisicalClientID,
+			InfisicalClientSecret: env.InfisicalClientSecret,
+		})
+		// Infisical may still be starting, requests log in again until it succeeds
+		if err := client.Login(); err != nil {
+			log.Printf("⚠️ %v, secrets are unavailable until it succeeds", err)
+			return client
+		}
+		log.Println("✅ Infisical client authenticated successfully")
+		return client
+	case secrets.File:
+		provider, err := secrets.NewFileProvider(env.SecretsFile, env.SecretsFileKey)
+		if err != nil {
+			log.Fatalf("❌ Failed to load secrets file: %v", err)
+		}
+		if env.SecretsFileKey == "" {
+			log.Printf("⚠️ SECRETS_FILE_KEY not set, secrets are stored unencrypted in %s", env.SecretsFile)
+		}
+		log.Printf("✅ Secrets stored in %s", env.SecretsFile)
+		return provider
+	case secrets.Memory:
+		log.Println("⚠️ Secrets are kept in memory and lost on restart")
+		return secrets.NewMemoryProvider()
+	default:
+		log.Fatalf("❌ Unknown SECRETS_BACKEND %q, expected %s, %s or %s", env.SecretsBackend, secrets.Infisical, secrets.File, secrets.Memory)
+		return nil
 	}
-
-	log.Println("✅ Infisical client authenticated successfully")
-	return client
 }
 
 func setupMinioClient() *minio.MinioClient {
@@ -137,22 +294,172 @@ func setupMinioClient() *minio.MinioClient {
 	return minioClient
 }
 
+func setupGitHubApp(secretsProvider secrets.Provider, dbStore *store.Store) *github.App {
+	if env.GitHubAppID == "" {
+		log.Println("⚠️ GITHUB_APP_ID not set, using personal GitHub tokens")
+		return nil
+	}
+	if env.GitHubAppInfisicalProjectID == "" {
+		log.Fatal("❌ GITHUB_APP_INFISICAL_PROJECT_ID is required when GITHUB_APP_ID is set")
+	}
+
+	privateKey, err := secretsProvider.Get(context.Background(), &secrets.Options{
+		Environment: env.GitHubAppInfisicalEnvironment,
+		ProjectID:   env.GitHubAppInfisicalProjectID,
+		SecretPath:  "/",
+	}, env.GitHubAppPrivateKeySecret)
+	if errors.Is(err, secrets.ErrNotFound) {
+		log.Fatalf("❌ Secret %s not found in project %s", env.GitHubAppPrivateKeySecret, env.GitHubAppInfisicalProjectID)
+	}
+	if err != nil {
+		log.Fatalf("❌ Failed to fetch GitHub App private key: %v", err)
+	}
+
+	app, err := github.NewApp(env.GitHubAppID, []byte(privateKey), dbStore)
+	if err != nil {
+		log.Fatalf("❌ Failed to initialize GitHub App: %v", err)
+	}
+
+	log.Println("✅ GitHub App initialized successfully")
+	return app
+}
+
+func setupStore(db *sql.DB) *store.Store {
+	s := store.NewStore(db)
+	if err := s.Migrate(); err != nil {
+		log.Fatalf("❌ Failed to migrate database: %v", err)
+	}
+
+	log.Println("✅ Database migrations applied successfully")
+	return s
+}
+
+func setupProviderCache(minioClient *minio.MinioClient) *providercache.Cache {
+	cache, err := providercache.New(env.ProviderCacheDir, env.TerraformOffline)
+	if err != nil {
+		log.Fatalf("❌ Failed to set up provider cache: %v", err)
+	}
+
+	if env.ProviderMirrorBucket != "" {
+		seeded, err := cache.Seed(context.Background(), minioClient, env.ProviderMirrorBucket, env.ProviderMirrorPrefix)
+		if err != nil {
+			// Providers missing from the mirror are still downloaded unless running offline
+			log.Printf("⚠️ Failed to seed provider mirror from bucket %s: %v", env.ProviderMirrorBucket, err)
+		} else {
+			log.Printf("✅ Seeded %d provider packages from bucket %s", seeded, env.ProviderMirrorBucket)
+		}
+		if env.ProviderMirrorInterval > 0 {
+			cache.SeedEvery(context.Background(), env.ProviderMirrorInterval, minioClient, env.ProviderMirrorBucket, env.ProviderMirrorPrefix)
+			log.Printf("✅ Provider mirror seeded again every %s", env.ProviderMirrorInterval)
+		}
+	}
+
+	log.Printf("✅ Provider cache set up in %s", env.ProviderCacheDir)
+	return cache
+}
+
+func setupEngines(minioClient *minio.MinioClient) *engine.Selector {
+	selector := &engine.Selector{
+		Terraform: tfversion.NewManager(env.TerraformVersionsDir, env.TerraformReleasesURL, env.TerraformOffline),
+		OpenTofu:  tfversion.NewOpenTofuManager(env.TerraformVersionsDir, env.OpenTofuReleasesURL, env.TerraformOffline),
+		Plugins:   setupProviderCache(minioClient),
+	}
+	if env.TerraformReleasesKeyring != "" {
+		keyring, err := tfversion.LoadKeyring(env.TerraformReleasesKeyring)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		selector.Terraform.Keyring = keyring
+		selector.OpenTofu.Keyring = keyring
+		log.Printf("✅ Terraform and OpenTofu releases verified against the keys in %s", env.TerraformReleasesKeyring)
+	} else {
+		log.Printf("⚠️ TERRAFORM_RELEASES_KEYRING is not set, release checksums are trusted without checking their signatures")
+	}
+	if env.TerraformOffline {
+		log.Printf("✅ Terraform and OpenTofu versions served offline from %s", env.TerraformVersionsDir)
+	} else {
+		log.Printf("✅ Terraform and OpenTofu versions installed into %s", env.TerraformVersionsDir)
+	}
+	return selector
+}
+
+func setupModelProvider() model.Provider {
+	provider, err := model.New(&model.ProviderConfig{
+		Name:    env.ModelProvider,
+		BaseURL: env.ModelBaseURL,
+		APIKey:  env.ModelAPIKey,
+		Model:   env.ModelName,
+	})
+	if err != nil {
+		log.Fatalf("❌ Failed to set up model provider: %v", err)
+	}
+	if provider == nil {
+		log.Println("⚠️ MODEL_PROVIDER not set, drift can only be remediated by reverting it")
+		return nil
+	}
+
+	log.Printf("✅ Model provider %s set up with %s", provider.Name(), env.ModelName)
+	return provider
+}
+
+func startDriftScheduler(config *drift.SchedulerConfig) {
+	if env.DriftInterval == 0 {
+		log.Println("⚠️ DRIFT_INTERVAL is 0, drift detection is off")
+		return
+	}
+
+	config.Interval = env.DriftInterval
+	config.Jitter = env.DriftJitter
+	config.Concurrency = env.DriftConcurrency
+	if env.DriftWebhookURL != "" {
+		config.Notifier = drift.NewNotifier(env.DriftWebhookURL, env.DriftWebhookSecret)
+	}
+	drift.NewScheduler(config).Start(context.Background())
+
+	log.Printf("✅ Drift detection scheduled every %s", env.DriftInterval)
+}
+
 func main() {
+	// Secret values of runs and their tokens never reach the logs
+	log.SetOutput(redact.Default.Writer(os.Stderr))
+
 	// Load environment configuration first
 	env = loadEnvironment()
 	log.Println("✅ Environment configuration loaded")
 
 	e := echo.New()
+	e.Logger.SetOutput(redact.Default.Writer(os.Stdout))
+	e.Use(redact.Middleware(redact.Default))
 	dbClient := setupDatabaseClient()
 	minioClient := setupMinioClient()
-	infisicalClient := setupInfisicalClient()
+	secretsProvider := setupSecretsProvider()
+	workerPool := worker.NewPool(env.WorkerConcurrency, env.WorkerQueueSize)
+	dbStore := setupStore(dbClient)
+	githubApp := setupGitHubApp(secretsProvider, dbStore)
+	engines := setupEngines(minioClient)
 
 	// Routes
 	SetupRoutes(&RoutesConfig{
-		Echo:            e,
-		DatabaseClient:  dbClient,
-		InfisicalClient: *infisicalClient,
-		MinioClient:     *minioClient,
+		Echo:                e,
+		DatabaseClient:      dbClient,
+		Store:               dbStore,
+		WorkerPool:          workerPool,
+		Secrets:             secretsProvider,
+		SecretsRevealToken:  env.SecretsRevealToken,
+		MinioClient:         *minioClient,
+		GitHubApp:           githubApp,
+		GitHubWebhookSecret: env.GitHubWebhookSecret,
+		Engines:             engines,
+		ModelProvider:       setupModelProvider(),
+	})
+
+	startDriftScheduler(&drift.SchedulerConfig{
+		Store:       dbStore,
+		WorkerPool:  workerPool,
+		MinioClient: *minioClient,
+		Secrets:     secretsProvider,
+		GitHubApp:   githubApp,
+		Engines:     engines,
 	})
 
 	e.Logger.Fatal(e.Start(":1323"))
@@ -4,18 +4,35 @@ import (
 	"database/sql"
 	"net/http"
 
+	"github.com/benkamin03/prism/internal/drift"
+	"github.com/benkamin03/prism/internal/engine"
+	"github.com/benkamin03/prism/internal/github"
 	"github.com/benkamin03/prism/internal/infisical"
 	"github.com/benkamin03/prism/internal/llm"
 	"github.com/benkamin03/prism/internal/minio"
+	"github.com/benkamin03/prism/internal/model"
 	"github.com/benkamin03/prism/internal/orchestrator"
+	"github.com/benkamin03/prism/internal/secrets"
+	"github.com/benkamin03/prism/internal/store"
+	"github.com/benkamin03/prism/internal/variables"
+	"github.com/benkamin03/prism/internal/webhooks"
+	"github.com/benkamin03/prism/internal/worker"
 	"github.com/labstack/echo/v4"
 )
 
 type RoutesConfig struct {
-	Echo            *echo.Echo
-	DatabaseClient  *sql.DB
-	InfisicalClient infisical.InfisicalClient
-	MinioClient     minio.MinioClient
+	Echo                *echo.Echo
+	DatabaseClient      *sql.DB
+	Store               *store.Store
+	WorkerPool          *worker.Pool
+	Secrets             secrets.Provider
+	SecretsRevealToken  string
+	MinioClient         minio.MinioClient
+	GitHubApp           *github.App
+	GitHubWebhookSecret string
+	Engines             *engine.Selector
+	// Optional, writes the HCL of drift remediations
+	ModelProvider model.Provider
 }
 
 func SetupRoutes(routesConfig *RoutesConfig) {
@@ -37,18 +54,57 @@ func SetupRoutes(routesConfig *RoutesConfig) {
 	})
 
 	orchestrator.SetupRoutes(&orchestrator.OrchestratorRoutesConfig{
-		Echo:            e,
-		MinioClient:     routesConfig.MinioClient,
-		InfisicalClient: routesConfig.InfisicalClient,
+		Echo:        e,
+		MinioClient: routesConfig.MinioClient,
+		Secrets:     routesConfig.Secrets,
+		GitHubApp:   routesConfig.GitHubApp,
+		Engines:     routesConfig.Engines,
+		Store:       routesConfig.Store,
 	})
 
 	infisical.SetupRoutes(&infisical.InfisicalRoutesConfig{
-		InfisicalClient: routesConfig.InfisicalClient,
-		Echo:            e,
+		Secrets:     routesConfig.Secrets,
+		RevealToken: routesConfig.SecretsRevealToken,
+		Echo:        e,
 	})
 
 	llm.SetupRoutes(&llm.LLMRoutesConfig{
-		InfisicalClient: routesConfig.InfisicalClient,
-		Echo:            e,
+		Secrets:     routesConfig.Secrets,
+		MinioClient: routesConfig.MinioClient,
+		GitHubApp:   routesConfig.GitHubApp,
+		Engines:     routesConfig.Engines,
+		Store:       routesConfig.Store,
+		Echo:        e,
+	})
+
+	variables.SetupRoutes(&variables.VariablesRoutesConfig{
+		Echo:  e,
+		Store: routesConfig.Store,
+	})
+
+	var codifier orchestrator.Codifier
+	if routesConfig.ModelProvider != nil {
+		codifier = &model.Codifier{Provider: routesConfig.ModelProvider}
+	}
+	drift.SetupRoutes(&drift.DriftRoutesConfig{
+		Echo:        e,
+		Store:       routesConfig.Store,
+		MinioClient: routesConfig.MinioClient,
+		Secrets:     routesConfig.Secrets,
+		GitHubApp:   routesConfig.GitHubApp,
+		Engines:     routesConfig.Engines,
+		Codifier:    codifier,
+	})
+
+	webhooks.SetupRoutes(&webhooks.WebhooksRoutesConfig{
+		Echo:          e,
+		Store:         routesConfig.Store,
+		Deliveries:    routesConfig.Store,
+		WorkerPool:    routesConfig.WorkerPool,
+		MinioClient:   routesConfig.MinioClient,
+		Secrets:       routesConfig.Secrets,
+		GitHubApp:     routesConfig.GitHubApp,
+		WebhookSecret: routesConfig.GitHubWebhookSecret,
+		Engines:       routesConfig.Engines,
 	})
 }